@@ -0,0 +1,126 @@
+package framing
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// CapBye means the peer understands {"msg":"bye"} from the relay while it waits
+// for its counterpart.
+const CapBye = "bye"
+
+// DisconnectRequest is the SSH global request a receiver or sender sends just
+// before closing the connection, so the other side can tell why the session
+// ended. Its payload is an ssh.Marshal'ed DisconnectPayload; no reply is expected.
+const DisconnectRequest = "disconnect@ssh-portal"
+
+// Close reasons carried by bye messages and disconnect requests
+const (
+	ReasonInviteExpired    = "invite-expired"    // the invite's TTL ran out before a sender arrived
+	ReasonInviteRevoked    = "invite-revoked"    // the relay operator revoked the invite
+	ReasonRelayShutdown    = "relay-shutdown"    // the relay is stopping or restarting
+	ReasonRelayDraining    = "relay-draining"    // the relay takes no new sessions; reconnect after retry_after
+	ReasonReceiverClosed   = "receiver-closed"   // the receiver user ended the session
+	ReasonSenderClosed     = "sender-closed"     // the sender user ended the session
+	ReasonKeepaliveTimeout = "keepalive-timeout" // the peer stopped answering keepalives
+	ReasonInvalidToken     = "invalid-token"     // the token was rejected
+	ReasonMaxDuration      = "max-duration"      // the session reached the relay's maximum duration
+	ReasonIdleTimeout      = "idle-timeout"      // the session was idle for longer than the relay allows
+)
+
+var reasonText = map[string]string{
+	ReasonInviteExpired:    "invite expired",
+	ReasonInviteRevoked:    "invite revoked by the relay operator",
+	ReasonRelayShutdown:    "relay restarting",
+	ReasonRelayDraining:    "relay draining for a restart",
+	ReasonReceiverClosed:   "receiver ended session",
+	ReasonSenderClosed:     "sender ended session",
+	ReasonKeepaliveTimeout: "keepalive timeout",
+	ReasonInvalidToken:     "invalid token",
+	ReasonMaxDuration:      "maximum session duration reached",
+	ReasonIdleTimeout:      "session idle for too long",
+}
+
+// DescribeReason returns a short human-readable text for a close reason,
+// falling back to the code itself for reasons this version does not know.
+func DescribeReason(reason string) string {
+	if text, ok := reasonText[reason]; ok {
+		return text
+	}
+	if reason == "" {
+		return "connection closed"
+	}
+	return reason
+}
+
+// ByeMessage is sent by the relay before it closes a connection on its own
+// initiative, to peers that negotiated CapBye.
+type ByeMessage struct {
+	Msg        string `json:"msg"` // "bye"
+	Reason     string `json:"reason"`
+	Message    string `json:"message,omitempty"`
+	RetryAfter int    `json:"retry_after,omitempty"` // seconds to wait before reconnecting
+}
+
+// ByeSchema is the schema of ByeMessage.
+var ByeSchema = Schema{Required: []string{"msg", "reason"}, Optional: []string{"message", "retry_after"}}
+
+// DisconnectPayload is the payload of a DisconnectRequest.
+type DisconnectPayload struct {
+	Reason  string
+	Message string
+}
+
+// CloseError reports that the other side ended the connection with a reason.
+type CloseError struct {
+	Reason     string
+	Message    string
+	RetryAfter int // seconds the peer asked us to wait before reconnecting, 0 if unset
+}
+
+func (e *CloseError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s: %s", DescribeReason(e.Reason), e.Message)
+	}
+	return DescribeReason(e.Reason)
+}
+
+// SendDisconnect sends a DisconnectRequest with reason over an SSH connection.
+func SendDisconnect(conn ssh.Conn, reason string) error {
+	_, _, err := conn.SendRequest(DisconnectRequest, false, ssh.Marshal(DisconnectPayload{Reason: reason}))
+	return err
+}
+
+// ParseDisconnect decodes the payload of a DisconnectRequest.
+func ParseDisconnect(payload []byte) (*CloseError, error) {
+	var p DisconnectPayload
+	if err := ssh.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	return &CloseError{Reason: p.Reason, Message: p.Message}, nil
+}
+
+// CloseRecorder remembers the first close reason reported for a connection,
+// whether received from the peer or decided locally.
+type CloseRecorder struct {
+	mu  sync.Mutex
+	err *CloseError
+}
+
+// Record stores e unless a reason was already recorded.
+func (r *CloseRecorder) Record(e *CloseError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = e
+	}
+}
+
+// Reason returns the recorded reason, or nil if none was recorded.
+func (r *CloseRecorder) Reason() *CloseError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
@@ -0,0 +1,19 @@
+package framing
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// DialRelay connects to the relay at addr, over TLS if useTLS is set, for
+// relay listeners that require it. The relay's certificate is checked against
+// the system roots; SSL_CERT_FILE points at a private CA instead. A zero
+// timeout waits as long as the OS does. The connection uses KeepAlive.
+func DialRelay(addr string, useTLS bool, timeout time.Duration) (net.Conn, error) {
+	d := &net.Dialer{Timeout: timeout, KeepAliveConfig: KeepAlive}
+	if !useTLS {
+		return d.Dial("tcp", addr)
+	}
+	return tls.DialWithDialer(d, "tcp", addr, &tls.Config{MinVersion: tls.VersionTLS12})
+}
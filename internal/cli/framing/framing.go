@@ -0,0 +1,197 @@
+// Package framing implements the line-based handshake framing shared by the
+// relay, the receiver and the sender: a version line followed by one JSON object
+// per line, each line bounded in size and each message checked against a strict
+// per-message schema before SSH takes over the connection.
+package framing
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Size limits for handshake lines (including the trailing newline)
+const (
+	MaxVersionLine = 64
+	MaxJSONLine    = 4096
+)
+
+// Error codes sent in {"msg":"error","error":...} responses for malformed frames
+const (
+	CodeLineTooLong  = "line-too-long" // line exceeds its size limit
+	CodeBadVersion   = "bad-version"   // missing or unsupported version line
+	CodeBadJSON      = "bad-json"      // line is not a single JSON object
+	CodeUnknownMsg   = "unknown-msg"   // msg/role combination is not part of the protocol
+	CodeUnknownField = "unknown-field" // field not allowed for this msg
+	CodeMissingField = "missing-field" // required field absent or empty
+	CodeBadField     = "bad-field"     // field present but of the wrong type or value
+	CodeTruncated    = "truncated"     // connection closed or timed out mid-line
+)
+
+// ErrLineTooLong is returned by ReadLine when no newline is found within the limit.
+var ErrLineTooLong = errors.New("line too long")
+
+// Error is a framing error carrying the code to report to the peer.
+type Error struct {
+	Code string
+	Err  error
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("%s: %v", e.Code, e.Err) }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Errorf returns a framing *Error with the given code.
+func Errorf(code, format string, args ...any) *Error {
+	return &Error{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// ErrorCode returns the framing code of err, or fallback if err is not a framing error.
+func ErrorCode(err error, fallback string) string {
+	var fe *Error
+	if errors.As(err, &fe) {
+		return fe.Code
+	}
+	return fallback
+}
+
+// ReadLine reads one newline-terminated line of at most max bytes (newline
+// included) and returns it without the line terminator. Unlike
+// bufio.Reader.ReadString it never buffers more than max bytes, so a peer
+// cannot make us hold megabytes by withholding the newline.
+func ReadLine(br *bufio.Reader, max int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := br.ReadSlice('\n')
+		if len(line)+len(chunk) > max {
+			return nil, &Error{Code: CodeLineTooLong, Err: ErrLineTooLong}
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, &Error{Code: CodeTruncated, Err: err}
+		}
+		return bytes.TrimRight(line, "\r\n"), nil
+	}
+}
+
+// Schema lists the fields a message may carry. Fields in Required must be
+// present and non-empty; any field not listed in Required or Optional is rejected.
+type Schema struct {
+	Required []string
+	Optional []string
+}
+
+// Fields decodes a JSON object line into its raw fields, rejecting anything that
+// is not exactly one JSON object.
+func Fields(line []byte) (map[string]json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	dec := json.NewDecoder(bytes.NewReader(line))
+	if err := dec.Decode(&fields); err != nil {
+		return nil, &Error{Code: CodeBadJSON, Err: err}
+	}
+	if fields == nil {
+		return nil, Errorf(CodeBadJSON, "expected a JSON object")
+	}
+	if dec.More() {
+		return nil, Errorf(CodeBadJSON, "trailing data after JSON object")
+	}
+	return fields, nil
+}
+
+// Check validates raw fields against the schema.
+func (s Schema) Check(fields map[string]json.RawMessage) error {
+	allowed := make(map[string]bool, len(s.Required)+len(s.Optional))
+	for _, f := range s.Required {
+		allowed[f] = true
+		v, ok := fields[f]
+		if !ok || isEmpty(v) {
+			return Errorf(CodeMissingField, "missing required field %q", f)
+		}
+	}
+	for _, f := range s.Optional {
+		allowed[f] = true
+	}
+	var unknown []string
+	for f := range fields {
+		if !allowed[f] {
+			unknown = append(unknown, f)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return Errorf(CodeUnknownField, "unknown field(s) %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// DecodeStrict decodes a JSON line into v, rejecting unknown fields.
+func DecodeStrict(line []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if strings.Contains(err.Error(), "unknown field") {
+			return &Error{Code: CodeUnknownField, Err: err}
+		}
+		return &Error{Code: CodeBadField, Err: err}
+	}
+	return nil
+}
+
+// Schemas maps a "msg" value to the schema of that message.
+type Schemas map[string]Schema
+
+// ReadFrame reads one bounded JSON line and checks it against the schema of its
+// "msg". It returns the msg and the raw line for a follow-up DecodeStrict into
+// the matching struct.
+func (s Schemas) ReadFrame(br *bufio.Reader) (string, []byte, error) {
+	line, err := ReadLine(br, MaxJSONLine)
+	if err != nil {
+		return "", nil, err
+	}
+	fields, err := Fields(line)
+	if err != nil {
+		return "", nil, err
+	}
+	msg := MsgOf(fields)
+	schema, ok := s[msg]
+	if !ok {
+		return "", nil, Errorf(CodeUnknownMsg, "unexpected message %q", msg)
+	}
+	if err := schema.Check(fields); err != nil {
+		return "", nil, err
+	}
+	return msg, line, nil
+}
+
+// MsgOf returns the "msg" field of a decoded line, or "" if absent or not a string.
+func MsgOf(fields map[string]json.RawMessage) string {
+	var msg string
+	if raw, ok := fields["msg"]; ok {
+		_ = json.Unmarshal(raw, &msg)
+	}
+	return msg
+}
+
+// StringField returns a string field of a decoded line, or "" if absent or not a string.
+func StringField(fields map[string]json.RawMessage, name string) string {
+	var s string
+	if raw, ok := fields[name]; ok {
+		_ = json.Unmarshal(raw, &s)
+	}
+	return s
+}
+
+func isEmpty(v json.RawMessage) bool {
+	switch strings.TrimSpace(string(v)) {
+	case "", "null", `""`:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package framing
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// CapLabel means the receiver may describe itself (a hostname or a short
+// description) with "label" in the await it sends after hello_ok. Senders see
+// the label when they inspect the invite.
+const CapLabel = "label"
+
+// MaxLabelLen is the longest receiver label the relay keeps, in bytes
+const MaxLabelLen = 100
+
+// InspectOK is the relay's answer to {"msg":"inspect","role":"sender"}: what
+// an invite code points to, for a sender that hasn't connected yet. Exp and
+// Label are only set for invites that exist.
+type InspectOK struct {
+	Msg     string `json:"msg"` // "inspect_ok"
+	Exists  bool   `json:"exists"`
+	Waiting bool   `json:"waiting,omitempty"` // a receiver is attached and waiting
+	Exp     int64  `json:"exp,omitempty"`
+	Label   string `json:"label,omitempty"`
+}
+
+// InspectOKSchema is the schema of InspectOK.
+var InspectOKSchema = Schema{Required: []string{"msg", "exists"}, Optional: []string{"waiting", "exp", "label"}}
+
+// CleanLabel makes a receiver label safe to show in a terminal: runs of
+// spaces, control and formatting characters become one space and the result
+// is cut to MaxLabelLen bytes.
+func CleanLabel(s string) string {
+	s = strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r) || unicode.Is(unicode.Cf, r) || r == utf8.RuneError
+	}), " ")
+	for len(s) > MaxLabelLen {
+		_, size := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-size]
+	}
+	return strings.TrimSpace(s)
+}
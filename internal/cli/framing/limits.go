@@ -0,0 +1,209 @@
+package framing
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// CapSessionLimits means the peer understands the max_duration and
+// idle_timeout fields the relay adds to ok and ready when a session is limited.
+const CapSessionLimits = "session-limits"
+
+// IdleNoiseBytes is the traffic a session may carry in IdleNoiseWindow and
+// still count as idle. SSH keepalives every 15 seconds stay below it, so a
+// session kept alive only by them still times out, while a few keystrokes and
+// their echo go over it. The relay and both endpoints use the same measure,
+// so the endpoints' countdowns match what the relay enforces.
+const IdleNoiseBytes = 1024
+
+// IdleNoiseWindow is how far back traffic is counted against IdleNoiseBytes.
+const IdleNoiseWindow = time.Minute
+
+// Warnings are logged this long before a session limit ends the session.
+var limitWarnings = []time.Duration{10 * time.Minute, 5 * time.Minute, time.Minute, 10 * time.Second}
+
+// SessionLimits are the limits the relay puts on a session; zero means none.
+type SessionLimits struct {
+	MaxDuration time.Duration
+	IdleTimeout time.Duration
+}
+
+// IsZero reports whether no limit applies.
+func (l SessionLimits) IsZero() bool {
+	return l.MaxDuration <= 0 && l.IdleTimeout <= 0
+}
+
+// String describes the limits for logs.
+func (l SessionLimits) String() string {
+	describe := func(d time.Duration) string {
+		if d <= 0 {
+			return "none"
+		}
+		return d.String()
+	}
+	return "max duration " + describe(l.MaxDuration) + ", idle timeout " + describe(l.IdleTimeout)
+}
+
+// ActivityMeter remembers when a session last carried more than IdleNoiseBytes
+// within IdleNoiseWindow.
+type ActivityMeter struct {
+	mu         sync.Mutex
+	second     int64                              // latest unix second counted
+	seconds    [IdleNoiseWindow / time.Second]int // bytes per second, by unix second modulo the window
+	bytes      int                                // sum of seconds
+	lastActive time.Time
+}
+
+// NewActivityMeter returns a meter that counts now as the last activity.
+func NewActivityMeter(now time.Time) *ActivityMeter {
+	return &ActivityMeter{lastActive: now}
+}
+
+// Add counts n bytes seen at now.
+func (m *ActivityMeter) Add(n int, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	window := int64(len(m.seconds))
+	if sec := now.Unix(); sec > m.second {
+		// Forget the seconds that fell out of the window
+		from := max(m.second+1, sec-window+1)
+		for s := from; s <= sec; s++ {
+			m.bytes -= m.seconds[s%window]
+			m.seconds[s%window] = 0
+		}
+		m.second = sec
+	}
+	m.seconds[m.second%window] += n
+	m.bytes += n
+	if m.bytes > IdleNoiseBytes {
+		m.lastActive = now
+	}
+}
+
+// LastActive returns when the session last counted as active.
+func (m *ActivityMeter) LastActive() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastActive
+}
+
+// SessionTimer follows the limits of a session on an endpoint.
+type SessionTimer struct {
+	Limits   SessionLimits
+	start    time.Time
+	activity *ActivityMeter
+}
+
+// NewSessionTimer starts following limits from now.
+func NewSessionTimer(limits SessionLimits, now time.Time) *SessionTimer {
+	return &SessionTimer{Limits: limits, start: now, activity: NewActivityMeter(now)}
+}
+
+// Conn wraps the connection to the relay so its traffic counts as activity.
+func (t *SessionTimer) Conn(c net.Conn) net.Conn {
+	return &activityConn{Conn: c, activity: t.activity}
+}
+
+// MaxDurationLeft returns the time left before the maximum duration is reached.
+func (t *SessionTimer) MaxDurationLeft(now time.Time) time.Duration {
+	return t.start.Add(t.Limits.MaxDuration).Sub(now)
+}
+
+// IdleLeft returns the time left before the session times out if it stays idle.
+func (t *SessionTimer) IdleLeft(now time.Time) time.Duration {
+	return t.activity.LastActive().Add(t.Limits.IdleTimeout).Sub(now)
+}
+
+// Next returns the limit that will end the session first and the time left
+// before it does. ok is false if no limit applies.
+func (t *SessionTimer) Next(now time.Time) (reason string, left time.Duration, ok bool) {
+	if t == nil {
+		return "", 0, false
+	}
+	if t.Limits.MaxDuration > 0 {
+		reason, left, ok = ReasonMaxDuration, t.MaxDurationLeft(now), true
+	}
+	if t.Limits.IdleTimeout > 0 {
+		if idle := t.IdleLeft(now); !ok || idle < left {
+			reason, left, ok = ReasonIdleTimeout, idle, true
+		}
+	}
+	return reason, left, ok
+}
+
+// RecordExpiry records a limit that has run out (or is about to: the relay
+// starts counting a moment before us) as the close reason.
+func (t *SessionTimer) RecordExpiry(r *CloseRecorder) {
+	if reason, left, ok := t.Next(time.Now()); ok && left <= 2*time.Second {
+		r.Record(&CloseError{Reason: reason})
+	}
+}
+
+// Watch calls warn with a message as a limit approaches, until done is closed.
+func (t *SessionTimer) Watch(done <-chan struct{}, warn func(msg string)) {
+	if t == nil || t.Limits.IsZero() {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	warned := map[string]time.Duration{} // smallest warning given per limit
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			reason, left, _ := t.Next(now)
+			if left <= 0 {
+				continue
+			}
+			// Activity pushes the idle timeout back, so its warnings start over
+			for r, w := range warned {
+				if r == ReasonIdleTimeout && t.IdleLeft(now) > w {
+					delete(warned, r)
+				}
+			}
+			var due time.Duration // the smallest warning time already reached
+			for _, w := range limitWarnings {
+				if left <= w {
+					due = w
+				}
+			}
+			if last, ok := warned[reason]; due > 0 && (!ok || due < last) {
+				warned[reason] = due
+				warn(LimitWarning(reason, left))
+			}
+		}
+	}
+}
+
+// LimitWarning is the message shown when reason will end the session in left.
+func LimitWarning(reason string, left time.Duration) string {
+	left = left.Round(time.Second)
+	if reason == ReasonIdleTimeout {
+		return "Session idle: the relay closes it in " + left.String() + " unless there is activity"
+	}
+	return "Maximum session duration: the relay closes the session in " + left.String()
+}
+
+// activityConn counts the traffic of a connection on an ActivityMeter.
+type activityConn struct {
+	net.Conn
+	activity *ActivityMeter
+}
+
+func (c *activityConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.activity.Add(n, time.Now())
+	}
+	return n, err
+}
+
+func (c *activityConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.activity.Add(n, time.Now())
+	}
+	return n, err
+}
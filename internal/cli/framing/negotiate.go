@@ -0,0 +1,97 @@
+package framing
+
+import (
+	"bufio"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Protocol version line. Endpoints send VersionLine; the relay accepts any minor
+// revision of ProtocolMajor so new optional features never need a new line.
+const (
+	ProtocolName  = "ssh-relay"
+	ProtocolMajor = 1
+	VersionLine   = "ssh-relay/1.0"
+)
+
+// Capabilities advertised in the "caps" field of hello messages. The relay
+// answers with the subset it shares with the peer; a feature is only used when
+// its capability was negotiated.
+const (
+	CapCodeWords    = "code-words"    // receiver may request a code strength in hello
+	CapErrorMessage = "error-message" // error responses may carry a human-readable "message"
+	CapRetryAfter   = "retry-after"   // error responses may carry "retry_after", seconds to wait before trying again
+)
+
+// Error codes sent by the relay to well-formed requests it turns away
+const (
+	CodeUpgradeRequired = "upgrade-required" // client older than the relay's minimum version
+	CodeRateLimited     = "rate-limited"     // too many requests from the client's address
+)
+
+// ReadVersion reads the version line and checks that it names ProtocolMajor,
+// returning the peer's minor revision.
+func ReadVersion(br *bufio.Reader) (int, error) {
+	line, err := ReadLine(br, MaxVersionLine)
+	if err != nil {
+		return 0, err
+	}
+	rev, ok := strings.CutPrefix(strings.TrimSpace(string(line)), ProtocolName+"/")
+	if !ok {
+		return 0, Errorf(CodeBadVersion, "unsupported or missing version line")
+	}
+	major, minor, ok := strings.Cut(rev, ".")
+	if !ok || major != strconv.Itoa(ProtocolMajor) {
+		return 0, Errorf(CodeBadVersion, "unsupported protocol version %q", rev)
+	}
+	n, err := strconv.Atoi(minor)
+	if err != nil || n < 0 {
+		return 0, Errorf(CodeBadVersion, "unsupported protocol version %q", rev)
+	}
+	return n, nil
+}
+
+// Negotiate returns the capabilities present in both ours and theirs, in the
+// order of ours.
+func Negotiate(ours, theirs []string) []string {
+	var common []string
+	for _, c := range ours {
+		if slices.Contains(theirs, c) {
+			common = append(common, c)
+		}
+	}
+	return common
+}
+
+// HasCap reports whether caps contains c.
+func HasCap(caps []string, c string) bool {
+	return slices.Contains(caps, c)
+}
+
+// RemoteError is an error response received from the relay.
+type RemoteError struct {
+	Code       string
+	Message    string // optional explanation (CapErrorMessage)
+	RetryAfter int    // seconds the relay asked us to wait before trying again (CapRetryAfter), 0 if unset
+}
+
+func (e *RemoteError) Error() string {
+	switch {
+	case e.Message != "":
+		return fmt.Sprintf("relay error: %s: %s", e.Code, e.Message)
+	case e.Code == CodeUpgradeRequired:
+		return "relay error: upgrade-required: this ssh-portal version is too old for the relay, please upgrade"
+	case e.Code == CodeRateLimited && e.RetryAfter > 0:
+		return fmt.Sprintf("relay error: rate-limited: too many requests from this address, retry in %ds", e.RetryAfter)
+	case e.Code == CodeRateLimited:
+		return "relay error: rate-limited: too many requests from this address, try again later"
+	case e.Code == "":
+		return "relay error: unknown error"
+	}
+	if text, ok := reasonText[e.Code]; ok {
+		return fmt.Sprintf("relay error: %s (%s)", text, e.Code)
+	}
+	return "relay error: " + e.Code
+}
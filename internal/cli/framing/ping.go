@@ -0,0 +1,34 @@
+package framing
+
+import (
+	"net"
+	"time"
+)
+
+// CapPing means the peer answers {"msg":"ping"} from the relay with
+// {"msg":"pong"} while it waits for its counterpart. The relay announces its
+// interval in hello_ok as ping_interval, in seconds.
+const CapPing = "ping"
+
+// PingMisses is how many intervals may pass without a pong before the relay
+// gives up on a waiting receiver, and without a ping before the receiver gives
+// up on the relay.
+const PingMisses = 3
+
+// PingMessage is a ping from the relay or a pong from the receiver.
+type PingMessage struct {
+	Msg string `json:"msg"` // "ping" or "pong"
+}
+
+// PingSchema is the schema of PingMessage.
+var PingSchema = Schema{Required: []string{"msg"}}
+
+// KeepAlive is the TCP keepalive of connections between the relay and the
+// endpoints. It finds peers that vanished without a FIN within about a minute
+// of silence, where the OS default takes hours.
+var KeepAlive = net.KeepAliveConfig{
+	Enable:   true,
+	Idle:     30 * time.Second,
+	Interval: 10 * time.Second,
+	Count:    3,
+}
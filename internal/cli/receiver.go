@@ -1,18 +1,28 @@
 package cli
 
 import (
+	"strings"
+
 	"github.com/spf13/cobra"
 
 	"ssh-portal/internal/cli/receiver"
+	"ssh-portal/internal/cli/usercode"
 )
 
 var (
-	receiverRelayHost   string
-	receiverRelayPort   int
-	receiverInteractive bool
-	receiverSession     bool
-	receiverLogView     bool
-	receiverToken       string
+	receiverRelayHost    string
+	receiverRelayPort    int
+	receiverTLS          bool
+	receiverInteractive  bool
+	receiverSession      bool
+	receiverLogView      bool
+	receiverToken        string
+	receiverCodeWords    int
+	receiverCodeEncoding string
+	receiverSenderToken  string
+	receiverClaim        string
+	receiverLabel        string
+	receiverForward      receiver.ForwardPolicy
 )
 
 var receiverCmd = &cobra.Command{
@@ -22,23 +32,40 @@ var receiverCmd = &cobra.Command{
 		// Load receiver config and merge with flags
 		cfg := receiver.LoadReceiverConfig()
 		merged := receiver.MergeReceiverFlags(cmd, cfg, receiver.ReceiverFlags{
-			RelayHost:   receiverRelayHost,
-			RelayPort:   receiverRelayPort,
-			Token:       receiverToken,
-			Interactive: receiverInteractive,
-			Session:     receiverSession,
-			LogView:     receiverLogView,
+			RelayHost:     receiverRelayHost,
+			RelayPort:     receiverRelayPort,
+			TLS:           receiverTLS,
+			Token:         receiverToken,
+			Interactive:   receiverInteractive,
+			Session:       receiverSession,
+			LogView:       receiverLogView,
+			CodeWords:     receiverCodeWords,
+			CodeEncoding:  receiverCodeEncoding,
+			SenderToken:   receiverSenderToken,
+			Claim:         receiverClaim,
+			Label:         receiverLabel,
+			ForwardPolicy: receiverForward,
 		})
 
-		return receiver.Run(merged.RelayHost, merged.RelayPort, merged.Interactive, merged.Session, merged.LogView, merged.Token)
+		return receiver.Run(merged)
 	},
 }
 
 func init() {
 	receiverCmd.Flags().StringVar(&receiverRelayHost, "relay", "", "Relay server host")
 	receiverCmd.Flags().IntVar(&receiverRelayPort, "relay-port", 0, "Relay server TCP port")
+	receiverCmd.Flags().BoolVar(&receiverTLS, "tls", false, "connect to the relay over TLS")
 	receiverCmd.Flags().BoolVar(&receiverInteractive, "interactive", true, "interactive mode")
 	receiverCmd.Flags().BoolVar(&receiverSession, "session", false, "enable session handling (PTY/shell/exec)")
 	receiverCmd.Flags().BoolVar(&receiverLogView, "logview", true, "show log panel in interactive mode")
 	receiverCmd.Flags().StringVar(&receiverToken, "token", "", "optional token to send in hello message")
+	receiverCmd.Flags().IntVar(&receiverCodeWords, "code-words", 0, "requested code strength in words (4, 6 or 8); the relay may raise it")
+	receiverCmd.Flags().StringVar(&receiverCodeEncoding, "code-encoding", "", "user code encoding ("+strings.Join(usercode.Encodings(), ", ")+")")
+	receiverCmd.Flags().StringVar(&receiverSenderToken, "sender-token", "", "sender token of the relay; only a short hash of it is added to share links (token-hint)")
+	receiverCmd.Flags().StringVar(&receiverClaim, "claim", "", "claim token of an invite pre-minted by the relay's invite API (e.g. from a helpdesk ticket)")
+	receiverCmd.Flags().StringVar(&receiverLabel, "label", "", "what this machine is, shown to senders that inspect the code (default: the hostname; empty for none)")
+	receiverCmd.Flags().StringSliceVar(&receiverForward.Allow, "forward-allow", nil, "destinations the sender's forwards may reach: CIDRs, addresses or host names (*.example.com for subdomains); repeatable")
+	receiverCmd.Flags().StringSliceVar(&receiverForward.Deny, "forward-deny", nil, "destinations the sender's forwards may not reach (same forms as --forward-allow); repeatable")
+	receiverCmd.Flags().StringSliceVar(&receiverForward.Ports, "forward-ports", nil, "ports the sender's forwards may reach, e.g. 22,8000-8100")
+	receiverCmd.Flags().BoolVar(&receiverForward.LoopbackOnly, "forward-loopback-only", false, "only let the sender's forwards reach this machine's loopback addresses")
 }
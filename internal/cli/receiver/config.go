@@ -1,18 +1,28 @@
 package receiver
 
 import (
+	"os"
+
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	"ssh-portal/internal/cli/usercode"
 )
 
 // ReceiverConfig represents the receiver configuration
 type ReceiverConfig struct {
-	Relay       string `yaml:"relay,omitempty"`
-	RelayPort   int    `yaml:"relay-port,omitempty"`
-	Token       string `yaml:"token,omitempty"`
-	Interactive *bool  `yaml:"interactive,omitempty"`
-	Session     *bool  `yaml:"session,omitempty"`
-	LogView     *bool  `yaml:"logview,omitempty"`
+	Relay         string        `yaml:"relay,omitempty"`
+	RelayPort     int           `yaml:"relay-port,omitempty"`
+	TLS           bool          `yaml:"tls,omitempty"`
+	Token         string        `yaml:"token,omitempty"`
+	Interactive   *bool         `yaml:"interactive,omitempty"`
+	Session       *bool         `yaml:"session,omitempty"`
+	LogView       *bool         `yaml:"logview,omitempty"`
+	CodeWords     int           `yaml:"code-words,omitempty" mapstructure:"code-words"`
+	CodeEncoding  string        `yaml:"code-encoding,omitempty" mapstructure:"code-encoding"`
+	SenderToken   string        `yaml:"sender-token,omitempty" mapstructure:"sender-token"`
+	Label         string        `yaml:"label,omitempty"`
+	ForwardPolicy ForwardPolicy `yaml:"forward-policy,omitempty" mapstructure:"forward-policy"`
 }
 
 // LoadReceiverConfig loads receiver configuration from viper
@@ -30,23 +40,33 @@ func LoadReceiverConfig() *ReceiverConfig {
 // MergeReceiverFlags merges config with CLI flags, returning the final values
 // Flags override config values when explicitly set
 type ReceiverFlags struct {
-	RelayHost   string
-	RelayPort   int
-	Token       string
-	Interactive bool
-	Session     bool
-	LogView     bool
+	RelayHost     string
+	RelayPort     int
+	TLS           bool // connect to a relay listener that requires TLS
+	Token         string
+	Interactive   bool
+	Session       bool
+	LogView       bool
+	CodeWords     int           // requested code strength (4, 6 or 8 words)
+	CodeEncoding  string        // user code encoding (see usercode.Encodings)
+	SenderToken   string        // relay sender token; only its hint is put in share links
+	Claim         string        // claim token of an invite pre-minted over the relay API (first connection only)
+	Label         string        // shown to senders inspecting the invite; defaults to the hostname
+	ForwardPolicy ForwardPolicy // where the sender's direct-tcpip forwards may go
 }
 
 func MergeReceiverFlags(cmd *cobra.Command, cfg *ReceiverConfig, flags ReceiverFlags) ReceiverFlags {
 	result := ReceiverFlags{
-		RelayHost:   "localhost",
-		RelayPort:   4430,
-		Token:       "",
-		Interactive: true,
-		Session:     false,
-		LogView:     true,
+		RelayHost:    "localhost",
+		RelayPort:    4430,
+		Token:        "",
+		Interactive:  true,
+		Session:      false,
+		LogView:      true,
+		CodeWords:    usercode.DefaultCodeWords,
+		CodeEncoding: usercode.DefaultEncoding,
 	}
+	result.Label, _ = os.Hostname()
 
 	// Apply config values as defaults
 	if cfg != nil {
@@ -56,6 +76,9 @@ func MergeReceiverFlags(cmd *cobra.Command, cfg *ReceiverConfig, flags ReceiverF
 		if cfg.RelayPort > 0 {
 			result.RelayPort = cfg.RelayPort
 		}
+		if cfg.TLS {
+			result.TLS = true
+		}
 		if cfg.Token != "" {
 			result.Token = cfg.Token
 		}
@@ -68,6 +91,19 @@ func MergeReceiverFlags(cmd *cobra.Command, cfg *ReceiverConfig, flags ReceiverF
 		if cfg.LogView != nil {
 			result.LogView = *cfg.LogView
 		}
+		if cfg.CodeWords > 0 {
+			result.CodeWords = cfg.CodeWords
+		}
+		if cfg.CodeEncoding != "" {
+			result.CodeEncoding = cfg.CodeEncoding
+		}
+		if cfg.SenderToken != "" {
+			result.SenderToken = cfg.SenderToken
+		}
+		if cfg.Label != "" {
+			result.Label = cfg.Label
+		}
+		result.ForwardPolicy = cfg.ForwardPolicy
 	}
 
 	// CLI flags override config
@@ -77,6 +113,9 @@ func MergeReceiverFlags(cmd *cobra.Command, cfg *ReceiverConfig, flags ReceiverF
 	if cmd.Flags().Changed("relay-port") && flags.RelayPort > 0 {
 		result.RelayPort = flags.RelayPort
 	}
+	if cmd.Flags().Changed("tls") {
+		result.TLS = flags.TLS
+	}
 	if cmd.Flags().Changed("token") && flags.Token != "" {
 		result.Token = flags.Token
 	}
@@ -89,6 +128,36 @@ func MergeReceiverFlags(cmd *cobra.Command, cfg *ReceiverConfig, flags ReceiverF
 	if cmd.Flags().Changed("logview") {
 		result.LogView = flags.LogView
 	}
+	if cmd.Flags().Changed("code-words") && flags.CodeWords > 0 {
+		result.CodeWords = flags.CodeWords
+	}
+	if cmd.Flags().Changed("code-encoding") && flags.CodeEncoding != "" {
+		result.CodeEncoding = flags.CodeEncoding
+	}
+	if cmd.Flags().Changed("sender-token") && flags.SenderToken != "" {
+		result.SenderToken = flags.SenderToken
+	}
+	// An empty --label sends none
+	if cmd.Flags().Changed("label") {
+		result.Label = flags.Label
+	}
+	if cmd.Flags().Changed("forward-allow") {
+		result.ForwardPolicy.Allow = flags.ForwardPolicy.Allow
+	}
+	if cmd.Flags().Changed("forward-deny") {
+		result.ForwardPolicy.Deny = flags.ForwardPolicy.Deny
+	}
+	if cmd.Flags().Changed("forward-ports") {
+		result.ForwardPolicy.Ports = flags.ForwardPolicy.Ports
+	}
+	if cmd.Flags().Changed("forward-loopback-only") {
+		result.ForwardPolicy.LoopbackOnly = flags.ForwardPolicy.LoopbackOnly
+	}
+	// A claim token works once, so it only ever comes from the command line
+	if cmd.Flags().Changed("claim") {
+		result.Claim = flags.Claim
+	}
+	result.CodeWords = usercode.NormalizeCodeWords(result.CodeWords)
 
 	return result
 }
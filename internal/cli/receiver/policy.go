@@ -0,0 +1,220 @@
+package receiver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// forwardResolveTimeout bounds the lookup of a forward's host name
+const forwardResolveTimeout = 5 * time.Second
+
+// ForwardPolicy limits where a sender's direct-tcpip forwards may go. Allow
+// and Deny entries are CIDRs, single addresses or host names ("*.example.com"
+// matches its subdomains). A destination must match Allow, if Allow is set,
+// and must not match Deny. Ports lists ports and port ranges ("22",
+// "8000-8100"); LoopbackOnly only allows this machine's loopback addresses.
+// An empty policy allows every destination.
+type ForwardPolicy struct {
+	Allow        []string `yaml:"allow,omitempty" mapstructure:"allow,omitempty"`
+	Deny         []string `yaml:"deny,omitempty" mapstructure:"deny,omitempty"`
+	Ports        []string `yaml:"ports,omitempty" mapstructure:"ports,omitempty"`
+	LoopbackOnly bool     `yaml:"loopback-only,omitempty" mapstructure:"loopback-only,omitempty"`
+}
+
+// Empty reports whether the policy restricts nothing.
+func (p ForwardPolicy) Empty() bool {
+	return len(p.Allow)+len(p.Deny)+len(p.Ports) == 0 && !p.LoopbackOnly
+}
+
+// String summarizes the policy for the log and the TUI.
+func (p ForwardPolicy) String() string {
+	if p.Empty() {
+		return "any destination"
+	}
+	var parts []string
+	if p.LoopbackOnly {
+		parts = append(parts, "loopback only")
+	}
+	if len(p.Allow) > 0 {
+		parts = append(parts, "allow "+strings.Join(p.Allow, ", "))
+	}
+	if len(p.Deny) > 0 {
+		parts = append(parts, "deny "+strings.Join(p.Deny, ", "))
+	}
+	if len(p.Ports) > 0 {
+		parts = append(parts, "ports "+strings.Join(p.Ports, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// forwardDeniedError is a destination the forward policy does not allow.
+type forwardDeniedError struct {
+	reason string
+}
+
+func (e *forwardDeniedError) Error() string { return e.reason }
+
+func denied(format string, args ...any) error {
+	return &forwardDeniedError{reason: fmt.Sprintf(format, args...)}
+}
+
+// portRange is an inclusive range of ports.
+type portRange struct {
+	lo, hi uint32
+}
+
+// forwardPolicy is a parsed ForwardPolicy. A nil forwardPolicy allows
+// everything.
+type forwardPolicy struct {
+	allowNets, denyNets   []netip.Prefix
+	allowHosts, denyHosts []string // lower case, "*." prefixed for subdomains
+	ports                 []portRange
+	loopbackOnly          bool
+	// resolve looks up the addresses of a host name
+	resolve func(ctx context.Context, host string) ([]netip.Addr, error)
+}
+
+// newForwardPolicy parses cfg; it returns nil if cfg is empty.
+func newForwardPolicy(cfg ForwardPolicy) (*forwardPolicy, error) {
+	if cfg.Empty() {
+		return nil, nil
+	}
+	p := &forwardPolicy{
+		loopbackOnly: cfg.LoopbackOnly,
+		resolve: func(ctx context.Context, host string) ([]netip.Addr, error) {
+			return net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		},
+	}
+	var err error
+	if p.allowNets, p.allowHosts, err = parseDestinations("allow", cfg.Allow); err != nil {
+		return nil, err
+	}
+	if p.denyNets, p.denyHosts, err = parseDestinations("deny", cfg.Deny); err != nil {
+		return nil, err
+	}
+	for _, e := range cfg.Ports {
+		lo, hi, isRange := strings.Cut(strings.TrimSpace(e), "-")
+		if !isRange {
+			hi = lo
+		}
+		from, errLo := strconv.ParseUint(strings.TrimSpace(lo), 10, 16)
+		to, errHi := strconv.ParseUint(strings.TrimSpace(hi), 10, 16)
+		if errLo != nil || errHi != nil || from == 0 || from > to {
+			return nil, fmt.Errorf("forward-policy ports: invalid port or range %q", e)
+		}
+		p.ports = append(p.ports, portRange{uint32(from), uint32(to)})
+	}
+	return p, nil
+}
+
+// parseDestinations splits allow or deny entries into networks and host names.
+func parseDestinations(what string, entries []string) ([]netip.Prefix, []string, error) {
+	var nets []netip.Prefix
+	var hosts []string
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if p, err := netip.ParsePrefix(e); err == nil {
+			nets = append(nets, p.Masked())
+			continue
+		}
+		if a, err := netip.ParseAddr(e); err == nil {
+			a = a.Unmap().WithZone("")
+			nets = append(nets, netip.PrefixFrom(a, a.BitLen()))
+			continue
+		}
+		host := strings.ToLower(strings.TrimSuffix(e, "."))
+		if !validHostPattern(host) {
+			return nil, nil, fmt.Errorf("forward-policy %s: invalid CIDR, address or host name %q", what, e)
+		}
+		hosts = append(hosts, host)
+	}
+	return nets, hosts, nil
+}
+
+// validHostPattern reports whether host is a host name, optionally prefixed
+// with "*." to match its subdomains
+func validHostPattern(host string) bool {
+	host = strings.TrimPrefix(host, "*.")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchesHost reports whether name matches one of the host patterns
+func matchesHost(patterns []string, name string) bool {
+	return slices.ContainsFunc(patterns, func(p string) bool {
+		if suffix, ok := strings.CutPrefix(p, "*"); ok {
+			return strings.HasSuffix(name, suffix)
+		}
+		return p == name
+	})
+}
+
+// matchesNet reports whether a is in one of the networks
+func matchesNet(nets []netip.Prefix, a netip.Addr) bool {
+	return slices.ContainsFunc(nets, func(p netip.Prefix) bool { return p.Contains(a) })
+}
+
+// destinations returns the addresses a forward to host:port may connect to,
+// or a *forwardDeniedError saying why the policy refuses it. Host names are
+// resolved here, so the addresses checked are the ones connected to.
+func (p *forwardPolicy) destinations(ctx context.Context, host string, port uint32) ([]netip.Addr, error) {
+	if len(p.ports) > 0 && !slices.ContainsFunc(p.ports, func(r portRange) bool { return port >= r.lo && port <= r.hi }) {
+		return nil, denied("port %d is not allowed", port)
+	}
+
+	name := ""
+	addrs := []netip.Addr{}
+	if a, err := netip.ParseAddr(host); err == nil {
+		addrs = append(addrs, a)
+	} else {
+		name = strings.ToLower(strings.TrimSuffix(host, "."))
+		if matchesHost(p.denyHosts, name) {
+			return nil, denied("%s is denied", host)
+		}
+		if addrs, err = p.resolve(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+
+	// A host name on the allow list allows what it resolves to, unless denied
+	allowed := len(p.allowNets)+len(p.allowHosts) == 0 || (name != "" && matchesHost(p.allowHosts, name))
+	var permitted []netip.Addr
+	err := denied("%s has no addresses", host)
+	for _, a := range addrs {
+		a = a.Unmap()
+		// Zones don't match any network
+		bare := a.WithZone("")
+		switch {
+		case p.loopbackOnly && !bare.IsLoopback():
+			err = denied("%s is not a loopback address", bare)
+		case matchesNet(p.denyNets, bare):
+			err = denied("%s is denied", bare)
+		case !allowed && !matchesNet(p.allowNets, bare):
+			err = denied("%s is not allowed", bare)
+		default:
+			permitted = append(permitted, a)
+		}
+	}
+	if len(permitted) == 0 {
+		return nil, err
+	}
+	return permitted, nil
+}
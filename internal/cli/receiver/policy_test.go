@@ -0,0 +1,102 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestForwardPolicy(t *testing.T) {
+	hosts := map[string][]string{
+		"localhost":          {"127.0.0.1", "::1"},
+		"db.internal":        {"10.1.0.5"},
+		"web.corp.example":   {"10.2.0.8"},
+		"mixed.corp.example": {"10.0.0.1", "10.0.0.2"},
+		"metadata.internal":  {"169.254.169.254"},
+	}
+	policy := func(cfg ForwardPolicy) *forwardPolicy {
+		t.Helper()
+		p, err := newForwardPolicy(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.resolve = func(_ context.Context, host string) ([]netip.Addr, error) {
+			var addrs []netip.Addr
+			for _, a := range hosts[host] {
+				addrs = append(addrs, netip.MustParseAddr(a))
+			}
+			if addrs == nil {
+				return nil, errors.New("no such host")
+			}
+			return addrs, nil
+		}
+		return p
+	}
+
+	lan := policy(ForwardPolicy{
+		Allow: []string{"10.0.0.0/24", "db.internal", "*.corp.example"},
+		Deny:  []string{"10.0.0.1", "metadata.internal"},
+		Ports: []string{"22", "8000-8100"},
+	})
+	loopback := policy(ForwardPolicy{LoopbackOnly: true})
+
+	for _, tc := range []struct {
+		name   string
+		policy *forwardPolicy
+		host   string
+		port   uint32
+		want   []string // nil: denied
+	}{
+		{"allowed network", lan, "10.0.0.7", 22, []string{"10.0.0.7"}},
+		{"mapped address", lan, "::ffff:10.0.0.7", 8080, []string{"10.0.0.7"}},
+		{"port not allowed", lan, "10.0.0.7", 445, nil},
+		{"denied address", lan, "10.0.0.1", 22, nil},
+		{"outside the allow list", lan, "192.168.1.1", 22, nil},
+		{"allowed host name", lan, "DB.internal.", 22, []string{"10.1.0.5"}},
+		{"allowed subdomain", lan, "web.corp.example", 8000, []string{"10.2.0.8"}},
+		{"denied address of an allowed name is skipped", lan, "mixed.corp.example", 22, []string{"10.0.0.2"}},
+		{"denied host name", lan, "metadata.internal", 22, nil},
+		{"loopback", loopback, "localhost", 5432, []string{"127.0.0.1", "::1"}},
+		{"loopback address", loopback, "127.0.0.2", 80, []string{"127.0.0.2"}},
+		{"not loopback", loopback, "10.0.0.7", 22, nil},
+	} {
+		addrs, err := tc.policy.destinations(context.Background(), tc.host, tc.port)
+		var deniedErr *forwardDeniedError
+		switch {
+		case tc.want == nil && !errors.As(err, &deniedErr):
+			t.Errorf("%s: got %v, %v; want denied", tc.name, addrs, err)
+		case tc.want != nil && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case tc.want != nil:
+			var got []string
+			for _, a := range addrs {
+				got = append(got, a.String())
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+			}
+		}
+	}
+
+	// Lookup failures are not policy denials
+	if _, err := lan.destinations(context.Background(), "nowhere.corp.example", 22); err == nil || errors.As(err, new(*forwardDeniedError)) {
+		t.Errorf("unresolvable host: %v", err)
+	}
+
+	for _, bad := range []ForwardPolicy{
+		{Allow: []string{"10.0.0.0/33"}},
+		{Deny: []string{"bad host"}},
+		{Ports: []string{"0"}},
+		{Ports: []string{"9000-8000"}},
+		{Ports: []string{"70000"}},
+	} {
+		if _, err := newForwardPolicy(bad); err == nil {
+			t.Errorf("%+v: no error", bad)
+		}
+	}
+	if p, err := newForwardPolicy(ForwardPolicy{}); p != nil || err != nil {
+		t.Errorf("empty policy: %v, %v", p, err)
+	}
+}
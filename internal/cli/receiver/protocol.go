@@ -3,41 +3,59 @@ package receiver
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"os"
 	"strconv"
-	"strings"
+	"time"
+
+	"ssh-portal/internal/cli/framing"
+	"ssh-portal/internal/cli/usercode"
+	"ssh-portal/internal/version"
 )
 
 // --- Protocol structures ---
 
 // AwaitMessage is the JSON await message sent to the relay before SSH starts
 type AwaitMessage struct {
-	Msg  string `json:"msg"`
-	Role string `json:"role"`
-	Code string `json:"code,omitempty"`
-	RID  string `json:"rid,omitempty"`
+	Msg   string `json:"msg"`
+	Role  string `json:"role"`
+	Code  string `json:"code,omitempty"`
+	RID   string `json:"rid,omitempty"`
+	Label string `json:"label,omitempty"` // what we are, for senders inspecting the invite (framing.CapLabel)
 }
 
 // JSON hello message/response over TCP
 type HelloRequest struct {
-	Msg        string `json:"msg"` // "hello"
-	Role       string `json:"role"`
-	ReceiverFP string `json:"receiver_fp"`
-	Token      string `json:"token,omitempty"`
+	Msg        string   `json:"msg"` // "hello"
+	Role       string   `json:"role"`
+	ReceiverFP string   `json:"receiver_fp"`
+	Token      string   `json:"token,omitempty"`
+	CodeWords  int      `json:"code_words,omitempty"` // requested code strength
+	Version    string   `json:"version,omitempty"`    // our software version
+	Caps       []string `json:"caps,omitempty"`       // our capabilities
+	Claim      string   `json:"claim,omitempty"`      // claim token of a pre-minted invite
 }
 
 type HelloResponse struct {
-	Msg  string `json:"msg"` // "hello_ok"
-	Code string `json:"code"`
-	RID  string `json:"rid"`
-	Exp  int64  `json:"exp"`
+	Msg       string   `json:"msg"` // "hello_ok"
+	Code      string   `json:"code"`
+	RID       string   `json:"rid"`
+	Exp       int64    `json:"exp"`
+	CodeWords int      `json:"code_words,omitempty"` // strength chosen by the relay (absent on old relays: 4)
+	Version   string   `json:"version,omitempty"`    // relay version (absent on old relays)
+	Caps      []string `json:"caps,omitempty"`       // capabilities shared with the relay
+	// Seconds between the relay's pings while we wait (framing.CapPing)
+	PingInterval int `json:"ping_interval,omitempty"`
 }
 
 type ErrorResponse struct {
-	Msg   string `json:"msg"`   // "error"
-	Error string `json:"error"` // error reason
+	Msg        string `json:"msg"`                   // "error"
+	Error      string `json:"error"`                 // error reason
+	Message    string `json:"message,omitempty"`     // optional explanation
+	RetryAfter int    `json:"retry_after,omitempty"` // seconds to wait before trying again
 }
 
 // ReadyMessage is received from relay when sender connects
@@ -48,6 +66,10 @@ type ReadyMessage struct {
 	Exp         int64       `json:"exp"`
 	Alg         string      `json:"alg,omitempty"`
 	Sender      *SenderInfo `json:"sender,omitempty"`
+	Caps        []string    `json:"caps,omitempty"` // capabilities shared by relay, receiver and sender
+	// Session limits in seconds (framing.CapSessionLimits)
+	MaxDuration int `json:"max_duration,omitempty"`
+	IdleTimeout int `json:"idle_timeout,omitempty"`
 }
 
 // SenderInfo mirrors metadata provided by sender via relay
@@ -56,6 +78,25 @@ type SenderInfo struct {
 	Identity  string `json:"identity,omitempty"`
 }
 
+// Capabilities lists the protocol features this receiver supports.
+var Capabilities = []string{framing.CapCodeWords, framing.CapErrorMessage, framing.CapBye, framing.CapSessionLimits, framing.CapRetryAfter, framing.CapPing, framing.CapLabel}
+
+// Schemas of the relay messages a receiver accepts before SSH starts
+var (
+	errorSchema  = framing.Schema{Required: []string{"msg", "error"}, Optional: []string{"message", "retry_after"}}
+	helloSchemas = framing.Schemas{
+		"hello_ok": {Required: []string{"msg", "code", "rid", "exp"}, Optional: []string{"code_words", "version", "caps", "ping_interval"}},
+		"error":    errorSchema,
+		"bye":      framing.ByeSchema,
+	}
+	readySchemas = framing.Schemas{
+		"ready": {Required: []string{"msg", "sender_addr", "fp", "exp"}, Optional: []string{"alg", "sender", "caps", "max_duration", "idle_timeout"}},
+		"error": errorSchema,
+		"bye":   framing.ByeSchema,
+		"ping":  framing.PingSchema,
+	}
+)
+
 // ConnectionResult holds the result of connecting to the relay
 type ConnectionResult struct {
 	Conn         net.Conn
@@ -72,19 +113,31 @@ type ConnectionResult struct {
 // Returns the connection and invite information
 // relayHost is the relay server host
 // relayPort is the TCP port (HTTP will be on port+1)
-func ConnectToRelay(relayHost string, relayPort int, receiverFP string, token string) (*ConnectionResult, *HelloResponse, error) {
+// useTLS connects to a relay listener that requires TLS
+// codeWords is the requested code strength; the relay may raise it
+// claim, if set, takes over an invite pre-minted over the relay API instead of minting one
+// label describes this machine to senders inspecting the invite, on relays that take it
+func ConnectToRelay(relayHost string, relayPort int, useTLS bool, receiverFP string, token string, codeWords int, claim string, label string) (*ConnectionResult, *HelloResponse, error) {
 	// 1) Connect TCP
 	relayTCP := net.JoinHostPort(relayHost, strconv.Itoa(relayPort))
-	conn, err := net.Dial("tcp", relayTCP)
+	conn, err := framing.DialRelay(relayTCP, useTLS, 0)
 	if err != nil {
 		return nil, nil, fmt.Errorf("socket error: %w", err)
 	}
 	// 2) Send version + JSON hello
-	if _, err := fmt.Fprintln(conn, "ssh-relay/1.0"); err != nil {
+	if _, err := fmt.Fprintln(conn, framing.VersionLine); err != nil {
 		conn.Close()
 		return nil, nil, fmt.Errorf("failed to send version: %w", err)
 	}
-	helloReq := HelloRequest{Msg: "hello", Role: "receiver", ReceiverFP: receiverFP}
+	helloReq := HelloRequest{
+		Msg:        "hello",
+		Role:       "receiver",
+		ReceiverFP: receiverFP,
+		CodeWords:  codeWords,
+		Version:    version.String(),
+		Caps:       Capabilities,
+		Claim:      claim,
+	}
 	if token != "" {
 		helloReq.Token = token
 	}
@@ -94,31 +147,43 @@ func ConnectToRelay(relayHost string, relayPort int, receiverFP string, token st
 	}
 	// 3) Read hello_ok response
 	br := bufio.NewReader(conn)
-	line, err := br.ReadString('\n')
+	msg, line, err := helloSchemas.ReadFrame(br)
 	if err != nil {
 		conn.Close()
-		return nil, nil, fmt.Errorf("failed to read hello response: %w", err)
+		return nil, nil, fmt.Errorf("bad hello response: %w", err)
 	}
 
 	// Check if response is an error first
-	var errResp ErrorResponse
-	if err := json.Unmarshal([]byte(line), &errResp); err == nil && errResp.Msg == "error" {
+	if msg == "error" {
+		var errResp ErrorResponse
+		_ = framing.DecodeStrict(line, &errResp)
 		conn.Close()
-		if errResp.Error != "" {
-			return nil, nil, fmt.Errorf("relay error: %s", errResp.Error)
-		}
-		return nil, nil, fmt.Errorf("relay error: unknown error")
+		return nil, nil, &framing.RemoteError{Code: errResp.Error, Message: errResp.Message, RetryAfter: errResp.RetryAfter}
+	}
+	if msg == "bye" {
+		// A draining relay turns new receivers away with a retry hint
+		conn.Close()
+		return nil, nil, decodeBye(line)
 	}
 
-	// Try to unmarshal as hello_ok response
 	var m HelloResponse
-	if err := json.Unmarshal([]byte(line), &m); err != nil || m.Msg != "hello_ok" {
+	if err := framing.DecodeStrict(line, &m); err != nil {
 		conn.Close()
-		return nil, nil, fmt.Errorf("bad hello response: %s", line)
+		return nil, nil, fmt.Errorf("bad hello response: %w", err)
+	}
+	if m.CodeWords == 0 {
+		// Relays predating strength negotiation always mint 4-word codes
+		m.CodeWords = usercode.DefaultCodeWords
+	}
+	if m.Version != "" {
+		log.Printf("Relay version %s, negotiated capabilities: %v", m.Version, m.Caps)
 	}
 
 	// 4) On same connection, send await with RID to attach
 	awaitMsg := AwaitMessage{Msg: "await", Role: "receiver", RID: m.RID}
+	if framing.HasCap(m.Caps, framing.CapLabel) {
+		awaitMsg.Label = framing.CleanLabel(label)
+	}
 	log.Printf("Sent await to relay: role=receiver rid=%s", m.RID)
 	if err := json.NewEncoder(conn).Encode(awaitMsg); err != nil {
 		conn.Close()
@@ -146,15 +211,53 @@ func (bc *bufferedConn) Read(p []byte) (int, error) {
 
 // WaitForReady waits for and reads the "ready" message from the relay connection
 // Returns the ready message and a buffered reader that preserves any SSH data
-func WaitForReady(conn net.Conn) (*ReadyMessage, *bufio.Reader, error) {
+// A bye from the relay (invite expired, relay restarting) is returned as *framing.CloseError
+// pingInterval is the relay's ping interval in seconds (0 if it doesn't ping);
+// pings are answered, and if they stop the connection is given up on with a
+// keepalive-timeout *framing.CloseError
+func WaitForReady(conn net.Conn, pingInterval int) (*ReadyMessage, *bufio.Reader, error) {
 	br := bufio.NewReader(conn)
-	readyLine, err := br.ReadString('\n')
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read ready message: %w", err)
+	timeout := time.Duration(framing.PingMisses*pingInterval) * time.Second
+	var msg string
+	var line []byte
+	for {
+		if timeout > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		}
+		var err error
+		msg, line, err = readySchemas.ReadFrame(br)
+		if err != nil {
+			if timeout > 0 && errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil, nil, &framing.CloseError{Reason: framing.ReasonKeepaliveTimeout, Message: fmt.Sprintf("no ping from the relay for %s", timeout)}
+			}
+			return nil, nil, fmt.Errorf("bad ready message: %w", err)
+		}
+		if msg != "ping" {
+			break
+		}
+		if err := json.NewEncoder(conn).Encode(framing.PingMessage{Msg: "pong"}); err != nil {
+			return nil, nil, fmt.Errorf("failed to answer ping: %w", err)
+		}
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	switch msg {
+	case "error":
+		var errResp ErrorResponse
+		_ = framing.DecodeStrict(line, &errResp)
+		return nil, nil, &framing.RemoteError{Code: errResp.Error, Message: errResp.Message, RetryAfter: errResp.RetryAfter}
+	case "bye":
+		return nil, nil, decodeBye(line)
 	}
 	var ready ReadyMessage
-	if err := json.Unmarshal([]byte(strings.TrimSpace(readyLine)), &ready); err != nil || ready.Msg != "ready" {
+	if err := framing.DecodeStrict(line, &ready); err != nil {
 		return nil, nil, fmt.Errorf("bad ready message: %w", err)
 	}
 	return &ready, br, nil
 }
+
+// decodeBye turns a bye line from the relay into a *framing.CloseError.
+func decodeBye(line []byte) *framing.CloseError {
+	var bye framing.ByeMessage
+	_ = framing.DecodeStrict(line, &bye)
+	return &framing.CloseError{Reason: bye.Reason, Message: bye.Message, RetryAfter: bye.RetryAfter}
+}
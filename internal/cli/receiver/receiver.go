@@ -13,14 +13,17 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strconv"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/creack/pty"
 	"golang.org/x/crypto/ssh"
 
 	"errors"
+	"ssh-portal/internal/cli/framing"
 	"ssh-portal/internal/cli/usercode"
 	"ssh-portal/internal/version"
 )
@@ -64,6 +67,23 @@ var (
 	reverseTCPIPs  = make(map[string]*ReverseTCPIP)
 )
 
+var (
+	activeConnMu sync.Mutex
+	activeConn   ssh.Conn // SSH connection of the current session, if any
+)
+
+func setActiveConn(c ssh.Conn) {
+	activeConnMu.Lock()
+	defer activeConnMu.Unlock()
+	activeConn = c
+}
+
+func getActiveConn() ssh.Conn {
+	activeConnMu.Lock()
+	defer activeConnMu.Unlock()
+	return activeConn
+}
+
 // GetAllDirectTCPIPs returns all active direct-tcpip forwarding connections
 func GetAllDirectTCPIPs() []*DirectTCPIP {
 	directTCPIPMu.RLock()
@@ -111,7 +131,10 @@ func cleanupConnections() {
 	reverseTCPIPMu.Unlock()
 }
 
-func startSSHServer(relayHost string, relayPort int, enableSession bool, interactive bool, token string) error {
+func startSSHServer(opts ReceiverFlags, policy *forwardPolicy) error {
+	relayHost, relayPort := opts.RelayHost, opts.RelayPort
+	enableSession, interactive := opts.Session, opts.Interactive
+
 	// 1) Generate host key (ephemeral; persist if you want TOFU)
 	_, priv, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
@@ -130,7 +153,7 @@ func startSSHServer(relayHost string, relayPort int, enableSession bool, interac
 	// 2) Connect to relay and perform protocol handshake (hello + await)
 	relayAddr := net.JoinHostPort(relayHost, strconv.Itoa(relayPort))
 	log.Printf("Connecting to relay: %s", relayAddr)
-	connResult, helloResp, err := ConnectToRelay(relayHost, relayPort, fp, token)
+	connResult, helloResp, err := ConnectToRelay(relayHost, relayPort, opts.TLS, fp, opts.Token, opts.CodeWords, opts.Claim, opts.Label)
 	if err != nil {
 		SetError(fmt.Sprintf("relay connection issue: %v", err))
 		log.Printf("relay connection issue: %v", err)
@@ -142,14 +165,21 @@ func startSSHServer(relayHost string, relayPort int, enableSession bool, interac
 	// We'll close it explicitly if we return before SSH is established
 
 	// 3) Generate receiver code and user code, then store state for TUI
-	localSecret, err := usercode.GenerateReceiverCode()
+	// The relay may have raised the requested strength; the local secret must match it
+	localSecret, err := usercode.GenerateReceiverCode(helloResp.CodeWords)
 	if err != nil {
 		SetError(fmt.Sprintf("failed to generate receiver code: %v", err))
 		log.Printf("failed to generate receiver code: %v", err)
 		return err
 	}
 
-	userCode, fullCode, err := usercode.GenerateUserCode(helloResp.Code, localSecret)
+	enc, err := usercode.LookupEncoding(opts.CodeEncoding)
+	if err != nil {
+		SetError(err.Error())
+		log.Printf("%v", err)
+		return err
+	}
+	userCode, fullCode, err := usercode.GenerateUserCode(enc, helloResp.CodeWords, helloResp.Code, localSecret)
 	if err != nil {
 		SetError(fmt.Sprintf("failed to generate user code: %v", err))
 		log.Printf("failed to generate user code: %v", err)
@@ -157,8 +187,11 @@ func startSSHServer(relayHost string, relayPort int, enableSession bool, interac
 	}
 
 	SetState(userCode, helloResp.Code, localSecret, helloResp.RID, fp)
+	shareLink := usercode.FormatShareLink(relayHost, relayPort, opts.TLS, userCode, opts.SenderToken)
+	SetShareLink(shareLink)
 	if !interactive {
 		fmt.Println("Code      :", userCode)
+		fmt.Println("Link      :", shareLink)
 		fmt.Println("RelayCode :", helloResp.Code)
 		fmt.Println("RID       :", helloResp.RID)
 		fmt.Println("FP        :", fp)
@@ -166,11 +199,18 @@ func startSSHServer(relayHost string, relayPort int, enableSession bool, interac
 	}
 
 	// 4) Wait for "ready" message (sender has connected)
-	ready, br, err := WaitForReady(relayConn)
+	ready, br, err := WaitForReady(relayConn, helloResp.PingInterval)
 	if err != nil {
-		SetError(fmt.Sprintf("failed to receive ready message: %v", err))
 		log.Printf("failed to receive ready message: %v", err)
 		ClearState()
+		var bye *framing.CloseError
+		if errors.As(err, &bye) && bye.Reason == framing.ReasonKeepaliveTimeout {
+			SetError(fmt.Sprintf("Lost the relay connection: %v", bye))
+		} else if errors.As(err, &bye) {
+			SetError(fmt.Sprintf("Relay closed the invite: %v", bye))
+		} else {
+			SetError(fmt.Sprintf("failed to receive ready message: %v", err))
+		}
 		relayConn.Close()
 		return err
 	}
@@ -195,7 +235,14 @@ func startSSHServer(relayHost string, relayPort int, enableSession bool, interac
 
 	// 5) Setup SSH server over the connection (now ready for SSH handshake)
 	// Wrap connection with buffered reader to preserve any SSH data that arrived
-	bufferedRelayConn := &bufferedConn{Conn: relayConn, br: br}
+	var bufferedRelayConn net.Conn = &bufferedConn{Conn: relayConn, br: br}
+	// Follow the relay's session limits on our own traffic for the countdown
+	var limits *framing.SessionTimer
+	if l := (framing.SessionLimits{MaxDuration: time.Duration(ready.MaxDuration) * time.Second, IdleTimeout: time.Duration(ready.IdleTimeout) * time.Second}); !l.IsZero() {
+		log.Printf("Relay session limits: %v", l)
+		limits = framing.NewSessionTimer(l, time.Now())
+		bufferedRelayConn = limits.Conn(bufferedRelayConn)
+	}
 
 	cfg := &ssh.ServerConfig{
 		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
@@ -232,6 +279,13 @@ func startSSHServer(relayHost string, relayPort int, enableSession bool, interac
 	}
 	log.Printf("SSH connection established with sender: %s via relay: %s", senderAddr, relayAddr)
 	SetSSHEstablished()
+	setActiveConn(sshConn)
+	defer setActiveConn(nil)
+	closeReason := &framing.CloseRecorder{}
+	SetSessionLimits(limits)
+	limitsDone := make(chan struct{})
+	defer close(limitsDone)
+	go limits.Watch(limitsDone, func(msg string) { log.Printf("%s", msg) })
 
 	// Handle keepalive requests and monitor connection health
 	keepaliveTimeout := 30 * time.Second
@@ -253,7 +307,8 @@ func startSSHServer(relayHost string, relayPort int, enableSession bool, interac
 
 			if time.Since(last) > keepaliveTimeout {
 				log.Printf("Keepalive timeout, sender connection appears dead, closing SSH connection")
-				SetError("Sender connection timeout")
+				closeReason.Record(&framing.CloseError{Reason: framing.ReasonKeepaliveTimeout})
+				_ = framing.SendDisconnect(sshConn, framing.ReasonKeepaliveTimeout)
 				// Close the connection to trigger channel loop exit
 				sshConn.Close()
 				return
@@ -262,7 +317,11 @@ func startSSHServer(relayHost string, relayPort int, enableSession bool, interac
 	}()
 
 	// Handle global requests (remote-forward control and keepalive)
-	go handleGlobal(reqs, sshConn, keepaliveMu, &lastKeepalive)
+	globalDone := make(chan struct{})
+	go func() {
+		defer close(globalDone)
+		handleGlobal(reqs, sshConn, keepaliveMu, &lastKeepalive, closeReason)
+	}()
 
 	// Handle channels - when this loop exits, the connection is closed
 	for ch := range chans {
@@ -281,7 +340,7 @@ func startSSHServer(relayHost string, relayPort int, enableSession bool, interac
 			log.Printf("SSH session channel opened by sender")
 			go handleSession(channel, reqs)
 		case "direct-tcpip":
-			handleDirectTCPIP(ch)
+			handleDirectTCPIP(ch, policy)
 		default:
 			ch.Reject(ssh.UnknownChannelType, "unsupported")
 		}
@@ -289,17 +348,31 @@ func startSSHServer(relayHost string, relayPort int, enableSession bool, interac
 
 	// Channel loop exited - connection closed
 	log.Printf("SSH connection closed, cleaning up")
+	// Global requests drain once the connection is gone; wait so a disconnect
+	// reason sent right before the close is not missed
+	<-globalDone
+
+	// A limit that ran out explains a connection the relay closed
+	limits.RecordExpiry(closeReason)
 
-	// Clean up all connections and state
+	// Clean up all connections and state, then say why the session ended
 	cleanupConnections()
 	ClearState()
+	if reason := closeReason.Reason(); reason != nil {
+		log.Printf("Session ended: %v", reason)
+		SetError(fmt.Sprintf("Session ended: %v", reason))
+	} else {
+		SetError("Session ended: connection to sender lost")
+	}
 
 	// sshConn.Close() is already deferred, which will close the underlying relayConn
 	return errConnectionClosed
 }
 
-// handleDirectTCPIP handles direct-tcpip channel requests (port forwarding)
-func handleDirectTCPIP(ch ssh.NewChannel) {
+// handleDirectTCPIP handles direct-tcpip channel requests (port forwarding).
+// Destinations the forward policy refuses are rejected before the channel is
+// accepted.
+func handleDirectTCPIP(ch ssh.NewChannel, policy *forwardPolicy) {
 	payload := ch.ExtraData()
 	var msg struct {
 		DestAddr   string
@@ -311,6 +384,31 @@ func handleDirectTCPIP(ch ssh.NewChannel) {
 		ch.Reject(ssh.ConnectionFailed, "bad payload")
 		return
 	}
+	dst := net.JoinHostPort(msg.DestAddr, strconv.FormatUint(uint64(msg.DestPort), 10))
+	dsts := []string{dst}
+	if policy != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), forwardResolveTimeout)
+		addrs, err := policy.destinations(ctx, msg.DestAddr, msg.DestPort)
+		cancel()
+		var deniedErr *forwardDeniedError
+		if errors.As(err, &deniedErr) {
+			origin := net.JoinHostPort(msg.OriginAddr, strconv.FormatUint(uint64(msg.OriginPort), 10))
+			log.Printf("[DIRECT-TCPIP] denied: origin=%s -> dest=%s reason=%v", origin, dst, err)
+			RecordForwardDenial(ForwardDenial{Time: time.Now(), Origin: origin, Dest: dst, Reason: err.Error()})
+			ch.Reject(ssh.Prohibited, "denied by the receiver's forward policy: "+err.Error())
+			return
+		}
+		if err != nil {
+			log.Printf("[DIRECT-TCPIP] connection failed: dest=%s error=%v", dst, err)
+			ch.Reject(ssh.ConnectionFailed, err.Error())
+			return
+		}
+		// Connect to the addresses that were checked, not to a new lookup
+		dsts = dsts[:0]
+		for _, a := range addrs {
+			dsts = append(dsts, net.JoinHostPort(a.String(), strconv.FormatUint(uint64(msg.DestPort), 10)))
+		}
+	}
 	channel, reqs, err := ch.Accept()
 	if err != nil {
 		log.Printf("[DIRECT-TCPIP] failed to accept channel: %v", err)
@@ -346,8 +444,12 @@ func handleDirectTCPIP(ch ssh.NewChannel) {
 	log.Printf("[DIRECT-TCPIP] created: id=%s origin=%s:%d -> dest=%s:%d",
 		dtcp.ID, msg.OriginAddr, msg.OriginPort, msg.DestAddr, msg.DestPort)
 
-	dst := net.JoinHostPort(msg.DestAddr, strconv.FormatUint(uint64(msg.DestPort), 10))
-	up, err := net.Dial("tcp", dst)
+	var up net.Conn
+	for _, d := range dsts {
+		if up, err = net.Dial("tcp", d); err == nil {
+			break
+		}
+	}
 	if err != nil {
 		log.Printf("[DIRECT-TCPIP] connection failed: id=%s dest=%s error=%v", dtcp.ID, dst, err)
 		channel.Close()
@@ -411,7 +513,13 @@ func handleSession(ch ssh.Channel, in <-chan *ssh.Request) {
 	for req := range in {
 		switch req.Type {
 		case "pty-req":
-			termEnv, winCols, winRows = parsePtyReq(req.Payload)
+			term, cols, rows, err := parsePtyReq(req.Payload)
+			if err != nil {
+				log.Printf("Rejected %v", err)
+				req.Reply(false, nil)
+				continue
+			}
+			termEnv, winCols, winRows = term, cols, rows
 			ptyRequested = true
 			req.Reply(true, nil)
 
@@ -451,8 +559,13 @@ func handleSession(ch ssh.Channel, in <-chan *ssh.Request) {
 			go sendExitStatus(cmd, ch)
 
 		case "window-change":
+			cols, rows, err := parseWinChg(req.Payload)
+			if err != nil {
+				log.Printf("Ignored %v", err)
+				continue
+			}
+			winCols, winRows = cols, rows
 			if ptyFile != nil {
-				winCols, winRows = parseWinChg(req.Payload)
 				pty.Setsize(ptyFile, &pty.Winsize{
 					Rows: uint16(winRows),
 					Cols: uint16(winCols),
@@ -534,23 +647,45 @@ func unmarshalUint32(b []byte) uint32 {
 	return binary.BigEndian.Uint32(b[:4])
 }
 
-func parsePtyReq(b []byte) (term string, cols, rows uint32) {
+// parsePtyReq parses a pty-req payload (RFC 4254 6.2), rejecting payloads too
+// short to hold the terminal name and dimensions.
+func parsePtyReq(b []byte) (term string, cols, rows uint32, err error) {
 	// string term; uint32 cols, rows, px, py; string modes
-	term, rest, _ := unmarshalString(b)
+	term, rest, err := unmarshalString(b)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("pty-req: %w", err)
+	}
+	if len(rest) < 8 {
+		return "", 0, 0, fmt.Errorf("pty-req: insufficient data for dimensions")
+	}
 	cols = unmarshalUint32(rest)
 	rows = unmarshalUint32(rest[4:])
-	return term, cols, rows
+	return term, cols, rows, nil
 }
 
-func parseWinChg(b []byte) (cols, rows uint32) {
+// parseWinChg parses a window-change payload (RFC 4254 6.7).
+func parseWinChg(b []byte) (cols, rows uint32, err error) {
+	if len(b) < 8 {
+		return 0, 0, fmt.Errorf("window-change: insufficient data for dimensions")
+	}
 	cols = unmarshalUint32(b)
 	rows = unmarshalUint32(b[4:])
-	return cols, rows
+	return cols, rows, nil
 }
 
-func handleGlobal(reqs <-chan *ssh.Request, conn *ssh.ServerConn, keepaliveMu *sync.Mutex, lastKeepalive *time.Time) {
+func handleGlobal(reqs <-chan *ssh.Request, conn *ssh.ServerConn, keepaliveMu *sync.Mutex, lastKeepalive *time.Time, closeReason *framing.CloseRecorder) {
 	for req := range reqs {
 		switch req.Type {
+		case framing.DisconnectRequest:
+			// Sender is about to close; remember why
+			reason, err := framing.ParseDisconnect(req.Payload)
+			if err != nil {
+				log.Printf("bad %s payload: %v", framing.DisconnectRequest, err)
+				continue
+			}
+			log.Printf("Sender disconnecting: %v", reason)
+			closeReason.Record(reason)
+			continue
 		case "keepalive@ssh-portal":
 			// Handle keepalive request
 			keepaliveMu.Lock()
@@ -683,23 +818,39 @@ func handleGlobal(reqs <-chan *ssh.Request, conn *ssh.ServerConn, keepaliveMu *s
 	}
 }
 
-// Run executes the receiver command
-func Run(relayHost string, relayPort int, interactive bool, session bool, logView bool, token string) error {
+// Run executes the receiver command with the merged config/flag values
+func Run(opts ReceiverFlags) error {
 	log.Printf("Starting receiver version %s", version.String())
-	ctx, cancel := context.WithCancel(context.Background())
+	// Fail fast on a bad encoding rather than in the restart loop
+	if _, err := usercode.LookupEncoding(opts.CodeEncoding); err != nil {
+		return err
+	}
+	policy, err := newForwardPolicy(opts.ForwardPolicy)
+	if err != nil {
+		return err
+	}
+	if policy != nil {
+		log.Printf("Forward policy: %v", opts.ForwardPolicy)
+	}
+	SetForwardPolicy(opts.ForwardPolicy)
+	// SIGINT/SIGTERM shut down like quitting the TUI, so the peer learns why
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(sigCtx)
 	defer cancel()
 
 	var tuiDone <-chan struct{}
-	if interactive {
+	if opts.Interactive {
 		// Start TUI for interactive mode
 		var err error
-		tuiDone, err = startTUI(ctx, cancel, logView)
+		tuiDone, err = startTUI(ctx, cancel, opts.LogView)
 		if err != nil {
 			return fmt.Errorf("failed to start TUI: %w", err)
 		}
 	}
 
 	// Start SSH server in a goroutine with restart loop
+	var claimErr error
 	go func() {
 		for {
 			select {
@@ -707,7 +858,22 @@ func Run(relayHost string, relayPort int, interactive bool, session bool, logVie
 				log.Printf("Context cancelled, stopping receiver")
 				return
 			default:
-				err := startSSHServer(relayHost, relayPort, session, interactive, token)
+				err := startSSHServer(opts, policy)
+				if opts.Claim != "" {
+					// A claim token works once: a rejected claim will not get better by
+					// retrying, and once the claimed invite is used up or closed,
+					// later restarts mint ordinary invites
+					var remote *framing.RemoteError
+					var bye *framing.CloseError
+					if errors.As(err, &remote) && remote.Code == "invalid-claim" {
+						claimErr = fmt.Errorf("the relay rejected the claim token (unknown, already used or expired): %w", err)
+						cancel()
+						return
+					}
+					if err == nil || err == errConnectionClosed || errors.As(err, &bye) {
+						opts.Claim = ""
+					}
+				}
 				if err == nil {
 					// Should not happen, but if it does, exit
 					log.Printf("SSH server returned without error, exiting")
@@ -721,9 +887,18 @@ func Run(relayHost string, relayPort int, interactive bool, session bool, logVie
 					continue
 				}
 
-				// Other errors - log and retry after delay
-				log.Printf("SSH server error: %v, retrying in 10 seconds...", err)
-				time.Sleep(10 * time.Second)
+				// A draining or rate-limiting relay says when to come back; otherwise
+				// retry after a fixed delay
+				retry := 10 * time.Second
+				var bye *framing.CloseError
+				var remote *framing.RemoteError
+				if errors.As(err, &bye) && bye.RetryAfter > 0 {
+					retry = time.Duration(bye.RetryAfter) * time.Second
+				} else if errors.As(err, &remote) && remote.RetryAfter > 0 {
+					retry = time.Duration(remote.RetryAfter) * time.Second
+				}
+				log.Printf("SSH server error: %v, retrying in %s...", err, retry)
+				time.Sleep(retry)
 			}
 		}
 	}()
@@ -731,11 +906,17 @@ func Run(relayHost string, relayPort int, interactive bool, session bool, logVie
 	// Wait for shutdown signal
 	<-ctx.Done()
 
+	// Let a connected sender know the receiver is going away
+	if conn := getActiveConn(); conn != nil {
+		_ = framing.SendDisconnect(conn, framing.ReasonReceiverClosed)
+		conn.Close()
+	}
+
 	// If TUI was running, wait for it to finish cleaning up the terminal
 	if tuiDone != nil {
 		<-tuiDone
 	}
 
 	log.Printf("receiver shutting down...")
-	return nil
+	return claimErr
 }
@@ -0,0 +1,57 @@
+package receiver
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// ptyReqPayload builds a pty-req payload as sent by OpenSSH.
+func ptyReqPayload(term string, cols, rows uint32) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(term)))
+	b = append(b, term...)
+	for _, v := range []uint32{cols, rows, 0, 0} {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return append(binary.BigEndian.AppendUint32(b, 1), 0) // modes: TTY_OP_END
+}
+
+func FuzzParsePtyReq(f *testing.F) {
+	f.Add(ptyReqPayload("xterm-256color", 120, 40))
+	f.Add(ptyReqPayload("", 0, 0))
+	f.Add([]byte{0, 0, 0, 5, 'x', 't', 'e', 'r', 'm'})
+	f.Add([]byte{0, 0, 0, 0, 0, 0, 0, 80})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		term, cols, rows, err := parsePtyReq(b)
+		if err != nil {
+			return
+		}
+		// An accepted payload holds the length-prefixed term and both dimensions
+		if need := 4 + len(term) + 8; len(b) < need {
+			t.Fatalf("accepted %d-byte payload, need at least %d", len(b), need)
+		}
+		if got := binary.BigEndian.Uint32(b[4+len(term):]); got != cols {
+			t.Fatalf("cols = %d, want %d", cols, got)
+		}
+		if got := binary.BigEndian.Uint32(b[8+len(term):]); got != rows {
+			t.Fatalf("rows = %d, want %d", rows, got)
+		}
+	})
+}
+
+func FuzzParseWinChg(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 120, 0, 0, 0, 40, 0, 0, 0, 0, 0, 0, 0, 0})
+	f.Add([]byte{0, 0, 0, 80, 0, 0, 0})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		cols, rows, err := parseWinChg(b)
+		if (err == nil) != (len(b) >= 8) {
+			t.Fatalf("len %d: err = %v", len(b), err)
+		}
+		if err == nil && (cols != binary.BigEndian.Uint32(b) || rows != binary.BigEndian.Uint32(b[4:])) {
+			t.Fatalf("got %dx%d from %x", cols, rows, b[:8])
+		}
+	})
+}
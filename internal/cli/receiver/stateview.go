@@ -2,12 +2,15 @@ package receiver
 
 import (
 	"fmt"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/table"
 	"github.com/charmbracelet/lipgloss"
 
+	"ssh-portal/internal/cli/framing"
 	"ssh-portal/internal/cli/tui"
 )
 
@@ -19,9 +22,11 @@ type ReceiverState struct {
 	LocalSecret    string // Locally generated secret (not displayed)
 	RID            string
 	FP             string
-	SenderAddr     string // Sender address from ready message
-	SenderIdentity string // Sender identity from ready message
-	SSHEstablished bool   // Whether SSH connection is established
+	SenderAddr     string                // Sender address from ready message
+	SenderIdentity string                // Sender identity from ready message
+	SSHEstablished bool                  // Whether SSH connection is established
+	ShareLink      string                // ssh-portal:// link for the user code
+	Limits         *framing.SessionTimer // Session limits set by the relay, nil if none
 	Error          string
 }
 
@@ -40,6 +45,8 @@ func GetState() *ReceiverState {
 		SenderAddr:     currentState.SenderAddr,
 		SenderIdentity: currentState.SenderIdentity,
 		SSHEstablished: currentState.SSHEstablished,
+		ShareLink:      currentState.ShareLink,
+		Limits:         currentState.Limits,
 		Error:          currentState.Error,
 	}
 }
@@ -63,6 +70,13 @@ func SetState(userCode, relayCode, localSecret, rid, fp string) {
 	currentState.Error = ""             // Clear error on successful connection
 }
 
+// SetShareLink stores the share link for the current user code
+func SetShareLink(link string) {
+	currentState.mu.Lock()
+	defer currentState.mu.Unlock()
+	currentState.ShareLink = link
+}
+
 // SetSenderAddr stores the sender address from the ready message
 func SetSenderAddr(addr string) {
 	currentState.mu.Lock()
@@ -84,6 +98,13 @@ func SetSSHEstablished() {
 	currentState.SSHEstablished = true
 }
 
+// SetSessionLimits stores the limits of the current session for the countdown
+func SetSessionLimits(t *framing.SessionTimer) {
+	currentState.mu.Lock()
+	defer currentState.mu.Unlock()
+	currentState.Limits = t
+}
+
 // SetError sets an error message in the state
 func SetError(err string) {
 	currentState.mu.Lock()
@@ -103,9 +124,61 @@ func ClearState() {
 	currentState.SenderAddr = ""
 	currentState.SenderIdentity = ""
 	currentState.SSHEstablished = false
+	currentState.ShareLink = ""
+	currentState.Limits = nil
 	currentState.Error = ""
 }
 
+// ForwardDenial is a direct-tcpip forward refused by the forward policy
+type ForwardDenial struct {
+	Time   time.Time
+	Origin string // host:port on the sender's side
+	Dest   string // host:port the sender asked for
+	Reason string
+}
+
+const (
+	maxForwardDenials   = 50 // refused forwards kept for the TUI
+	shownForwardDenials = 3  // refused forwards listed under the forwards table
+)
+
+var (
+	forwardMu          sync.RWMutex
+	forwardPolicyDesc  string          // summary of the forward policy, "" without one
+	forwardDenials     []ForwardDenial // oldest first
+	forwardDenialCount int
+)
+
+// SetForwardPolicy records the forward policy, for the TUI
+func SetForwardPolicy(p ForwardPolicy) {
+	forwardMu.Lock()
+	defer forwardMu.Unlock()
+	forwardPolicyDesc = ""
+	if !p.Empty() {
+		forwardPolicyDesc = p.String()
+	}
+}
+
+// RecordForwardDenial records a forward the policy refused, for the TUI
+func RecordForwardDenial(d ForwardDenial) {
+	forwardMu.Lock()
+	defer forwardMu.Unlock()
+	if len(forwardDenials) == maxForwardDenials {
+		forwardDenials = slices.Delete(forwardDenials, 0, 1)
+	}
+	forwardDenials = append(forwardDenials, d)
+	forwardDenialCount++
+}
+
+// GetForwardPolicy returns the summary of the forward policy ("" without
+// one), the latest forwards it refused, oldest first, and how many it refused
+// in all
+func GetForwardPolicy() (policy string, latest []ForwardDenial, total int) {
+	forwardMu.RLock()
+	defer forwardMu.RUnlock()
+	return forwardPolicyDesc, slices.Clone(forwardDenials), forwardDenialCount
+}
+
 // NewForwardsTable creates and returns a table.Model configured for DirectTCPIP forwards
 func NewForwardsTable(width, height int) table.Model {
 	if width < 20 {
@@ -273,6 +346,9 @@ func RenderLeftPaneContent(width int, sp spinner.Model, connectedSp spinner.Mode
 			waitingStyle := lipgloss.NewStyle().
 				Foreground(lipgloss.Color("62"))
 			content += "\n\n" + spinnerView + " " + waitingStyle.Render("Waiting for SSH connection...")
+			if state.ShareLink != "" {
+				content += "\n\n" + infoStyle.Render("Press 's' to share (QR code + copy link)")
+			}
 		} else {
 			if state.SenderIdentity != "" {
 				identityStyle := lipgloss.NewStyle().
@@ -289,6 +365,7 @@ func RenderLeftPaneContent(width int, sp spinner.Model, connectedSp spinner.Mode
 					Bold(true)
 				content += "\n" + connectedSpinnerView + " " + connectedStyle.Render("Connected to: ") + addressStyle.Render(state.SenderAddr)
 			}
+			content += tui.RenderSessionLimits(state.Limits)
 		}
 	}
 
@@ -324,6 +401,29 @@ func RenderRightPaneContent(width int, forwardsTable table.Model) string {
 	return content
 }
 
+// RenderForwardPolicy renders the forward policy and the latest forwards it
+// refused, or "" without a policy
+func RenderForwardPolicy(width int) string {
+	policy, denials, total := GetForwardPolicy()
+	if policy == "" {
+		return ""
+	}
+
+	infoStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("240")).
+		MaxWidth(width)
+
+	deniedStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("196")). // Bright red
+		MaxWidth(width)
+
+	lines := []string{infoStyle.Render(fmt.Sprintf("Policy: %s | Denied: %d", policy, total))}
+	for _, d := range denials[max(len(denials)-shownForwardDenials, 0):] {
+		lines = append(lines, deniedStyle.Render(fmt.Sprintf("%s denied %s from %s: %s", d.Time.Format("15:04:05"), d.Dest, d.Origin, d.Reason)))
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, lines...)
+}
+
 // NewReverseForwardsTable creates and returns a table.Model for reverse (tcpip-forward) entries
 func NewReverseForwardsTable(width, height int) table.Model {
 	if width < 20 {
@@ -37,6 +37,8 @@ type receiverTUIModel struct {
 	height               int
 	ready                bool
 	showLogView          bool
+	showShare            bool   // full-screen QR code of the share link
+	shareStatus          string // result of the last clipboard copy
 }
 
 func newReceiverTUIModel(logWriter *tui.LogTailWriter, cancel context.CancelFunc, showLogView bool) *receiverTUIModel {
@@ -86,6 +88,30 @@ func (m *receiverTUIModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.cancel()
 			}
 			return m, tea.Quit
+		case "s":
+			// Toggle the share view; copy the link each time it opens
+			if m.showShare {
+				m.showShare = false
+				return m, nil
+			}
+			state := GetState()
+			if state.ShareLink == "" || state.SSHEstablished {
+				return m, nil
+			}
+			link := state.ShareLink
+			m.showShare = true
+			if err := tui.CopyToClipboard(link); err != nil {
+				m.shareStatus = "Could not copy link: " + err.Error()
+			} else {
+				m.shareStatus = "Link copied to clipboard"
+			}
+			log.Printf("Share link: %s", link)
+			return m, nil
+		case "esc":
+			if m.showShare {
+				m.showShare = false
+				return m, nil
+			}
 		}
 
 	case tea.WindowSizeMsg:
@@ -166,6 +192,10 @@ func (m *receiverTUIModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 
 	case updateTopContentMsg:
+		// The link is spent once the sender connected or the receiver restarted
+		if state := GetState(); m.showShare && (state.ShareLink == "" || state.SSHEstablished) {
+			m.showShare = false
+		}
 		m.updateTopContent()
 		return m, tea.Tick(time.Millisecond*200, func(time.Time) tea.Msg {
 			return updateTopContentMsg{}
@@ -228,6 +258,11 @@ func (m *receiverTUIModel) updateTopContent() {
 		tableWidth = 20
 	}
 	availableTableHeight := m.rightViewport.Height - 4
+	// The forward policy and its latest denials go under the forwards table
+	policyView := RenderForwardPolicy(tableWidth)
+	if policyView != "" {
+		availableTableHeight -= 1 + shownForwardDenials
+	}
 	if availableTableHeight < 6 {
 		availableTableHeight = 6
 	}
@@ -253,15 +288,20 @@ func (m *receiverTUIModel) updateTopContent() {
 	if reverseView == "" {
 		reverseView = "  No reverse forwards"
 	}
-	rightContent := lipgloss.JoinVertical(
-		lipgloss.Left,
+	parts := []string{
 		headerDirect,
 		"", // Blank line between R->L header and table
 		directView,
+	}
+	if policyView != "" {
+		parts = append(parts, policyView)
+	}
+	parts = append(parts,
 		headerReverse,
 		"", // Blank line between L->R header and table
 		reverseView,
 	)
+	rightContent := lipgloss.JoinVertical(lipgloss.Left, parts...)
 	m.rightViewport.SetContent(rightContent)
 }
 
@@ -273,6 +313,11 @@ func (m *receiverTUIModel) View() string {
 	// Header spans full width
 	header := tui.RenderTitleBar("Receiver", m.width-2)
 
+	if m.showShare {
+		shareView := tui.RenderShareView(m.width-2, m.height-lipgloss.Height(header), GetState().ShareLink, m.shareStatus)
+		return lipgloss.JoinVertical(lipgloss.Left, header, shareView)
+	}
+
 	// Invisible borders to maintain spacing
 	splitStyle := lipgloss.NewStyle().
 		Border(lipgloss.HiddenBorder())
@@ -381,6 +426,11 @@ func startTUI(ctx context.Context, cancel context.CancelFunc, showLogView bool)
 			log.SetOutput(originalOutput)
 			close(done)
 		}()
+		// Quit when the receiver stops on its own (signal, rejected claim)
+		go func() {
+			<-ctx.Done()
+			p.Quit()
+		}()
 		if _, err := p.Run(); err != nil {
 			log.Printf("TUI error: %v", err)
 		}
@@ -1,6 +1,11 @@
 package cli
 
 import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
 	"github.com/spf13/cobra"
 
 	"ssh-portal/internal/cli/relay"
@@ -8,9 +13,38 @@ import (
 
 var (
 	relayPort          int
+	relayListen        []string
 	relayInteractive   bool
 	relayReceiverToken string
 	relaySenderToken   string
+	relayCodeWords     int
+	relayMinClient     string
+	relayDrainTimeout  time.Duration
+	relayHealthAddr    string
+	relayDashboard     bool
+	relayDashToken     string
+	relayAdminSocket   string
+	relayAPIAddr       string
+	relayAPIToken      string
+	relayPublicHost    string
+	relayWebhooks      []string
+	relayWebhookSecret string
+	relayExecHooks     []string
+	relayAuditLog      string
+	relayAuditMaxSize  int64
+	relayAuditRotate   time.Duration
+	relaySpliceRetain  time.Duration
+	relayBandwidth     string
+	relaySpliceBW      string
+	relayMaxSplice     time.Duration
+	relaySpliceIdle    time.Duration
+	relayInviteTTL     time.Duration
+	relayMaxInviteTTL  time.Duration
+	relayPingInterval  time.Duration
+	auditSince         string
+	auditUntil         string
+	auditFormat        string
+	auditEvents        []string
 )
 
 var relayCmd = &cobra.Command{
@@ -19,20 +53,180 @@ var relayCmd = &cobra.Command{
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Load relay config and merge with flags
 		cfg := relay.LoadRelayConfig()
-		merged := relay.MergeRelayFlags(cmd, cfg, relay.RelayFlags{
-			Port:          relayPort,
-			Interactive:   relayInteractive,
-			ReceiverToken: relayReceiverToken,
-			SenderToken:   relaySenderToken,
-		})
-
-		return relay.Run(merged.Port, merged.Interactive, merged.ReceiverToken, merged.SenderToken)
+		var listen []relay.ListenerConfig
+		for _, addr := range relayListen {
+			listen = append(listen, relay.ListenerConfig{Addr: addr})
+		}
+		flags := relay.RelayFlags{
+			Port:             relayPort,
+			Listen:           listen,
+			Interactive:      relayInteractive,
+			ReceiverToken:    relayReceiverToken,
+			SenderToken:      relaySenderToken,
+			CodeWords:        relayCodeWords,
+			MinClientVersion: relayMinClient,
+			DrainTimeout:     relayDrainTimeout,
+			HealthAddr:       relayHealthAddr,
+			Dashboard:        relayDashboard,
+			DashboardToken:   relayDashToken,
+			AdminSocket:      relayAdminSocket,
+			APIAddr:          relayAPIAddr,
+			APIToken:         relayAPIToken,
+			PublicHost:       relayPublicHost,
+			WebhookURLs:      relayWebhooks,
+			WebhookSecret:    relayWebhookSecret,
+			ExecHooks:        relayExecHooks,
+			AuditLog:         relayAuditLog,
+			AuditMaxSize:     relayAuditMaxSize << 20,
+			AuditRotate:      relayAuditRotate,
+			SpliceRetention:  relaySpliceRetain,
+			Bandwidth:        relay.BandwidthConfig{Global: relayBandwidth, PerSplice: relaySpliceBW},
+			MaxSplice:        relayMaxSplice,
+			SpliceIdle:       relaySpliceIdle,
+			InviteTTL:        relayInviteTTL,
+			MaxInviteTTL:     relayMaxInviteTTL,
+			PingInterval:     relayPingInterval,
+		}
+		merged := relay.MergeRelayFlags(cmd, cfg, flags)
+		// Flags keep overriding the config file across reloads
+		merged.Reload = func() (relay.RelayFlags, error) {
+			cfg, err := relay.ReloadRelayConfig()
+			if err != nil {
+				return relay.RelayFlags{}, err
+			}
+			return relay.MergeRelayFlags(cmd, cfg, flags), nil
+		}
+
+		return relay.Run(merged)
+	},
+}
+
+var relayCtlCmd = &cobra.Command{
+	Use:   "ctl",
+	Short: "Control a running relay through its admin socket",
+}
+
+var relayAuditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Query the relay audit log",
+	Long:  "Print audit log records (including rotated files) as CSV or JSON, e.g. for a quarterly \"who connected to what and when\" report.",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		merged := relay.MergeRelayFlags(cmd, relay.LoadRelayConfig(), relay.RelayFlags{AuditLog: relayAuditLog})
+		if merged.AuditLog == "" {
+			return fmt.Errorf("no audit log configured (set relay.audit-log or --audit-log)")
+		}
+		q := relay.AuditQuery{Path: merged.AuditLog, Events: auditEvents, Format: auditFormat}
+		var err error
+		if auditSince != "" {
+			if q.Since, err = relay.ParseAuditTime(auditSince); err != nil {
+				return err
+			}
+		}
+		if auditUntil != "" {
+			if q.Until, err = relay.ParseAuditTime(auditUntil); err != nil {
+				return err
+			}
+		}
+		cmd.SilenceUsage = true
+		return relay.RunAudit(q, os.Stdout)
 	},
 }
 
+// ctlCommand returns a relay ctl subcommand that sends the request built from its args
+func ctlCommand(use, short string, args cobra.PositionalArgs, build func(args []string) (relay.AdminRequest, error)) *cobra.Command {
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  args,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			req, err := build(args)
+			if err != nil {
+				return err
+			}
+			merged := relay.MergeRelayFlags(cmd, relay.LoadRelayConfig(), relay.RelayFlags{AdminSocket: relayAdminSocket})
+			if merged.AdminSocket == "" {
+				return fmt.Errorf("no admin socket configured")
+			}
+			cmd.SilenceUsage = true
+			return relay.RunCtl(merged.AdminSocket, req)
+		},
+	}
+}
+
+// parseExtension accepts a duration ("15m") or a number of seconds
+func parseExtension(s string) (int, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid extension %q (want a duration like 15m or seconds)", s)
+	}
+	return int(d / time.Second), nil
+}
+
 func init() {
 	relayCmd.Flags().IntVar(&relayPort, "port", 0, "TCP port for relay server")
+	relayCmd.Flags().StringArrayVar(&relayListen, "listen", nil, "listen address for receivers and senders, e.g. 0.0.0.0:4430 or [::]:4430 (repeatable; replaces the configured listeners, default every address on --port)")
 	relayCmd.Flags().BoolVar(&relayInteractive, "interactive", true, "interactive mode")
 	relayCmd.Flags().StringVar(&relayReceiverToken, "receiver-token", "", "optional token that receivers must provide in hello messages")
 	relayCmd.Flags().StringVar(&relaySenderToken, "sender-token", "", "optional token that senders must provide in hello messages")
+	relayCmd.Flags().IntVar(&relayCodeWords, "code-words", 0, "minimum code strength in words (4, 6 or 8); raised automatically for long TTLs and many invites")
+	relayCmd.Flags().StringVar(&relayMinClient, "min-client-version", "", "reject receivers and senders older than this version (e.g. 1.4.0) with upgrade-required")
+	relayCmd.Flags().DurationVar(&relayDrainTimeout, "drain-timeout", 0, "how long a drain (SIGTERM or POST /drain) waits for active sessions before closing them (default 30m)")
+	relayCmd.PersistentFlags().StringVar(&relayAdminSocket, "admin-socket", "", "path of the admin unix socket used by 'relay ctl' (default ~/.ssh-portal-relay.sock; empty disables it)")
+	relayCmd.Flags().StringVar(&relayAPIAddr, "api-addr", "", "listen address for the invite HTTP API (e.g. 127.0.0.1:4432); disabled if empty")
+	relayCmd.Flags().StringVar(&relayAPIToken, "api-token", "", "bearer token required by the invite HTTP API")
+	relayCmd.Flags().StringVar(&relayPublicHost, "public-host", "", "relay host name put in the receiver commands returned by the invite API (default: the API request's host)")
+	relayCmd.Flags().StringArrayVar(&relayWebhooks, "webhook", nil, "URL to POST relay events to as JSON (repeatable; added to the configured hooks)")
+	relayCmd.Flags().StringVar(&relayWebhookSecret, "webhook-secret", "", "secret used to sign --webhook deliveries (HMAC-SHA256 in X-SSH-Portal-Signature)")
+	relayCmd.Flags().StringArrayVar(&relayExecHooks, "exec-hook", nil, "command run through /bin/sh with each relay event as JSON on stdin (repeatable)")
+	relayCmd.PersistentFlags().StringVar(&relayAuditLog, "audit-log", "", "path of the JSONL audit log of invites, auth failures and sessions; disabled if empty")
+	relayCmd.Flags().Int64Var(&relayAuditMaxSize, "audit-max-size", 0, "rotate the audit log when it reaches this many MiB, 0 for no limit (default 100)")
+	relayCmd.Flags().DurationVar(&relayAuditRotate, "audit-rotate", 0, "rotate the audit log after this long, 0 for never (default 24h)")
+	relayCmd.Flags().DurationVar(&relaySpliceRetain, "splice-retention", 0, "how long closed sessions stay listed in memory (default 1h)")
+	relayCmd.Flags().StringVar(&relayBandwidth, "bandwidth", "", "cap on all relayed traffic, shared fairly between sessions (e.g. 100Mbit/s); unlimited if empty")
+	relayCmd.Flags().StringVar(&relaySpliceBW, "splice-bandwidth", "", "cap on each session's traffic (e.g. 10MB/s); unlimited if empty")
+	relayCmd.Flags().DurationVar(&relayMaxSplice, "max-splice-duration", 0, "close sessions after this long (e.g. 2h); endpoints are warned before; 0 for no limit")
+	relayCmd.Flags().DurationVar(&relaySpliceIdle, "splice-idle-timeout", 0, "close sessions idle for this long (e.g. 15m); endpoints are warned before; 0 for no limit")
+	relayCmd.Flags().DurationVar(&relayInviteTTL, "invite-ttl", 0, "TTL of invites minted for receivers that don't ask for one (default 10m)")
+	relayCmd.Flags().DurationVar(&relayMaxInviteTTL, "max-invite-ttl", 0, "longest invite TTL a receiver may ask for; longer requests get the default (default 1h)")
+	relayCmd.Flags().DurationVar(&relayPingInterval, "ping-interval", 0, "how often receivers waiting for a sender are pinged; 0 disables pings (default 15s)")
+	relayCmd.Flags().StringVar(&relayHealthAddr, "health-addr", "", "listen address for the /healthz, /readyz and /drain HTTP endpoints (e.g. 127.0.0.1:4431); disabled if empty")
+	relayCmd.Flags().BoolVar(&relayDashboard, "dashboard", false, "serve a read-only web dashboard at /dashboard/ on the health-addr listener")
+	relayCmd.Flags().StringVar(&relayDashToken, "dashboard-token", "", "bearer token that unlocks revoke and kill buttons in the dashboard; read-only if empty")
+
+	relayCtlCmd.AddCommand(
+		ctlCommand("list", "List outstanding invites, splices and throttled or banned addresses", cobra.NoArgs, func(args []string) (relay.AdminRequest, error) {
+			return relay.AdminRequest{Cmd: relay.AdminList}, nil
+		}),
+		ctlCommand("revoke <rid|code>", "Revoke an outstanding invite and disconnect its receiver", cobra.ExactArgs(1), func(args []string) (relay.AdminRequest, error) {
+			return relay.AdminRequest{Cmd: relay.AdminRevoke, ID: args[0]}, nil
+		}),
+		ctlCommand("kill <splice-id|code>", "Close an active splice", cobra.ExactArgs(1), func(args []string) (relay.AdminRequest, error) {
+			return relay.AdminRequest{Cmd: relay.AdminKill, ID: args[0]}, nil
+		}),
+		ctlCommand("extend <rid|code> <duration>", "Extend an invite's TTL (e.g. 15m)", cobra.ExactArgs(2), func(args []string) (relay.AdminRequest, error) {
+			secs, err := parseExtension(args[1])
+			return relay.AdminRequest{Cmd: relay.AdminExtend, ID: args[0], Seconds: secs}, err
+		}),
+		ctlCommand("unban [ip|subnet]", "Lift the ban and rate limits of an address or subnet, or of all", cobra.MaximumNArgs(1), func(args []string) (relay.AdminRequest, error) {
+			req := relay.AdminRequest{Cmd: relay.AdminUnban}
+			if len(args) == 1 {
+				req.IP = args[0]
+			}
+			return req, nil
+		}),
+		ctlCommand("drain", "Start draining the relay for a restart", cobra.NoArgs, func(args []string) (relay.AdminRequest, error) {
+			return relay.AdminRequest{Cmd: relay.AdminDrain}, nil
+		}),
+	)
+	relayCmd.AddCommand(relayCtlCmd)
+
+	relayAuditCmd.Flags().StringVar(&auditSince, "since", "", "only records at or after this time: a duration back from now (2160h), a date (2024-01-01) or an RFC 3339 timestamp")
+	relayAuditCmd.Flags().StringVar(&auditUntil, "until", "", "only records at or before this time (same formats as --since)")
+	relayAuditCmd.Flags().StringVar(&auditFormat, "format", "csv", "output format: csv or json")
+	relayAuditCmd.Flags().StringSliceVar(&auditEvents, "event", nil, "only these events (e.g. splice.closed,auth.failed)")
+	relayCmd.AddCommand(relayAuditCmd)
 }
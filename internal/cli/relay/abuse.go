@@ -0,0 +1,610 @@
+package relay
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ssh-portal/internal/cli/framing"
+)
+
+// Abuse protection: every address, and the subnet it belongs to (/24 for
+// IPv4, /64 for IPv6), gets token buckets for handshakes, invite mints,
+// invite inspections and failures (unknown codes, RIDs and claims, wrong tokens). A request over a
+// limit is answered at once with a rate-limited error and a retry hint
+// instead of being held open. Addresses that keep running into limits are
+// banned for a while; their connections are closed as soon as they are
+// accepted, as are connections over the pending-handshake caps.
+
+// Kinds of limits, as shown in the throttle table
+const (
+	limitHandshakes = "handshakes"
+	limitMints      = "mints"
+	limitInspects   = "inspects"
+	limitFailures   = "failures"
+	limitPending    = "pending" // concurrent connections still in their handshake
+	limitACL        = "acl"     // connections from networks the access lists refuse
+	limitBanned     = "banned"  // connections from banned addresses (metrics only)
+)
+
+const (
+	strikeWindow    = 10 * time.Minute // refusals older than this are forgotten
+	maxTrackedAddrs = 100000           // buckets, and refused addresses, kept; more evict one
+	evictSample     = 8                // entries looked at to find one to evict
+)
+
+// RateLimitConfig limits one kind of request per address and per subnet.
+// Rates are strings like "30/min", "5/s" or "100/h"; the count is also the
+// burst. Empty means the default, "off" means no limit.
+type RateLimitConfig struct {
+	PerIP     string `yaml:"per-ip,omitempty" mapstructure:"per-ip,omitempty"`
+	PerSubnet string `yaml:"per-subnet,omitempty" mapstructure:"per-subnet,omitempty"`
+}
+
+// AbuseConfig configures the relay's abuse protection. Zero values take the
+// defaults; a negative cap or ban-after turns that protection off.
+type AbuseConfig struct {
+	Handshakes      RateLimitConfig `yaml:"handshakes,omitempty" mapstructure:"handshakes,omitempty"`                 // every hello and await
+	Mints           RateLimitConfig `yaml:"mints,omitempty" mapstructure:"mints,omitempty"`                           // invites minted by receiver hellos
+	Inspects        RateLimitConfig `yaml:"inspects,omitempty" mapstructure:"inspects,omitempty"`                     // invites inspected by senders
+	Failures        RateLimitConfig `yaml:"failures,omitempty" mapstructure:"failures,omitempty"`                     // unknown codes, RIDs and claims, wrong tokens
+	MaxPending      int             `yaml:"max-pending,omitempty" mapstructure:"max-pending,omitempty"`               // connections still in their handshake, relay-wide
+	MaxPendingPerIP int             `yaml:"max-pending-per-ip,omitempty" mapstructure:"max-pending-per-ip,omitempty"` // the same, per address
+	BanAfter        int             `yaml:"ban-after,omitempty" mapstructure:"ban-after,omitempty"`                   // refusals within 10 minutes that get an address banned
+	BanDuration     string          `yaml:"ban-duration,omitempty" mapstructure:"ban-duration,omitempty"`             // how long a ban lasts
+}
+
+var defaultAbuseConfig = AbuseConfig{
+	Handshakes:      RateLimitConfig{PerIP: "120/min", PerSubnet: "1200/min"},
+	Mints:           RateLimitConfig{PerIP: "20/min", PerSubnet: "200/min"},
+	Inspects:        RateLimitConfig{PerIP: "30/min", PerSubnet: "300/min"},
+	Failures:        RateLimitConfig{PerIP: "10/min", PerSubnet: "50/min"},
+	MaxPending:      1024,
+	MaxPendingPerIP: 32,
+	BanAfter:        20,
+	BanDuration:     "15m",
+}
+
+// requestRate is a parsed rate limit: count requests per period, with a burst of count.
+type requestRate struct {
+	count  float64
+	period time.Duration
+}
+
+func (r *requestRate) perSecond() float64 {
+	return r.count / r.period.Seconds()
+}
+
+func (r *requestRate) String() string {
+	unit := map[time.Duration]string{time.Second: "s", time.Minute: "min", time.Hour: "h"}[r.period]
+	return strconv.FormatFloat(r.count, 'g', -1, 64) + "/" + unit
+}
+
+// parseRequestRate parses a rate like "30/min", "5/s" or "100/h"; "off"
+// returns nil.
+func parseRequestRate(s string) (*requestRate, error) {
+	if strings.TrimSpace(s) == "off" {
+		return nil, nil
+	}
+	num, unit, ok := strings.Cut(strings.TrimSpace(s), "/")
+	period := map[string]time.Duration{"s": time.Second, "sec": time.Second, "m": time.Minute, "min": time.Minute, "h": time.Hour, "hour": time.Hour}[strings.TrimSpace(unit)]
+	v, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if !ok || period == 0 || err != nil || v < 1 || math.IsInf(v, 0) {
+		return nil, fmt.Errorf("invalid request rate %q (want e.g. 30/min, 5/s or 100/h, or off)", s)
+	}
+	return &requestRate{count: v, period: period}, nil
+}
+
+// requestBucket holds the tokens of one address or subnet for one kind of request.
+type requestBucket struct {
+	rate   *requestRate
+	tokens float64
+	last   time.Time
+}
+
+// refill tops the bucket up for the time elapsed until now
+func (b *requestBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.rate.count, b.tokens+now.Sub(b.last).Seconds()*b.rate.perSecond())
+	b.last = now
+}
+
+// wait returns how long until the bucket holds a token again
+func (b *requestBucket) wait() time.Duration {
+	return time.Duration((1 - b.tokens) / b.rate.perSecond() * float64(time.Second))
+}
+
+type bucketKey struct {
+	kind, addr string
+}
+
+// blockEntry tracks the refusals of an address or subnet.
+type blockEntry struct {
+	limit       string // kind of the last limit it ran into
+	refused     int    // refusals within strikeWindow
+	lastRefused time.Time
+	bannedUntil time.Time
+}
+
+// abuseGuard enforces AbuseConfig. A nil guard allows everything.
+type abuseGuard struct {
+	limits          map[string][2]*requestRate // per kind: per address, per subnet (nil: unlimited)
+	maxPending      int
+	maxPendingPerIP int
+	banAfter        int
+	banDuration     time.Duration
+
+	mu        sync.Mutex
+	buckets   map[bucketKey]*requestBucket
+	blocks    map[string]*blockEntry // by address or subnet
+	pending   int
+	pendingBy map[string]int
+	refusals  map[string]int64 // by kind, for metrics
+	bans      int64
+
+	now func() time.Time // time.Now; tests step it by hand
+}
+
+var guard *abuseGuard
+
+// newAbuseGuard fills in defaults and validates cfg.
+func newAbuseGuard(cfg AbuseConfig) (*abuseGuard, error) {
+	def := defaultAbuseConfig
+	g := &abuseGuard{
+		limits:    map[string][2]*requestRate{},
+		buckets:   map[bucketKey]*requestBucket{},
+		blocks:    map[string]*blockEntry{},
+		pendingBy: map[string]int{},
+		refusals:  map[string]int64{},
+		now:       time.Now,
+	}
+	for kind, rl := range map[string][2]RateLimitConfig{
+		limitHandshakes: {cfg.Handshakes, def.Handshakes},
+		limitMints:      {cfg.Mints, def.Mints},
+		limitInspects:   {cfg.Inspects, def.Inspects},
+		limitFailures:   {cfg.Failures, def.Failures},
+	} {
+		var rates [2]*requestRate
+		for i, s := range [2][2]string{{rl[0].PerIP, rl[1].PerIP}, {rl[0].PerSubnet, rl[1].PerSubnet}} {
+			if s[0] == "" {
+				s[0] = s[1]
+			}
+			r, err := parseRequestRate(s[0])
+			if err != nil {
+				return nil, fmt.Errorf("abuse %s: %w", kind, err)
+			}
+			rates[i] = r
+		}
+		g.limits[kind] = rates
+	}
+	orDefault := func(v, d int) int {
+		if v == 0 {
+			return d
+		}
+		return max(v, 0)
+	}
+	g.maxPending = orDefault(cfg.MaxPending, def.MaxPending)
+	g.maxPendingPerIP = orDefault(cfg.MaxPendingPerIP, def.MaxPendingPerIP)
+	g.banAfter = orDefault(cfg.BanAfter, def.BanAfter)
+	ban := cfg.BanDuration
+	if ban == "" {
+		ban = def.BanDuration
+	}
+	d, err := time.ParseDuration(ban)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("abuse ban-duration: invalid duration %q", ban)
+	}
+	g.banDuration = d
+	return g, nil
+}
+
+// reconfigure takes over the limits of next, keeping the bans and refusals
+// counted so far. Buckets whose rate changed keep the share of their burst
+// they had left, at the new rate; the others are left alone.
+func (g *abuseGuard) reconfigure(next *abuseGuard) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	for k, b := range g.buckets {
+		i := 0 // per address
+		if strings.Contains(k.addr, "/") {
+			i = 1 // per subnet
+		}
+		r := next.limits[k.kind][i]
+		switch {
+		case r == nil:
+			delete(g.buckets, k)
+		case *r != *b.rate:
+			b.refill(now)
+			b.tokens = b.tokens / b.rate.count * r.count
+		}
+		if r != nil {
+			b.rate = r
+		}
+	}
+	g.limits = next.limits
+	g.maxPending, g.maxPendingPerIP = next.maxPending, next.maxPendingPerIP
+	g.banAfter, g.banDuration = next.banAfter, next.banDuration
+}
+
+// String describes the limits for the startup log
+func (g *abuseGuard) String() string {
+	describe := func(kind string) string {
+		r := g.limits[kind]
+		return fmt.Sprintf("%s %s per IP, %s per subnet", kind, rateOrOff(r[0]), rateOrOff(r[1]))
+	}
+	ban := "never"
+	if g.banAfter > 0 {
+		ban = fmt.Sprintf("%s after %d refusals", g.banDuration, g.banAfter)
+	}
+	return fmt.Sprintf("%s; %s; %s; %s; pending handshakes %s (%s per IP); ban %s",
+		describe(limitHandshakes), describe(limitMints), describe(limitInspects), describe(limitFailures),
+		capOrOff(g.maxPending), capOrOff(g.maxPendingPerIP), ban)
+}
+
+func rateOrOff(r *requestRate) string {
+	if r == nil {
+		return "off"
+	}
+	return r.String()
+}
+
+func capOrOff(n int) string {
+	if n <= 0 {
+		return "off"
+	}
+	return strconv.Itoa(n)
+}
+
+// subnetOf returns the /24 (IPv4) or /64 (IPv6) containing ip, or "" if ip
+// does not parse.
+func subnetOf(ip string) string {
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	a = a.Unmap()
+	bits := 64
+	if a.Is4() {
+		bits = 24
+	}
+	p, err := a.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return p.String()
+}
+
+// connIP returns the address a connection comes from, without the port
+func connIP(c net.Conn) string {
+	ip, _, _ := net.SplitHostPort(c.RemoteAddr().String())
+	return ip
+}
+
+// admit decides whether a freshly accepted connection from ip may start its
+// handshake. Banned addresses and connections over the pending caps are
+// refused; the caller closes them without a reply. release must be called
+// once the handshake message was read.
+func (g *abuseGuard) admit(ip string) (release func(), ok bool) {
+	if g == nil {
+		return func() {}, true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	if g.banned(ip, now) || g.banned(subnetOf(ip), now) {
+		g.refusals[limitBanned]++
+		return nil, false
+	}
+	if g.maxPending > 0 && g.pending >= g.maxPending {
+		// Not the address's fault, so no strike against it
+		g.refusals[limitPending]++
+		return nil, false
+	}
+	if g.maxPendingPerIP > 0 && g.pendingBy[ip] >= g.maxPendingPerIP {
+		g.refuse(ip, limitPending, now)
+		return nil, false
+	}
+	g.pending++
+	g.pendingBy[ip]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			g.pending--
+			if g.pendingBy[ip]--; g.pendingBy[ip] <= 0 {
+				delete(g.pendingBy, ip)
+			}
+		})
+	}, true
+}
+
+// allow takes a token of kind for ip and its subnet. If either is out of
+// tokens nothing is taken and allow returns how long to wait.
+func (g *abuseGuard) allow(kind, ip string) (time.Duration, bool) {
+	return g.take(kind, ip, true)
+}
+
+// check reports whether ip has a token of kind left without taking it.
+func (g *abuseGuard) check(kind, ip string) (time.Duration, bool) {
+	return g.take(kind, ip, false)
+}
+
+// fail records a failed request from ip. Running out of failures is refused
+// by the next check.
+func (g *abuseGuard) fail(ip string) {
+	g.take(limitFailures, ip, true)
+}
+
+// strike counts a refusal of kind against ip that no bucket limits, such as
+// an access list refusal, so addresses that keep trying get banned.
+func (g *abuseGuard) strike(ip, kind string) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.refuse(ip, kind, g.now())
+}
+
+func (g *abuseGuard) take(kind, ip string, consume bool) (time.Duration, bool) {
+	if g == nil {
+		return 0, true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	rates := g.limits[kind]
+	var held []*requestBucket
+	for i, addr := range []string{ip, subnetOf(ip)} {
+		if rates[i] == nil || addr == "" {
+			continue
+		}
+		b := g.bucket(kind, addr, rates[i], now)
+		if b.tokens < 1 {
+			g.refuse(addr, kind, now)
+			return b.wait(), false
+		}
+		held = append(held, b)
+	}
+	if consume {
+		for _, b := range held {
+			b.tokens--
+		}
+	}
+	return 0, true
+}
+
+// bucket returns the refilled bucket of kind for addr, creating a full one
+func (g *abuseGuard) bucket(kind, addr string, rate *requestRate, now time.Time) *requestBucket {
+	k := bucketKey{kind, addr}
+	b := g.buckets[k]
+	if b == nil {
+		if len(g.buckets) >= maxTrackedAddrs {
+			g.evictLocked(now)
+		}
+		b = &requestBucket{rate: rate, tokens: rate.count, last: now}
+		g.buckets[k] = b
+	}
+	b.refill(now)
+	return b
+}
+
+// evictLocked drops one bucket to make room for another, a full one if a
+// few random samples find one. Full buckets are forgotten anyway; dropping
+// another hands its address a fresh bucket, which is no more than an address
+// seen for the first time gets. Pruning all of them is left to prune, which
+// runs on a timer, so a flood of new addresses never waits for a scan of
+// the whole map under g.mu.
+func (g *abuseGuard) evictLocked(now time.Time) {
+	var victim bucketKey
+	n := 0
+	for k, b := range g.buckets { // map order is random
+		victim = k
+		if b.refill(now); b.tokens >= b.rate.count {
+			break
+		}
+		if n++; n >= evictSample {
+			break
+		}
+	}
+	delete(g.buckets, victim)
+}
+
+// evictBlockLocked drops one entry of the throttle table to make room for
+// another: of a few random samples, a stale one if there is one, else one
+// that is not banned, else the ban that ends first.
+func (g *abuseGuard) evictBlockLocked(now time.Time) {
+	var victim string
+	var victimEntry *blockEntry
+	n := 0
+	for addr, e := range g.blocks { // map order is random
+		if !now.Before(e.bannedUntil) && now.Sub(e.lastRefused) > strikeWindow {
+			victim = addr
+			break
+		}
+		if victimEntry == nil || e.bannedUntil.Before(victimEntry.bannedUntil) {
+			victim, victimEntry = addr, e
+		}
+		if n++; n >= evictSample {
+			break
+		}
+	}
+	delete(g.blocks, victim)
+}
+
+// refuse counts a refusal against addr and bans it after banAfter refusals
+// within strikeWindow. Called with g.mu held.
+func (g *abuseGuard) refuse(addr, kind string, now time.Time) {
+	g.refusals[kind]++
+	e := g.blocks[addr]
+	if e == nil {
+		if len(g.blocks) >= maxTrackedAddrs {
+			g.evictBlockLocked(now)
+		}
+		e = &blockEntry{}
+		g.blocks[addr] = e
+	}
+	if now.Sub(e.lastRefused) > strikeWindow {
+		e.refused = 0
+	}
+	if e.refused == 0 {
+		log.Printf("[ABUSE] %s is over the %s limit", addr, kind)
+	}
+	e.refused++
+	e.limit, e.lastRefused = kind, now
+	if g.banAfter > 0 && e.refused >= g.banAfter && !now.Before(e.bannedUntil) {
+		e.bannedUntil = now.Add(g.banDuration)
+		g.bans++
+		log.Printf("[ABUSE] banned %s for %s after %d refused requests (%s)", addr, g.banDuration, e.refused, kind)
+	}
+}
+
+// banned reports whether addr is banned at now. Called with g.mu held.
+func (g *abuseGuard) banned(addr string, now time.Time) bool {
+	e := g.blocks[addr]
+	return e != nil && now.Before(e.bannedUntil)
+}
+
+// prune forgets full buckets and stale refusals
+func (g *abuseGuard) prune(now time.Time) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pruneLocked(now)
+}
+
+func (g *abuseGuard) pruneLocked(now time.Time) {
+	for k, b := range g.buckets {
+		if b.refill(now); b.tokens >= b.rate.count {
+			delete(g.buckets, k)
+		}
+	}
+	for addr, e := range g.blocks {
+		if !now.Before(e.bannedUntil) && now.Sub(e.lastRefused) > strikeWindow {
+			delete(g.blocks, addr)
+		}
+	}
+}
+
+// ThrottledIP is an address or subnet that recently ran into an abuse limit,
+// or is banned.
+type ThrottledIP struct {
+	IP          string     `json:"ip"` // address or subnet
+	Limit       string     `json:"limit"`
+	Refused     int        `json:"refused"`
+	LastRefused time.Time  `json:"last_refused"`
+	BannedUntil *time.Time `json:"banned_until,omitempty"`
+}
+
+// GetThrottledIPs returns the throttle and ban table, bans first, then the
+// most recently refused.
+func GetThrottledIPs() []ThrottledIP {
+	g := guard
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	var result []ThrottledIP
+	for addr, e := range g.blocks {
+		if now.Sub(e.lastRefused) > strikeWindow && !now.Before(e.bannedUntil) {
+			continue
+		}
+		t := ThrottledIP{IP: addr, Limit: e.limit, Refused: e.refused, LastRefused: e.lastRefused}
+		if now.Before(e.bannedUntil) {
+			until := e.bannedUntil
+			t.BannedUntil = &until
+		}
+		result = append(result, t)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if (result[i].BannedUntil == nil) != (result[j].BannedUntil == nil) {
+			return result[i].BannedUntil != nil
+		}
+		return result[i].LastRefused.After(result[j].LastRefused)
+	})
+	return result
+}
+
+// Unban lifts the ban and refills the buckets of ip (an address or subnet),
+// or of every address if ip is empty, and returns how many entries of the
+// throttle table were cleared.
+func Unban(ip string) int {
+	g := guard
+	if g == nil {
+		return 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n := 0
+	for addr := range g.blocks {
+		if ip == "" || addr == ip {
+			delete(g.blocks, addr)
+			n++
+		}
+	}
+	for k := range g.buckets {
+		if ip == "" || k.addr == ip {
+			delete(g.buckets, k)
+		}
+	}
+	if n > 0 {
+		log.Printf("[ADMIN] unbanned %d address(es)", n)
+	}
+	return n
+}
+
+// abuseStats returns refusals by kind, bans so far, banned entries and
+// pending handshakes, for metrics.
+func abuseStats() (refusals map[string]int64, bans int64, banned, pending int) {
+	refusals = map[string]int64{}
+	g := guard
+	if g == nil {
+		return refusals, 0, 0, 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	for kind, n := range g.refusals {
+		refusals[kind] = n
+	}
+	for _, e := range g.blocks {
+		if now.Before(e.bannedUntil) {
+			banned++
+		}
+	}
+	return refusals, g.bans, banned, g.pending
+}
+
+// rejectRateLimited answers a request over a limit with a rate-limited error
+// and closes the connection. Peers that negotiated framing.CapRetryAfter are
+// told when to try again.
+func rejectRateLimited(c net.Conn, msg *EndpointMessage, kind string, wait time.Duration) {
+	_, caps := negotiate(msg)
+	retry := max(int(math.Ceil(wait.Seconds())), 1)
+	log.Printf("[ABUSE] %s -> ERR: rate-limited (%s), retry in %ds", c.RemoteAddr(), kind, retry)
+	resp := ErrorResponse{Msg: "error", Err: framing.CodeRateLimited}
+	if framing.HasCap(caps, framing.CapErrorMessage) {
+		resp.Message = fmt.Sprintf("too many %s from your address, retry in %ds", kind, retry)
+	}
+	if framing.HasCap(caps, framing.CapRetryAfter) {
+		resp.RetryAfter = retry
+	}
+	_ = c.SetWriteDeadline(time.Now().Add(2 * time.Second))
+	_ = sendJSON(c, resp)
+	c.Close()
+}
@@ -0,0 +1,177 @@
+package relay
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAbuseReconfigureKeepsBuckets(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	newGuard := func(mints string) *abuseGuard {
+		g, err := newAbuseGuard(AbuseConfig{Mints: RateLimitConfig{PerIP: mints, PerSubnet: "off"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+	g := newGuard("10/h")
+	for range 6 {
+		if _, ok := g.allow(limitMints, "192.0.2.1"); !ok {
+			t.Fatal("mint refused within the burst")
+		}
+	}
+	tokens := func() float64 {
+		b := g.buckets[bucketKey{limitMints, "192.0.2.1"}]
+		if b == nil {
+			return -1
+		}
+		return math.Round(b.tokens)
+	}
+
+	for _, step := range []struct {
+		mints string
+		want  float64
+	}{
+		{"10/h", 4},  // unchanged: the bucket stays as it was
+		{"20/h", 8},  // doubled: so are the requests left
+		{"5/h", 2},   // halved again
+		{"off", -1},  // no limit: nothing to keep
+		{"10/h", -1}, // and a later limit starts over
+	} {
+		g.reconfigure(newGuard(step.mints))
+		if got := tokens(); got != step.want {
+			t.Fatalf("after reload to %s: %v tokens left, want %v", step.mints, got, step.want)
+		}
+	}
+}
+
+// testClock is a clock the test moves by hand
+type testClock struct{ t time.Time }
+
+func (c *testClock) now() time.Time          { return c.t }
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// newTestGuard returns a guard on clock that bans after three refusals, and
+// makes it the relay's guard until t ends
+func newTestGuard(t *testing.T, clock *testClock) *abuseGuard {
+	t.Helper()
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	g, err := newAbuseGuard(AbuseConfig{
+		Failures:    RateLimitConfig{PerIP: "2/min", PerSubnet: "off"},
+		BanAfter:    3,
+		BanDuration: "15m",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.now = clock.now
+	old := guard
+	guard = g
+	t.Cleanup(func() {
+		guard = old
+		log.SetOutput(out)
+	})
+	return g
+}
+
+func TestAbuseBan(t *testing.T) {
+	clock := &testClock{t: time.Unix(1_700_000_000, 0)}
+	g := newTestGuard(t, clock)
+	const ip = "192.0.2.1"
+	admitted := func() bool {
+		release, ok := g.admit(ip)
+		if ok {
+			release()
+		}
+		return ok
+	}
+
+	// Two failures use up the bucket; every check after that is refused
+	g.fail(ip)
+	g.fail(ip)
+	for i := range 3 {
+		if !admitted() {
+			t.Fatalf("banned after %d refusals", i)
+		}
+		if _, ok := g.check(limitFailures, ip); ok {
+			t.Fatal("check allowed with no failures left")
+		}
+	}
+	if admitted() {
+		t.Fatal("not banned after 3 refusals")
+	}
+	if got := GetThrottledIPs(); len(got) != 1 || got[0].IP != ip || got[0].BannedUntil == nil || !got[0].BannedUntil.Equal(clock.t.Add(15*time.Minute)) {
+		t.Fatalf("throttle table %+v", got)
+	}
+
+	// The ban ends on time, and the bucket has refilled by then
+	clock.advance(15*time.Minute - time.Second)
+	if admitted() {
+		t.Fatal("ban ended early")
+	}
+	clock.advance(time.Second)
+	if !admitted() {
+		t.Fatal("ban didn't end")
+	}
+	if _, ok := g.check(limitFailures, ip); !ok {
+		t.Fatal("bucket didn't refill")
+	}
+
+	// Refusals further apart than the strike window don't add up
+	for range 3 {
+		g.strike(ip, limitACL)
+		clock.advance(strikeWindow + time.Second)
+	}
+	if !admitted() {
+		t.Fatal("banned for refusals outside the strike window")
+	}
+
+	// Unban lifts a ban at once
+	for range 3 {
+		g.strike(ip, limitACL)
+	}
+	if admitted() {
+		t.Fatal("not banned after 3 refusals")
+	}
+	if n := Unban(ip); n != 1 {
+		t.Fatalf("Unban cleared %d entries, want 1", n)
+	}
+	if !admitted() || len(GetThrottledIPs()) != 0 {
+		t.Fatal("still banned after Unban")
+	}
+}
+
+func TestAbuseEvictsWhenFull(t *testing.T) {
+	clock := &testClock{t: time.Unix(1_700_000_000, 0)}
+	g := newTestGuard(t, clock)
+	const banned = "198.51.100.1"
+	for range 3 {
+		g.strike(banned, limitACL)
+	}
+	// Fill the table with addresses refused once, a while ago
+	for i := 1; len(g.blocks) < maxTrackedAddrs; i++ {
+		g.strike(fmt.Sprintf("10.%d.%d.%d", i>>16&0xFF, i>>8&0xFF, i&0xFF), limitACL)
+	}
+	clock.advance(time.Minute)
+
+	for i := range 100 {
+		g.strike(fmt.Sprintf("203.0.113.%d", i), limitACL)
+		if len(g.blocks) > maxTrackedAddrs {
+			t.Fatalf("%d entries tracked, cap %d", len(g.blocks), maxTrackedAddrs)
+		}
+	}
+	if !g.banned(banned, clock.t) {
+		t.Fatal("eviction lifted a ban while unbanned entries were left")
+	}
+	if g.blocks["203.0.113.99"] == nil {
+		t.Fatal("newest refusal not tracked")
+	}
+}
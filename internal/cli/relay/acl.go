@@ -0,0 +1,228 @@
+package relay
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// RoleACL lists the networks a role may connect from. Entries are CIDRs or
+// single addresses. An address must match allow, if allow is set, and must
+// not match deny.
+type RoleACL struct {
+	Allow []string `yaml:"allow,omitempty" mapstructure:"allow,omitempty"`
+	Deny  []string `yaml:"deny,omitempty" mapstructure:"deny,omitempty"`
+}
+
+// ACLConfig holds the allow and deny lists of receivers and senders.
+type ACLConfig struct {
+	Receiver RoleACL `yaml:"receiver,omitempty" mapstructure:"receiver,omitempty"`
+	Sender   RoleACL `yaml:"sender,omitempty" mapstructure:"sender,omitempty"`
+}
+
+// Empty reports whether no list is configured.
+func (c ACLConfig) Empty() bool {
+	return len(c.Receiver.Allow)+len(c.Receiver.Deny)+len(c.Sender.Allow)+len(c.Sender.Deny) == 0
+}
+
+// roleACL is a parsed RoleACL.
+type roleACL struct {
+	allow, deny []netip.Prefix
+}
+
+func (r roleACL) permits(a netip.Addr) bool {
+	for _, p := range r.deny {
+		if p.Contains(a) {
+			return false
+		}
+	}
+	if len(r.allow) == 0 {
+		return true
+	}
+	for _, p := range r.allow {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// accessList is the parsed ACLConfig. A nil accessList permits everything.
+type accessList struct {
+	receiver, sender roleACL
+}
+
+// acls is swapped as a whole on reload, so connections never see half a list.
+var acls atomic.Pointer[accessList]
+
+// newAccessList parses cfg; it returns nil if cfg is empty.
+func newAccessList(cfg ACLConfig) (*accessList, error) {
+	if cfg.Empty() {
+		return nil, nil
+	}
+	parse := func(what string, entries []string) ([]netip.Prefix, error) {
+		var prefixes []netip.Prefix
+		for _, e := range entries {
+			e = strings.TrimSpace(e)
+			p, err := netip.ParsePrefix(e)
+			if err != nil {
+				a, aerr := netip.ParseAddr(e)
+				if aerr != nil {
+					return nil, fmt.Errorf("acl %s: invalid CIDR or address %q", what, e)
+				}
+				p = netip.PrefixFrom(a.Unmap(), a.Unmap().BitLen())
+			}
+			prefixes = append(prefixes, p.Masked())
+		}
+		return prefixes, nil
+	}
+	l := &accessList{}
+	for _, r := range []struct {
+		what string
+		cfg  RoleACL
+		acl  *roleACL
+	}{
+		{"receiver", cfg.Receiver, &l.receiver},
+		{"sender", cfg.Sender, &l.sender},
+	} {
+		var err error
+		if r.acl.allow, err = parse(r.what+" allow", r.cfg.Allow); err != nil {
+			return nil, err
+		}
+		if r.acl.deny, err = parse(r.what+" deny", r.cfg.Deny); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// String describes the lists for the log
+func (l *accessList) String() string {
+	if l == nil {
+		return "none"
+	}
+	return fmt.Sprintf("receivers %d allowed/%d denied, senders %d allowed/%d denied network(s)",
+		len(l.receiver.allow), len(l.receiver.deny), len(l.sender.allow), len(l.sender.deny))
+}
+
+// permits reports whether ip may connect as role. An empty role asks whether
+// any role may, which is all that can be checked before the handshake is read.
+// Roles the relay does not know are left for the handler to refuse.
+func (l *accessList) permits(role, ip string) bool {
+	if l == nil {
+		return true
+	}
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	a = a.Unmap()
+	switch role {
+	case "":
+		return l.receiver.permits(a) || l.sender.permits(a)
+	case "receiver":
+		return l.receiver.permits(a)
+	case "sender":
+		return l.sender.permits(a)
+	}
+	return true
+}
+
+// Refusals before the handshake are audited per network rather than per
+// connection: one record per IPv4 /24 or IPv6 /64 and flush, with a count.
+// Once aclDeniedMax networks are waiting, they are flushed early.
+const (
+	aclDeniedBits4 = 24
+	aclDeniedBits6 = 64
+	aclDeniedMax   = 1024
+)
+
+// ACL rejections by role ("any" before the handshake), for metrics, and the
+// refusals before the handshake not yet audited
+var (
+	aclMu       sync.Mutex
+	aclRejected = map[string]int64{}
+	aclDenied   = map[netip.Prefix]int64{}
+)
+
+// rejectACL counts a connection refused by the access lists as a strike
+// against its address and closes it. Connections refused after their
+// handshake are logged, audited and told why; the ones refused before it
+// cost nothing to open, so they are audited in aggregate by flushACLDenied.
+func rejectACL(c net.Conn, msg *EndpointMessage) {
+	role := "any"
+	if msg != nil {
+		role = msg.Role
+	}
+	ip := connIP(c)
+	aclMu.Lock()
+	aclRejected[role]++
+	full := false
+	if msg == nil {
+		if p, ok := aclDeniedPrefix(ip); ok {
+			aclDenied[p]++
+			full = len(aclDenied) >= aclDeniedMax
+		}
+	}
+	aclMu.Unlock()
+	if full {
+		flushACLDenied()
+	}
+	guard.strike(ip, limitACL)
+	if msg != nil {
+		log.Printf("[ACL] %s -> ERR: address not allowed (role %s)", c.RemoteAddr(), role)
+		auditAuthFailure(c, msg.Role, "acl-denied", "")
+		_, caps := negotiate(msg)
+		SendErrorMessage(c, "forbidden", "connections from this address are not allowed for "+msg.Role+"s", caps)
+	}
+	c.Close()
+}
+
+// aclDeniedPrefix returns the network ip is audited under
+func aclDeniedPrefix(ip string) (netip.Prefix, bool) {
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	a = a.Unmap()
+	bits := aclDeniedBits6
+	if a.Is4() {
+		bits = aclDeniedBits4
+	}
+	p, err := a.Prefix(bits)
+	return p, err == nil
+}
+
+// flushACLDenied audits the refusals before the handshake since the last
+// flush, one auth.failed record with reason acl-denied per network.
+func flushACLDenied() {
+	aclMu.Lock()
+	denied := aclDenied
+	aclDenied = map[netip.Prefix]int64{}
+	aclMu.Unlock()
+	prefixes := make([]netip.Prefix, 0, len(denied))
+	for p := range denied {
+		prefixes = append(prefixes, p)
+	}
+	slices.SortFunc(prefixes, func(a, b netip.Prefix) int { return a.Addr().Compare(b.Addr()) })
+	for _, p := range prefixes {
+		log.Printf("[ACL] %s -> refused %d connection(s) before the handshake", p, denied[p])
+		auditRecord(AuditRecord{Event: AuditAuthFailed, Reason: "acl-denied", RemoteAddr: p.String(), Count: denied[p]})
+	}
+}
+
+// aclRejections returns ACL rejections by role, for metrics
+func aclRejections() map[string]int64 {
+	aclMu.Lock()
+	defer aclMu.Unlock()
+	result := make(map[string]int64, len(aclRejected))
+	for role, n := range aclRejected {
+		result[role] = n
+	}
+	return result
+}
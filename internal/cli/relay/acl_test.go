@@ -0,0 +1,162 @@
+package relay
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fromConn is a connection that seems to come from addr
+type fromConn struct {
+	net.Conn
+	addr string
+}
+
+func (c fromConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(c.addr), Port: 40000}
+}
+
+func TestNewAccessList(t *testing.T) {
+	l, err := newAccessList(ACLConfig{
+		Receiver: RoleACL{Allow: []string{" 10.1.2.3/8 ", "2001:db8::/32"}},
+		Sender:   RoleACL{Allow: []string{"192.0.2.7", "::ffff:198.51.100.1"}, Deny: []string{"fe80::1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.7/32", "198.51.100.1/32", "fe80::1/128"}
+	var got []string
+	for _, ps := range [][]netip.Prefix{l.receiver.allow, l.receiver.deny, l.sender.allow, l.sender.deny} {
+		for _, p := range ps {
+			got = append(got, p.String())
+		}
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("parsed %v, want %v", got, want)
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "relay.example", "10.0.0"} {
+		if _, err := newAccessList(ACLConfig{Sender: RoleACL{Deny: []string{bad}}}); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+	if l, err := newAccessList(ACLConfig{}); l != nil || err != nil || !l.permits("sender", "192.0.2.1") {
+		t.Fatal("empty config doesn't permit everything")
+	}
+}
+
+func TestAccessListPermits(t *testing.T) {
+	l, err := newAccessList(ACLConfig{
+		Receiver: RoleACL{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.9.0.0/16"}},
+		Sender:   RoleACL{Deny: []string{"203.0.113.0/24"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		role, ip string
+		want     bool
+	}{
+		{"receiver", "10.1.2.3", true},
+		{"receiver", "::ffff:10.1.2.3", true},
+		{"receiver", "10.9.1.1", false}, // deny wins over allow
+		{"receiver", "192.0.2.1", false},
+		{"sender", "192.0.2.1", true},
+		{"sender", "203.0.113.9", false},
+		{"", "203.0.113.9", false}, // no role may connect from there
+		{"", "10.9.1.1", true},     // senders may
+		{"", "not an address", false},
+		{"mint", "203.0.113.9", true}, // left for the handler
+	}
+	for _, tt := range tests {
+		if got := l.permits(tt.role, tt.ip); got != tt.want {
+			t.Errorf("permits(%q, %q) = %v, want %v", tt.role, tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestACLRefusesBeforeHandshake(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	l, err := newAccessList(ACLConfig{
+		Receiver: RoleACL{Allow: []string{"10.0.0.0/8"}},
+		Sender:   RoleACL{Allow: []string{"10.0.0.0/8", "192.0.2.0/24"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acls.Store(l)
+	defer acls.Store(nil)
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a, err := openAuditLog(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit = a
+	defer func() { audit = nil }()
+
+	// Addresses no role may use are closed without reading the handshake,
+	// which the peer never sends
+	for _, ip := range []string{"198.51.100.7", "198.51.100.200"} {
+		relaySide, peer := net.Pipe()
+		go handleTCP(fromConn{relaySide, ip}, func() {}, &listener{})
+		_ = peer.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := peer.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+			t.Fatalf("%s: got %v, want the connection closed", ip, err)
+		}
+		peer.Close()
+	}
+
+	// The others are checked against their role after it
+	relaySide, peer := net.Pipe()
+	defer peer.Close()
+	go handleTCP(fromConn{relaySide, "192.0.2.5"}, func() {}, &listener{})
+	hello := "ssh-relay/1.0\n{\"msg\":\"hello\",\"role\":\"receiver\",\"receiver_fp\":\"SHA256:abc\"}\n"
+	if _, err := peer.Write([]byte(hello)); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(peer).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp ErrorResponse
+	if err := json.Unmarshal(line, &resp); err != nil || resp.Err != "forbidden" {
+		t.Fatalf("receiver hello from a sender network got %s", line)
+	}
+
+	// The refusals before the handshake are audited together
+	flushACLDenied()
+	a.close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recs []AuditRecord
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var r AuditRecord
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, r)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("audited %d records, want 2: %s", len(recs), data)
+	}
+	if r := recs[0]; r.Reason != "acl-denied" || r.Role != "receiver" || r.Count != 0 || !strings.HasPrefix(r.RemoteAddr, "192.0.2.5:") {
+		t.Errorf("refusal after the handshake audited as %+v", r)
+	}
+	if r := recs[1]; r.Reason != "acl-denied" || r.RemoteAddr != "198.51.100.0/24" || r.Count != 2 {
+		t.Errorf("refusals before the handshake audited as %+v", r)
+	}
+}
@@ -0,0 +1,355 @@
+package relay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ssh-portal/internal/cli/framing"
+)
+
+// ====== Admin operations ======
+// Shared by the admin socket and the TUI keybindings
+
+// RevokeInvite closes the waiting receiver of the invite with the given RID or
+// relay code and removes the invite.
+func RevokeInvite(id string) (*Invite, error) {
+	inv := findInvite(id)
+	if inv == nil {
+		return nil, fmt.Errorf("no outstanding invite %q", id)
+	}
+	if rc, caps := inv.ReceiverConn(), inv.ReceiverCaps(); rc != nil {
+		sendBye(rc, framing.ReasonInviteRevoked, 0, caps)
+		rc.Close()
+	}
+	DeleteInvite(inv, "revoked")
+	log.Printf("[ADMIN] revoked invite code=%s rid=%s", inv.Code, inv.RID)
+	return inv, nil
+}
+
+// ExtendInvite pushes back the expiration of the invite with the given RID or
+// relay code by d.
+func ExtendInvite(id string, d time.Duration) (*Invite, error) {
+	if d <= 0 {
+		return nil, fmt.Errorf("extension must be positive, got %s", d)
+	}
+	inv := findInvite(id)
+	if inv == nil {
+		return nil, fmt.Errorf("no outstanding invite %q", id)
+	}
+	exp, ok := extendInvite(inv, d)
+	if !ok {
+		return nil, fmt.Errorf("no outstanding invite %q", id)
+	}
+	log.Printf("[ADMIN] extended invite code=%s rid=%s by %s, expires %s", inv.Code, inv.RID, d, exp.Format(time.RFC3339))
+	return inv, nil
+}
+
+// KillSplice closes both connections of the active splice with the given ID
+// or relay code.
+func KillSplice(id string) (*Splice, error) {
+	for _, s := range GetActiveSplices() {
+		if s.ID == id || s.Code == id {
+			spliceMu.Lock()
+			s.CloseReason = "killed"
+			spliceMu.Unlock()
+			s.closeConns()
+			log.Printf("[ADMIN] killed splice id=%s code=%s sender=%s receiver=%s", s.ID, s.Code, s.SenderAddr, s.ReceiverAddr)
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("no active splice %q", id)
+}
+
+// findInvite looks up an outstanding invite by RID, then by relay code.
+func findInvite(id string) *Invite {
+	inv := GetByRID(id)
+	if inv == nil {
+		inv = GetByCode(id)
+	}
+	if inv == nil || inv.expired(time.Now()) {
+		return nil
+	}
+	return inv
+}
+
+// ====== Admin socket ======
+
+// Admin commands
+const (
+	AdminList   = "list"
+	AdminRevoke = "revoke"
+	AdminKill   = "kill"
+	AdminExtend = "extend"
+	AdminUnban  = "unban"
+	AdminDrain  = "drain"
+)
+
+// AdminRequest is one command sent over the admin socket.
+type AdminRequest struct {
+	Cmd     string `json:"cmd"`
+	ID      string `json:"id,omitempty"`      // RID or relay code (revoke, extend); splice ID or code (kill)
+	Seconds int    `json:"seconds,omitempty"` // extend
+	IP      string `json:"ip,omitempty"`      // unban; empty unbans all
+}
+
+// AdminResponse is the relay's answer to an AdminRequest.
+type AdminResponse struct {
+	OK        bool          `json:"ok"`
+	Error     string        `json:"error,omitempty"`
+	Message   string        `json:"message,omitempty"`
+	Invites   []InviteInfo  `json:"invites,omitempty"`
+	Splices   []SpliceInfo  `json:"splices,omitempty"`
+	Throttled []ThrottledIP `json:"throttled,omitempty"`
+	Draining  bool          `json:"draining,omitempty"`
+}
+
+// InviteInfo describes an outstanding invite.
+type InviteInfo struct {
+	RID           string    `json:"rid"`
+	Code          string    `json:"code"`
+	Label         string    `json:"label,omitempty"`
+	ReceiverLabel string    `json:"receiver_label,omitempty"` // what the receiver says it is
+	State         string    `json:"state"`                    // "unclaimed" (pre-minted), "waiting" (receiver attached), "unresponsive" (receiver missing pings) or "claimed"
+	ReceiverAddr  string    `json:"receiver_addr,omitempty"`
+	CodeWords     int       `json:"code_words"`
+	CreatedAt     time.Time `json:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// SpliceInfo describes an established splice.
+type SpliceInfo struct {
+	ID           string     `json:"id"`
+	Code         string     `json:"code"`
+	RID          string     `json:"rid"`
+	SenderAddr   string     `json:"sender_addr"`
+	ReceiverAddr string     `json:"receiver_addr"`
+	CreatedAt    time.Time  `json:"created_at"`
+	BytesUp      int64      `json:"bytes_up"`
+	BytesDown    int64      `json:"bytes_down"`
+	ClosedAt     *time.Time `json:"closed_at,omitempty"`
+	Label        string     `json:"label,omitempty"`
+	Tenant       string     `json:"tenant,omitempty"`
+	Rate         float64    `json:"rate"` // bytes per second at the last sample
+	Throttled    bool       `json:"throttled,omitempty"`
+	EndsAt       *time.Time `json:"ends_at,omitempty"`      // when the maximum duration closes it
+	IdleTimeout  int        `json:"idle_timeout,omitempty"` // seconds of idleness that close it
+	CloseReason  string     `json:"close_reason,omitempty"` // set if the relay closed it
+}
+
+// DefaultAdminSocket returns the default admin socket path, next to the
+// user's ~/.ssh-portal.yml.
+func DefaultAdminSocket() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".ssh-portal-relay.sock"
+	}
+	return filepath.Join(home, ".ssh-portal-relay.sock")
+}
+
+// handleAdmin executes one admin request.
+func handleAdmin(req AdminRequest, drain func() bool) AdminResponse {
+	var err error
+	resp := AdminResponse{OK: true}
+	switch req.Cmd {
+	case AdminList:
+		resp.Invites = inviteInfos()
+		resp.Splices = spliceInfos()
+		resp.Throttled = GetThrottledIPs()
+		resp.Draining = IsDraining()
+	case AdminRevoke:
+		var inv *Invite
+		if inv, err = RevokeInvite(req.ID); err == nil {
+			resp.Message = fmt.Sprintf("revoked invite %s (rid %s)", inv.Code, inv.RID)
+		}
+	case AdminExtend:
+		var inv *Invite
+		if inv, err = ExtendInvite(req.ID, time.Duration(req.Seconds)*time.Second); err == nil {
+			resp.Message = fmt.Sprintf("invite %s now expires %s", inv.Code, inv.ExpiresAt().Format(time.RFC3339))
+		}
+	case AdminKill:
+		var s *Splice
+		if s, err = KillSplice(req.ID); err == nil {
+			resp.Message = fmt.Sprintf("killed splice %s (code %s)", s.ID, s.Code)
+		}
+	case AdminUnban:
+		n := Unban(req.IP)
+		if req.IP != "" && n == 0 {
+			err = fmt.Errorf("%s is not throttled", req.IP)
+		} else {
+			resp.Message = fmt.Sprintf("unbanned %d address(es)", n)
+		}
+	case AdminDrain:
+		if drain() {
+			resp.Message = "drain started"
+		} else {
+			resp.Message = "already draining"
+		}
+	default:
+		err = fmt.Errorf("unknown command %q", req.Cmd)
+	}
+	if err != nil {
+		return AdminResponse{Error: err.Error()}
+	}
+	return resp
+}
+
+func inviteInfos() []InviteInfo {
+	invites := sortedInvites()
+	result := make([]InviteInfo, 0, len(invites))
+	for _, inv := range invites {
+		result = append(result, inviteInfo(inv))
+	}
+	return result
+}
+
+func inviteInfo(inv *Invite) InviteInfo {
+	info := InviteInfo{
+		RID:       inv.RID,
+		Code:      inv.Code,
+		Label:     inv.Label,
+		State:     "claimed",
+		CodeWords: inv.CodeWords,
+		CreatedAt: inv.CreatedAt,
+		ExpiresAt: inv.ExpiresAt(),
+	}
+	switch rc := inv.ReceiverConn(); {
+	case rc != nil:
+		info.State = "waiting"
+		if inv.Unresponsive() {
+			info.State = "unresponsive"
+		}
+		info.ReceiverAddr = rc.RemoteAddr().String()
+		info.ReceiverLabel = inv.ReceiverLabel()
+	case !inv.Claimed():
+		info.State = "unclaimed"
+	}
+	return info
+}
+
+func spliceInfos() []SpliceInfo {
+	spliceMu.RLock()
+	defer spliceMu.RUnlock()
+	result := make([]SpliceInfo, 0, len(splices))
+	for _, s := range splices {
+		result = append(result, spliceInfo(s))
+	}
+	return result
+}
+
+// spliceInfo describes s; the caller holds spliceMu
+func spliceInfo(s *Splice) SpliceInfo {
+	info := SpliceInfo{
+		ID:           s.ID,
+		Code:         s.Code,
+		RID:          s.RID,
+		SenderAddr:   s.SenderAddr,
+		ReceiverAddr: s.ReceiverAddr,
+		CreatedAt:    s.CreatedAt,
+		BytesUp:      s.BytesUp(),
+		BytesDown:    s.BytesDown(),
+		ClosedAt:     s.ClosedAt,
+		Label:        s.Label,
+		Tenant:       s.Tenant,
+		Rate:         s.Rate,
+		Throttled:    s.Throttled,
+		IdleTimeout:  limitSeconds(s.Limits.IdleTimeout),
+		CloseReason:  s.CloseReason,
+	}
+	if s.Limits.MaxDuration > 0 {
+		end := s.CreatedAt.Add(s.Limits.MaxDuration)
+		info.EndsAt = &end
+	}
+	return info
+}
+
+// adminServe serves the admin API on a unix socket at path until ctx is
+// cancelled. Each connection carries one JSON request line and gets one JSON
+// response line. The socket is only accessible to the relay's user.
+func adminServe(ctx context.Context, path string, drain func() bool) error {
+	if fi, err := os.Lstat(path); err == nil {
+		// Never remove what a mistyped path points at
+		if fi.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("admin socket %s exists and is not a socket", path)
+		}
+		// A socket left behind by a crashed relay is stale; a live one is not ours
+		if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			c.Close()
+			return fmt.Errorf("admin socket %s is in use by another relay", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("remove stale admin socket: %w", err)
+		}
+	}
+	// Owner-only from the start: no other user may connect
+	ln, err := listenAdminSocket(path)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	log.Printf("relay admin socket listening on %s", path)
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go serveAdminConn(c, drain)
+	}
+}
+
+func serveAdminConn(c net.Conn, drain func() bool) {
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(10 * time.Second))
+
+	var resp AdminResponse
+	line, err := framing.ReadLine(bufio.NewReader(c), framing.MaxJSONLine)
+	if err == nil {
+		var req AdminRequest
+		if err = framing.DecodeStrict(line, &req); err == nil {
+			resp = handleAdmin(req, drain)
+		}
+	}
+	if err != nil {
+		resp = AdminResponse{Error: fmt.Sprintf("bad request: %v", err)}
+	}
+	_ = sendJSON(c, resp)
+}
+
+// AdminCall sends req to the relay admin socket at path and returns its response.
+func AdminCall(path string, req AdminRequest) (*AdminResponse, error) {
+	c, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach relay admin socket %s (is the relay running?): %w", path, err)
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if err := sendJSON(c, req); err != nil {
+		return nil, err
+	}
+	var resp AdminResponse
+	if err := json.NewDecoder(c).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("bad admin response: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(strings.TrimSpace(resp.Error))
+	}
+	return &resp, nil
+}
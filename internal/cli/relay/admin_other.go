@@ -0,0 +1,19 @@
+//go:build !unix
+
+package relay
+
+import (
+	"net"
+	"os"
+)
+
+// listenAdminSocket listens on a Unix socket at path. Without Unix file
+// modes, who may connect is up to the permissions of its directory.
+func listenAdminSocket(path string) (net.Listener, error) {
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	_ = os.Chmod(path, 0o600)
+	return ln, nil
+}
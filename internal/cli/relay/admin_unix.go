@@ -0,0 +1,51 @@
+//go:build unix
+
+package relay
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// listenAdminSocket listens on a Unix socket at path that only the relay's
+// user may connect to. The socket is bound in a fresh owner-only directory
+// next to path, made owner-only and only then moved to path, so no one can
+// connect before the chmod. The umask is left alone: it is process-wide.
+func listenAdminSocket(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".relay-admin-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "sock")
+	ln, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	// The socket is removed from path on close, not from where it was bound
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0o600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return &adminListener{Listener: ln, path: path}, nil
+}
+
+// adminListener removes its socket when closed
+type adminListener struct {
+	net.Listener
+	path string
+	once sync.Once
+}
+
+func (l *adminListener) Close() error {
+	err := l.Listener.Close()
+	l.once.Do(func() { os.Remove(l.path) })
+	return err
+}
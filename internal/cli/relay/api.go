@@ -0,0 +1,167 @@
+package relay
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"ssh-portal/internal/cli/usercode"
+)
+
+// Invite API limits
+const (
+	apiDefaultTTL  = 30 * time.Minute
+	apiMaxTTL      = 24 * time.Hour
+	apiMaxLabelLen = 200
+	apiMaxBodySize = 4096
+)
+
+// APIConfig configures the invite HTTP API.
+type APIConfig struct {
+	Token      string // bearer token required on every request
+	PublicHost string // relay host put in receiver commands; the request's Host if empty
+	Port       int    // relay TCP port put in receiver commands
+	TLS        bool   // receiver commands connect over TLS
+	CodeWords  int    // minimum code strength
+}
+
+// CreateInviteRequest is the body of POST /v1/invites.
+type CreateInviteRequest struct {
+	Label      string `json:"label"`
+	TTLSeconds int    `json:"ttl_seconds,omitempty"`
+	CodeWords  int    `json:"code_words,omitempty"`
+}
+
+// CreateInviteResponse is returned by POST /v1/invites. The claim token is
+// only ever shown here.
+type CreateInviteResponse struct {
+	InviteInfo
+	ClaimToken string `json:"claim_token"`
+	Command    string `json:"command"` // receiver one-liner for the customer
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// NewAPIHandler returns the invite API:
+//
+//	POST   /v1/invites       pre-mint a labeled invite, returns its claim token
+//	GET    /v1/invites       list outstanding invites
+//	GET    /v1/invites/{id}  one invite, by RID or relay code
+//	DELETE /v1/invites/{id}  revoke an invite
+//
+// Every request needs "Authorization: Bearer <token>".
+func NewAPIHandler(cfg APIConfig) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/invites", func(w http.ResponseWriter, r *http.Request) {
+		if IsDraining() {
+			writeAPIError(w, http.StatusServiceUnavailable, "relay is draining")
+			return
+		}
+		var req CreateInviteRequest
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBodySize))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("bad request body: %v", err))
+			return
+		}
+		req.Label = strings.TrimSpace(req.Label)
+		if len(req.Label) > apiMaxLabelLen {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("label longer than %d bytes", apiMaxLabelLen))
+			return
+		}
+		ttl := apiDefaultTTL
+		if req.TTLSeconds != 0 {
+			ttl = time.Duration(req.TTLSeconds) * time.Second
+			if ttl < 0 || ttl > apiMaxTTL {
+				writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("ttl_seconds must be between 1 and %d", int(apiMaxTTL/time.Second)))
+				return
+			}
+		}
+		if req.CodeWords != 0 && usercode.NormalizeCodeWords(req.CodeWords) != req.CodeWords {
+			writeAPIError(w, http.StatusBadRequest, "code_words must be 4, 6 or 8")
+			return
+		}
+
+		words := usercode.NormalizeCodeWords(max(req.CodeWords, cfg.CodeWords, currentPolicy().codeWords, RequiredCodeWords(CountOutstandingInvites(), ttl)))
+		inv, claim, err := PreMintInvite(req.Label, ttl, words)
+		if err != nil {
+			log.Printf("[API] %s -> ERR: %v", r.RemoteAddr, err)
+			writeAPIError(w, http.StatusInternalServerError, "mint failed")
+			return
+		}
+		log.Printf("[API] %s -> pre-minted invite: code=%s rid=%s label=%q expires=%s", r.RemoteAddr, inv.Code, inv.RID, inv.Label, inv.ExpiresAt().Format(time.RFC3339))
+		auditInvite(AuditInviteMinted, inv, r.RemoteAddr, "api")
+
+		host := cfg.PublicHost
+		if host == "" {
+			host = r.Host
+			if h, _, err := net.SplitHostPort(r.Host); err == nil {
+				host = h
+			}
+		}
+		command := fmt.Sprintf("ssh-portal --relay %s --relay-port %d --claim %s", host, cfg.Port, claim)
+		if cfg.TLS {
+			command += " --tls"
+		}
+		writeJSON(w, http.StatusCreated, CreateInviteResponse{
+			InviteInfo: inviteInfo(inv),
+			ClaimToken: claim,
+			Command:    command,
+		})
+	})
+	mux.HandleFunc("GET /v1/invites", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string][]InviteInfo{"invites": inviteInfos()})
+	})
+	mux.HandleFunc("GET /v1/invites/{id}", func(w http.ResponseWriter, r *http.Request) {
+		inv := findInvite(r.PathValue("id"))
+		if inv == nil {
+			writeAPIError(w, http.StatusNotFound, "no such invite")
+			return
+		}
+		writeJSON(w, http.StatusOK, inviteInfo(inv))
+	})
+	mux.HandleFunc("DELETE /v1/invites/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := RevokeInvite(r.PathValue("id")); err != nil {
+			writeAPIError(w, http.StatusNotFound, "no such invite")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return requireBearer(cfg.Token, mux)
+}
+
+// requireBearer rejects requests without the expected bearer token.
+func requireBearer(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ssh-portal"`)
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, apiError{Error: msg})
+}
+
+// apiServe runs the invite API on addr until ctx is cancelled.
+func apiServe(ctx context.Context, addr string, cfg APIConfig) error {
+	return serveHTTP(ctx, addr, "invite API", NewAPIHandler(cfg))
+}
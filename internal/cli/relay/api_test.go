@@ -0,0 +1,104 @@
+package relay
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestInviteAPI(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	srv := httptest.NewServer(NewAPIHandler(APIConfig{Token: "s3cret", PublicHost: "relay.example.com", Port: 4430, CodeWords: 4}))
+	defer srv.Close()
+
+	do := func(method, path, token, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		if resp := do("GET", "/v1/invites", token, ""); resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("token %q: status %d, want 401", token, resp.StatusCode)
+		}
+	}
+
+	for _, body := range []string{`{"label":"x","ttl_seconds":-1}`, `{"label":"x","code_words":5}`, `{"label":"x","extra":1}`, `not json`} {
+		if resp := do("POST", "/v1/invites", "s3cret", body); resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("body %s: status %d, want 400", body, resp.StatusCode)
+		}
+	}
+
+	resp := do("POST", "/v1/invites", "s3cret", `{"label":"TICKET-42 ACME","ttl_seconds":600}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create: status %d, want 201", resp.StatusCode)
+	}
+	var created CreateInviteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Label != "TICKET-42 ACME" || created.State != "unclaimed" || created.ClaimToken == "" {
+		t.Fatalf("unexpected create response: %+v", created)
+	}
+	if want := "ssh-portal --relay relay.example.com --relay-port 4430 --claim " + created.ClaimToken; created.Command != want {
+		t.Fatalf("command %q, want %q", created.Command, want)
+	}
+	defer func() {
+		if inv := GetByRID(created.RID); inv != nil {
+			DeleteInvite(inv, "test")
+		}
+	}()
+
+	var list struct{ Invites []InviteInfo }
+	if err := json.NewDecoder(do("GET", "/v1/invites", "s3cret", "").Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, inv := range list.Invites {
+		found = found || (inv.RID == created.RID && inv.Label == created.Label)
+	}
+	if !found {
+		t.Fatalf("created invite missing from list: %+v", list.Invites)
+	}
+
+	// The claim token works exactly once
+	if _, err := ClaimInvite(created.ClaimToken, "SHA256:test"); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if _, err := ClaimInvite(created.ClaimToken, "SHA256:other"); err == nil {
+		t.Fatal("second claim succeeded")
+	}
+	var got InviteInfo
+	if err := json.NewDecoder(do("GET", "/v1/invites/"+url.PathEscape(created.Code), "s3cret", "").Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.RID != created.RID || got.State != "claimed" {
+		t.Fatalf("after claim: %+v", got)
+	}
+
+	if resp := do("DELETE", "/v1/invites/"+created.RID, "s3cret", ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete: status %d, want 204", resp.StatusCode)
+	}
+	if resp := do("GET", "/v1/invites/"+created.RID, "s3cret", ""); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("get after delete: status %d, want 404", resp.StatusCode)
+	}
+}
@@ -0,0 +1,373 @@
+package relay
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Audit record events
+const (
+	AuditInviteMinted  = "invite.minted"
+	AuditInviteClaimed = "invite.claimed"
+	AuditInvitePaired  = "invite.paired"
+	AuditInviteClosed  = "invite.closed" // expired, revoked, relay shutdown, ...
+	AuditAuthFailed    = "auth.failed"   // bad token, claim token or code
+	AuditSpliceClosed  = "splice.closed"
+)
+
+// AuditRecord is one line of the audit log.
+type AuditRecord struct {
+	Time           time.Time  `json:"time"`
+	Event          string     `json:"event"`
+	Reason         string     `json:"reason,omitempty"`
+	Code           string     `json:"code,omitempty"`
+	RID            string     `json:"rid,omitempty"`
+	Label          string     `json:"label,omitempty"`
+	Role           string     `json:"role,omitempty"`        // auth.failed
+	RemoteAddr     string     `json:"remote_addr,omitempty"` // peer that caused the event
+	ReceiverFP     string     `json:"receiver_fp,omitempty"`
+	ReceiverAddr   string     `json:"receiver_addr,omitempty"`
+	SenderAddr     string     `json:"sender_addr,omitempty"`
+	SenderIdentity string     `json:"sender_identity,omitempty"`
+	SpliceID       string     `json:"splice_id,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	DurationSecs   float64    `json:"duration_s,omitempty"`
+	BytesUp        int64      `json:"bytes_up,omitempty"`
+	BytesDown      int64      `json:"bytes_down,omitempty"`
+	Count          int64      `json:"count,omitempty"` // auth.failed for a network, before the handshake
+}
+
+// auditRotatedFormat is the timestamp suffix of rotated audit files; it sorts
+// in time order.
+const auditRotatedFormat = "20060102T150405.000Z"
+
+// auditLog appends records as JSON lines to a file, rotating it once it
+// reaches maxSize bytes or has been written to for rotateEvery.
+type auditLog struct {
+	mu          sync.Mutex
+	path        string
+	maxSize     int64
+	rotateEvery time.Duration
+	f           *os.File
+	size        int64
+	opened      time.Time
+}
+
+var audit *auditLog
+
+func openAuditLog(path string, maxSize int64, rotateEvery time.Duration) (*auditLog, error) {
+	a := &auditLog{path: path, maxSize: maxSize, rotateEvery: rotateEvery}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *auditLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	a.f, a.size, a.opened = f, st.Size(), time.Now()
+	return nil
+}
+
+// rotate moves the current file aside with a timestamp suffix and starts a new one
+func (a *auditLog) rotate(now time.Time) error {
+	if err := a.f.Close(); err != nil {
+		log.Printf("[AUDIT] close %s: %v", a.path, err)
+	}
+	rotated := a.path + "." + now.UTC().Format(auditRotatedFormat)
+	if err := os.Rename(a.path, rotated); err != nil {
+		log.Printf("[AUDIT] rotate %s: %v", a.path, err)
+	}
+	return a.open()
+}
+
+func (a *auditLog) write(rec AuditRecord) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("[AUDIT] encode %s: %v", rec.Event, err)
+		return
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil {
+		return
+	}
+	if a.size > 0 && ((a.maxSize > 0 && a.size+int64(len(line)) > a.maxSize) || (a.rotateEvery > 0 && rec.Time.Sub(a.opened) >= a.rotateEvery)) {
+		if err := a.rotate(rec.Time); err != nil {
+			log.Printf("[AUDIT] %v", err)
+			return
+		}
+	}
+	n, err := a.f.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		log.Printf("[AUDIT] write %s: %v", a.path, err)
+	}
+}
+
+func (a *auditLog) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f != nil {
+		a.f.Close()
+		a.f = nil
+	}
+}
+
+// auditRecord appends rec to the audit log, if one is configured
+func auditRecord(rec AuditRecord) {
+	if audit == nil {
+		return
+	}
+	rec.Time = time.Now().UTC()
+	audit.write(rec)
+}
+
+// auditInvite records an invite event
+func auditInvite(event string, inv *Invite, remoteAddr, reason string) {
+	if audit == nil {
+		return
+	}
+	rec := AuditRecord{
+		Event:      event,
+		Reason:     reason,
+		Code:       inv.Code,
+		RID:        inv.RID,
+		Label:      inv.Label,
+		RemoteAddr: remoteAddr,
+		ReceiverFP: inv.ReceiverFP(),
+	}
+	exp := inv.ExpiresAt()
+	rec.ExpiresAt = &exp
+	if rc := inv.ReceiverConn(); rc != nil {
+		rec.ReceiverAddr = rc.RemoteAddr().String()
+	}
+	auditRecord(rec)
+}
+
+// auditAuthFailure records a rejected token, claim token or code
+func auditAuthFailure(c net.Conn, role, reason, code string) {
+	auditRecord(AuditRecord{Event: AuditAuthFailed, Role: role, Reason: reason, Code: code, RemoteAddr: c.RemoteAddr().String()})
+}
+
+// auditSplice records a splice event; the caller holds spliceMu
+func auditSplice(event string, s *Splice) {
+	rec := AuditRecord{
+		Event:          event,
+		Reason:         s.CloseReason,
+		Code:           s.Code,
+		RID:            s.RID,
+		Label:          s.Label,
+		ReceiverFP:     s.ReceiverFP,
+		ReceiverAddr:   s.ReceiverAddr,
+		SenderAddr:     s.SenderAddr,
+		SenderIdentity: s.SenderIdentity,
+		SpliceID:       s.ID,
+		BytesUp:        s.BytesUp(),
+		BytesDown:      s.BytesDown(),
+	}
+	if s.ClosedAt != nil {
+		started := s.CreatedAt
+		rec.StartedAt = &started
+		rec.DurationSecs = float64(s.ClosedAt.Sub(s.CreatedAt).Milliseconds()) / 1000
+	}
+	auditRecord(rec)
+}
+
+// pruneClosedSplices forgets splices closed more than retention ago and
+// returns how many were removed. Their record lives on in the audit log.
+func pruneClosedSplices(retention time.Duration) int {
+	cutoff := time.Now().Add(-retention)
+	spliceMu.Lock()
+	defer spliceMu.Unlock()
+	n := 0
+	for id, s := range splices {
+		if s.ClosedAt != nil && s.ClosedAt.Before(cutoff) {
+			delete(splices, id)
+			n++
+		}
+	}
+	return n
+}
+
+// ====== Audit queries ======
+
+// AuditQuery selects records from an audit log and its rotated files.
+type AuditQuery struct {
+	Path   string
+	Since  time.Time // zero means from the beginning
+	Until  time.Time // zero means up to now
+	Events []string  // empty means all
+	Format string    // "json" or "csv"
+}
+
+// auditCSVHeader lists the CSV columns written by RunAudit
+var auditCSVHeader = []string{"time", "event", "reason", "code", "rid", "label", "role", "remote_addr", "receiver_fp", "receiver_addr", "sender_addr", "sender_identity", "splice_id", "started_at", "duration_s", "bytes_up", "bytes_down", "count"}
+
+// ParseAuditTime accepts a duration back from now ("24h"), an RFC 3339
+// timestamp or a date ("2006-01-02").
+func ParseAuditTime(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (want a duration like 24h, a date like 2006-01-02 or an RFC 3339 timestamp)", s)
+}
+
+// auditFiles returns the rotated files of path, oldest first, then path itself
+func auditFiles(path string) ([]string, error) {
+	rotated, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, f := range rotated {
+		if _, err := time.Parse(auditRotatedFormat, strings.TrimPrefix(f, path+".")); err == nil {
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no audit log at %s", path)
+	}
+	return files, nil
+}
+
+// RunAudit writes the records matching q to out.
+func RunAudit(q AuditQuery, out io.Writer) error {
+	if q.Format != "json" && q.Format != "csv" {
+		return fmt.Errorf("unknown format %q (want csv or json)", q.Format)
+	}
+	files, err := auditFiles(q.Path)
+	if err != nil {
+		return err
+	}
+
+	var emit func(AuditRecord) error
+	var finish func() error
+	switch q.Format {
+	case "csv":
+		w := csv.NewWriter(out)
+		if err := w.Write(auditCSVHeader); err != nil {
+			return err
+		}
+		emit = func(r AuditRecord) error { return w.Write(auditCSVRow(r)) }
+		finish = func() error { w.Flush(); return w.Error() }
+	default:
+		// A JSON array, one record per line
+		sep := "[\n"
+		emit = func(r AuditRecord) error {
+			b, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(out, "%s%s", sep, b)
+			sep = ",\n"
+			return err
+		}
+		finish = func() error {
+			if sep == "[\n" {
+				_, err := fmt.Fprintln(out, "[]")
+				return err
+			}
+			_, err := fmt.Fprintln(out, "\n]")
+			return err
+		}
+	}
+
+	for _, path := range files {
+		if !q.Since.IsZero() {
+			// A file last written before the window holds nothing in it
+			if st, err := os.Stat(path); err == nil && st.ModTime().Before(q.Since) {
+				continue
+			}
+		}
+		if err := scanAuditFile(path, q, emit); err != nil {
+			return err
+		}
+	}
+	return finish()
+}
+
+func scanAuditFile(path string, q AuditQuery, emit func(AuditRecord) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		var r AuditRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			// A torn last line after a crash must not hide the rest of the log
+			fmt.Fprintf(os.Stderr, "%s:%d: skipping bad record: %v\n", path, lineNo, err)
+			continue
+		}
+		if (!q.Since.IsZero() && r.Time.Before(q.Since)) || (!q.Until.IsZero() && r.Time.After(q.Until)) {
+			continue
+		}
+		if len(q.Events) > 0 && !slices.Contains(q.Events, r.Event) {
+			continue
+		}
+		if err := emit(r); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+func auditCSVRow(r AuditRecord) []string {
+	started := ""
+	if r.StartedAt != nil {
+		started = r.StartedAt.Format(time.RFC3339)
+	}
+	num := func(n int64) string {
+		if n == 0 {
+			return ""
+		}
+		return strconv.FormatInt(n, 10)
+	}
+	duration := ""
+	if r.DurationSecs != 0 {
+		duration = strconv.FormatFloat(r.DurationSecs, 'f', -1, 64)
+	}
+	return []string{
+		r.Time.Format(time.RFC3339), r.Event, r.Reason, r.Code, r.RID, r.Label, r.Role, r.RemoteAddr,
+		r.ReceiverFP, r.ReceiverAddr, r.SenderAddr, r.SenderIdentity, r.SpliceID,
+		started, duration, num(r.BytesUp), num(r.BytesDown), num(r.Count),
+	}
+}
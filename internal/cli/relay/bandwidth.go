@@ -0,0 +1,318 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bandwidth shaping: every splice writes through a chain of token buckets
+// (its own, its tenant's and the relay's). Buckets hand out tokens in
+// reservation order, so splices sharing a bucket take turns chunk by chunk
+// and split its rate evenly instead of the busiest one starving the rest.
+const (
+	shapeMinChunk       = 1 << 10
+	shapeMaxChunk       = 32 << 10
+	shapeBurst          = 100 * time.Millisecond // bucket depth, in time at the bucket's rate
+	rateSampleInterval  = time.Second
+	rateHistoryLen      = 120                   // rate samples kept per splice, for the TUI's sparklines
+	throttledAfterDelay = 10 * time.Millisecond // shorter waits don't count as throttling
+)
+
+// BandwidthConfig caps relayed traffic. Rates are strings like "10MB/s",
+// "512KiB/s" or "100Mbit/s"; empty means unlimited.
+type BandwidthConfig struct {
+	Global    string `yaml:"global,omitempty" mapstructure:"global,omitempty"`         // all splices together
+	PerSplice string `yaml:"per-splice,omitempty" mapstructure:"per-splice,omitempty"` // each splice
+}
+
+// TenantConfig names a group of receivers and senders by the token they
+// present, with its own bandwidth caps and session limits.
+type TenantConfig struct {
+	Name        string `yaml:"name" mapstructure:"name"`
+	Token       string `yaml:"token" mapstructure:"token"`                                   // accepted as receiver and sender token
+	Bandwidth   string `yaml:"bandwidth,omitempty" mapstructure:"bandwidth,omitempty"`       // all of the tenant's splices together
+	PerSplice   string `yaml:"per-splice,omitempty" mapstructure:"per-splice,omitempty"`     // overrides the relay's per-splice cap
+	MaxDuration string `yaml:"max-duration,omitempty" mapstructure:"max-duration,omitempty"` // overrides the relay's max-splice-duration ("0" for none)
+	IdleTimeout string `yaml:"idle-timeout,omitempty" mapstructure:"idle-timeout,omitempty"` // overrides the relay's splice-idle-timeout ("0" for none)
+}
+
+// ParseRate parses a rate like "10MB/s", "512KiB/s" or "100Mbit/s" into
+// bytes per second. The "/s" is optional and a bare number is bytes.
+func ParseRate(s string) (float64, error) {
+	t := strings.TrimSuffix(strings.TrimSpace(s), "/s")
+	i := strings.IndexFunc(t, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	num, unit := t, ""
+	if i >= 0 {
+		num, unit = t[:i], strings.TrimSpace(t[i:])
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v <= 0 || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("invalid rate %q (want e.g. 10MB/s, 512KiB/s or 100Mbit/s)", s)
+	}
+	mult := map[string]float64{
+		"": 1, "B": 1,
+		"KB": 1e3, "MB": 1e6, "GB": 1e9,
+		"KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30,
+		"kbit": 1e3 / 8, "Kbit": 1e3 / 8, "Mbit": 1e6 / 8, "Gbit": 1e9 / 8,
+	}[unit]
+	if mult == 0 {
+		return 0, fmt.Errorf("invalid rate %q: unknown unit %q", s, unit)
+	}
+	return v * mult, nil
+}
+
+// formatRate renders bytes per second for humans
+func formatRate(r float64) string {
+	return formatBytes(int64(r)) + "/s"
+}
+
+func orUnlimited(rate string) string {
+	if rate == "" {
+		return "unlimited"
+	}
+	return rate
+}
+
+// tokenBucket hands out bytes at rate with a burst of shapeBurst.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64 // negative while reservations are queued
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := math.Max(rate*shapeBurst.Seconds(), shapeMinChunk)
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve takes n bytes and returns how long the caller must wait before
+// sending them.
+func (b *tokenBucket) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// spliceLimiter is the bucket chain a splice writes through.
+type spliceLimiter struct {
+	buckets []*tokenBucket
+	chunk   int
+	waiting atomic.Int32 // directions currently held back
+	waited  atomic.Int64 // nanoseconds spent held back
+}
+
+// wait blocks until n bytes may be sent.
+func (l *spliceLimiter) wait(n int) {
+	var d time.Duration
+	for _, b := range l.buckets {
+		d = max(d, b.reserve(n))
+	}
+	if d <= 0 {
+		return
+	}
+	if d >= throttledAfterDelay {
+		l.waited.Add(int64(d))
+		throttledNanos.Add(int64(d))
+	}
+	l.waiting.Add(1)
+	time.Sleep(d)
+	l.waiting.Add(-1)
+}
+
+// bandwidthShaper holds the relay-wide and per-tenant buckets.
+type bandwidthShaper struct {
+	global    *tokenBucket
+	perSplice float64
+	tenants   map[string]*tenantShape
+}
+
+type tenantShape struct {
+	bucket    *tokenBucket
+	perSplice float64
+}
+
+var (
+	shaper *bandwidthShaper
+	// cumulative counters for metrics, including splices already forgotten
+	relayedBytesUp   atomic.Int64
+	relayedBytesDown atomic.Int64
+	throttledNanos   atomic.Int64
+)
+
+// newBandwidthShaper validates the configured rates. It returns nil when
+// nothing is capped.
+func newBandwidthShaper(cfg BandwidthConfig, tenants []TenantConfig) (*bandwidthShaper, error) {
+	parse := func(what, s string) (float64, error) {
+		if s == "" {
+			return 0, nil
+		}
+		r, err := ParseRate(s)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", what, err)
+		}
+		return r, nil
+	}
+	s := &bandwidthShaper{tenants: map[string]*tenantShape{}}
+	limited := false
+	global, err := parse("bandwidth.global", cfg.Global)
+	if err != nil {
+		return nil, err
+	}
+	if global > 0 {
+		s.global = newTokenBucket(global)
+		limited = true
+	}
+	if s.perSplice, err = parse("bandwidth.per-splice", cfg.PerSplice); err != nil {
+		return nil, err
+	}
+	limited = limited || s.perSplice > 0
+	for _, t := range tenants {
+		ts := &tenantShape{}
+		total, err := parse("tenant "+t.Name+" bandwidth", t.Bandwidth)
+		if err != nil {
+			return nil, err
+		}
+		if total > 0 {
+			ts.bucket = newTokenBucket(total)
+		}
+		if ts.perSplice, err = parse("tenant "+t.Name+" per-splice", t.PerSplice); err != nil {
+			return nil, err
+		}
+		limited = limited || total > 0 || ts.perSplice > 0
+		s.tenants[t.Name] = ts
+	}
+	if !limited {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// limiter returns the bucket chain for a new splice of tenant ("" for none),
+// or nil if it is not capped.
+func (s *bandwidthShaper) limiter(tenant string) *spliceLimiter {
+	if s == nil {
+		return nil
+	}
+	l := &spliceLimiter{}
+	perSplice := s.perSplice
+	if ts := s.tenants[tenant]; ts != nil {
+		if ts.perSplice > 0 {
+			perSplice = ts.perSplice
+		}
+		if ts.bucket != nil {
+			l.buckets = append(l.buckets, ts.bucket)
+		}
+	}
+	if perSplice > 0 {
+		l.buckets = append(l.buckets, newTokenBucket(perSplice))
+	}
+	if s.global != nil {
+		l.buckets = append(l.buckets, s.global)
+	}
+	if len(l.buckets) == 0 {
+		return nil
+	}
+	// Small chunks at low rates keep the waits short and the sharing smooth
+	slowest := math.Inf(1)
+	for _, b := range l.buckets {
+		slowest = math.Min(slowest, b.rate)
+	}
+	l.chunk = min(max(int(slowest/20), shapeMinChunk), shapeMaxChunk)
+	return l
+}
+
+// GlobalBandwidthCap returns the relay-wide cap in bytes per second, or 0.
+func GlobalBandwidthCap() float64 {
+	if shaper == nil || shaper.global == nil {
+		return 0
+	}
+	return shaper.global.rate
+}
+
+// tenantForToken returns the tenant whose token is token, or nil.
+func tenantForToken(tenants []TenantConfig, token string) *TenantConfig {
+	if token == "" {
+		return nil
+	}
+	for i := range tenants {
+		if tenants[i].Token == token {
+			return &tenants[i]
+		}
+	}
+	return nil
+}
+
+// ====== Rate sampling ======
+
+// relayRate is the total rate measured at the last sample, in bytes per second
+var relayRate atomic.Uint64 // math.Float64bits
+
+// CurrentRelayRate returns the rate of all splices together at the last sample.
+func CurrentRelayRate() float64 {
+	return math.Float64frombits(relayRate.Load())
+}
+
+// sampleRates updates the current rate and throttling state of every active splice.
+func sampleRates(now time.Time) {
+	spliceMu.Lock()
+	defer spliceMu.Unlock()
+	total := 0.0
+	for _, s := range splices {
+		if s.ClosedAt != nil {
+			continue
+		}
+		bytes := s.BytesUp() + s.BytesDown()
+		if dt := now.Sub(s.lastSample).Seconds(); !s.lastSample.IsZero() && dt > 0 {
+			s.Rate = float64(bytes-s.lastBytes) / dt
+			if len(s.rateHistory) == rateHistoryLen {
+				s.rateHistory = slices.Delete(s.rateHistory, 0, 1)
+			}
+			s.rateHistory = append(s.rateHistory, s.Rate)
+		}
+		s.lastBytes, s.lastSample = bytes, now
+		if s.limiter != nil {
+			waited := s.limiter.waited.Load()
+			s.Throttled = waited > s.lastWaited || s.limiter.waiting.Load() > 0
+			s.lastWaited = waited
+		}
+		total += s.Rate
+	}
+	relayRate.Store(math.Float64bits(total))
+}
+
+// RateHistory returns the splice's rate at its last samples, oldest first.
+func (s *Splice) RateHistory() []float64 {
+	spliceMu.RLock()
+	defer spliceMu.RUnlock()
+	return slices.Clone(s.rateHistory)
+}
+
+// rateSampler samples splice rates until ctx is cancelled.
+func rateSampler(ctx context.Context) {
+	t := time.NewTicker(rateSampleInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			sampleRates(now)
+		}
+	}
+}
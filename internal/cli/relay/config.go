@@ -1,16 +1,46 @@
 package relay
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	"ssh-portal/internal/cli/usercode"
 )
 
 // RelayConfig represents the relay configuration
 type RelayConfig struct {
-	Port          int    `yaml:"port,omitempty" mapstructure:"port,omitempty"`
-	Interactive   *bool  `yaml:"interactive,omitempty" mapstructure:"interactive,omitempty"`
-	ReceiverToken string `yaml:"receiver-token,omitempty" mapstructure:"receiver-token,omitempty"`
-	SenderToken   string `yaml:"sender-token,omitempty" mapstructure:"sender-token,omitempty"`
+	Port             int              `yaml:"port,omitempty" mapstructure:"port,omitempty"`
+	Listen           []ListenerConfig `yaml:"listen,omitempty" mapstructure:"listen,omitempty"`
+	Interactive      *bool            `yaml:"interactive,omitempty" mapstructure:"interactive,omitempty"`
+	ReceiverToken    string           `yaml:"receiver-token,omitempty" mapstructure:"receiver-token,omitempty"`
+	SenderToken      string           `yaml:"sender-token,omitempty" mapstructure:"sender-token,omitempty"`
+	CodeWords        int              `yaml:"code-words,omitempty" mapstructure:"code-words,omitempty"`
+	MinClientVersion string           `yaml:"min-client-version,omitempty" mapstructure:"min-client-version,omitempty"`
+	DrainTimeout     string           `yaml:"drain-timeout,omitempty" mapstructure:"drain-timeout,omitempty"`
+	HealthAddr       string           `yaml:"health-addr,omitempty" mapstructure:"health-addr,omitempty"`
+	Dashboard        bool             `yaml:"dashboard,omitempty" mapstructure:"dashboard,omitempty"`
+	DashboardToken   string           `yaml:"dashboard-token,omitempty" mapstructure:"dashboard-token,omitempty"`
+	AdminSocket      string           `yaml:"admin-socket,omitempty" mapstructure:"admin-socket,omitempty"`
+	APIAddr          string           `yaml:"api-addr,omitempty" mapstructure:"api-addr,omitempty"`
+	APIToken         string           `yaml:"api-token,omitempty" mapstructure:"api-token,omitempty"`
+	PublicHost       string           `yaml:"public-host,omitempty" mapstructure:"public-host,omitempty"`
+	Hooks            HooksConfig      `yaml:"hooks,omitempty" mapstructure:"hooks,omitempty"`
+	AuditLog         string           `yaml:"audit-log,omitempty" mapstructure:"audit-log,omitempty"`
+	AuditMaxSize     int              `yaml:"audit-max-size,omitempty" mapstructure:"audit-max-size,omitempty"` // MiB
+	AuditRotate      string           `yaml:"audit-rotate,omitempty" mapstructure:"audit-rotate,omitempty"`
+	SpliceRetention  string           `yaml:"splice-retention,omitempty" mapstructure:"splice-retention,omitempty"`
+	Bandwidth        BandwidthConfig  `yaml:"bandwidth,omitempty" mapstructure:"bandwidth,omitempty"`
+	Tenants          []TenantConfig   `yaml:"tenants,omitempty" mapstructure:"tenants,omitempty"`
+	MaxSplice        string           `yaml:"max-splice-duration,omitempty" mapstructure:"max-splice-duration,omitempty"`
+	SpliceIdle       string           `yaml:"splice-idle-timeout,omitempty" mapstructure:"splice-idle-timeout,omitempty"`
+	Abuse            AbuseConfig      `yaml:"abuse,omitempty" mapstructure:"abuse,omitempty"`
+	ACL              ACLConfig        `yaml:"acl,omitempty" mapstructure:"acl,omitempty"`
+	InviteTTL        string           `yaml:"invite-ttl,omitempty" mapstructure:"invite-ttl,omitempty"`
+	MaxInviteTTL     string           `yaml:"max-invite-ttl,omitempty" mapstructure:"max-invite-ttl,omitempty"`
+	PingInterval     string           `yaml:"ping-interval,omitempty" mapstructure:"ping-interval,omitempty"`
 }
 
 // LoadRelayConfig loads relay configuration from viper
@@ -25,21 +55,82 @@ func LoadRelayConfig() *RelayConfig {
 	return &cfg
 }
 
+// ReloadRelayConfig reads the config file again and returns its relay
+// section, nil if it has none. Unlike LoadRelayConfig it reports errors,
+// so a broken edit does not wipe out a running relay's settings.
+func ReloadRelayConfig() (*RelayConfig, error) {
+	if viper.ConfigFileUsed() == "" {
+		return nil, fmt.Errorf("the relay was started without a config file")
+	}
+	if err := viper.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	if !viper.IsSet("relay") {
+		return nil, nil
+	}
+	var cfg RelayConfig
+	if err := viper.UnmarshalKey("relay", &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
 // MergeRelayFlags merges config with CLI flags, returning the final values
 // Flags override config values when explicitly set
 type RelayFlags struct {
-	Port          int
-	Interactive   bool
-	ReceiverToken string
-	SenderToken   string
+	Port             int
+	Listen           []ListenerConfig // listen addresses with their own policy (default: every address on Port)
+	Interactive      bool
+	ReceiverToken    string
+	SenderToken      string
+	CodeWords        int             // minimum code strength (4, 6 or 8 words)
+	MinClientVersion string          // oldest client version accepted ("" accepts all)
+	DrainTimeout     time.Duration   // how long a drain waits for active splices
+	HealthAddr       string          // listen address of the health endpoints ("" disables them)
+	Dashboard        bool            // serve the web dashboard on HealthAddr
+	DashboardToken   string          // bearer token that unlocks revoke and kill in the dashboard ("": read-only)
+	AdminSocket      string          // path of the admin unix socket ("" disables it)
+	APIAddr          string          // listen address of the invite HTTP API ("" disables it)
+	APIToken         string          // bearer token required by the invite API
+	PublicHost       string          // relay host name handed out in receiver commands
+	Hooks            HooksConfig     // webhooks and exec hooks notified of relay events
+	WebhookURLs      []string        // --webhook, added to the configured webhooks
+	WebhookSecret    string          // --webhook-secret, signs the --webhook deliveries
+	ExecHooks        []string        // --exec-hook, added to the configured exec hooks
+	AuditLog         string          // path of the JSONL audit log ("" disables it)
+	AuditMaxSize     int64           // audit log size in bytes that triggers a rotation (0: no limit)
+	AuditRotate      time.Duration   // audit log age that triggers a rotation (0: never)
+	SpliceRetention  time.Duration   // how long closed splices stay in memory
+	Bandwidth        BandwidthConfig // relay-wide and per-splice bandwidth caps
+	Tenants          []TenantConfig  // tokens with their own bandwidth caps and session limits
+	MaxSplice        time.Duration   // longest a splice may stay open (0: no limit)
+	SpliceIdle       time.Duration   // how long a splice may stay idle (0: no limit)
+	Abuse            AbuseConfig     // rate limits, connection caps and bans
+	ACL              ACLConfig       // networks receivers and senders may connect from
+	InviteTTL        time.Duration   // TTL of invites minted for receivers that don't ask for one
+	MaxInviteTTL     time.Duration   // longest TTL a receiver may ask for
+	PingInterval     time.Duration   // how often waiting receivers are pinged (0: never)
+
+	// Reload reads the config file again and merges it with the same flags;
+	// nil if the relay cannot reload
+	Reload func() (RelayFlags, error)
 }
 
 func MergeRelayFlags(cmd *cobra.Command, cfg *RelayConfig, flags RelayFlags) RelayFlags {
 	result := RelayFlags{
-		Port:          4430,
-		Interactive:   true,
-		ReceiverToken: "",
-		SenderToken:   "",
+		Port:            4430,
+		Interactive:     true,
+		ReceiverToken:   "",
+		SenderToken:     "",
+		CodeWords:       usercode.DefaultCodeWords,
+		DrainTimeout:    30 * time.Minute,
+		AdminSocket:     DefaultAdminSocket(),
+		AuditMaxSize:    100 << 20,
+		AuditRotate:     24 * time.Hour,
+		SpliceRetention: time.Hour,
+		InviteTTL:       10 * time.Minute,
+		MaxInviteTTL:    time.Hour,
+		PingInterval:    15 * time.Second,
 	}
 
 	// Apply config values as defaults
@@ -47,6 +138,7 @@ func MergeRelayFlags(cmd *cobra.Command, cfg *RelayConfig, flags RelayFlags) Rel
 		if cfg.Port > 0 {
 			result.Port = cfg.Port
 		}
+		result.Listen = cfg.Listen
 		if cfg.Interactive != nil {
 			result.Interactive = *cfg.Interactive
 		}
@@ -56,12 +148,92 @@ func MergeRelayFlags(cmd *cobra.Command, cfg *RelayConfig, flags RelayFlags) Rel
 		if cfg.SenderToken != "" {
 			result.SenderToken = cfg.SenderToken
 		}
+		if cfg.CodeWords > 0 {
+			result.CodeWords = cfg.CodeWords
+		}
+		if cfg.MinClientVersion != "" {
+			result.MinClientVersion = cfg.MinClientVersion
+		}
+		if cfg.DrainTimeout != "" {
+			if d, err := time.ParseDuration(cfg.DrainTimeout); err == nil && d > 0 {
+				result.DrainTimeout = d
+			}
+		}
+		if cfg.HealthAddr != "" {
+			result.HealthAddr = cfg.HealthAddr
+		}
+		result.Dashboard = cfg.Dashboard
+		if cfg.DashboardToken != "" {
+			result.DashboardToken = cfg.DashboardToken
+		}
+		if cfg.AdminSocket != "" {
+			result.AdminSocket = cfg.AdminSocket
+		}
+		if cfg.APIAddr != "" {
+			result.APIAddr = cfg.APIAddr
+		}
+		if cfg.APIToken != "" {
+			result.APIToken = cfg.APIToken
+		}
+		if cfg.PublicHost != "" {
+			result.PublicHost = cfg.PublicHost
+		}
+		result.Hooks = cfg.Hooks
+		result.Bandwidth = cfg.Bandwidth
+		result.Tenants = cfg.Tenants
+		result.Abuse = cfg.Abuse
+		result.ACL = cfg.ACL
+		if cfg.AuditLog != "" {
+			result.AuditLog = cfg.AuditLog
+		}
+		if cfg.AuditMaxSize > 0 {
+			result.AuditMaxSize = int64(cfg.AuditMaxSize) << 20
+		}
+		if cfg.AuditRotate != "" {
+			if d, err := time.ParseDuration(cfg.AuditRotate); err == nil && d >= 0 {
+				result.AuditRotate = d
+			}
+		}
+		if cfg.MaxSplice != "" {
+			if d, err := time.ParseDuration(cfg.MaxSplice); err == nil && d >= 0 {
+				result.MaxSplice = d
+			}
+		}
+		if cfg.SpliceIdle != "" {
+			if d, err := time.ParseDuration(cfg.SpliceIdle); err == nil && d >= 0 {
+				result.SpliceIdle = d
+			}
+		}
+		if cfg.InviteTTL != "" {
+			if d, err := time.ParseDuration(cfg.InviteTTL); err == nil && d > 0 {
+				result.InviteTTL = d
+			}
+		}
+		if cfg.MaxInviteTTL != "" {
+			if d, err := time.ParseDuration(cfg.MaxInviteTTL); err == nil && d > 0 {
+				result.MaxInviteTTL = d
+			}
+		}
+		if cfg.PingInterval != "" {
+			if d, err := time.ParseDuration(cfg.PingInterval); err == nil && d >= 0 {
+				result.PingInterval = d
+			}
+		}
+		if cfg.SpliceRetention != "" {
+			if d, err := time.ParseDuration(cfg.SpliceRetention); err == nil && d > 0 {
+				result.SpliceRetention = d
+			}
+		}
 	}
 
 	// CLI flags override config
 	if cmd.Flags().Changed("port") && flags.Port > 0 {
 		result.Port = flags.Port
 	}
+	// Listen addresses given on the command line replace the configured listeners
+	if cmd.Flags().Changed("listen") {
+		result.Listen = flags.Listen
+	}
 	if cmd.Flags().Changed("interactive") {
 		result.Interactive = flags.Interactive
 	}
@@ -71,6 +243,77 @@ func MergeRelayFlags(cmd *cobra.Command, cfg *RelayConfig, flags RelayFlags) Rel
 	if cmd.Flags().Changed("sender-token") {
 		result.SenderToken = flags.SenderToken
 	}
+	if cmd.Flags().Changed("code-words") && flags.CodeWords > 0 {
+		result.CodeWords = flags.CodeWords
+	}
+	if cmd.Flags().Changed("min-client-version") {
+		result.MinClientVersion = flags.MinClientVersion
+	}
+	if cmd.Flags().Changed("drain-timeout") && flags.DrainTimeout > 0 {
+		result.DrainTimeout = flags.DrainTimeout
+	}
+	if cmd.Flags().Changed("health-addr") {
+		result.HealthAddr = flags.HealthAddr
+	}
+	if cmd.Flags().Changed("dashboard") {
+		result.Dashboard = flags.Dashboard
+	}
+	if cmd.Flags().Changed("dashboard-token") {
+		result.DashboardToken = flags.DashboardToken
+	}
+	if cmd.Flags().Changed("admin-socket") {
+		result.AdminSocket = flags.AdminSocket
+	}
+	if cmd.Flags().Changed("api-addr") {
+		result.APIAddr = flags.APIAddr
+	}
+	if cmd.Flags().Changed("api-token") {
+		result.APIToken = flags.APIToken
+	}
+	if cmd.Flags().Changed("public-host") {
+		result.PublicHost = flags.PublicHost
+	}
+	if cmd.Flags().Changed("audit-log") {
+		result.AuditLog = flags.AuditLog
+	}
+	if cmd.Flags().Changed("audit-max-size") && flags.AuditMaxSize >= 0 {
+		result.AuditMaxSize = flags.AuditMaxSize
+	}
+	if cmd.Flags().Changed("audit-rotate") && flags.AuditRotate >= 0 {
+		result.AuditRotate = flags.AuditRotate
+	}
+	if cmd.Flags().Changed("splice-retention") && flags.SpliceRetention > 0 {
+		result.SpliceRetention = flags.SpliceRetention
+	}
+	if cmd.Flags().Changed("max-splice-duration") && flags.MaxSplice >= 0 {
+		result.MaxSplice = flags.MaxSplice
+	}
+	if cmd.Flags().Changed("splice-idle-timeout") && flags.SpliceIdle >= 0 {
+		result.SpliceIdle = flags.SpliceIdle
+	}
+	if cmd.Flags().Changed("invite-ttl") && flags.InviteTTL > 0 {
+		result.InviteTTL = flags.InviteTTL
+	}
+	if cmd.Flags().Changed("max-invite-ttl") && flags.MaxInviteTTL > 0 {
+		result.MaxInviteTTL = flags.MaxInviteTTL
+	}
+	if cmd.Flags().Changed("ping-interval") && flags.PingInterval >= 0 {
+		result.PingInterval = flags.PingInterval
+	}
+	if cmd.Flags().Changed("bandwidth") {
+		result.Bandwidth.Global = flags.Bandwidth.Global
+	}
+	if cmd.Flags().Changed("splice-bandwidth") {
+		result.Bandwidth.PerSplice = flags.Bandwidth.PerSplice
+	}
+	// Hooks given on the command line come on top of the configured ones
+	for _, u := range flags.WebhookURLs {
+		result.Hooks.Webhooks = append(result.Hooks.Webhooks, WebhookConfig{URL: u, Secret: flags.WebhookSecret})
+	}
+	for _, c := range flags.ExecHooks {
+		result.Hooks.Exec = append(result.Hooks.Exec, ExecHookConfig{Command: c})
+	}
+	result.CodeWords = usercode.NormalizeCodeWords(result.CodeWords)
 
 	return result
 }
@@ -0,0 +1,90 @@
+package relay
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// RunCtl sends req to the relay admin socket and prints the result
+func RunCtl(socket string, req AdminRequest) error {
+	resp, err := AdminCall(socket, req)
+	if err != nil {
+		return err
+	}
+	if req.Cmd == AdminList {
+		printAdminList(os.Stdout, resp)
+		return nil
+	}
+	fmt.Println(resp.Message)
+	return nil
+}
+
+// printAdminList prints outstanding invites, splices and throttled IPs as tables
+func printAdminList(out io.Writer, resp *AdminResponse) {
+	now := time.Now()
+	if resp.Draining {
+		fmt.Fprintln(out, "Relay is DRAINING")
+		fmt.Fprintln(out)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "INVITES (%d)\n", len(resp.Invites))
+	fmt.Fprintln(w, "CODE\tRID\tLABEL\tSTATE\tRECEIVER\tEXPIRES IN")
+	for _, inv := range resp.Invites {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", inv.Code, inv.RID, orDash(cmp.Or(inv.Label, inv.ReceiverLabel)), inv.State, orDash(inv.ReceiverAddr), inv.ExpiresAt.Sub(now).Round(time.Second))
+	}
+	w.Flush()
+	fmt.Fprintln(out)
+
+	// Active splices first, newest first within each group
+	splices := resp.Splices
+	sort.Slice(splices, func(i, j int) bool {
+		if (splices[i].ClosedAt == nil) != (splices[j].ClosedAt == nil) {
+			return splices[i].ClosedAt == nil
+		}
+		return splices[i].CreatedAt.After(splices[j].CreatedAt)
+	})
+	fmt.Fprintf(w, "SPLICES (%d)\n", len(splices))
+	fmt.Fprintln(w, "ID\tCODE\tLABEL\tSENDER\tRECEIVER\tUP\tDOWN\tRATE\tSTATE")
+	for _, s := range splices {
+		state, rate := "active "+now.Sub(s.CreatedAt).Round(time.Second).String(), formatRate(s.Rate)
+		if s.Throttled {
+			state += " (throttled)"
+		}
+		if s.EndsAt != nil && s.ClosedAt == nil {
+			state += ", ends in " + s.EndsAt.Sub(now).Round(time.Second).String()
+		}
+		if s.ClosedAt != nil {
+			state, rate = "closed "+s.ClosedAt.Format(time.TimeOnly), "-"
+			if s.CloseReason != "" {
+				state += " (" + s.CloseReason + ")"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", s.ID, s.Code, orDash(s.Label), s.SenderAddr, s.ReceiverAddr, formatBytes(s.BytesUp), formatBytes(s.BytesDown), rate, state)
+	}
+	w.Flush()
+	fmt.Fprintln(out)
+
+	fmt.Fprintf(w, "THROTTLED AND BANNED (%d)\n", len(resp.Throttled))
+	fmt.Fprintln(w, "ADDRESS\tLIMIT\tREFUSED\tLAST REFUSED\tBANNED FOR")
+	for _, t := range resp.Throttled {
+		banned := "-"
+		if t.BannedUntil != nil {
+			banned = t.BannedUntil.Sub(now).Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", t.IP, t.Limit, t.Refused, t.LastRefused.Format(time.TimeOnly), banned)
+	}
+	w.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
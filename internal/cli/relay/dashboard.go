@@ -0,0 +1,258 @@
+package relay
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"ssh-portal/internal/version"
+)
+
+// The dashboard is a read-only web view of what the relay TUI shows, for
+// people who can reach the health listener but not the relay host. The page
+// streams the relay's state as server-sent events. With a dashboard token it
+// also offers the TUI's revoke and kill, authenticated with the token as a
+// bearer token; the stream itself is never authenticated, so relay codes are
+// shortened in it and RIDs, which let a receiver wait on an invite, are
+// replaced with opaque handles.
+
+//go:embed dashboard.html
+var dashboardPage []byte
+
+const (
+	dashboardInterval   = time.Second      // how often the state is sent when it changed
+	dashboardHeartbeat  = 15 * time.Second // comment sent on an unchanged state, so proxies keep the stream
+	dashboardMaxStreams = 64               // concurrent event streams
+	dashboardClosed     = 50               // recently closed splices shown
+)
+
+// dashboardConfig configures the dashboard served on the health listener.
+type dashboardConfig struct {
+	Token string // unlocks revoke and kill ("": read-only)
+}
+
+// dashboardState is one event of the dashboard's stream
+type dashboardState struct {
+	Time      time.Time     `json:"time"` // when it was sent
+	Version   string        `json:"version"`
+	Draining  bool          `json:"draining,omitempty"`
+	Actions   bool          `json:"actions,omitempty"` // revoke and kill are available
+	Rate      float64       `json:"rate"`              // bytes per second, all splices
+	RateCap   float64       `json:"rate_cap,omitempty"`
+	BytesUp   int64         `json:"bytes_up"` // relayed since start
+	BytesDown int64         `json:"bytes_down"`
+	Invites   []InviteInfo  `json:"invites"`
+	Splices   []SpliceInfo  `json:"splices"`
+	Closed    []SpliceInfo  `json:"closed"` // most recently closed first
+	Throttled []ThrottledIP `json:"throttled"`
+}
+
+var dashboardStreams atomic.Int32
+
+// dashboardKey keys the invite handles of the dashboard and of hook events;
+// it is new for every relay process
+var dashboardKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+// inviteHandle is the opaque name the dashboard and hook events use for the
+// invite with rid. It can't be turned back into the RID; only the dashboard's
+// revoke takes it.
+func inviteHandle(rid string) string {
+	mac := hmac.New(sha256.New, dashboardKey)
+	mac.Write([]byte(rid))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// inviteByHandle returns the outstanding invite with handle h, if any
+func inviteByHandle(h string) *Invite {
+	for _, inv := range GetOutstandingInvites() {
+		if hmac.Equal([]byte(inviteHandle(inv.RID)), []byte(h)) {
+			return inv
+		}
+	}
+	return nil
+}
+
+// currentDashboardState returns the relay's state, without its Time
+func currentDashboardState(actions bool) dashboardState {
+	st := dashboardState{
+		Version:   version.String(),
+		Draining:  IsDraining(),
+		Actions:   actions,
+		Rate:      CurrentRelayRate(),
+		RateCap:   GlobalBandwidthCap(),
+		BytesUp:   relayedBytesUp.Load(),
+		BytesDown: relayedBytesDown.Load(),
+		Invites:   []InviteInfo{},
+		Splices:   []SpliceInfo{},
+		Closed:    []SpliceInfo{},
+		Throttled: GetThrottledIPs(),
+	}
+	for _, inv := range sortedInvites() {
+		st.Invites = append(st.Invites, inviteInfo(inv).redacted())
+	}
+	for _, s := range spliceInfos() {
+		s = s.redacted()
+		if s.ClosedAt == nil {
+			st.Splices = append(st.Splices, s)
+		} else {
+			st.Closed = append(st.Closed, s)
+		}
+	}
+	slices.SortFunc(st.Splices, func(a, b SpliceInfo) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	slices.SortFunc(st.Closed, func(a, b SpliceInfo) int { return b.ClosedAt.Compare(*a.ClosedAt) })
+	st.Closed = st.Closed[:min(len(st.Closed), dashboardClosed)]
+	if st.Throttled == nil {
+		st.Throttled = []ThrottledIP{}
+	}
+	return st
+}
+
+// redacted returns info as shown outside the relay's own tools: with a
+// short code and an invite handle, neither of which lets anyone connect
+func (info InviteInfo) redacted() InviteInfo {
+	info.Code = shortCode(info.Code)
+	info.RID = inviteHandle(info.RID)
+	return info
+}
+
+// redacted returns s as shown outside the relay's own tools, like an InviteInfo
+func (s SpliceInfo) redacted() SpliceInfo {
+	s.Code = shortCode(s.Code)
+	s.RID = inviteHandle(s.RID)
+	return s
+}
+
+// shortCode keeps enough of a relay code to tell invites apart, not enough
+// to use it
+func shortCode(code string) string {
+	if len(code) <= 3 {
+		return code
+	}
+	return code[:3] + "…"
+}
+
+// registerDashboard adds the dashboard to the health endpoints:
+//
+//	GET  /dashboard/         the page
+//	GET  /dashboard/events   the relay's state as server-sent events
+//	POST /dashboard/revoke   revoke the invite with handle id (bearer token)
+//	POST /dashboard/kill     kill the splice with ID id (bearer token)
+func registerDashboard(mux *http.ServeMux, cfg dashboardConfig) {
+	mux.HandleFunc("GET /dashboard/{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+		w.Header().Set("X-Frame-Options", "DENY")
+		_, _ = w.Write(dashboardPage)
+	})
+	mux.HandleFunc("GET /dashboard/events", func(w http.ResponseWriter, r *http.Request) {
+		serveDashboardEvents(w, r, cfg.Token != "")
+	})
+
+	action := func(name string, do func(id string) error) {
+		if cfg.Token == "" {
+			mux.HandleFunc("POST /dashboard/"+name, func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "the dashboard is read-only (no dashboard-token)", http.StatusForbidden)
+			})
+			return
+		}
+		mux.Handle("POST /dashboard/"+name, requireBearer(cfg.Token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := strings.TrimSpace(r.FormValue("id"))
+			if id == "" {
+				http.Error(w, "missing id", http.StatusBadRequest)
+				return
+			}
+			if err := do(id); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			log.Printf("[DASHBOARD] %s %s by %s", name, id, r.RemoteAddr)
+			w.WriteHeader(http.StatusNoContent)
+		})))
+	}
+	// Invites by handle and splices by ID only, as the page never sees whole
+	// codes or RIDs
+	action("revoke", func(h string) error {
+		inv := inviteByHandle(h)
+		if inv == nil {
+			return fmt.Errorf("no outstanding invite %q", h)
+		}
+		_, err := RevokeInvite(inv.RID)
+		return err
+	})
+	action("kill", func(id string) error {
+		if !slices.ContainsFunc(GetActiveSplices(), func(s *Splice) bool { return s.ID == id }) {
+			return fmt.Errorf("no active splice %q", id)
+		}
+		_, err := KillSplice(id)
+		return err
+	})
+}
+
+// serveDashboardEvents streams the relay's state until the client goes away
+// or the server shuts down
+func serveDashboardEvents(w http.ResponseWriter, r *http.Request, actions bool) {
+	if dashboardStreams.Add(1) > dashboardMaxStreams {
+		dashboardStreams.Add(-1)
+		http.Error(w, "too many dashboard streams", http.StatusServiceUnavailable)
+		return
+	}
+	defer dashboardStreams.Add(-1)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	tick := time.NewTicker(dashboardInterval)
+	defer tick.Stop()
+	var last []byte
+	lastSent := time.Now()
+	for {
+		st := currentDashboardState(actions)
+		data, err := json.Marshal(st)
+		if err != nil {
+			log.Printf("[DASHBOARD] encode state: %v", err)
+			return
+		}
+		switch {
+		case !bytes.Equal(data, last):
+			last, lastSent = data, time.Now()
+			st.Time = lastSent
+			event, _ := json.Marshal(st)
+			_, err = fmt.Fprintf(w, "event: state\ndata: %s\n\n", event)
+		case time.Since(lastSent) >= dashboardHeartbeat:
+			_, err = io.WriteString(w, ": heartbeat\n\n")
+			lastSent = time.Now()
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
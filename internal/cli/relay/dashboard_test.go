@@ -0,0 +1,111 @@
+package relay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboard(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	inv, _, err := PreMintInvite("TICKET-7", 10*time.Minute, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if GetByRID(inv.RID) != nil {
+			DeleteInvite(inv, "test")
+		}
+	}()
+
+	readOnly := httptest.NewServer(healthHandler(func() bool { return false }, &dashboardConfig{}))
+	defer readOnly.Close()
+	srv := httptest.NewServer(healthHandler(func() bool { return false }, &dashboardConfig{Token: "s3cret"}))
+	defer srv.Close()
+
+	revoke := func(base, token, id string) int {
+		t.Helper()
+		req, _ := http.NewRequest("POST", base+"/dashboard/revoke", strings.NewReader(url.Values{"id": {id}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	resp, err := http.Get(srv.URL + "/dashboard/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type %q", ct)
+	}
+	var st dashboardState
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		if data, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+			if err := json.Unmarshal([]byte(data), &st); err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+	if !st.Actions {
+		t.Fatal("actions not offered with a dashboard token")
+	}
+	var shown *InviteInfo
+	for i := range st.Invites {
+		if st.Invites[i].RID == inviteHandle(inv.RID) {
+			shown = &st.Invites[i]
+		}
+	}
+	if shown == nil || shown.Label != "TICKET-7" {
+		t.Fatalf("invite missing from the stream: %+v", st.Invites)
+	}
+	if strings.Contains(fmt.Sprint(st), inv.RID) {
+		t.Fatal("stream shows the invite's RID")
+	}
+	if shown.Code == inv.Code || !strings.HasPrefix(inv.Code, strings.TrimSuffix(shown.Code, "…")) {
+		t.Fatalf("stream shows code %q for %q", shown.Code, inv.Code)
+	}
+
+	if code := revoke(readOnly.URL, "s3cret", inv.RID); code != http.StatusForbidden {
+		t.Fatalf("read-only revoke: status %d, want 403", code)
+	}
+	if code := revoke(srv.URL, "wrong", inv.RID); code != http.StatusUnauthorized {
+		t.Fatalf("bad token revoke: status %d, want 401", code)
+	}
+	if code := revoke(srv.URL, "s3cret", inv.Code); code != http.StatusNotFound {
+		t.Fatalf("revoke by code: status %d, want 404", code)
+	}
+	if code := revoke(srv.URL, "s3cret", inv.RID); code != http.StatusNotFound {
+		t.Fatalf("revoke by RID: status %d, want 404", code)
+	}
+	if GetByRID(inv.RID) == nil {
+		t.Fatal("invite revoked by a refused request")
+	}
+	if code := revoke(srv.URL, "s3cret", shown.RID); code != http.StatusNoContent {
+		t.Fatalf("revoke: status %d, want 204", code)
+	}
+	if GetByRID(inv.RID) != nil {
+		t.Fatal("invite still outstanding after revoke")
+	}
+}
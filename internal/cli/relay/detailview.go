@@ -0,0 +1,171 @@
+package relay
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"ssh-portal/internal/cli/tui"
+)
+
+// detailField is one line of the detail pane
+type detailField struct {
+	Name  string
+	Value string
+}
+
+// inviteDetail describes an outstanding invite for the detail pane
+func inviteDetail(inv *Invite) (string, []detailField) {
+	info := inviteInfo(inv)
+	identity := ""
+	if sender := inv.Sender(); sender != nil {
+		identity = sender.Identity
+	}
+	now := time.Now()
+	return "Invite " + inv.Code, []detailField{
+		{"RID", inv.RID},
+		{"Label", inv.Label},
+		{"State", info.State},
+		{"Receiver", info.ReceiverAddr},
+		{"Receiver label", inv.ReceiverLabel()},
+		{"Fingerprint", inv.ReceiverFP()},
+		{"Tenant", inv.Tenant()},
+		{"Sender identity", identity},
+		{"Code words", fmt.Sprint(inv.CodeWords)},
+		{"Created", formatAt(inv.CreatedAt, now)},
+		{"Expires", formatAt(inv.ExpiresAt(), now)},
+	}
+}
+
+// spliceDetail describes an active splice for the detail pane, with its rate
+// at the last samples
+func spliceDetail(s *Splice) (string, []detailField, []float64) {
+	spliceMu.RLock()
+	info := spliceInfo(s)
+	spliceMu.RUnlock()
+
+	now := time.Now()
+	rate := formatRate(info.Rate)
+	if info.Throttled {
+		rate += " (throttled)"
+	}
+	ends, idle := "", ""
+	if info.EndsAt != nil {
+		ends = formatAt(*info.EndsAt, now)
+	}
+	if info.IdleTimeout > 0 {
+		idle = (time.Duration(info.IdleTimeout) * time.Second).String()
+	}
+	return "Splice " + s.Code, []detailField{
+		{"ID", s.ID},
+		{"RID", s.RID},
+		{"Label", s.Label},
+		{"Tenant", s.Tenant},
+		{"Sender", s.SenderAddr},
+		{"Sender identity", s.SenderIdentity},
+		{"Receiver", s.ReceiverAddr},
+		{"Fingerprint", s.ReceiverFP},
+		{"Started", formatAt(s.CreatedAt, now)},
+		{"Ends", ends},
+		{"Idle timeout", idle},
+		{"Up", formatBytes(info.BytesUp)},
+		{"Down", formatBytes(info.BytesDown)},
+		{"Rate", rate},
+	}, append([]float64{}, s.RateHistory()...) // not nil before the first sample
+}
+
+// blockDetail describes a throttled or banned address for the detail pane
+func blockDetail(t ThrottledIP) (string, []detailField) {
+	now := time.Now()
+	banned := ""
+	if t.BannedUntil != nil {
+		banned = formatAt(*t.BannedUntil, now)
+	}
+	return "Throttled " + t.IP, []detailField{
+		{"Limit", t.Limit},
+		{"Refused", fmt.Sprint(t.Refused)},
+		{"Last refused", formatAt(t.LastRefused, now)},
+		{"Banned until", banned},
+	}
+}
+
+// formatAt renders a time of day with how long ago or how far ahead it is
+func formatAt(t, now time.Time) string {
+	d := t.Sub(now).Round(time.Second)
+	if d < 0 {
+		return fmt.Sprintf("%s (%s ago)", t.Format("15:04:05"), -d)
+	}
+	return fmt.Sprintf("%s (in %s)", t.Format("15:04:05"), d)
+}
+
+// RenderDetailPane renders the details of the selected row in place of the
+// logs. Splices get a sparkline of their rate over the last samples; rates
+// is nil for other rows.
+func RenderDetailPane(width, height int, title string, fields []detailField, rates []float64) string {
+	paneStyle := lipgloss.NewStyle().
+		Background(lipgloss.Color("235")).
+		Width(width-2).
+		Height(height).
+		MaxHeight(height).
+		Padding(0, 1)
+
+	titleStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(lipgloss.Color("62"))
+
+	infoStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("240"))
+
+	inner := max(width-4, 10)
+	lines := []string{titleStyle.Render(title) + infoStyle.Render("  (enter or esc to close)"), ""}
+	if rates != nil {
+		peak := 0.0
+		for _, r := range rates {
+			peak = max(peak, r)
+		}
+		window := time.Duration(len(rates)) * rateSampleInterval
+		lines = append(lines,
+			infoStyle.Render(fmt.Sprintf("Throughput over %s, peak %s", window, formatRate(peak))),
+			tui.Sparkline(rates, inner),
+			"")
+	}
+
+	// Two columns when one doesn't fit
+	rows := max(height-len(lines), 1)
+	columns := 1
+	if len(fields) > rows && inner >= 80 {
+		columns = 2
+	}
+	perColumn := (len(fields) + columns - 1) / columns
+	var blocks []string
+	for i := 0; i < len(fields); i += perColumn {
+		blocks = append(blocks, renderFields(fields[i:min(i+perColumn, len(fields))], inner/columns))
+	}
+	lines = append(lines, lipgloss.JoinHorizontal(lipgloss.Top, blocks...))
+
+	return paneStyle.Render(strings.Join(lines, "\n"))
+}
+
+// renderFields renders fields as aligned name: value lines cut to width
+func renderFields(fields []detailField, width int) string {
+	nameStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("240"))
+
+	nameWidth := 0
+	for _, f := range fields {
+		nameWidth = max(nameWidth, len(f.Name))
+	}
+	lines := make([]string, 0, len(fields))
+	for _, f := range fields {
+		value := []rune(cmp.Or(f.Value, "-"))
+		if room := max(width-nameWidth-3, 1); len(value) > room {
+			value = value[:room]
+		}
+		name := fmt.Sprintf("%-*s", nameWidth+1, f.Name+":")
+		lines = append(lines, nameStyle.Render(name)+" "+string(value))
+	}
+	return lipgloss.NewStyle().Width(width).Render(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,205 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ssh-portal/internal/cli/framing"
+)
+
+// Drain mode lets a relay be replaced without cutting off live sessions: it
+// stops minting invites, sends waiting receivers elsewhere and waits for the
+// active splices to end (or the drain timeout to pass) before stopping.
+const (
+	drainRetryAfter   = 5 // seconds receivers are told to wait before reconnecting
+	drainPollInterval = time.Second
+)
+
+var (
+	draining      atomic.Bool
+	drainMu       sync.Mutex
+	drainDeadline time.Time
+)
+
+// CodeDraining is the error code sent to peers that connect while the relay drains.
+const CodeDraining = "draining"
+
+// IsDraining reports whether the relay is draining.
+func IsDraining() bool {
+	return draining.Load()
+}
+
+// DrainDeadline returns when a running drain closes the remaining splices,
+// or the zero time if the relay is not draining.
+func DrainDeadline() time.Time {
+	drainMu.Lock()
+	defer drainMu.Unlock()
+	return drainDeadline
+}
+
+// startDrain puts the relay in drain mode and calls cancel once every splice
+// has ended or timeout has passed. It returns false if a drain was already running.
+func startDrain(ctx context.Context, timeout time.Duration, cancel context.CancelFunc) bool {
+	if !draining.CompareAndSwap(false, true) {
+		return false
+	}
+	drainMu.Lock()
+	drainDeadline = time.Now().Add(timeout)
+	drainMu.Unlock()
+
+	log.Printf("[DRAIN] draining: no new invites, waiting up to %s for %d active splice(s)", timeout, len(GetActiveSplices()))
+	CloseAllInvites(framing.ReasonRelayDraining, drainRetryAfter)
+	go waitForSplices(ctx, timeout, cancel)
+	return true
+}
+
+func waitForSplices(ctx context.Context, timeout time.Duration, cancel context.CancelFunc) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	t := time.NewTicker(drainPollInterval)
+	defer t.Stop()
+
+	for len(GetActiveSplices()) > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline.C:
+			log.Printf("[DRAIN] deadline reached, closing %d active splice(s)", closeActiveSplices())
+			cancel()
+			return
+		case <-t.C:
+		}
+	}
+	log.Printf("[DRAIN] all splices finished, stopping")
+	cancel()
+}
+
+// closeActiveSplices closes both connections of every active splice and
+// returns how many were closed.
+func closeActiveSplices() int {
+	active := GetActiveSplices()
+	for _, s := range active {
+		s.closeConns()
+	}
+	return len(active)
+}
+
+// closeConns closes both ends of a splice; spliceConnections then records it as closed.
+func (s *Splice) closeConns() {
+	if s.receiverConn != nil {
+		s.receiverConn.Close()
+	}
+	if s.senderConn != nil {
+		s.senderConn.Close()
+	}
+}
+
+// rejectDraining turns away a new receiver or sender while the relay drains.
+// Receivers that negotiated framing.CapBye get a bye with a retry hint so
+// they reconnect (to the restarted relay) on their own.
+func rejectDraining(c net.Conn, msg *EndpointMessage) {
+	_, caps := negotiate(msg)
+	if msg.Role == "receiver" && framing.HasCap(caps, framing.CapBye) {
+		sendBye(c, framing.ReasonRelayDraining, drainRetryAfter, caps)
+	} else {
+		SendErrorMessage(c, CodeDraining, "the relay is restarting and takes no new sessions; try again shortly", caps)
+	}
+	c.Close()
+}
+
+// ====== Health endpoints ======
+
+type healthStatus struct {
+	Status        string `json:"status"` // "ok" or "draining"
+	Invites       int    `json:"invites"`
+	Splices       int    `json:"splices"`
+	DrainDeadline int64  `json:"drain_deadline,omitempty"` // unix seconds
+}
+
+func currentHealth() healthStatus {
+	h := healthStatus{Status: "ok", Invites: CountOutstandingInvites(), Splices: len(GetActiveSplices())}
+	if IsDraining() {
+		h.Status = "draining"
+		h.DrainDeadline = DrainDeadline().Unix()
+	}
+	return h
+}
+
+// healthHandler serves the health endpoints:
+//
+//	GET  /healthz  liveness; 200 with the status, also while draining
+//	GET  /readyz   readiness; 503 while draining so load balancers move on
+//	POST /drain    start a drain (loopback clients only)
+//	GET  /metrics  Prometheus metrics
+//
+// and the dashboard when dash is set.
+func healthHandler(drain func() bool, dash *dashboardConfig) http.Handler {
+	mux := http.NewServeMux()
+	writeHealth := func(w http.ResponseWriter, code int) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(currentHealth())
+	}
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, http.StatusOK)
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if IsDraining() {
+			writeHealth(w, http.StatusServiceUnavailable)
+			return
+		}
+		writeHealth(w, http.StatusOK)
+	})
+	mux.HandleFunc("GET /metrics", metricsHandler)
+	mux.HandleFunc("POST /drain", func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			http.Error(w, "drain is only accepted from loopback", http.StatusForbidden)
+			return
+		}
+		if drain() {
+			log.Printf("[DRAIN] drain requested over HTTP by %s", r.RemoteAddr)
+		}
+		writeHealth(w, http.StatusAccepted)
+	})
+	if dash != nil {
+		registerDashboard(mux, *dash)
+	}
+	return mux
+}
+
+// healthServe runs the health endpoints on addr until ctx is cancelled.
+func healthServe(ctx context.Context, addr string, drain func() bool, dash *dashboardConfig) error {
+	return serveHTTP(ctx, addr, "health endpoints", healthHandler(drain, dash))
+}
+
+// serveHTTP serves h on addr until ctx is cancelled.
+func serveHTTP(ctx context.Context, addr, name string, h http.Handler) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: 5 * time.Second,
+		// Long-lived requests (dashboard streams) end with ctx
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	log.Printf("relay %s listening on %s", name, addr)
+	if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,352 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"ssh-portal/internal/version"
+)
+
+// Event types delivered to hooks
+const (
+	EventInviteCreated = "invite.created"
+	EventInviteClosed  = "invite.closed"
+	EventSpliceOpened  = "splice.opened"
+	EventSpliceClosed  = "splice.closed"
+)
+
+var eventTypes = []string{EventInviteCreated, EventInviteClosed, EventSpliceOpened, EventSpliceClosed}
+
+// Hook delivery limits
+const (
+	hookQueueSize      = 256 // events buffered per hook before new ones are dropped
+	hookFlushTimeout   = 5 * time.Second
+	webhookTimeout     = 10 * time.Second
+	webhookAttempts    = 5
+	webhookBackoff     = time.Second // doubled after every failed attempt
+	execHookTimeout    = 30 * time.Second
+	execHookMaxLogged  = 512 // bytes of a failed command's output put in the log
+	webhookSigHeader   = "X-SSH-Portal-Signature"
+	webhookEventHeader = "X-SSH-Portal-Event"
+	webhookIDHeader    = "X-SSH-Portal-Delivery"
+)
+
+// Event is the JSON document posted to webhooks and written to the stdin of
+// exec hooks.
+type Event struct {
+	ID     string      `json:"id"`
+	Type   string      `json:"type"`
+	Time   time.Time   `json:"time"`
+	Reason string      `json:"reason,omitempty"` // why an invite was closed ("paired", "expired", ...)
+	Invite *InviteInfo `json:"invite,omitempty"`
+	Splice *SpliceInfo `json:"splice,omitempty"` // byte counts are final in splice.closed
+}
+
+// WebhookConfig posts events as JSON to URL. With a secret, every request
+// carries an HMAC-SHA256 of the body in the X-SSH-Portal-Signature header.
+type WebhookConfig struct {
+	URL    string   `yaml:"url" mapstructure:"url"`
+	Secret string   `yaml:"secret,omitempty" mapstructure:"secret,omitempty"`
+	Events []string `yaml:"events,omitempty" mapstructure:"events,omitempty"` // empty means all
+}
+
+// ExecHookConfig runs Command through /bin/sh with the event on stdin.
+type ExecHookConfig struct {
+	Command string   `yaml:"command" mapstructure:"command"`
+	Events  []string `yaml:"events,omitempty" mapstructure:"events,omitempty"` // empty means all
+}
+
+// HooksConfig lists the event sinks of the relay.
+type HooksConfig struct {
+	Webhooks []WebhookConfig  `yaml:"webhooks,omitempty" mapstructure:"webhooks,omitempty"`
+	Exec     []ExecHookConfig `yaml:"exec,omitempty" mapstructure:"exec,omitempty"`
+}
+
+// Empty reports whether no hook is configured.
+func (c HooksConfig) Empty() bool {
+	return len(c.Webhooks) == 0 && len(c.Exec) == 0
+}
+
+// hookSink delivers one event to one destination.
+type hookSink interface {
+	String() string
+	deliver(ctx context.Context, ev *Event, body []byte) error
+}
+
+// hook queues events for a sink and delivers them in order on its own
+// goroutine, so a slow endpoint never holds up the relay or other hooks.
+type hook struct {
+	sink   hookSink
+	events []string // empty means all
+	queue  chan *Event
+}
+
+func (h *hook) wants(typ string) bool {
+	return len(h.events) == 0 || slices.Contains(h.events, typ)
+}
+
+func (h *hook) run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for ev := range h.queue {
+		body, err := json.Marshal(ev)
+		if err != nil {
+			log.Printf("[HOOK] %s: encode %s: %v", h.sink, ev.Type, err)
+			continue
+		}
+		if err := h.sink.deliver(ctx, ev, body); err != nil {
+			log.Printf("[HOOK] %s: %s %s not delivered: %v", h.sink, ev.Type, ev.ID, err)
+		}
+	}
+}
+
+// hookDispatcher fans relay events out to the configured hooks.
+type hookDispatcher struct {
+	hooks  []*hook
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	mu     sync.RWMutex
+	closed bool
+}
+
+// newHookDispatcher validates cfg and starts one delivery goroutine per hook.
+func newHookDispatcher(cfg HooksConfig) (*hookDispatcher, error) {
+	d := &hookDispatcher{}
+	add := func(sink hookSink, events []string) error {
+		for _, e := range events {
+			if !slices.Contains(eventTypes, e) {
+				return fmt.Errorf("hook %s: unknown event %q (want one of %s)", sink, e, strings.Join(eventTypes, ", "))
+			}
+		}
+		d.hooks = append(d.hooks, &hook{sink: sink, events: events, queue: make(chan *Event, hookQueueSize)})
+		return nil
+	}
+	for _, w := range cfg.Webhooks {
+		if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("webhook: invalid url (want http:// or https://)")
+		}
+		if err := add(&webhookSink{url: w.URL, secret: w.Secret, client: &http.Client{Timeout: webhookTimeout}}, w.Events); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range cfg.Exec {
+		if strings.TrimSpace(e.Command) == "" {
+			return nil, fmt.Errorf("exec hook: empty command")
+		}
+		if err := add(&execSink{command: e.Command}, e.Events); err != nil {
+			return nil, err
+		}
+	}
+
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	for _, h := range d.hooks {
+		d.wg.Add(1)
+		go h.run(d.ctx, &d.wg)
+	}
+	return d, nil
+}
+
+// emit queues ev for every hook subscribed to its type. It never blocks:
+// a hook whose queue is full loses the event.
+func (d *hookDispatcher) emit(ev *Event) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+	ev.ID = newEventID()
+	ev.Time = time.Now().UTC()
+	for _, h := range d.hooks {
+		if !h.wants(ev.Type) {
+			continue
+		}
+		select {
+		case h.queue <- ev:
+		default:
+			log.Printf("[HOOK] %s: queue full, dropping %s %s", h.sink, ev.Type, ev.ID)
+		}
+	}
+}
+
+// close stops accepting events and gives the queued ones up to
+// hookFlushTimeout to be delivered.
+func (d *hookDispatcher) close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	for _, h := range d.hooks {
+		close(h.queue)
+	}
+	d.mu.Unlock()
+	defer d.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(hookFlushTimeout):
+		log.Printf("[HOOK] giving up on undelivered events after %s", hookFlushTimeout)
+		d.cancel()
+		<-done
+	}
+}
+
+// callbacks turns relay events into hook events. Codes and RIDs are redacted
+// as on the dashboard: events go to third-party services and shell commands.
+func (d *hookDispatcher) callbacks() *EventCallbacks {
+	return &EventCallbacks{
+		OnNewInvite: func(inv *Invite) {
+			info := inviteInfo(inv).redacted()
+			d.emit(&Event{Type: EventInviteCreated, Invite: &info})
+		},
+		OnClosedInvite: func(inv *Invite, reason string) {
+			info := inviteInfo(inv).redacted()
+			d.emit(&Event{Type: EventInviteClosed, Reason: reason, Invite: &info})
+		},
+		OnNewSplice: func(s *Splice) {
+			spliceMu.RLock()
+			info := spliceInfo(s).redacted()
+			spliceMu.RUnlock()
+			d.emit(&Event{Type: EventSpliceOpened, Splice: &info})
+		},
+		OnClosedSplice: func(s *Splice) {
+			spliceMu.RLock()
+			info := spliceInfo(s).redacted()
+			spliceMu.RUnlock()
+			d.emit(&Event{Type: EventSpliceClosed, Splice: &info})
+		},
+	}
+}
+
+func newEventID() string {
+	b := make([]byte, 10)
+	_, _ = rand.Read(b)
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+}
+
+// ====== Webhooks ======
+
+type webhookSink struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// String names the webhook by host only: webhook URLs often embed a secret
+func (w *webhookSink) String() string {
+	if u, err := url.Parse(w.url); err == nil {
+		return "webhook " + u.Host
+	}
+	return "webhook"
+}
+
+// signWebhook returns the X-SSH-Portal-Signature value of body.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver posts the event, retrying with exponential backoff on network
+// errors, 429 and 5xx responses.
+func (w *webhookSink) deliver(ctx context.Context, ev *Event, body []byte) error {
+	backoff := webhookBackoff
+	var err error
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		var retry bool
+		if retry, err = w.post(ctx, ev, body); err == nil || !retry {
+			return err
+		}
+		if attempt == webhookAttempts {
+			break
+		}
+		log.Printf("[HOOK] %s: attempt %d for %s failed: %v, retrying in %s", w, attempt, ev.ID, err, backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return fmt.Errorf("%w (gave up after %d attempts)", err, webhookAttempts)
+}
+
+// post makes one delivery attempt and reports whether a failure is worth retrying.
+func (w *webhookSink) post(ctx context.Context, ev *Event, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ssh-portal-relay/"+version.Version)
+	req.Header.Set(webhookEventHeader, ev.Type)
+	req.Header.Set(webhookIDHeader, ev.ID)
+	if w.secret != "" {
+		req.Header.Set(webhookSigHeader, signWebhook(w.secret, body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("HTTP %s", resp.Status)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// ====== Exec hooks ======
+
+type execSink struct {
+	command string
+}
+
+func (e *execSink) String() string {
+	return "exec " + e.command
+}
+
+// deliver runs the command with the event on stdin and its type and ID in
+// SSH_PORTAL_EVENT and SSH_PORTAL_EVENT_ID. Failures are not retried.
+func (e *execSink) deliver(ctx context.Context, ev *Event, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, execHookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", e.command)
+	cmd.Stdin = bytes.NewReader(append(body, '\n'))
+	cmd.Env = append(os.Environ(), "SSH_PORTAL_EVENT="+ev.Type, "SSH_PORTAL_EVENT_ID="+ev.ID)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if len(out) > execHookMaxLogged {
+			out = out[:execHookMaxLogged]
+		}
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
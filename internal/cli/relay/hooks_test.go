@@ -0,0 +1,66 @@
+package relay
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSignWebhook(t *testing.T) {
+	// RFC 4231, test case 2
+	got := signWebhook("Jefe", []byte("what do ya want for nothing?"))
+	want := "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+	if got != want {
+		t.Fatalf("signWebhook = %s, want %s", got, want)
+	}
+}
+
+func TestWebhookRedactsInvites(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	type delivery struct {
+		body      []byte
+		signature string
+	}
+	got := make(chan delivery, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- delivery{body, r.Header.Get("X-SSH-Portal-Signature")}
+	}))
+	defer srv.Close()
+	d, err := newHookDispatcher(HooksConfig{Webhooks: []WebhookConfig{{URL: srv.URL, Secret: "s3cret", Events: []string{EventInviteCreated}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.close()
+
+	inv, err := MintInvite("SHA256:test", time.Minute, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteInvite(inv, "test")
+	d.callbacks().OnNewInvite(inv)
+
+	var dl delivery
+	select {
+	case dl = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not delivered")
+	}
+	if dl.signature != signWebhook("s3cret", dl.body) {
+		t.Errorf("signature %s doesn't match the body", dl.signature)
+	}
+	var ev Event
+	if err := json.Unmarshal(dl.body, &ev); err != nil || ev.Invite == nil {
+		t.Fatalf("event %s: %v", dl.body, err)
+	}
+	if ev.Invite.Code != shortCode(inv.Code) || ev.Invite.RID != inviteHandle(inv.RID) {
+		t.Errorf("invite.created carries code %q and rid %q, want them redacted", ev.Invite.Code, ev.Invite.RID)
+	}
+}
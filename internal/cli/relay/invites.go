@@ -3,41 +3,229 @@ package relay
 import (
 	"crypto/rand"
 	"encoding/base32"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"ssh-portal/internal/cli/framing"
 	"ssh-portal/internal/cli/usercode"
 )
 
-// Invite represents a connection invitation
+// Invite represents a connection invitation. The fields are fixed when the
+// invite is minted; what changes as receivers and senders come and go is
+// read and set through methods.
 type Invite struct {
-	RID          string
-	Code         string
-	ReceiverFP   string // "SHA256:..."
-	ExpiresAt    time.Time
-	ReceiverConn net.Conn
-	sentOK       bool
-	CreatedAt    time.Time
-	Sender       *SenderInfo
+	RID        string
+	Code       string
+	CreatedAt  time.Time
+	CodeWords  int    // code strength negotiated with the receiver
+	Label      string // free-form label set when pre-minted over the API (ticket ID, customer)
+	claimToken string // secret a receiver presents to claim a pre-minted invite
+
+	mu           sync.Mutex
+	receiverFP   string // "SHA256:..."; empty until a pre-minted invite is claimed
+	expiresAt    time.Time
+	receiverConn net.Conn
+	receiverCaps []string // capabilities negotiated with the receiver
+	sender       *SenderInfo
+	tenant       string          // tenant of the receiver's token, if any
+	label        string          // what the receiver says it is (framing.CapLabel)
+	closed       bool            // removed from the store, or taken for pairing
+	pinger       *receiverPinger // nil unless the waiting receiver is pinged
+	unresponsive bool            // the receiver missed its last pong
+
+	// Guarded by the store's expiry lock
+	deadline  time.Time
+	heapIndex int
+}
+
+// Errors attaching a receiver to an invite
+var (
+	errAlreadyAttached = errors.New("receiver already attached")
+	errInviteClosed    = errors.New("invite closed")
+)
+
+// ExpiresAt returns when the invite expires
+func (inv *Invite) ExpiresAt() time.Time {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	return inv.expiresAt
+}
+
+// ReceiverFP returns the fingerprint of the receiver's host key
+func (inv *Invite) ReceiverFP() string {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	return inv.receiverFP
+}
+
+// Claimed reports whether a receiver has taken the invite (pre-minted invites
+// have no receiver fingerprint until claimed)
+func (inv *Invite) Claimed() bool {
+	return inv.ReceiverFP() != ""
+}
+
+// ReceiverConn returns the waiting receiver's connection, nil if none is attached
+func (inv *Invite) ReceiverConn() net.Conn {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	return inv.receiverConn
+}
+
+// ReceiverCaps returns the capabilities negotiated with the receiver
+func (inv *Invite) ReceiverCaps() []string {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	return inv.receiverCaps
+}
+
+// Sender returns the metadata announced by the sender, if any
+func (inv *Invite) Sender() *SenderInfo {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	return inv.sender
+}
+
+// Tenant returns the tenant of the receiver's token, if any
+func (inv *Invite) Tenant() string {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	return inv.tenant
+}
+
+// ReceiverLabel returns what the waiting receiver says it is, if it said
+func (inv *Invite) ReceiverLabel() string {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	return inv.label
+}
+
+func (inv *Invite) setReceiverLabel(label string) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.label = label
+}
+
+// Unresponsive reports whether the waiting receiver stopped answering pings
+func (inv *Invite) Unresponsive() bool {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	return inv.unresponsive
+}
+
+func (inv *Invite) setUnresponsive(unresponsive bool) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.unresponsive = unresponsive
+}
+
+func (inv *Invite) receiverPinger() *receiverPinger {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	return inv.pinger
+}
+
+// expired reports whether the invite had expired at now
+func (inv *Invite) expired(now time.Time) bool {
+	return now.After(inv.ExpiresAt())
+}
+
+// attachReceiver parks the receiver's connection on the invite
+func (inv *Invite) attachReceiver(c net.Conn, caps []string) error {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	switch {
+	case inv.closed:
+		return errInviteClosed
+	case inv.receiverConn != nil:
+		return errAlreadyAttached
+	}
+	inv.receiverConn, inv.receiverCaps = c, caps
+	return nil
+}
+
+func (inv *Invite) setSender(meta *SenderInfo) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.sender = meta
+}
+
+func (inv *Invite) setTenant(tenant string) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.tenant = tenant
+}
+
+// takeForPairing hands the waiting receiver to one sender: it closes the
+// invite, removes it from the store and returns the receiver's connection and
+// capabilities. Of senders racing for the same code only one gets them; the
+// others get false, as does a sender for an invite without a receiver. The
+// caller reports the invite closed with inviteClosed.
+func (inv *Invite) takeForPairing() (net.Conn, []string, bool) {
+	inv.mu.Lock()
+	if inv.closed || inv.receiverConn == nil {
+		inv.mu.Unlock()
+		return nil, nil, false
+	}
+	inv.closed = true
+	rc, caps := inv.receiverConn, inv.receiverCaps
+	inv.mu.Unlock()
+	store.remove(inv)
+	return rc, caps, true
+}
+
+// close marks the invite removed; false if it already was
+func (inv *Invite) close() bool {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if inv.closed {
+		return false
+	}
+	inv.closed = true
+	return true
 }
 
 // Splice represents an established connection between sender and receiver
 type Splice struct {
-	ID           string
-	Code         string
-	RID          string
-	ReceiverFP   string
-	SenderAddr   string
-	ReceiverAddr string
-	CreatedAt    time.Time
-	BytesUp      int64 // bytes from receiver to sender
-	BytesDown    int64 // bytes from sender to receiver
-	ClosedAt     *time.Time
+	ID             string
+	Code           string
+	RID            string
+	ReceiverFP     string
+	SenderAddr     string
+	ReceiverAddr   string
+	CreatedAt      time.Time
+	ClosedAt       *time.Time
+	Label          string  // label of the invite, if pre-minted over the API
+	SenderIdentity string  // identity the sender announced, if any
+	Tenant         string  // tenant of the sender's (or else the receiver's) token
+	Rate           float64 // bytes per second, both directions, at the last sample
+	Throttled      bool    // held back by a bandwidth cap since the last sample
+	Limits         framing.SessionLimits
+	CloseReason    string   // why the relay closed it (max-duration, idle-timeout, killed), if it did
+	receiverConn   net.Conn // closed to force the splice down (drain deadline)
+	senderConn     net.Conn
+	limiter        *spliceLimiter         // nil if not bandwidth capped
+	activity       *framing.ActivityMeter // nil without an idle timeout
+	bytesUp        atomic.Int64           // bytes from receiver to sender
+	bytesDown      atomic.Int64           // bytes from sender to receiver
+	lastBytes      int64
+	lastSample     time.Time
+	rateHistory    []float64 // rates at the last rateHistoryLen samples, oldest first
+	lastWaited     int64
 }
 
+// BytesUp returns the bytes relayed from receiver to sender so far.
+func (s *Splice) BytesUp() int64 { return s.bytesUp.Load() }
+
+// BytesDown returns the bytes relayed from sender to receiver so far.
+func (s *Splice) BytesDown() int64 { return s.bytesDown.Load() }
+
 // Event callbacks
 type EventCallbacks struct {
 	OnNewInvite    func(*Invite)
@@ -46,29 +234,10 @@ type EventCallbacks struct {
 	OnClosedSplice func(*Splice)
 }
 
-// Rate limiting for code guessing
-const (
-	rateLimitThreshold = 3              // free attempts before throttling
-	rateLimitDelay     = 3 * time.Second // delay per attempt once throttled
-	rateLimitWindow    = time.Minute     // failures expire after this
-)
-
-type rateLimitEntry struct {
-	count    int
-	lastFail time.Time
-}
-
 var (
-	invMu   sync.RWMutex
-	invByID = map[string]*Invite{}
-	invByCd = map[string]*Invite{}
-
 	spliceMu sync.RWMutex
 	splices  = map[string]*Splice{}
 
-	rateMu         sync.Mutex
-	failedAttempts = map[string]*rateLimitEntry{}
-
 	callbacks *EventCallbacks
 )
 
@@ -79,13 +248,11 @@ func SetEventCallbacks(cb *EventCallbacks) {
 
 // GetOutstandingInvites returns all outstanding (not expired) invites
 func GetOutstandingInvites() []*Invite {
-	invMu.RLock()
-	defer invMu.RUnlock()
-
 	now := time.Now()
-	result := make([]*Invite, 0, len(invByID))
-	for _, inv := range invByID {
-		if now.Before(inv.ExpiresAt) {
+	invites := store.byRID.all()
+	result := invites[:0]
+	for _, inv := range invites {
+		if !inv.expired(now) {
 			result = append(result, inv)
 		}
 	}
@@ -120,159 +287,198 @@ func GetActiveSplices() []*Splice {
 
 // GetByRID retrieves an invite by rendezvous ID
 func GetByRID(rid string) *Invite {
-	invMu.RLock()
-	defer invMu.RUnlock()
-	return invByID[rid]
+	return store.byRID.get(rid)
 }
 
 // GetByCode retrieves an invite by code
 func GetByCode(code string) *Invite {
-	invMu.RLock()
-	defer invMu.RUnlock()
-	return invByCd[code]
+	return store.byCode.get(code)
 }
 
-// MintInvite creates a new invite for the given receiver fingerprint
-func MintInvite(receiverFP string, ttl time.Duration) *Invite {
-	rid := randB32(16)                         // rendezvous id (base32)
-	code, _ := usercode.GenerateReceiverCode() // receiver code; discard error or second value for now
-	exp := time.Now().Add(ttl).UTC()           // expiry
-	now := time.Now().UTC()
-	inv := &Invite{
-		RID:        rid,
-		Code:       code,
-		ReceiverFP: receiverFP,
-		ExpiresAt:  exp,
-		CreatedAt:  now,
-	}
-	invMu.Lock()
-	invByID[rid] = inv
-	invByCd[code] = inv
-	invMu.Unlock()
+// Code strength and minting parameters
+const (
+	// maxMintAttempts bounds the retries when a freshly minted code or rid
+	// collides with an outstanding invite
+	maxMintAttempts = 8
+	// strengthMarginBits is the guessing/collision margin kept on top of what
+	// the outstanding invites and the invite lifetime consume
+	strengthMarginBits = 16
+)
 
-	// Call callback if set
-	if callbacks != nil && callbacks.OnNewInvite != nil {
-		callbacks.OnNewInvite(inv)
+// RequiredCodeWords returns the smallest code strength (in words) that keeps
+// strengthMarginBits of headroom given the number of outstanding invites and the
+// invite TTL. Every outstanding invite is a valid target for a guess, and every
+// second of lifetime is another second of guessing, so both eat into the relay
+// code's entropy.
+func RequiredCodeWords(outstanding int, ttl time.Duration) int {
+	need := math.Log2(float64(outstanding+1)) + math.Log2(math.Max(ttl.Seconds(), 1)) + strengthMarginBits
+	for w := usercode.MinCodeWords; w <= usercode.MaxCodeWords; w += 2 {
+		if float64(usercode.PartBits(w)) >= need {
+			return w
+		}
 	}
-
-	return inv
+	return usercode.MaxCodeWords
 }
 
-// DeleteInvite removes an invite from both maps
-// reason should be provided for logging/tracking purposes
-func DeleteInvite(inv *Invite, reason string) {
-	invMu.Lock()
-	delete(invByID, inv.RID)
-	delete(invByCd, inv.Code)
-	invMu.Unlock()
-
-	// Call callback if set
-	if callbacks != nil && callbacks.OnClosedInvite != nil {
-		callbacks.OnClosedInvite(inv, reason)
-	}
+// MintInvite creates a new invite for the given receiver fingerprint.
+// words is the code strength; minting retries on code or rid collisions and
+// fails cleanly if the random source errors.
+func MintInvite(receiverFP string, ttl time.Duration, words int) (*Invite, error) {
+	return mintInvite(receiverFP, "", "", ttl, words)
 }
 
-// LockInvites locks the invite mutex (for external access)
-func LockInvites() {
-	invMu.Lock()
+// PreMintInvite creates an invite with a label and no receiver yet. The
+// returned claim token lets one receiver take it over in its hello.
+func PreMintInvite(label string, ttl time.Duration, words int) (*Invite, string, error) {
+	claim, err := randB32(16)
+	if err != nil {
+		return nil, "", fmt.Errorf("mint claim token: %w", err)
+	}
+	inv, err := mintInvite("", label, claim, ttl, words)
+	if err != nil {
+		return nil, "", err
+	}
+	return inv, claim, nil
 }
 
-// UnlockInvites unlocks the invite mutex
-func UnlockInvites() {
-	invMu.Unlock()
+// ClaimInvite hands the pre-minted invite for claim to the receiver with
+// fingerprint receiverFP. A claim token works once.
+func ClaimInvite(claim, receiverFP string) (*Invite, error) {
+	inv := store.byClaim.get(claim)
+	if inv == nil || inv.expired(time.Now()) || !store.byClaim.remove(claim, inv) {
+		return nil, fmt.Errorf("unknown, used or expired claim token")
+	}
+	inv.mu.Lock()
+	inv.receiverFP = receiverFP
+	inv.mu.Unlock()
+	return inv, nil
 }
 
-// StartInviteCleanupLoop starts the background cleanup goroutine
-func StartInviteCleanupLoop() {
-	go cleanupLoop()
-}
+func mintInvite(receiverFP, label, claim string, ttl time.Duration, words int) (*Invite, error) {
+	now := time.Now().UTC()
+	var inv *Invite
+	for attempt := 1; ; attempt++ {
+		rid, err := randB32(16) // rendezvous id (base32)
+		if err != nil {
+			return nil, fmt.Errorf("mint rid: %w", err)
+		}
+		code, err := usercode.GenerateRelayCode(words) // relay half of the user code
+		if err != nil {
+			return nil, fmt.Errorf("mint code: %w", err)
+		}
 
-func cleanupLoop() {
-	t := time.NewTicker(1 * time.Minute)
-	for range t.C {
-		now := time.Now()
-		invMu.Lock()
-		var toCleanup []*Invite
-		for _, v := range invByID {
-			if now.After(v.ExpiresAt) {
-				toCleanup = append(toCleanup, v)
-			}
+		// A fresh invite per attempt: a losing one may have been visible by rid
+		inv = &Invite{
+			RID:        rid,
+			Code:       code,
+			CreatedAt:  now,
+			CodeWords:  words,
+			Label:      label,
+			claimToken: claim,
+			receiverFP: receiverFP,
+			expiresAt:  now.Add(ttl),
+			heapIndex:  -1,
 		}
-		invMu.Unlock()
-
-		cleaned := 0
-		for _, v := range toCleanup {
-			if v.ReceiverConn != nil {
-				log.Printf("[CLEANUP] closing expired connection: code=%s rid=%s", v.Code, v.RID)
-				v.ReceiverConn.Close()
-			}
-			DeleteInvite(v, "expired")
-			cleaned++
+		ridTaken, codeTaken := store.add(inv)
+		if !ridTaken && !codeTaken {
+			break
 		}
-		if cleaned > 0 {
-			log.Printf("[CLEANUP] removed %d expired invite(s)", cleaned)
+
+		log.Printf("[MINT] collision on attempt %d (rid=%v code=%v), retrying", attempt, ridTaken, codeTaken)
+		if attempt >= maxMintAttempts {
+			return nil, fmt.Errorf("mint: no free code after %d attempts", attempt)
 		}
-		cleanupRateLimitEntries()
 	}
+
+	// Call callback if set
+	if callbacks != nil && callbacks.OnNewInvite != nil {
+		callbacks.OnNewInvite(inv)
+	}
+
+	return inv, nil
 }
 
-// checkRateLimit sleeps if the given IP has exceeded the failure threshold.
-func checkRateLimit(ip string) {
-	rateMu.Lock()
-	entry := failedAttempts[ip]
-	rateMu.Unlock()
+// CountOutstandingInvites returns the number of invites currently held
+func CountOutstandingInvites() int {
+	return int(store.count.Load())
+}
 
-	if entry == nil {
-		return
+// extendInvite pushes back the expiration of inv by d; false if inv is gone
+func extendInvite(inv *Invite, d time.Duration) (time.Time, bool) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if inv.closed {
+		return time.Time{}, false
 	}
-	if time.Since(entry.lastFail) > rateLimitWindow {
+	inv.expiresAt = inv.expiresAt.Add(d)
+	// Under inv.mu, so an invite being deleted is not scheduled again
+	store.schedule(inv, inv.expiresAt)
+	return inv.expiresAt, true
+}
+
+// DeleteInvite removes an invite from the store; deleting it again does
+// nothing. reason should be provided for logging/tracking purposes
+func DeleteInvite(inv *Invite, reason string) {
+	if !inv.close() {
 		return
 	}
-	if entry.count >= rateLimitThreshold {
-		log.Printf("[RATE] throttling %s (%d failures)", ip, entry.count)
-		time.Sleep(rateLimitDelay)
-	}
+	store.remove(inv)
+	inviteClosed(inv, reason)
 }
 
-// recordFailedAttempt increments the failure counter for an IP.
-func recordFailedAttempt(ip string) {
-	rateMu.Lock()
-	defer rateMu.Unlock()
+// inviteClosed records and reports an invite that was removed from the store
+func inviteClosed(inv *Invite, reason string) {
+	if reason != "paired" {
+		// Pairing is audited with the splice it becomes
+		auditInvite(AuditInviteClosed, inv, "", reason)
+	}
+
+	// Call callback if set
+	if callbacks != nil && callbacks.OnClosedInvite != nil {
+		callbacks.OnClosedInvite(inv, reason)
+	}
+}
 
-	entry := failedAttempts[ip]
-	if entry == nil {
-		entry = &rateLimitEntry{}
-		failedAttempts[ip] = entry
+// CloseAllInvites tells every waiting receiver why it is being disconnected,
+// closes its connection and removes all invites. Used on relay shutdown and
+// drain; retryAfter (seconds, 0 for none) tells receivers when to reconnect.
+func CloseAllInvites(reason string, retryAfter int) {
+	all := store.byRID.all()
+	for _, v := range all {
+		if rc, caps := v.ReceiverConn(), v.ReceiverCaps(); rc != nil {
+			sendBye(rc, reason, retryAfter, caps)
+			rc.Close()
+		}
+		DeleteInvite(v, reason)
 	}
-	// Reset if the window has elapsed since the last failure.
-	if time.Since(entry.lastFail) > rateLimitWindow {
-		entry.count = 0
+	if len(all) > 0 {
+		log.Printf("[SHUTDOWN] closed %d waiting invite(s): %s", len(all), reason)
 	}
-	entry.count++
-	entry.lastFail = time.Now()
 }
 
-// clearFailedAttempts removes the failure record for an IP.
-func clearFailedAttempts(ip string) {
-	rateMu.Lock()
-	delete(failedAttempts, ip)
-	rateMu.Unlock()
+// StartInviteCleanupLoop starts the background cleanup goroutines: invites
+// are removed as soon as they expire, closed splices are forgotten
+// spliceRetention after they end
+func StartInviteCleanupLoop(spliceRetention time.Duration) {
+	go store.expireLoop()
+	go cleanupLoop(spliceRetention)
 }
 
-// cleanupRateLimitEntries removes stale rate-limit entries.
-func cleanupRateLimitEntries() {
-	rateMu.Lock()
-	defer rateMu.Unlock()
-	now := time.Now()
-	for ip, entry := range failedAttempts {
-		if now.Sub(entry.lastFail) > rateLimitWindow {
-			delete(failedAttempts, ip)
+func cleanupLoop(spliceRetention time.Duration) {
+	t := time.NewTicker(1 * time.Minute)
+	for range t.C {
+		if pruned := pruneClosedSplices(spliceRetention); pruned > 0 {
+			log.Printf("[CLEANUP] forgot %d splice(s) closed more than %s ago", pruned, spliceRetention)
 		}
+		guard.prune(time.Now())
+		flushACLDenied()
 	}
 }
 
-func randB32(n int) string {
+func randB32(n int) (string, error) {
 	b := make([]byte, n)
-	_, _ = rand.Read(b)
-	return strings.TrimRight(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), "=")
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), "="), nil
 }
@@ -0,0 +1,242 @@
+package relay
+
+import (
+	"container/heap"
+	"hash/maphash"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ssh-portal/internal/cli/framing"
+)
+
+// inviteShards is the number of shards of each invite index. Lookups, mints
+// and deletes of unrelated invites lock different shards, so a relay with a
+// hundred thousand waiting receivers doesn't serialize on one mutex.
+const inviteShards = 64
+
+// inviteIndex maps one key of the invites (rid, relay code or claim token) to
+// them, split into shards by the hash of the key.
+type inviteIndex struct {
+	seed   maphash.Seed
+	shards [inviteShards]struct {
+		mu      sync.RWMutex
+		invites map[string]*Invite
+	}
+}
+
+func newInviteIndex() *inviteIndex {
+	x := &inviteIndex{seed: maphash.MakeSeed()}
+	for i := range x.shards {
+		x.shards[i].invites = map[string]*Invite{}
+	}
+	return x
+}
+
+func (x *inviteIndex) shard(key string) int {
+	return int(maphash.String(x.seed, key) % inviteShards)
+}
+
+func (x *inviteIndex) get(key string) *Invite {
+	s := &x.shards[x.shard(key)]
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.invites[key]
+}
+
+// add maps key to inv unless key is taken
+func (x *inviteIndex) add(key string, inv *Invite) bool {
+	s := &x.shards[x.shard(key)]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, taken := s.invites[key]; taken {
+		return false
+	}
+	s.invites[key] = inv
+	return true
+}
+
+// remove unmaps key if it still maps to inv
+func (x *inviteIndex) remove(key string, inv *Invite) bool {
+	s := &x.shards[x.shard(key)]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.invites[key] != inv {
+		return false
+	}
+	delete(s.invites, key)
+	return true
+}
+
+// all returns every invite in the index, one shard at a time
+func (x *inviteIndex) all() []*Invite {
+	var result []*Invite
+	for i := range x.shards {
+		s := &x.shards[i]
+		s.mu.RLock()
+		for _, inv := range s.invites {
+			result = append(result, inv)
+		}
+		s.mu.RUnlock()
+	}
+	return result
+}
+
+// expiryHeap orders invites by deadline, earliest first
+type expiryHeap []*Invite
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].deadline.Before(h[j].deadline) }
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex, h[j].heapIndex = i, j
+}
+func (h *expiryHeap) Push(x any) {
+	inv := x.(*Invite)
+	inv.heapIndex = len(*h)
+	*h = append(*h, inv)
+}
+func (h *expiryHeap) Pop() any {
+	old := *h
+	inv := old[len(old)-1]
+	old[len(old)-1] = nil
+	inv.heapIndex = -1
+	*h = old[:len(old)-1]
+	return inv
+}
+
+// inviteStore holds the outstanding invites: indexed by rid, relay code and
+// (until claimed) claim token, and ordered by expiry so each invite is
+// removed the moment it expires.
+type inviteStore struct {
+	byRID, byCode, byClaim *inviteIndex
+	count                  atomic.Int64
+
+	expMu  sync.Mutex
+	expiry expiryHeap
+	wake   chan struct{} // the earliest deadline moved
+}
+
+var store = newInviteStore()
+
+func newInviteStore() *inviteStore {
+	return &inviteStore{
+		byRID:   newInviteIndex(),
+		byCode:  newInviteIndex(),
+		byClaim: newInviteIndex(),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// add stores inv unless its rid or code is taken
+func (s *inviteStore) add(inv *Invite) (ridTaken, codeTaken bool) {
+	// Nothing may extend or delete inv before it is scheduled
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if !s.byRID.add(inv.RID, inv) {
+		return true, false
+	}
+	if !s.byCode.add(inv.Code, inv) {
+		s.byRID.remove(inv.RID, inv)
+		return false, true
+	}
+	if inv.claimToken != "" {
+		s.byClaim.add(inv.claimToken, inv)
+	}
+	s.count.Add(1)
+	s.schedule(inv, inv.expiresAt)
+	return false, false
+}
+
+// remove drops inv from the store; false if it was not stored
+func (s *inviteStore) remove(inv *Invite) bool {
+	if !s.byRID.remove(inv.RID, inv) {
+		return false
+	}
+	s.byCode.remove(inv.Code, inv)
+	if inv.claimToken != "" {
+		s.byClaim.remove(inv.claimToken, inv)
+	}
+	s.count.Add(-1)
+	s.expMu.Lock()
+	if inv.heapIndex >= 0 {
+		heap.Remove(&s.expiry, inv.heapIndex)
+	}
+	s.expMu.Unlock()
+	return true
+}
+
+// schedule (re)sets the time inv expires at
+func (s *inviteStore) schedule(inv *Invite, deadline time.Time) {
+	s.expMu.Lock()
+	inv.deadline = deadline
+	if inv.heapIndex >= 0 {
+		heap.Fix(&s.expiry, inv.heapIndex)
+	} else {
+		heap.Push(&s.expiry, inv)
+	}
+	first := s.expiry[0] == inv
+	s.expMu.Unlock()
+	if first {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// expired pops the invites whose deadline is not after now, and returns
+// how long until the next one is due (0 if none is left)
+func (s *inviteStore) expired(now time.Time) ([]*Invite, time.Duration) {
+	s.expMu.Lock()
+	defer s.expMu.Unlock()
+	var due []*Invite
+	for len(s.expiry) > 0 && !s.expiry[0].deadline.After(now) {
+		due = append(due, heap.Pop(&s.expiry).(*Invite))
+	}
+	if len(s.expiry) == 0 {
+		return due, 0
+	}
+	return due, s.expiry[0].deadline.Sub(now)
+}
+
+// expireLoop closes every invite when its deadline passes
+func (s *inviteStore) expireLoop() {
+	timer := time.NewTimer(time.Hour)
+	for {
+		now := time.Now()
+		due, next := s.expired(now)
+		removed := 0
+		for _, inv := range due {
+			if inv.ExpiresAt().After(now) {
+				// Extended after it was popped; extendInvite scheduled it again
+				continue
+			}
+			expireInvite(inv)
+			removed++
+		}
+		if removed > 0 {
+			log.Printf("[CLEANUP] removed %d expired invite(s)", removed)
+		}
+		if next == 0 {
+			next = time.Hour
+		}
+		timer.Reset(next)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// expireInvite tells the waiting receiver, if any, that the invite expired
+// and removes the invite
+func expireInvite(inv *Invite) {
+	if rc, caps := inv.ReceiverConn(), inv.ReceiverCaps(); rc != nil {
+		log.Printf("[CLEANUP] closing expired connection: code=%s rid=%s", inv.Code, inv.RID)
+		sendBye(rc, framing.ReasonInviteExpired, 0, caps)
+		rc.Close()
+	}
+	DeleteInvite(inv, "expired")
+}
@@ -0,0 +1,208 @@
+package relay
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ssh-portal/internal/cli/framing"
+)
+
+func TestInviteExpiresOnTime(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	go store.expireLoop()
+
+	inv, err := MintInvite("SHA256:test", 200*time.Millisecond, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relaySide, receiverSide := net.Pipe()
+	defer receiverSide.Close()
+	if err := inv.attachReceiver(relaySide, []string{framing.CapBye}); err != nil {
+		t.Fatal(err)
+	}
+	if err := inv.attachReceiver(relaySide, nil); err != errAlreadyAttached {
+		t.Fatalf("second receiver: %v, want %v", err, errAlreadyAttached)
+	}
+
+	start := time.Now()
+	line, err := bufio.NewReader(receiverSide).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("receiver told after %s, want about 200ms", elapsed)
+	}
+	if !strings.Contains(line, framing.ReasonInviteExpired) {
+		t.Fatalf("receiver got %q, want a bye with %s", line, framing.ReasonInviteExpired)
+	}
+	// The receiver is told before the invite is deleted
+	for GetByRID(inv.RID) != nil || GetByCode(inv.Code) != nil {
+		if time.Since(start) > time.Second {
+			t.Fatal("expired invite still stored")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := extendInvite(inv, time.Minute); ok {
+		t.Fatal("extended an expired invite")
+	}
+}
+
+func TestOneSenderPairs(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	inv, err := MintInvite("SHA256:test", time.Minute, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteInvite(inv, "test")
+	receiver := benchConn{}
+	if err := inv.attachReceiver(receiver, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Senders with the same code race for the receiver
+	const senders = 16
+	answers := make(chan string, senders)
+	var wg sync.WaitGroup
+	for range senders {
+		relaySide, senderSide := net.Pipe()
+		defer senderSide.Close()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, rc, _ := HandleSender(relaySide, &EndpointMessage{Msg: "hello", Role: "sender", Code: inv.Code}, ""); got != nil && rc != receiver {
+				t.Errorf("paired with %v, want the waiting receiver", rc)
+			}
+		}()
+		go func() {
+			br := bufio.NewReader(senderSide)
+			line, _ := br.ReadString('\n')
+			if strings.Contains(line, `"ok"`) {
+				br.ReadString('\n') // blank line before SSH
+			}
+			answers <- line
+		}()
+	}
+	wg.Wait()
+
+	paired := 0
+	for range senders {
+		switch line := <-answers; {
+		case strings.Contains(line, `"ok"`):
+			paired++
+		case !strings.Contains(line, "not-ready"):
+			t.Fatalf("sender got %q", line)
+		}
+	}
+	if paired != 1 {
+		t.Fatalf("%d senders paired with one receiver", paired)
+	}
+	if GetByRID(inv.RID) != nil || GetByCode(inv.Code) != nil {
+		t.Fatal("paired invite still stored")
+	}
+	if _, _, ok := inv.takeForPairing(); ok {
+		t.Fatal("paired invite taken again")
+	}
+}
+
+// benchConn stands in for a waiting receiver's connection
+type benchConn struct{ net.Conn }
+
+func (benchConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000}
+}
+
+// waitingReceivers mints n invites with a receiver attached, like a relay
+// with n receivers waiting for their senders, and removes them when b ends.
+func waitingReceivers(b *testing.B, n int) []*Invite {
+	b.Helper()
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	words := RequiredCodeWords(n, time.Hour)
+	invites := make([]*Invite, n)
+	for i := range invites {
+		inv, err := MintInvite("SHA256:bench", time.Hour+time.Duration(i)*time.Millisecond, words)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := inv.attachReceiver(benchConn{}, nil); err != nil {
+			b.Fatal(err)
+		}
+		invites[i] = inv
+	}
+	b.Cleanup(func() {
+		for _, inv := range invites {
+			DeleteInvite(inv, "bench")
+		}
+		log.SetOutput(out)
+	})
+	return invites
+}
+
+const benchWaiting = 100_000
+
+// Senders looking up their receiver's invite
+func BenchmarkInviteLookup100k(b *testing.B) {
+	invites := waitingReceivers(b, benchWaiting)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			inv := GetByCode(invites[rand.IntN(len(invites))].Code)
+			if inv == nil || inv.ReceiverConn() == nil {
+				b.Fatal("invite not found")
+			}
+		}
+	})
+}
+
+// Receivers arriving and leaving while 100k others wait
+func BenchmarkInviteMintDelete100k(b *testing.B) {
+	waitingReceivers(b, benchWaiting)
+	words := RequiredCodeWords(benchWaiting, time.Hour)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			inv, err := MintInvite("SHA256:bench", 10*time.Minute, words)
+			if err != nil {
+				b.Fatal(err)
+			}
+			DeleteInvite(inv, "bench")
+		}
+	})
+}
+
+// Operators extending invites, which reorders the expiry heap
+func BenchmarkInviteExtend100k(b *testing.B) {
+	invites := waitingReceivers(b, benchWaiting)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, ok := extendInvite(invites[rand.IntN(len(invites))], time.Millisecond); !ok {
+				b.Fatal("invite gone")
+			}
+		}
+	})
+}
+
+// The TUI, admin socket and metrics listing every invite
+func BenchmarkInviteList100k(b *testing.B) {
+	waitingReceivers(b, benchWaiting)
+	b.ResetTimer()
+	for b.Loop() {
+		if n := len(GetOutstandingInvites()); n != benchWaiting {
+			b.Fatalf("%d invites, want %d", n, benchWaiting)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"ssh-portal/internal/cli/framing"
+)
+
+// sessionPolicy holds the relay-wide and per-tenant session limits.
+type sessionPolicy struct {
+	global  framing.SessionLimits
+	tenants map[string]framing.SessionLimits
+}
+
+var sessions *sessionPolicy
+
+// newSessionPolicy validates the tenant overrides. It returns nil when no
+// session is limited.
+func newSessionPolicy(maxDuration, idleTimeout time.Duration, tenants []TenantConfig) (*sessionPolicy, error) {
+	parse := func(what, s string, d *time.Duration) error {
+		if s == "" {
+			return nil // inherit the relay's limit
+		}
+		v, err := time.ParseDuration(s)
+		if err != nil || v < 0 {
+			return fmt.Errorf("%s: invalid duration %q", what, s)
+		}
+		*d = v
+		return nil
+	}
+	p := &sessionPolicy{global: framing.SessionLimits{MaxDuration: maxDuration, IdleTimeout: idleTimeout}, tenants: map[string]framing.SessionLimits{}}
+	limited := !p.global.IsZero()
+	for _, t := range tenants {
+		l := p.global
+		if err := parse("tenant "+t.Name+" max-duration", t.MaxDuration, &l.MaxDuration); err != nil {
+			return nil, err
+		}
+		if err := parse("tenant "+t.Name+" idle-timeout", t.IdleTimeout, &l.IdleTimeout); err != nil {
+			return nil, err
+		}
+		limited = limited || !l.IsZero()
+		p.tenants[t.Name] = l
+	}
+	if !limited {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// limits returns the limits of a splice of tenant ("" for none).
+func (p *sessionPolicy) limits(tenant string) framing.SessionLimits {
+	if p == nil {
+		return framing.SessionLimits{}
+	}
+	if l, ok := p.tenants[tenant]; ok {
+		return l
+	}
+	return p.global
+}
+
+// spliceTenant returns the tenant a splice of inv belongs to: the sender's,
+// else the receiver's
+func spliceTenant(inv *Invite, senderTenant string) string {
+	if senderTenant != "" {
+		return senderTenant
+	}
+	return inv.Tenant()
+}
+
+// limitSeconds renders a limit for ok and ready; 0 (omitted) means none
+func limitSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(d / time.Second)
+}
+
+// expiredSplices returns the active splices that ran past a limit at now,
+// with the limit each one ran past.
+func expiredSplices(now time.Time) map[*Splice]string {
+	spliceMu.RLock()
+	defer spliceMu.RUnlock()
+	expired := map[*Splice]string{}
+	for _, s := range splices {
+		if s.ClosedAt != nil || s.CloseReason != "" {
+			continue
+		}
+		switch {
+		case s.Limits.MaxDuration > 0 && now.Sub(s.CreatedAt) >= s.Limits.MaxDuration:
+			expired[s] = framing.ReasonMaxDuration
+		case s.Limits.IdleTimeout > 0 && now.Sub(s.activity.LastActive()) >= s.Limits.IdleTimeout:
+			expired[s] = framing.ReasonIdleTimeout
+		}
+	}
+	return expired
+}
+
+// enforceSessionLimits closes the splices that ran past their limits.
+// The endpoints were told the limits when the splice opened and counted down
+// themselves, so they know why the connection closes.
+func enforceSessionLimits(now time.Time) {
+	for s, reason := range expiredSplices(now) {
+		spliceMu.Lock()
+		s.CloseReason = reason
+		spliceMu.Unlock()
+		s.closeConns()
+		log.Printf("[SPLICE] closing splice id=%s code=%s: %s", s.ID, s.Code, framing.DescribeReason(reason))
+	}
+}
+
+// sessionLimiter enforces session limits until ctx is cancelled.
+func sessionLimiter(ctx context.Context) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			enforceSessionLimits(now)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package relay
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"ssh-portal/internal/cli/framing"
+)
+
+// ListenerConfig is one address the relay accepts connections on, with its
+// own policy. Listeners without roles accept receivers and senders; tokens
+// left unset fall back to the relay-wide ones, and an empty token lets that
+// role in without one.
+type ListenerConfig struct {
+	Addr          string     `yaml:"addr" mapstructure:"addr"`
+	Roles         []string   `yaml:"roles,omitempty" mapstructure:"roles,omitempty"`
+	TLS           *TLSConfig `yaml:"tls,omitempty" mapstructure:"tls,omitempty"`
+	ReceiverToken *string    `yaml:"receiver-token,omitempty" mapstructure:"receiver-token,omitempty"`
+	SenderToken   *string    `yaml:"sender-token,omitempty" mapstructure:"sender-token,omitempty"`
+}
+
+// TLSConfig makes a listener accept TLS connections only.
+type TLSConfig struct {
+	Cert string `yaml:"cert" mapstructure:"cert"` // PEM certificate chain
+	Key  string `yaml:"key" mapstructure:"key"`   // PEM private key
+}
+
+// listener is a parsed ListenerConfig.
+type listener struct {
+	network       string // tcp4 or tcp6 for literal addresses, so 0.0.0.0 and [::] bind separately
+	addr          string
+	roles         []string // nil accepts both
+	tls           *tls.Config
+	receiverToken *string
+	senderToken   *string
+}
+
+// newListeners parses cfgs; without any, the relay listens on port on every
+// address.
+func newListeners(cfgs []ListenerConfig, port int) ([]*listener, error) {
+	if len(cfgs) == 0 {
+		return []*listener{{network: "tcp", addr: fmt.Sprintf(":%d", port)}}, nil
+	}
+	var result []*listener
+	seen := map[string]bool{}
+	for _, cfg := range cfgs {
+		host, _, err := net.SplitHostPort(cfg.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("listener %q: %v", cfg.Addr, err)
+		}
+		if seen[cfg.Addr] {
+			return nil, fmt.Errorf("listener %q given twice", cfg.Addr)
+		}
+		seen[cfg.Addr] = true
+		l := &listener{network: "tcp", addr: cfg.Addr, receiverToken: cfg.ReceiverToken, senderToken: cfg.SenderToken}
+		if a, err := netip.ParseAddr(host); err == nil {
+			if a.Is4() {
+				l.network = "tcp4"
+			} else {
+				l.network = "tcp6"
+			}
+		}
+		for _, role := range cfg.Roles {
+			if role != "receiver" && role != "sender" {
+				return nil, fmt.Errorf("listener %q: unknown role %q (want receiver or sender)", cfg.Addr, role)
+			}
+			if !slices.Contains(l.roles, role) {
+				l.roles = append(l.roles, role)
+			}
+		}
+		if cfg.TLS != nil {
+			if cfg.TLS.Cert == "" || cfg.TLS.Key == "" {
+				return nil, fmt.Errorf("listener %q: tls needs a cert and a key", cfg.Addr)
+			}
+			cert, err := tls.LoadX509KeyPair(cfg.TLS.Cert, cfg.TLS.Key)
+			if err != nil {
+				return nil, fmt.Errorf("listener %q: %v", cfg.Addr, err)
+			}
+			l.tls = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+		}
+		result = append(result, l)
+	}
+	return result, nil
+}
+
+// listen opens the listener's socket; accepted connections use
+// framing.KeepAlive
+func (l *listener) listen() (net.Listener, error) {
+	lc := net.ListenConfig{KeepAliveConfig: framing.KeepAlive}
+	ln, err := lc.Listen(context.Background(), l.network, l.addr)
+	if err != nil {
+		return nil, err
+	}
+	if l.tls != nil {
+		ln = tls.NewListener(ln, l.tls)
+	}
+	return ln, nil
+}
+
+// String describes the listener for the log
+func (l *listener) String() string {
+	var policy []string
+	if l.tls != nil {
+		policy = append(policy, "tls")
+	}
+	if l.roles != nil {
+		policy = append(policy, strings.Join(l.roles, "+")+" only")
+	}
+	if l.receiverToken != nil || l.senderToken != nil {
+		policy = append(policy, "own tokens")
+	}
+	if len(policy) == 0 {
+		return l.addr
+	}
+	return fmt.Sprintf("%s (%s)", l.addr, strings.Join(policy, ", "))
+}
+
+// receiverEndpoint returns the port and TLS setting of the first of ls that
+// receivers may connect through, for the commands the invite API hands out;
+// port is 0 if none does.
+func receiverEndpoint(ls []*listener) (port int, useTLS bool) {
+	for _, l := range ls {
+		if !l.accepts("receiver") {
+			continue
+		}
+		_, p, err := net.SplitHostPort(l.addr)
+		if err != nil {
+			continue
+		}
+		if port, err = strconv.Atoi(p); err == nil {
+			return port, l.tls != nil
+		}
+	}
+	return 0, false
+}
+
+// accepts reports whether role may connect through this listener. Roles the
+// relay does not know are left for the handler to refuse.
+func (l *listener) accepts(role string) bool {
+	if l.roles == nil || (role != "receiver" && role != "sender") {
+		return true
+	}
+	return slices.Contains(l.roles, role)
+}
+
+// tokens returns the receiver and sender tokens required on this listener
+func (l *listener) tokens(p *connPolicy) (receiver, sender string) {
+	receiver, sender = p.receiverToken, p.senderToken
+	if l.receiverToken != nil {
+		receiver = *l.receiverToken
+	}
+	if l.senderToken != nil {
+		sender = *l.senderToken
+	}
+	return receiver, sender
+}
+
+// tenant returns the tenant whose token a role presented on this listener.
+// Tenant tokens stand in for the relay-wide tokens only: a listener with its
+// own token for role accepts that token and nothing else.
+func (l *listener) tenant(p *connPolicy, role, token string) *TenantConfig {
+	if (role == "receiver" && l.receiverToken != nil) || (role == "sender" && l.senderToken != nil) {
+		return nil
+	}
+	return tenantForToken(p.tenants, token)
+}
+
+// rejectListenerRole turns away a role the listener does not accept
+func rejectListenerRole(c net.Conn, msg *EndpointMessage, l *listener) {
+	log.Printf("[TCP] %s -> ERR: %s not accepted on %s", c.RemoteAddr(), msg.Role, l.addr)
+	auditAuthFailure(c, msg.Role, "listener-role", "")
+	_, caps := negotiate(msg)
+	SendErrorMessage(c, "forbidden", msg.Role+"s are not accepted on this address", caps)
+	c.Close()
+}
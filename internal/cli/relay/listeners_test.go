@@ -0,0 +1,96 @@
+package relay
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"testing"
+)
+
+func TestReceiverEndpoint(t *testing.T) {
+	senders := &listener{addr: ":4430", roles: []string{"sender"}}
+	receivers := &listener{addr: "0.0.0.0:443", roles: []string{"receiver"}, tls: &tls.Config{}}
+	both := &listener{addr: "[::1]:4431"}
+	for _, tt := range []struct {
+		name     string
+		ls       []*listener
+		wantPort int
+		wantTLS  bool
+	}{
+		{"first that accepts receivers", []*listener{senders, receivers, both}, 443, true},
+		{"both roles", []*listener{senders, both, receivers}, 4431, false},
+		{"senders only", []*listener{senders}, 0, false},
+	} {
+		if port, useTLS := receiverEndpoint(tt.ls); port != tt.wantPort || useTLS != tt.wantTLS {
+			t.Errorf("%s: got port %d tls=%v, want %d tls=%v", tt.name, port, useTLS, tt.wantPort, tt.wantTLS)
+		}
+	}
+}
+
+func TestListenerTenant(t *testing.T) {
+	p := &connPolicy{receiverToken: "relay-r", senderToken: "relay-s", tenants: []TenantConfig{{Name: "acme", Token: "acme-token"}}}
+	own := "listener-token"
+	shared := &listener{addr: ":4430"}
+	ownSender := &listener{addr: ":4431", senderToken: &own}
+	ownBoth := &listener{addr: ":4432", receiverToken: &own, senderToken: &own}
+	for _, tt := range []struct {
+		l          *listener
+		role       string
+		token      string
+		wantTenant string
+	}{
+		{shared, "receiver", "acme-token", "acme"},
+		{shared, "sender", "acme-token", "acme"},
+		{shared, "sender", "other", ""},
+		{ownSender, "sender", "acme-token", ""},       // its own token only
+		{ownSender, "receiver", "acme-token", "acme"}, // no own receiver token
+		{ownBoth, "receiver", "acme-token", ""},
+		{ownBoth, "sender", own, ""},
+	} {
+		got := ""
+		if tenant := tt.l.tenant(p, tt.role, tt.token); tenant != nil {
+			got = tenant.Name
+		}
+		if got != tt.wantTenant {
+			t.Errorf("%s on %s with %q: tenant %q, want %q", tt.role, tt.l.addr, tt.token, got, tt.wantTenant)
+		}
+	}
+}
+
+func TestListenerTenantHandshake(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+	policy.Store(&connPolicy{senderToken: "relay-s", tenants: []TenantConfig{{Name: "acme", Token: "acme-token"}}})
+	defer policy.Store(nil)
+
+	own := "listener-token"
+	// A sender presenting a tenant token for an unknown code
+	hello := func(l *listener) string {
+		relaySide, peer := net.Pipe()
+		defer peer.Close()
+		go handleTCP(fromConn{relaySide, "192.0.2.5"}, func() {}, l)
+		msg := "ssh-relay/1.0\n{\"msg\":\"hello\",\"role\":\"sender\",\"code\":\"ab12cd34\",\"token\":\"acme-token\"}\n"
+		if _, err := peer.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		line, err := bufio.NewReader(peer).ReadBytes('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp ErrorResponse
+		if err := json.Unmarshal(line, &resp); err != nil {
+			t.Fatalf("%s: %s", l.addr, line)
+		}
+		return resp.Err
+	}
+	if got := hello(&listener{addr: ":4431", senderToken: &own}); got != "invalid-token" {
+		t.Errorf("tenant token on a listener with its own sender token: %s, want invalid-token", got)
+	}
+	if got := hello(&listener{addr: ":4430"}); got == "invalid-token" {
+		t.Error("tenant token refused on a listener without its own token")
+	}
+}
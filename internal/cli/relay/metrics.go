@@ -0,0 +1,117 @@
+package relay
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// metricsHandler serves the relay's metrics in the Prometheus text format.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w)
+}
+
+func writeMetrics(w io.Writer) {
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	value := func(name, labels string, v float64) {
+		if labels != "" {
+			labels = "{" + labels + "}"
+		}
+		fmt.Fprintf(w, "%s%s %s\n", name, labels, strconv.FormatFloat(v, 'g', -1, 64))
+	}
+
+	active := GetActiveSplices()
+	throttled := 0
+	type tenantStats struct {
+		splices int
+		rate    float64
+	}
+	tenants := map[string]*tenantStats{}
+	spliceMu.RLock()
+	for _, s := range active {
+		if s.Throttled {
+			throttled++
+		}
+		if s.Tenant != "" {
+			ts := tenants[s.Tenant]
+			if ts == nil {
+				ts = &tenantStats{}
+				tenants[s.Tenant] = ts
+			}
+			ts.splices++
+			ts.rate += s.Rate
+		}
+	}
+	spliceMu.RUnlock()
+
+	metric("ssh_portal_relay_invites", "gauge", "Outstanding invites.")
+	value("ssh_portal_relay_invites", "", float64(CountOutstandingInvites()))
+	metric("ssh_portal_relay_splices_active", "gauge", "Active splices.")
+	value("ssh_portal_relay_splices_active", "", float64(len(active)))
+	metric("ssh_portal_relay_splices_throttled", "gauge", "Active splices held back by a bandwidth cap since the last sample.")
+	value("ssh_portal_relay_splices_throttled", "", float64(throttled))
+	metric("ssh_portal_relay_relayed_bytes_total", "counter", "Bytes relayed, by direction (up: receiver to sender).")
+	value("ssh_portal_relay_relayed_bytes_total", `direction="up"`, float64(relayedBytesUp.Load()))
+	value("ssh_portal_relay_relayed_bytes_total", `direction="down"`, float64(relayedBytesDown.Load()))
+	metric("ssh_portal_relay_rate_bytes_per_second", "gauge", "Rate of all splices together at the last sample.")
+	value("ssh_portal_relay_rate_bytes_per_second", "", CurrentRelayRate())
+	metric("ssh_portal_relay_bandwidth_cap_bytes_per_second", "gauge", "Relay-wide bandwidth cap, 0 if unlimited.")
+	value("ssh_portal_relay_bandwidth_cap_bytes_per_second", "", GlobalBandwidthCap())
+	metric("ssh_portal_relay_throttled_seconds_total", "counter", "Time splices spent held back by bandwidth caps.")
+	value("ssh_portal_relay_throttled_seconds_total", "", float64(throttledNanos.Load())/float64(time.Second))
+
+	names := make([]string, 0, len(tenants))
+	for name := range tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metric("ssh_portal_relay_tenant_splices_active", "gauge", "Active splices per tenant.")
+	for _, name := range names {
+		value("ssh_portal_relay_tenant_splices_active", "tenant="+strconv.Quote(name), float64(tenants[name].splices))
+	}
+	metric("ssh_portal_relay_tenant_rate_bytes_per_second", "gauge", "Rate of each tenant's splices together at the last sample.")
+	for _, name := range names {
+		value("ssh_portal_relay_tenant_rate_bytes_per_second", "tenant="+strconv.Quote(name), tenants[name].rate)
+	}
+
+	refusals, bans, banned, pending := abuseStats()
+	kinds := make([]string, 0, len(refusals))
+	for kind := range refusals {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	metric("ssh_portal_relay_abuse_refused_total", "counter", "Connections and requests refused by abuse limits, by limit.")
+	for _, kind := range kinds {
+		value("ssh_portal_relay_abuse_refused_total", "limit="+strconv.Quote(kind), float64(refusals[kind]))
+	}
+	metric("ssh_portal_relay_abuse_bans_total", "counter", "Addresses and subnets banned.")
+	value("ssh_portal_relay_abuse_bans_total", "", float64(bans))
+	metric("ssh_portal_relay_abuse_banned", "gauge", "Addresses and subnets currently banned.")
+	value("ssh_portal_relay_abuse_banned", "", float64(banned))
+	metric("ssh_portal_relay_pending_handshakes", "gauge", "Connections that have not finished their handshake.")
+	value("ssh_portal_relay_pending_handshakes", "", float64(pending))
+
+	rejected := aclRejections()
+	roles := make([]string, 0, len(rejected))
+	for role := range rejected {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	metric("ssh_portal_relay_acl_rejected_total", "counter", "Connections refused by the access lists, by role (any: before the handshake).")
+	for _, role := range roles {
+		value("ssh_portal_relay_acl_rejected_total", `reason="acl-denied",role=`+strconv.Quote(role), float64(rejected[role]))
+	}
+
+	draining := 0.0
+	if IsDraining() {
+		draining = 1
+	}
+	metric("ssh_portal_relay_draining", "gauge", "1 while the relay drains.")
+	value("ssh_portal_relay_draining", "", draining)
+}
@@ -0,0 +1,166 @@
+package relay
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"ssh-portal/internal/cli/framing"
+)
+
+// Receivers that negotiated framing.CapPing are pinged while they wait, so
+// one whose connection died (a NAT box dropping it, a laptop going to sleep)
+// is found and its invite removed instead of failing the sender that pairs
+// with it. A receiver that misses a pong is marked unresponsive; one that
+// misses framing.PingMisses in a row, or whose connection ends, is dropped.
+
+// pongGrace is how long pairing waits for the pong of a ping in flight
+const pongGrace = 5 * time.Second
+
+// pongSchemas are the messages a waiting receiver may send. The await it
+// sends after hello_ok is still unread when the pinger starts.
+var pongSchemas = framing.Schemas{
+	"pong":  framing.PingSchema,
+	"await": endpointSchemas["await/receiver"],
+}
+
+// receiverPinger pings the receiver waiting on an invite until a sender
+// takes the connection over.
+type receiverPinger struct {
+	inv      *Invite
+	conn     *bufferedConn
+	caps     []string
+	interval time.Duration
+
+	mu       sync.Mutex
+	inFlight bool // a ping awaits its pong
+	handoff  bool // a sender is pairing; stop after the ping in flight
+	stop     chan struct{}
+	done     chan struct{}
+	alive    bool // the receiver answered its last ping; valid once done is closed
+}
+
+// startPinger starts pinging the receiver attached to inv on conn
+func startPinger(inv *Invite, conn *bufferedConn, caps []string, interval time.Duration) {
+	p := &receiverPinger{
+		inv:      inv,
+		conn:     conn,
+		caps:     caps,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		alive:    true,
+	}
+	inv.mu.Lock()
+	inv.pinger = p
+	inv.mu.Unlock()
+	go p.run()
+}
+
+func (p *receiverPinger) run() {
+	defer close(p.done)
+	missed := 0
+	next := time.NewTimer(p.interval)
+	defer next.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-next.C:
+		}
+		p.mu.Lock()
+		if p.handoff {
+			p.mu.Unlock()
+			return
+		}
+		p.inFlight = true
+		p.mu.Unlock()
+
+		sent := time.Now()
+		err := p.ping(sent.Add(p.interval))
+
+		p.mu.Lock()
+		p.inFlight = false
+		p.alive = err == nil
+		handoff := p.handoff
+		p.mu.Unlock()
+		if handoff {
+			return
+		}
+
+		switch {
+		case err == nil:
+			if missed > 0 {
+				log.Printf("[PING] receiver %s responsive again: code=%s rid=%s", p.conn.RemoteAddr(), p.inv.Code, p.inv.RID)
+				p.inv.setUnresponsive(false)
+			}
+			missed = 0
+			next.Reset(time.Until(sent.Add(p.interval)))
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			missed++
+			if missed == 1 {
+				log.Printf("[PING] receiver %s unresponsive: code=%s rid=%s", p.conn.RemoteAddr(), p.inv.Code, p.inv.RID)
+				p.inv.setUnresponsive(true)
+			}
+			if missed >= framing.PingMisses {
+				log.Printf("[PING] receiver %s missed %d pings, dropping invite: code=%s rid=%s", p.conn.RemoteAddr(), missed, p.inv.Code, p.inv.RID)
+				sendBye(p.conn, framing.ReasonKeepaliveTimeout, 0, p.caps)
+				p.conn.Close()
+				DeleteInvite(p.inv, "unresponsive")
+				return
+			}
+			next.Reset(0)
+		case errors.Is(err, net.ErrClosed):
+			// Closed by the relay (expiry, revocation, drain), which removes the invite
+			return
+		default:
+			log.Printf("[PING] receiver %s gone: %v: code=%s rid=%s", p.conn.RemoteAddr(), err, p.inv.Code, p.inv.RID)
+			p.conn.Close()
+			DeleteInvite(p.inv, "receiver-gone")
+			return
+		}
+	}
+}
+
+// ping sends a ping and reads until the pong, or until deadline
+func (p *receiverPinger) ping(deadline time.Time) error {
+	_ = p.conn.SetWriteDeadline(deadline)
+	err := sendJSON(p.conn, framing.PingMessage{Msg: "ping"})
+	_ = p.conn.SetWriteDeadline(time.Time{})
+	if err != nil {
+		return err
+	}
+	_ = p.conn.SetReadDeadline(deadline)
+	for {
+		msg, _, err := pongSchemas.ReadFrame(p.conn.br)
+		if err != nil {
+			return err
+		}
+		if msg == "pong" {
+			return nil
+		}
+	}
+}
+
+// handOff stops the pings before a sender takes the connection over and
+// reports whether the receiver is still there. A ping in flight gets up to
+// pongGrace for its pong, so no half-read pong is left in front of SSH.
+func (p *receiverPinger) handOff() bool {
+	p.mu.Lock()
+	first := !p.handoff
+	p.handoff = true
+	if first {
+		if p.inFlight {
+			_ = p.conn.SetReadDeadline(time.Now().Add(pongGrace))
+		} else {
+			close(p.stop)
+		}
+	}
+	p.mu.Unlock()
+	<-p.done
+	_ = p.conn.SetReadDeadline(time.Time{})
+	return p.alive
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"log"
 	"net"
-	"strings"
 	"time"
+
+	"ssh-portal/internal/cli/framing"
+	"ssh-portal/internal/version"
 )
 
 // ====== Protocol message parsing ======
@@ -28,34 +30,51 @@ func (bc *bufferedConn) Read(p []byte) (int, error) {
 
 // JSON protocol messages
 type EndpointMessage struct {
-	Msg        string      `json:"msg"`  // "hello", "await"
+	Msg        string      `json:"msg"`  // "hello", "await", "inspect"
 	Role       string      `json:"role"` // "sender" or "receiver"
 	Code       string      `json:"code,omitempty"`
 	RID        string      `json:"rid,omitempty"`
 	ReceiverFP string      `json:"receiver_fp,omitempty"`
 	TTLSeconds int         `json:"ttl_seconds,omitempty"`
 	Token      string      `json:"token,omitempty"`
+	CodeWords  int         `json:"code_words,omitempty"` // requested code strength (receiver hello)
 	Sender     *SenderInfo `json:"sender,omitempty"`
+	Version    string      `json:"version,omitempty"` // client software version
+	Caps       []string    `json:"caps,omitempty"`    // client capabilities
+	Claim      string      `json:"claim,omitempty"`   // claim token of a pre-minted invite (receiver hello)
+	Label      string      `json:"label,omitempty"`   // what the receiver is, for senders inspecting its invite (await, framing.CapLabel)
 }
 
 type OKResponse struct {
-	Msg string `json:"msg"` // "ok"
-	FP  string `json:"fp,omitempty"`
-	Exp int64  `json:"exp,omitempty"`
-	Alg string `json:"alg,omitempty"`
+	Msg     string   `json:"msg"` // "ok"
+	FP      string   `json:"fp,omitempty"`
+	Exp     int64    `json:"exp,omitempty"`
+	Alg     string   `json:"alg,omitempty"`
+	Version string   `json:"version,omitempty"` // relay version (negotiating peers only)
+	Caps    []string `json:"caps,omitempty"`    // negotiated capabilities
+	// Session limits in seconds, for peers with framing.CapSessionLimits
+	MaxDuration int `json:"max_duration,omitempty"`
+	IdleTimeout int `json:"idle_timeout,omitempty"`
 }
 
 type ErrorResponse struct {
-	Msg string `json:"msg"` // "error"
-	Err string `json:"error"`
+	Msg        string `json:"msg"` // "error"
+	Err        string `json:"error"`
+	Message    string `json:"message,omitempty"`     // explanation, for peers with framing.CapErrorMessage
+	RetryAfter int    `json:"retry_after,omitempty"` // seconds to wait, for peers with framing.CapRetryAfter
 }
 
 // HelloOKResponse is sent back to a receiver after a successful hello
 type HelloOKResponse struct {
-	Msg  string `json:"msg"` // "hello_ok"
-	Code string `json:"code"`
-	RID  string `json:"rid"`
-	Exp  int64  `json:"exp"`
+	Msg       string   `json:"msg"` // "hello_ok"
+	Code      string   `json:"code"`
+	RID       string   `json:"rid"`
+	Exp       int64    `json:"exp"`
+	CodeWords int      `json:"code_words,omitempty"` // negotiated code strength
+	Version   string   `json:"version,omitempty"`    // relay version (negotiating peers only)
+	Caps      []string `json:"caps,omitempty"`       // negotiated capabilities
+	// Seconds between pings while the receiver waits (framing.CapPing)
+	PingInterval int `json:"ping_interval,omitempty"`
 }
 
 // ReadyMessage is sent to receiver when sender connects
@@ -66,6 +85,10 @@ type ReadyMessage struct {
 	Exp         int64       `json:"exp"`
 	Alg         string      `json:"alg,omitempty"`
 	Sender      *SenderInfo `json:"sender,omitempty"`
+	Caps        []string    `json:"caps,omitempty"` // capabilities shared by relay, receiver and sender
+	// Session limits in seconds, for receivers with framing.CapSessionLimits
+	MaxDuration int `json:"max_duration,omitempty"`
+	IdleTimeout int `json:"idle_timeout,omitempty"`
 }
 
 // SenderInfo mirrors the sender metadata provided in the initial hello
@@ -74,50 +97,79 @@ type SenderInfo struct {
 	Identity  string `json:"identity,omitempty"`
 }
 
+// Capabilities lists the protocol features this relay supports.
+var Capabilities = []string{framing.CapCodeWords, framing.CapErrorMessage, framing.CapBye, framing.CapSessionLimits, framing.CapRetryAfter, framing.CapPing, framing.CapLabel}
+
+// endpointSchemas lists the fields each endpoint message may carry, keyed by msg/role.
+var endpointSchemas = map[string]framing.Schema{
+	"hello/receiver": {
+		Required: []string{"msg", "role", "receiver_fp"},
+		Optional: []string{"token", "ttl_seconds", "code_words", "version", "caps", "claim"},
+	},
+	"hello/sender": {
+		Required: []string{"msg", "role", "code"},
+		Optional: []string{"rid", "token", "sender", "version", "caps"},
+	},
+	"await/receiver": {
+		Required: []string{"msg", "role", "rid"},
+		Optional: []string{"code", "version", "caps", "label"},
+	},
+	"inspect/sender": {
+		Required: []string{"msg", "role", "code"},
+		Optional: []string{"token", "version", "caps"},
+	},
+}
+
 // ParseMessage reads the version line and a single JSON payload message.
 // It returns the parsed EndpointMessage and a buffered reader preserving any extra bytes.
+// Malformed frames yield a *framing.Error whose Code can be reported to the peer.
 func ParseMessage(c net.Conn) (*EndpointMessage, *bufio.Reader, error) {
 	_ = c.SetDeadline(time.Now().Add(20 * time.Second))
 	defer c.SetDeadline(time.Time{}) // clear deadline
 
 	br := bufio.NewReader(c)
-
-	// 1) Expect exact version line
-	verLine, err := br.ReadString('\n')
+	payload, err := readEndpointMessage(br)
 	if err != nil {
-		return nil, nil, fmt.Errorf("read version: %w", err)
+		return nil, nil, err
 	}
-	if strings.TrimSpace(verLine) != "ssh-relay/1.0" {
-		return nil, nil, fmt.Errorf("unsupported or missing version line")
+	log.Printf("[TCP] %s -> payload: msg=%s role=%s", c.RemoteAddr(), payload.Msg, payload.Role)
+	return payload, br, nil
+}
+
+// readEndpointMessage reads the version line and one JSON line from br and
+// validates the message against endpointSchemas.
+func readEndpointMessage(br *bufio.Reader) (*EndpointMessage, error) {
+	// 1) Expect exact version line
+	if _, err := framing.ReadVersion(br); err != nil {
+		return nil, fmt.Errorf("read version: %w", err)
 	}
 
-	// 2) Read one JSON line
-	jsonLine, err := br.ReadString('\n')
+	// 2) Read one JSON line and check it against the schema for its msg/role
+	jsonLine, err := framing.ReadLine(br, framing.MaxJSONLine)
 	if err != nil {
-		return nil, nil, fmt.Errorf("read payload json: %w", err)
+		return nil, fmt.Errorf("read payload json: %w", err)
 	}
-	jsonLine = strings.TrimSpace(jsonLine)
-	log.Printf("[TCP] %s -> payload json: %s", c.RemoteAddr(), jsonLine)
-
-	var payload EndpointMessage
-	if err := json.Unmarshal([]byte(jsonLine), &payload); err != nil {
-		return nil, nil, fmt.Errorf("decode payload: %w", err)
+	fields, err := framing.Fields(jsonLine)
+	if err != nil {
+		return nil, fmt.Errorf("decode payload: %w", err)
 	}
-	// validate based on role and message
-	if payload.Role != "sender" && payload.Role != "receiver" {
-		return nil, nil, fmt.Errorf("invalid role %q", payload.Role)
+	key := framing.MsgOf(fields) + "/" + framing.StringField(fields, "role")
+	schema, ok := endpointSchemas[key]
+	if !ok {
+		return nil, framing.Errorf(framing.CodeUnknownMsg, "unexpected message %q", key)
 	}
-	if payload.Msg == "hello" && payload.Role == "sender" && payload.Code == "" {
-		return nil, nil, fmt.Errorf("missing code for sender")
+	if err := schema.Check(fields); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", key, err)
 	}
-	if payload.Msg == "await" && payload.Role == "receiver" && payload.RID == "" {
-		return nil, nil, fmt.Errorf("missing rid for receiver")
+
+	var payload EndpointMessage
+	if err := framing.DecodeStrict(jsonLine, &payload); err != nil {
+		return nil, fmt.Errorf("decode payload: %w", err)
 	}
-	if payload.Msg == "hello" && payload.Role == "receiver" && payload.ReceiverFP == "" {
-		return nil, nil, fmt.Errorf("invalid hello message (need receiver_fp)")
+	if payload.TTLSeconds < 0 || payload.CodeWords < 0 {
+		return nil, framing.Errorf(framing.CodeBadField, "negative ttl_seconds or code_words")
 	}
-
-	return &payload, br, nil
+	return &payload, nil
 }
 
 // ====== JSON response helpers ======
@@ -139,9 +191,31 @@ func SendErrorResponse(c net.Conn, errMsg string) error {
 	return sendJSON(c, ErrorResponse{Msg: "error", Err: errMsg})
 }
 
+// SendErrorMessage sends a JSON error response with an explanation. The message
+// is dropped for peers that did not negotiate framing.CapErrorMessage, since
+// their strict decoders would reject the extra field.
+func SendErrorMessage(c net.Conn, errMsg, message string, caps []string) error {
+	if !framing.HasCap(caps, framing.CapErrorMessage) {
+		message = ""
+	}
+	return sendJSON(c, ErrorResponse{Msg: "error", Err: errMsg, Message: message})
+}
+
+// sendBye tells a peer why the relay is about to close its connection and, if
+// retryAfter is positive, how many seconds to wait before reconnecting. Peers
+// that did not negotiate framing.CapBye only see the connection close.
+func sendBye(c net.Conn, reason string, retryAfter int, caps []string) {
+	if !framing.HasCap(caps, framing.CapBye) {
+		return
+	}
+	_ = c.SetWriteDeadline(time.Now().Add(2 * time.Second))
+	_ = sendJSON(c, framing.ByeMessage{Msg: "bye", Reason: reason, RetryAfter: retryAfter})
+}
+
 // SendSuccessResponse sends a JSON ok response and a blank line before SSH starts
-func SendSuccessResponse(c net.Conn, fp string, exp int64, alg string) error {
-	if err := sendJSON(c, OKResponse{Msg: "ok", FP: fp, Exp: exp, Alg: alg}); err != nil {
+func SendSuccessResponse(c net.Conn, ok OKResponse) error {
+	ok.Msg = "ok"
+	if err := sendJSON(c, ok); err != nil {
 		return err
 	}
 	// Single blank line before SSH banner begins
@@ -151,6 +225,34 @@ func SendSuccessResponse(c net.Conn, fp string, exp int64, alg string) error {
 	return nil
 }
 
+// ====== Version and capability negotiation ======
+
+// negotiate returns the relay version and the capabilities shared with a peer
+// advertising caps. Both are empty for peers that predate negotiation, so the
+// responses they get stay byte-for-byte what they expect.
+func negotiate(msg *EndpointMessage) (string, []string) {
+	if msg.Version == "" && msg.Caps == nil {
+		return "", nil
+	}
+	return version.String(), framing.Negotiate(Capabilities, msg.Caps)
+}
+
+// checkClientVersion returns an upgrade message if the peer is older than min.
+// Peers that do not advertise a version predate negotiation and are too old
+// whenever a minimum is configured.
+func checkClientVersion(msg *EndpointMessage, min string) (string, bool) {
+	if min == "" {
+		return "", true
+	}
+	if msg.Version == "" {
+		return fmt.Sprintf("this ssh-portal version is older than %s, the minimum supported by this relay; please upgrade", min), false
+	}
+	if !version.AtLeast(msg.Version, min) {
+		return fmt.Sprintf("ssh-portal %s is older than %s, the minimum supported by this relay; please upgrade", msg.Version, min), false
+	}
+	return "", true
+}
+
 // ====== Receiver protocol handler ======
 
 // HandleReceiver processes a receiver connection
@@ -161,33 +263,41 @@ func HandleReceiver(c net.Conn, rid string, br *bufio.Reader) (*Invite, net.Conn
 	log.Printf("[TCP] %s -> receiver connecting with rid=%s", remoteAddr, rid)
 
 	inv := GetByRID(rid)
-	if inv == nil || time.Now().After(inv.ExpiresAt) {
+	if inv == nil || inv.expired(time.Now()) {
+		guard.fail(connIP(c))
 		log.Printf("[TCP] %s -> ERR: invalid or expired rid=%s", remoteAddr, rid)
 		SendErrorResponse(c, "no-invite")
 		c.Close()
 		return nil, nil
 	}
-
-	// Check if receiver already attached
-	LockInvites()
-	if inv.ReceiverConn != nil {
-		UnlockInvites()
-		log.Printf("[TCP] %s -> ERR: receiver already attached for rid=%s", remoteAddr, rid)
-		SendErrorResponse(c, "already-attached")
+	// A pre-minted invite is only taken with its claim token, never by RID
+	if !inv.Claimed() {
+		guard.fail(connIP(c))
+		log.Printf("[TCP] %s -> ERR: await for unclaimed rid=%s", remoteAddr, rid)
+		auditAuthFailure(c, "receiver", "invalid-claim", "")
+		SendErrorResponse(c, "no-invite")
 		c.Close()
 		return nil, nil
 	}
 
 	// Wrap connection with buffered reader to preserve any SSH banner data
 	bufferedC := newBufferedConn(c, br)
-	inv.ReceiverConn = bufferedC
-	UnlockInvites()
+	if err := inv.attachReceiver(bufferedC, nil); err != nil {
+		code := "already-attached"
+		if err == errInviteClosed {
+			code = "no-invite"
+		}
+		log.Printf("[TCP] %s -> ERR: %v for rid=%s", remoteAddr, err, rid)
+		SendErrorResponse(c, code)
+		c.Close()
+		return nil, nil
+	}
 
 	log.Printf("[TCP] %s -> receiver attached successfully: code=%s rid=%s waiting for sender...", remoteAddr, inv.Code, rid)
 
 	// Wait for sender or timeout
 	go func() {
-		<-time.After(time.Until(inv.ExpiresAt))
+		<-time.After(time.Until(inv.ExpiresAt()))
 		log.Printf("[TCP] %s -> receiver connection timeout/closed", remoteAddr)
 	}()
 
@@ -196,43 +306,84 @@ func HandleReceiver(c net.Conn, rid string, br *bufio.Reader) (*Invite, net.Conn
 
 // ====== Sender protocol handler ======
 
-// HandleSender processes a sender connection
-// Returns the invite if ready for pairing, nil on error
-func HandleSender(c net.Conn, code string, meta *SenderInfo) *Invite {
+// HandleSender processes a sender connection; tenant is the tenant of the
+// sender's token, if any
+// Returns the invite, taken off the store, with its receiver's connection and
+// capabilities if ready for pairing, nil on error
+func HandleSender(c net.Conn, msg *EndpointMessage, tenant string) (*Invite, net.Conn, []string) {
+	code, meta := msg.Code, msg.Sender
 	remoteAddr := c.RemoteAddr().String()
-	ip, _, _ := net.SplitHostPort(remoteAddr)
 	log.Printf("[TCP] %s -> sender connecting with code=%s", remoteAddr, code)
 
-	// Throttle after repeated failures from this IP
-	checkRateLimit(ip)
-
 	inv := GetByCode(code)
-	if inv == nil || time.Now().After(inv.ExpiresAt) || inv.ReceiverConn == nil {
-		recordFailedAttempt(ip)
-		log.Printf("[TCP] %s -> ERR: code %s not ready (invalid/expired/no receiver)", remoteAddr, code)
+	var rc net.Conn
+	var receiverCaps []string
+	ok := inv != nil && !inv.expired(time.Now())
+	if ok {
+		// Only one sender takes the receiver; the others find it gone
+		rc, receiverCaps, ok = inv.takeForPairing()
+	}
+	if !ok {
+		guard.fail(connIP(c))
+		auditAuthFailure(c, "sender", "not-ready", code)
+		log.Printf("[TCP] %s -> ERR: code %s not ready (invalid/expired/no receiver/taken)", remoteAddr, code)
 		SendErrorResponse(c, "not-ready")
 		c.Close()
-		return nil
+		return nil, nil, nil
 	}
 
-	clearFailedAttempts(ip)
-
 	// Attach sender metadata to invite for forwarding to receiver
 	if meta != nil {
-		LockInvites()
-		inv.Sender = meta
-		UnlockInvites()
+		inv.setSender(meta)
 	}
 
-	// Send authentication response if not already sent
-	if !inv.sentOK {
-		alg := "" // TODO: extract from receiver connection if available
-		if err := SendSuccessResponse(c, inv.ReceiverFP, inv.ExpiresAt.Unix(), alg); err != nil {
-			return nil
-		}
-		inv.sentOK = true
-		log.Printf("[TCP] %s -> sender authenticated: code=%s fp=%s", remoteAddr, code, inv.ReceiverFP)
+	alg := "" // TODO: extract from receiver connection if available
+	relayVersion, caps := negotiate(msg)
+	okResp := OKResponse{FP: inv.ReceiverFP(), Exp: inv.ExpiresAt().Unix(), Alg: alg, Version: relayVersion, Caps: caps}
+	if framing.HasCap(caps, framing.CapSessionLimits) {
+		limits := sessions.limits(spliceTenant(inv, tenant))
+		okResp.MaxDuration, okResp.IdleTimeout = limitSeconds(limits.MaxDuration), limitSeconds(limits.IdleTimeout)
+	}
+	if err := SendSuccessResponse(c, okResp); err != nil {
+		// The invite is used up; its receiver can't be handed to anyone else
+		log.Printf("[TCP] %s -> sender gone before ok: code=%s: %v", remoteAddr, code, err)
+		rc.Close()
+		c.Close()
+		inviteClosed(inv, "sender-gone")
+		return nil, nil, nil
+	}
+	log.Printf("[TCP] %s -> sender authenticated: code=%s fp=%s", remoteAddr, code, inv.ReceiverFP())
+
+	return inv, rc, receiverCaps
+}
+
+// ====== Inspect protocol handler ======
+
+// HandleInspect tells a sender what its code points to before it connects:
+// whether the invite exists, when it expires and what the receiver says it
+// is. The invite is left as it is and the inspection is no pairing attempt,
+// so unknown codes don't spend the pairing failure budget; inspections have
+// their own rate limit, which bounds guessing codes this way.
+func HandleInspect(c net.Conn, msg *EndpointMessage) {
+	defer c.Close()
+	remoteAddr := c.RemoteAddr().String()
+	ip := connIP(c)
+	if wait, ok := guard.allow(limitInspects, ip); !ok {
+		rejectRateLimited(c, msg, limitInspects, wait)
+		return
 	}
 
-	return inv
+	resp := framing.InspectOK{Msg: "inspect_ok"}
+	inv := GetByCode(msg.Code)
+	if inv == nil || inv.expired(time.Now()) {
+		log.Printf("[INSPECT] %s -> code %s: no invite", remoteAddr, msg.Code)
+	} else {
+		resp.Exists = true
+		resp.Waiting = inv.ReceiverConn() != nil && !inv.Unresponsive()
+		resp.Exp = inv.ExpiresAt().Unix()
+		resp.Label = inv.ReceiverLabel()
+		log.Printf("[INSPECT] %s -> code %s: rid=%s waiting=%v label=%q", remoteAddr, msg.Code, inv.RID, resp.Waiting, resp.Label)
+	}
+	_ = c.SetWriteDeadline(time.Now().Add(2 * time.Second))
+	_ = sendJSON(c, resp)
 }
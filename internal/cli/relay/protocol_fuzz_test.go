@@ -0,0 +1,62 @@
+package relay
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"testing"
+
+	"ssh-portal/internal/cli/framing"
+)
+
+func FuzzParseMessage(f *testing.F) {
+	f.Add([]byte("ssh-relay/1.0\n{\"msg\":\"hello\",\"role\":\"receiver\",\"receiver_fp\":\"SHA256:abc\",\"code_words\":6}\n"))
+	f.Add([]byte("ssh-relay/1.0\n{\"msg\":\"hello\",\"role\":\"sender\",\"code\":\"ab12cd34\",\"sender\":{\"keepalive\":30}}\n"))
+	f.Add([]byte("ssh-relay/1.0\n{\"msg\":\"await\",\"role\":\"receiver\",\"rid\":\"r1\"}\nSSH-2.0-Go\r\n"))
+	f.Add([]byte("ssh-relay/1.0\n{\"msg\":\"inspect\",\"role\":\"sender\",\"code\":\"ab12cd\"}\n"))
+	f.Add([]byte("ssh-relay/1.0\r\n{\"msg\":\"hello\",\"role\":\"sender\",\"code\":\"x\",\"extra\":1}\r\n"))
+	f.Add([]byte("ssh-relay/2.0\n{}\n"))
+	f.Add([]byte("ssh-relay/1.0\n{\"msg\":\"hello\"} {\"msg\":\"hello\"}\n"))
+
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		client, server := net.Pipe()
+		go func() {
+			_, _ = client.Write(data)
+			client.Close()
+		}()
+		defer server.Close()
+
+		msg, br, err := ParseMessage(server)
+		if err != nil {
+			var fe *framing.Error
+			if !errors.As(err, &fe) {
+				t.Fatalf("error without framing code: %v", err)
+			}
+			return
+		}
+		if br == nil {
+			t.Fatal("nil reader on success")
+		}
+		switch msg.Msg + "/" + msg.Role {
+		case "hello/receiver":
+			if msg.ReceiverFP == "" {
+				t.Fatal("receiver hello accepted without receiver_fp")
+			}
+		case "hello/sender", "inspect/sender":
+			if msg.Code == "" {
+				t.Fatalf("sender %s accepted without code", msg.Msg)
+			}
+		case "await/receiver":
+			if msg.RID == "" {
+				t.Fatal("await accepted without rid")
+			}
+		default:
+			t.Fatalf("accepted unexpected message %s/%s", msg.Msg, msg.Role)
+		}
+	})
+}
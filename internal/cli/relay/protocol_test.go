@@ -0,0 +1,110 @@
+package relay
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"ssh-portal/internal/cli/framing"
+)
+
+// inspect sends an inspect for code and returns the relay's answer
+func inspect(t *testing.T, code string) framing.InspectOK {
+	t.Helper()
+	relaySide, senderSide := net.Pipe()
+	defer senderSide.Close()
+	go HandleInspect(relaySide, &EndpointMessage{Msg: "inspect", Role: "sender", Code: code})
+	line, err := bufio.NewReader(senderSide).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got framing.InspectOK
+	if err := framing.DecodeStrict(line, &got); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestInspectLeavesInviteAlone(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	inv, err := MintInvite("SHA256:test", time.Minute, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteInvite(inv, "test")
+
+	// A receiver that negotiated labels describes itself in its await
+	relaySide, receiverSide := net.Pipe()
+	defer receiverSide.Close()
+	hello := &EndpointMessage{Msg: "hello", Role: "receiver", ReceiverFP: "SHA256:test", Caps: []string{framing.CapLabel}}
+	go attachReceiver(relaySide, bufio.NewReader(relaySide), inv, hello)
+	if _, err := bufio.NewReader(receiverSide).ReadBytes('\n'); err != nil {
+		t.Fatal(err)
+	}
+	await, _ := json.Marshal(EndpointMessage{Msg: "await", Role: "receiver", RID: inv.RID, Label: "build-server-3\x1b[2J  (CI)"})
+	if _, err := receiverSide.Write(append(await, '\n')); err != nil {
+		t.Fatal(err)
+	}
+	for inv.ReceiverConn() == nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	for range 2 {
+		got := inspect(t, inv.Code)
+		want := framing.InspectOK{Msg: "inspect_ok", Exists: true, Waiting: true, Exp: inv.ExpiresAt().Unix(), Label: "build-server-3 [2J (CI)"}
+		if got != want {
+			t.Fatalf("inspect = %+v, want %+v", got, want)
+		}
+	}
+	if GetByCode(inv.Code) != inv || inv.ReceiverConn() == nil || inv.Sender() != nil {
+		t.Fatal("inspect touched the invite")
+	}
+
+	if got := inspect(t, inv.Code+"x"); got.Exists || got.Exp != 0 || got.Label != "" {
+		t.Fatalf("inspect of an unknown code = %+v", got)
+	}
+}
+
+func TestAwaitRefusesUnclaimedInvite(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	inv, claim, err := PreMintInvite("TICKET-9", time.Minute, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteInvite(inv, "test")
+
+	relaySide, receiverSide := net.Pipe()
+	defer receiverSide.Close()
+	done := make(chan *Invite)
+	go func() {
+		got, _ := HandleReceiver(relaySide, inv.RID, bufio.NewReader(relaySide))
+		done <- got
+	}()
+	line, err := bufio.NewReader(receiverSide).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp ErrorResponse
+	if err := json.Unmarshal(line, &resp); err != nil || resp.Err != "no-invite" {
+		t.Fatalf("await by RID got %s", line)
+	}
+	if <-done != nil || inv.ReceiverConn() != nil {
+		t.Fatal("receiver attached to an unclaimed invite by RID")
+	}
+	if GetByRID(inv.RID) == nil {
+		t.Fatal("refused await removed the invite")
+	}
+	if _, err := ClaimInvite(claim, "SHA256:test"); err != nil {
+		t.Fatalf("claim after a refused await: %v", err)
+	}
+}
@@ -4,24 +4,30 @@ import (
 	"bufio"
 	"context"
 	"fmt"
-	"io"
 	"log"
 	"net"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/spf13/viper"
+
+	"ssh-portal/internal/cli/framing"
+	"ssh-portal/internal/cli/usercode"
 	"ssh-portal/internal/version"
 )
 
 // ====== TCP rendezvous/splice ======
-func tcpServe(ctx context.Context, addr string, receiverToken string, senderToken string) error {
-	ln, err := net.Listen("tcp", addr)
+func tcpServe(ctx context.Context, l *listener) error {
+	ln, err := l.listen()
 	if err != nil {
 		return err
 	}
 	defer ln.Close()
 
-	log.Printf("relay TCP listening on %s", addr)
+	log.Printf("relay TCP listening on %v", l)
 
 	// Handle accept in a goroutine to allow context cancellation
 	acceptDone := make(chan struct{})
@@ -38,8 +44,14 @@ func tcpServe(ctx context.Context, addr string, receiverToken string, senderToke
 					continue
 				}
 			}
+			// Banned addresses and connections over the pending caps cost no more than this
+			release, ok := guard.admit(connIP(c))
+			if !ok {
+				c.Close()
+				continue
+			}
 			log.Printf("[TCP] new connection from %s", c.RemoteAddr())
-			go handleTCP(c, receiverToken, senderToken)
+			go handleTCP(c, release, l)
 		}
 	}()
 
@@ -59,60 +71,159 @@ func tcpServe(ctx context.Context, addr string, receiverToken string, senderToke
 	}
 }
 
-func handleTCP(c net.Conn, receiverToken string, senderToken string) {
+// handleTCP serves one connection accepted by l; release ends its pending handshake
+func handleTCP(c net.Conn, release func(), l *listener) {
 	remoteAddr := c.RemoteAddr().String()
+	ip := connIP(c)
+
+	// Addresses no role may connect from are dropped before reading anything
+	acl := acls.Load()
+	if !acl.permits("", ip) {
+		release()
+		rejectACL(c, nil)
+		return
+	}
 
 	// Parse version + first JSON message (hello or mint)
 	msg, br, err := ParseMessage(c)
+	release()
 	if err != nil {
 		log.Printf("[TCP] %s -> %v", remoteAddr, err)
+		// Tell well-behaved peers what was wrong; a dropped connection has no one to tell
+		if code := framing.ErrorCode(err, ""); code != "" && code != framing.CodeTruncated {
+			SendErrorResponse(c, code)
+		}
 		c.Close()
 		return
 	}
 
+	if !acl.permits(msg.Role, ip) {
+		rejectACL(c, msg)
+		return
+	}
+	if !l.accepts(msg.Role) {
+		rejectListenerRole(c, msg, l)
+		return
+	}
+
+	// Answer floods at once instead of holding their sockets open; an address
+	// out of failures may not try again until it has some back
+	if wait, ok := guard.allow(limitHandshakes, ip); !ok {
+		rejectRateLimited(c, msg, limitHandshakes, wait)
+		return
+	}
+	if wait, ok := guard.check(limitFailures, ip); !ok {
+		rejectRateLimited(c, msg, limitFailures, wait)
+		return
+	}
+
+	// Settings reloaded from now on apply to the next connection
+	p := currentPolicy()
+	receiverToken, senderToken := l.tokens(p)
+
+	// Turn away clients older than the configured minimum before doing any work
+	if reason, ok := checkClientVersion(msg, p.minClientVersion); !ok {
+		log.Printf("[TCP] %s -> ERR: %s client too old (version %q)", remoteAddr, msg.Role, msg.Version)
+		SendErrorMessage(c, framing.CodeUpgradeRequired, reason, msg.Caps)
+		c.Close()
+		return
+	}
+
+	// A draining relay takes no new sessions; receivers already waiting were sent away
+	if msg.Msg == "hello" && IsDraining() {
+		log.Printf("[TCP] %s -> ERR: %s turned away, relay is draining", remoteAddr, msg.Role)
+		rejectDraining(c, msg)
+		return
+	}
+
 	// Dispatch to appropriate handler
 	switch msg.Role {
 	case "receiver":
 		if msg.Msg == "hello" {
-			// Validate receiver token if configured
-			if receiverToken != "" {
+			if msg.Claim != "" {
+				// Pre-minted invite; the claim token stands in for the receiver token
+				handleReceiverClaim(c, msg, br)
+				return
+			}
+			// Validate receiver token if configured; tenant tokens are accepted
+			// unless the listener has its own receiver token
+			tenant := l.tenant(p, "receiver", msg.Token)
+			if receiverToken != "" && tenant == nil {
 				if msg.Token != receiverToken {
+					guard.fail(ip)
 					log.Printf("[TCP] %s -> ERR: receiver token mismatch", remoteAddr)
+					auditAuthFailure(c, "receiver", "invalid-token", "")
 					SendErrorResponse(c, "invalid-token")
 					c.Close()
 					return
 				}
 			}
 			// Mint invite and attach this connection as the receiver
-			ttl := 10 * time.Minute
-			if msg.TTLSeconds > 0 && msg.TTLSeconds <= 3600 {
-				ttl = time.Duration(msg.TTLSeconds) * time.Second
+			ttl := p.inviteTTL
+			if requested := time.Duration(msg.TTLSeconds) * time.Second; requested > 0 && requested <= p.maxInviteTTL {
+				ttl = requested
+			}
+			// Pick the code strength: the strongest of what the receiver asked for,
+			// the configured minimum and what the current load requires
+			required := RequiredCodeWords(CountOutstandingInvites(), ttl)
+			if msg.CodeWords == 0 && required > usercode.DefaultCodeWords {
+				// Receivers that don't send code_words only know 4-word codes; load
+				// alone must not lock them out
+				log.Printf("[TCP] %s -> receiver cannot negotiate codes, keeping %d words (load asks for %d)", remoteAddr, usercode.DefaultCodeWords, required)
+				required = usercode.DefaultCodeWords
+			}
+			words := usercode.NormalizeCodeWords(max(msg.CodeWords, p.codeWords, required))
+			if msg.CodeWords == 0 && words != usercode.DefaultCodeWords {
+				// Only the configured minimum turns them away
+				log.Printf("[TCP] %s -> ERR: receiver cannot negotiate a %d-word code", remoteAddr, words)
+				SendErrorResponse(c, "code-words-unsupported")
+				c.Close()
+				return
+			}
+			if wait, ok := guard.allow(limitMints, ip); !ok {
+				rejectRateLimited(c, msg, limitMints, wait)
+				return
 			}
-			inv := MintInvite(msg.ReceiverFP, ttl)
-			log.Printf("[HELLO] receiver connected: fp=%s code=%s rid=%s expires=%s", msg.ReceiverFP, inv.Code, inv.RID, inv.ExpiresAt.Format(time.RFC3339))
-			// Reply with hello_ok
-			_ = sendJSON(c, HelloOKResponse{Msg: "hello_ok", Code: inv.Code, RID: inv.RID, Exp: inv.ExpiresAt.Unix()})
-			// Attach this connection as receiver
-			LockInvites()
-			inv.ReceiverConn = newBufferedConn(c, br)
-			UnlockInvites()
-			// Now wait for sender as in receiver attachment
+			inv, err := MintInvite(msg.ReceiverFP, ttl, words)
+			if err != nil {
+				log.Printf("[TCP] %s -> ERR: %v", remoteAddr, err)
+				SendErrorResponse(c, "mint-failed")
+				c.Close()
+				return
+			}
+			if tenant != nil {
+				inv.setTenant(tenant.Name)
+			}
+			auditInvite(AuditInviteMinted, inv, remoteAddr, "")
+			attachReceiver(c, br, inv, msg)
 			return
 		}
 		handleReceiverConnection(c, msg.RID, br)
 	case "sender":
-		if msg.Msg == "hello" {
-			// Validate sender token if configured
-			if senderToken != "" {
+		tenant := l.tenant(p, "sender", msg.Token)
+		if msg.Msg == "hello" || msg.Msg == "inspect" {
+			// Validate sender token if configured; tenant tokens are accepted
+			// unless the listener has its own sender token
+			if senderToken != "" && tenant == nil {
 				if msg.Token != senderToken {
+					guard.fail(ip)
 					log.Printf("[TCP] %s -> ERR: sender token mismatch", remoteAddr)
+					auditAuthFailure(c, "sender", "invalid-token", msg.Code)
 					SendErrorResponse(c, "invalid-token")
 					c.Close()
 					return
 				}
 			}
 		}
-		handleSenderConnection(c, msg, br)
+		if msg.Msg == "inspect" {
+			HandleInspect(c, msg)
+			return
+		}
+		var tenantName string
+		if tenant != nil {
+			tenantName = tenant.Name
+		}
+		handleSenderConnection(c, msg, br, tenantName)
 	default:
 		log.Printf("[TCP] %s -> ERR: unknown role '%s'", remoteAddr, msg.Role)
 		SendErrorResponse(c, "bad-side")
@@ -120,6 +231,97 @@ func handleTCP(c net.Conn, receiverToken string, senderToken string) {
 	}
 }
 
+// handleReceiverClaim attaches a receiver to the pre-minted invite named by its claim token
+func handleReceiverClaim(c net.Conn, msg *EndpointMessage, br *bufio.Reader) {
+	remoteAddr := c.RemoteAddr().String()
+	inv, err := ClaimInvite(msg.Claim, msg.ReceiverFP)
+	if err != nil {
+		guard.fail(connIP(c))
+		log.Printf("[TCP] %s -> ERR: claim rejected: %v", remoteAddr, err)
+		auditAuthFailure(c, "receiver", "invalid-claim", "")
+		SendErrorResponse(c, "invalid-claim")
+		c.Close()
+		return
+	}
+	// The code strength was fixed when the invite was pre-minted; receivers
+	// that know about claims also understand code_words in hello_ok
+	log.Printf("[CLAIM] %s -> receiver claimed invite: code=%s rid=%s label=%q", remoteAddr, inv.Code, inv.RID, inv.Label)
+	auditInvite(AuditInviteClaimed, inv, remoteAddr, "")
+	attachReceiver(c, br, inv, msg)
+}
+
+// attachReceiver answers a receiver hello with hello_ok for inv and parks the
+// connection on the invite until a sender arrives
+func attachReceiver(c net.Conn, br *bufio.Reader, inv *Invite, msg *EndpointMessage) {
+	relayVersion, caps := negotiate(msg)
+	log.Printf("[HELLO] receiver connected: fp=%s code=%s rid=%s words=%d version=%q caps=%v expires=%s", msg.ReceiverFP, inv.Code, inv.RID, inv.CodeWords, msg.Version, caps, inv.ExpiresAt().Format(time.RFC3339))
+	// Reply with hello_ok
+	helloOK := HelloOKResponse{Msg: "hello_ok", Code: inv.Code, RID: inv.RID, Exp: inv.ExpiresAt().Unix(), CodeWords: inv.CodeWords, Version: relayVersion, Caps: caps}
+	pingInterval := currentPolicy().pingInterval
+	if !framing.HasCap(caps, framing.CapPing) {
+		pingInterval = 0
+	}
+	if pingInterval > 0 {
+		helloOK.PingInterval = max(1, int(pingInterval.Seconds()))
+	}
+	_ = sendJSON(c, helloOK)
+	// A receiver that can describe itself does so in its await, which other
+	// receivers send unread in front of their SSH banner
+	if framing.HasCap(caps, framing.CapLabel) {
+		label, err := readAwaitLabel(c, br, inv.RID)
+		if err != nil {
+			log.Printf("[HELLO] receiver %s not attached to rid=%s: await: %v", c.RemoteAddr(), inv.RID, err)
+			c.Close()
+			return
+		}
+		inv.setReceiverLabel(label)
+	}
+	// Attach this connection as receiver
+	rc := newBufferedConn(c, br)
+	if err := inv.attachReceiver(rc, caps); err != nil {
+		// Revoked or expired between hello and now
+		log.Printf("[HELLO] receiver %s not attached to rid=%s: %v", c.RemoteAddr(), inv.RID, err)
+		rc.Close()
+		return
+	}
+	if IsDraining() {
+		// The drain started while this invite was minted and missed it
+		sendBye(rc, framing.ReasonRelayDraining, drainRetryAfter, caps)
+		rc.Close()
+		DeleteInvite(inv, "draining")
+		return
+	}
+	if pingInterval > 0 {
+		startPinger(inv, rc, caps, pingInterval)
+	}
+	// Now wait for sender as in receiver attachment
+}
+
+// awaitTimeout is how long a receiver that negotiated framing.CapLabel has to
+// send its await after hello_ok
+const awaitTimeout = 10 * time.Second
+
+var awaitSchemas = framing.Schemas{"await": endpointSchemas["await/receiver"]}
+
+// readAwaitLabel reads the await a receiver sends after hello_ok and returns
+// the label in it, cleaned up for display
+func readAwaitLabel(c net.Conn, br *bufio.Reader, rid string) (string, error) {
+	_ = c.SetReadDeadline(time.Now().Add(awaitTimeout))
+	defer c.SetReadDeadline(time.Time{})
+	_, line, err := awaitSchemas.ReadFrame(br)
+	if err != nil {
+		return "", err
+	}
+	var await EndpointMessage
+	if err := framing.DecodeStrict(line, &await); err != nil {
+		return "", err
+	}
+	if await.RID != rid {
+		return "", fmt.Errorf("rid %q, want %q", await.RID, rid)
+	}
+	return framing.CleanLabel(await.Label), nil
+}
+
 // handleReceiverConnection processes a receiver connection and waits for pairing
 func handleReceiverConnection(c net.Conn, rid string, br *bufio.Reader) {
 	inv, bufferedC := HandleReceiver(c, rid, br)
@@ -129,13 +331,14 @@ func handleReceiverConnection(c net.Conn, rid string, br *bufio.Reader) {
 	}
 	// Connection is now attached to invite and waiting for sender
 	// bufferedC is used to preserve any SSH banner data that was buffered
-	_ = bufferedC // stored in inv.ReceiverConn
+	_ = bufferedC // stored in the invite
 	// Timeout is handled by goroutine in HandleReceiver
 }
 
-// handleSenderConnection processes a sender connection and pairs with receiver
-func handleSenderConnection(c net.Conn, msg *EndpointMessage, br *bufio.Reader) {
-	inv := HandleSender(c, msg.Code, msg.Sender)
+// handleSenderConnection processes a sender connection and pairs with receiver;
+// tenant is the tenant of the sender's token, if any
+func handleSenderConnection(c net.Conn, msg *EndpointMessage, br *bufio.Reader, tenant string) {
+	inv, rc, receiverCaps := HandleSender(c, msg, tenant)
 	if inv == nil {
 		// Error already handled and connection closed by HandleSender
 		return
@@ -143,31 +346,48 @@ func handleSenderConnection(c net.Conn, msg *EndpointMessage, br *bufio.Reader)
 
 	// Pair sender with receiver
 	// Note: rc is already a bufferedConn that preserves any SSH banner data
-	rc := inv.ReceiverConn
 	rcAddr := rc.RemoteAddr().String()
 	senderAddr := c.RemoteAddr().String()
 
-	log.Printf("[PAIR] successfully paired: sender=%s receiver=%s code=%s rid=%s", senderAddr, rcAddr, inv.Code, inv.RID)
+	log.Printf("[PAIR] successfully paired: sender=%s receiver=%s code=%s rid=%s sender_version=%q", senderAddr, rcAddr, inv.Code, inv.RID, msg.Version)
+
+	// The sender's tenant pays for the session and sets its limits, else the receiver's
+	tenant = spliceTenant(inv, tenant)
+	limits := sessions.limits(tenant)
+
+	// Stop pinging the receiver before SSH takes over its connection
+	if p := inv.receiverPinger(); p != nil && !p.handOff() {
+		log.Printf("[PAIR] receiver %s stopped answering pings, closing: sender=%s code=%s", rcAddr, senderAddr, inv.Code)
+		rc.Close()
+		c.Close()
+		inviteClosed(inv, "unresponsive")
+		return
+	}
 
 	// Send "ready" message to receiver with sender address
 	alg := "" // TODO: extract from receiver connection if available
 	readyMsg := ReadyMessage{
 		Msg:         "ready",
 		SenderAddr:  senderAddr,
-		Fingerprint: inv.ReceiverFP,
-		Exp:         inv.ExpiresAt.Unix(),
+		Fingerprint: inv.ReceiverFP(),
+		Exp:         inv.ExpiresAt().Unix(),
 		Alg:         alg,
-		Sender:      inv.Sender,
+		Sender:      inv.Sender(),
+		Caps:        framing.Negotiate(receiverCaps, msg.Caps),
+	}
+	if framing.HasCap(receiverCaps, framing.CapSessionLimits) {
+		readyMsg.MaxDuration, readyMsg.IdleTimeout = limitSeconds(limits.MaxDuration), limitSeconds(limits.IdleTimeout)
 	}
 	if err := sendJSON(rc, readyMsg); err != nil {
 		log.Printf("[PAIR] failed to send ready to receiver: %v", err)
 		rc.Close()
 		c.Close()
+		inviteClosed(inv, "receiver-gone")
 		return
 	}
 
-	// Remove from maps to make it one-shot
-	DeleteInvite(inv, "paired")
+	// Taken off the store by HandleSender, so it is one-shot
+	inviteClosed(inv, "paired")
 
 	// Create splice record
 	spliceID := fmt.Sprintf("%d", time.Now().UnixNano())
@@ -175,15 +395,29 @@ func handleSenderConnection(c net.Conn, msg *EndpointMessage, br *bufio.Reader)
 		ID:           spliceID,
 		Code:         inv.Code,
 		RID:          inv.RID,
-		ReceiverFP:   inv.ReceiverFP,
+		ReceiverFP:   inv.ReceiverFP(),
 		SenderAddr:   senderAddr,
 		ReceiverAddr: rcAddr,
 		CreatedAt:    time.Now(),
+		Label:        inv.Label,
+		receiverConn: rc,
+		senderConn:   c,
+	}
+
+	if sender := inv.Sender(); sender != nil {
+		splice.SenderIdentity = sender.Identity
+	}
+	splice.Tenant = tenant
+	splice.limiter = shaper.limiter(splice.Tenant)
+	splice.Limits = limits
+	if limits.IdleTimeout > 0 {
+		splice.activity = framing.NewActivityMeter(splice.CreatedAt)
 	}
 
 	// Register splice
 	spliceMu.Lock()
 	splices[spliceID] = splice
+	auditSplice(AuditInvitePaired, splice)
 	spliceMu.Unlock()
 
 	// Call callback for new splice
@@ -196,102 +430,207 @@ func handleSenderConnection(c net.Conn, msg *EndpointMessage, br *bufio.Reader)
 	log.Printf("[SPLICE] connection closed: sender=%s receiver=%s", senderAddr, rcAddr)
 }
 
-// countingWriter wraps an io.Writer and updates splice counters atomically
-type countingWriter struct {
-	w      io.Writer
-	splice *Splice
-	isUp   bool // true for receiver->sender (up), false for sender->receiver (down)
-}
-
-func (cw *countingWriter) Write(p []byte) (int, error) {
-	n, err := cw.w.Write(p)
-	if n > 0 {
-		spliceMu.Lock()
-		if cw.isUp {
-			cw.splice.BytesUp += int64(n)
-		} else {
-			cw.splice.BytesDown += int64(n)
+// Run executes the relay command with the merged config/flag values
+// opts.Port is the TCP port number, listened on unless opts.Listen is set
+// opts.Listen lists the listen addresses, each with its roles, TLS and tokens
+// opts.ReceiverToken is an optional token that receivers must provide in hello messages
+// opts.SenderToken is an optional token that senders must provide in hello messages
+// opts.CodeWords is the minimum code strength handed out to receivers
+// opts.MinClientVersion rejects older receivers and senders with upgrade-required
+// opts.DrainTimeout bounds how long a drain waits for active splices
+// opts.HealthAddr serves the health endpoints when set, and the web dashboard
+// if opts.Dashboard is set (with revoke and kill if opts.DashboardToken is set)
+// opts.AdminSocket serves the admin API (relay ctl) when set
+// opts.APIAddr serves the invite HTTP API, authenticated with opts.APIToken
+// opts.Hooks are notified of invites and splices opening and closing
+// opts.AuditLog records invites, auth failures and splices as JSON lines
+// opts.SpliceRetention bounds how long closed splices are kept in memory
+// opts.Bandwidth and opts.Tenants cap the bandwidth of splices
+// opts.MaxSplice, opts.SpliceIdle and opts.Tenants limit how long splices stay open
+// opts.Abuse and opts.ACL protect the relay from floods and unwanted networks
+// opts.Reload re-reads the config on SIGHUP and when the config file changes
+func Run(opts RelayFlags) error {
+	log.Printf("Starting relay version %s", version.String())
+	if err := checkRelayFlags(opts); err != nil {
+		return err
+	}
+	if opts.MinClientVersion != "" {
+		log.Printf("Rejecting clients older than %s", opts.MinClientVersion)
+	}
+	if opts.APIAddr != "" && opts.APIToken == "" {
+		return fmt.Errorf("api-addr requires an api-token")
+	}
+	if opts.Dashboard && opts.HealthAddr == "" {
+		return fmt.Errorf("dashboard requires a health-addr to serve it on")
+	}
+	if opts.DashboardToken != "" && !opts.Dashboard {
+		return fmt.Errorf("dashboard-token requires dashboard")
+	}
+	if !opts.Hooks.Empty() {
+		hooks, err := newHookDispatcher(opts.Hooks)
+		if err != nil {
+			return err
 		}
-		spliceMu.Unlock()
+		// Flushed last, after the shutdown has closed the remaining invites
+		defer hooks.close()
+		SetEventCallbacks(hooks.callbacks())
+		log.Printf("Sending relay events to %d webhook(s) and %d exec hook(s)", len(opts.Hooks.Webhooks), len(opts.Hooks.Exec))
+	}
+	if opts.AuditLog != "" {
+		a, err := openAuditLog(opts.AuditLog, opts.AuditMaxSize, opts.AuditRotate)
+		if err != nil {
+			return err
+		}
+		audit = a
+		defer func() {
+			flushACLDenied() // refusals still waiting for the next flush
+			a.close()
+		}()
+		log.Printf("Writing audit log to %s", opts.AuditLog)
+	}
+	sh, err := newBandwidthShaper(opts.Bandwidth, opts.Tenants)
+	if err != nil {
+		return err
+	}
+	shaper = sh
+	if sh != nil {
+		log.Printf("Bandwidth caps: global %s, per splice %s, %d tenant(s)", orUnlimited(opts.Bandwidth.Global), orUnlimited(opts.Bandwidth.PerSplice), len(opts.Tenants))
+	}
+	sp, err := newSessionPolicy(opts.MaxSplice, opts.SpliceIdle, opts.Tenants)
+	if err != nil {
+		return err
+	}
+	sessions = sp
+	if sp != nil {
+		log.Printf("Session limits: %v, %d tenant(s)", sp.global, len(opts.Tenants))
+	}
+	g, err := newAbuseGuard(opts.Abuse)
+	if err != nil {
+		return err
+	}
+	guard = g
+	log.Printf("Abuse limits: %v", g)
+	acl, err := newAccessList(opts.ACL)
+	if err != nil {
+		return err
+	}
+	acls.Store(acl)
+	if acl != nil {
+		log.Printf("Access lists: %v", acl)
+	}
+	policy.Store(newConnPolicy(opts))
+	listeners, err := newListeners(opts.Listen, opts.Port)
+	if err != nil {
+		return err
+	}
+	// Receivers claim the invites the API mints, so its commands name a
+	// listener they may use
+	apiPort, apiTLS := receiverEndpoint(listeners)
+	if opts.APIAddr != "" && apiPort == 0 {
+		return fmt.Errorf("api-addr: no listener accepts receivers")
 	}
-	return n, err
-}
-
-func spliceConnections(receiver, sender net.Conn, splice *Splice) {
-	defer receiver.Close()
-	defer sender.Close()
-
-	done := make(chan struct{}, 2)
-	receiverAddr := receiver.RemoteAddr().String()
-	senderAddr := sender.RemoteAddr().String()
 
-	// receiver -> sender (upstream)
-	go func() {
-		upWriter := &countingWriter{w: sender, splice: splice, isUp: true}
-		io.Copy(upWriter, receiver)
-		done <- struct{}{}
-	}()
-	// sender -> receiver (downstream)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	drain := func() bool { return startDrain(ctx, opts.DrainTimeout, cancel) }
+
+	// SIGTERM drains so deployments don't cut off running sessions; SIGINT,
+	// or a second SIGTERM, stops right away. SIGHUP reloads the config
+	reloads := newReloader(opts)
+	go reloads.run(ctx)
+	if path := viper.ConfigFileUsed(); path != "" && opts.Reload != nil {
+		if err := watchConfigFile(ctx, path, reloads); err != nil {
+			log.Printf("[RELOAD] not watching %s for changes: %v", path, err)
+		}
+	}
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
 	go func() {
-		downWriter := &countingWriter{w: receiver, splice: splice, isUp: false}
-		io.Copy(downWriter, sender)
-		done <- struct{}{}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-sigCh:
+				if sig == syscall.SIGHUP {
+					reloads.request("SIGHUP")
+					continue
+				}
+				if sig == syscall.SIGTERM && !IsDraining() {
+					log.Printf("SIGTERM received, draining (send SIGINT or SIGTERM again to stop now)")
+					drain()
+					continue
+				}
+				log.Printf("%v received, stopping", sig)
+				cancel()
+				return
+			}
+		}
 	}()
 
-	<-done // wait for first direction
-	<-done // wait for second direction
-
-	// Mark splice as closed
-	now := time.Now()
-	spliceMu.Lock()
-	splice.ClosedAt = &now
-	spliceMu.Unlock()
-
-	// Get final counts for logging
-	spliceMu.RLock()
-	finalUp := splice.BytesUp
-	finalDown := splice.BytesDown
-	spliceMu.RUnlock()
-
-	log.Printf("[SPLICE] stats: %s <-> %s (%d bytes receiver->sender, %d bytes sender->receiver)",
-		receiverAddr, senderAddr, finalUp, finalDown)
-
-	// Call callback for closed splice
-	if callbacks != nil && callbacks.OnClosedSplice != nil {
-		callbacks.OnClosedSplice(splice)
+	StartInviteCleanupLoop(opts.SpliceRetention)
+	go rateSampler(ctx)
+	if sessions != nil {
+		go sessionLimiter(ctx)
 	}
-}
 
-// Run executes the relay command
-// port is the TCP port number; HTTP will be served on port+1
-// receiverToken is an optional token that receivers must provide in hello messages
-// senderToken is an optional token that senders must provide in hello messages
-func Run(port int, interactive bool, receiverToken string, senderToken string) error {
-	log.Printf("Starting relay version %s", version.String())
-	tcpAddr := fmt.Sprintf(":%d", port)
+	var wg sync.WaitGroup
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// Start a TCP server per listener
+	for _, l := range listeners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := tcpServe(ctx, l); err != nil {
+				log.Printf("TCP server error on %s: %v", l.addr, err)
+				cancel() // Signal shutdown on error
+			}
+		}()
+	}
 
-	StartInviteCleanupLoop()
+	if opts.AdminSocket != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := adminServe(ctx, opts.AdminSocket, drain); err != nil {
+				// The relay keeps working without it, just like without health endpoints
+				log.Printf("admin socket error: %v", err)
+			}
+		}()
+	}
 
-	var wg sync.WaitGroup
+	if opts.APIAddr != "" {
+		apiCfg := APIConfig{Token: opts.APIToken, PublicHost: opts.PublicHost, Port: apiPort, TLS: apiTLS, CodeWords: opts.CodeWords}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := apiServe(ctx, opts.APIAddr, apiCfg); err != nil {
+				log.Printf("invite API error: %v", err)
+				cancel()
+			}
+		}()
+	}
 
-	// Start TCP server
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if err := tcpServe(ctx, tcpAddr, receiverToken, senderToken); err != nil {
-			log.Printf("TCP server error: %v", err)
-			cancel() // Signal shutdown on error
-		}
-	}()
+	if opts.HealthAddr != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var dash *dashboardConfig
+			if opts.Dashboard {
+				dash = &dashboardConfig{Token: opts.DashboardToken}
+			}
+			if err := healthServe(ctx, opts.HealthAddr, drain, dash); err != nil {
+				log.Printf("health server error: %v", err)
+				cancel()
+			}
+		}()
+	}
 
 	var tuiDone <-chan struct{}
-	if interactive {
+	if opts.Interactive {
 		// Start TUI for interactive mode
 		var err error
-		tuiDone, err = startTUI(ctx, cancel)
+		tuiDone, err = startTUI(ctx, cancel, drain)
 		if err != nil {
 			return fmt.Errorf("failed to start TUI: %w", err)
 		}
@@ -305,7 +644,9 @@ func Run(port int, interactive bool, receiverToken string, senderToken string) e
 		<-tuiDone
 	}
 
-	// No HTTP server to shut down (HTTP mint removed)
+	// Tell waiting receivers why they are being dropped; active splices carry
+	// SSH end to end, so their endpoints only see the connection close
+	CloseAllInvites(framing.ReasonRelayShutdown, 0)
 
 	// Wait for TCP server to finish
 	wg.Wait()
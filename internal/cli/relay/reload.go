@@ -0,0 +1,298 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"ssh-portal/internal/version"
+)
+
+// Reloads: SIGHUP or a change to the config file re-reads the config and
+// swaps in the settings that only matter when a connection arrives (tokens,
+// access lists, abuse limits, code strength, invite TTLs and receiver pings). Waiting
+// receivers and active splices are left alone. Settings that would need the
+// relay to rebuild something (listeners and their tokens, hooks, bandwidth
+// caps, ...) are reported as needing a restart.
+
+// reloadDebounce lets editors finish writing before the file is read
+const reloadDebounce = 500 * time.Millisecond
+
+// connPolicy is what a new connection is checked against.
+type connPolicy struct {
+	receiverToken    string
+	senderToken      string
+	tenants          []TenantConfig
+	codeWords        int
+	minClientVersion string
+	inviteTTL        time.Duration
+	maxInviteTTL     time.Duration
+	pingInterval     time.Duration
+}
+
+var policy atomic.Pointer[connPolicy]
+
+func newConnPolicy(opts RelayFlags) *connPolicy {
+	return &connPolicy{
+		receiverToken:    opts.ReceiverToken,
+		senderToken:      opts.SenderToken,
+		tenants:          opts.Tenants,
+		codeWords:        opts.CodeWords,
+		minClientVersion: opts.MinClientVersion,
+		inviteTTL:        opts.InviteTTL,
+		maxInviteTTL:     opts.MaxInviteTTL,
+		pingInterval:     opts.PingInterval,
+	}
+}
+
+// currentPolicy returns the policy for a new connection; the zero policy
+// before Run stored one.
+func currentPolicy() *connPolicy {
+	if p := policy.Load(); p != nil {
+		return p
+	}
+	return &connPolicy{inviteTTL: 10 * time.Minute, maxInviteTTL: time.Hour, pingInterval: 15 * time.Second}
+}
+
+// checkRelayFlags validates the settings a reload may change.
+func checkRelayFlags(opts RelayFlags) error {
+	if opts.MinClientVersion != "" {
+		if _, ok := version.Semver(opts.MinClientVersion); !ok {
+			return fmt.Errorf("invalid min-client-version %q (want MAJOR.MINOR.PATCH)", opts.MinClientVersion)
+		}
+	}
+	for _, t := range opts.Tenants {
+		if t.Name == "" || t.Token == "" {
+			return fmt.Errorf("every tenant needs a name and a token")
+		}
+	}
+	return nil
+}
+
+// applyReload validates next and swaps in its connection settings. Nothing
+// changes if any of them is invalid.
+func applyReload(next RelayFlags) error {
+	if err := checkRelayFlags(next); err != nil {
+		return err
+	}
+	acl, err := newAccessList(next.ACL)
+	if err != nil {
+		return err
+	}
+	g, err := newAbuseGuard(next.Abuse)
+	if err != nil {
+		return err
+	}
+	guard.reconfigure(g)
+	acls.Store(acl)
+	policy.Store(newConnPolicy(next))
+	return nil
+}
+
+// settingChange is one setting compared across a reload
+type settingChange struct {
+	name     string
+	old, new any
+	secret   bool // don't log the values
+}
+
+func (c settingChange) changed() bool {
+	return !reflect.DeepEqual(c.old, c.new)
+}
+
+func (c settingChange) String() string {
+	switch c.old.(type) {
+	case string, int, int64, bool, time.Duration:
+		if !c.secret {
+			return fmt.Sprintf("%s %v -> %v", c.name, orNone(c.old), orNone(c.new))
+		}
+	}
+	return c.name + " changed"
+}
+
+func orNone(v any) any {
+	if v == "" {
+		return `""`
+	}
+	return v
+}
+
+// diffRelayFlags lists the settings that differ between old and next: those
+// applied by a reload, and those that need a restart.
+func diffRelayFlags(old, next RelayFlags) (applied, restart []string) {
+	// Tenant caps and limits are built into the bandwidth shaper and session
+	// policy at startup; only the tokens take effect on reload
+	limitsOf := func(tenants []TenantConfig) []TenantConfig {
+		limits := make([]TenantConfig, len(tenants))
+		for i, t := range tenants {
+			limits[i] = t
+			limits[i].Token = ""
+		}
+		return limits
+	}
+	live := []settingChange{
+		{"receiver-token", old.ReceiverToken, next.ReceiverToken, true},
+		{"sender-token", old.SenderToken, next.SenderToken, true},
+		{"tenants", old.Tenants, next.Tenants, true},
+		{"code-words", old.CodeWords, next.CodeWords, false},
+		{"min-client-version", old.MinClientVersion, next.MinClientVersion, false},
+		{"invite-ttl", old.InviteTTL, next.InviteTTL, false},
+		{"max-invite-ttl", old.MaxInviteTTL, next.MaxInviteTTL, false},
+		{"ping-interval", old.PingInterval, next.PingInterval, false},
+		{"abuse limits", old.Abuse, next.Abuse, false},
+		{"acl", old.ACL, next.ACL, false},
+	}
+	fixed := []settingChange{
+		{"port", old.Port, next.Port, false},
+		{"listen", old.Listen, next.Listen, true},
+		{"interactive", old.Interactive, next.Interactive, false},
+		{"drain-timeout", old.DrainTimeout, next.DrainTimeout, false},
+		{"health-addr", old.HealthAddr, next.HealthAddr, false},
+		{"dashboard", old.Dashboard, next.Dashboard, false},
+		{"dashboard-token", old.DashboardToken, next.DashboardToken, true},
+		{"admin-socket", old.AdminSocket, next.AdminSocket, false},
+		{"api-addr", old.APIAddr, next.APIAddr, false},
+		{"api-token", old.APIToken, next.APIToken, true},
+		{"public-host", old.PublicHost, next.PublicHost, false},
+		{"hooks", old.Hooks, next.Hooks, false},
+		{"audit-log", old.AuditLog, next.AuditLog, false},
+		{"audit-max-size", old.AuditMaxSize, next.AuditMaxSize, false},
+		{"audit-rotate", old.AuditRotate, next.AuditRotate, false},
+		{"splice-retention", old.SpliceRetention, next.SpliceRetention, false},
+		{"bandwidth", old.Bandwidth, next.Bandwidth, false},
+		{"max-splice-duration", old.MaxSplice, next.MaxSplice, false},
+		{"splice-idle-timeout", old.SpliceIdle, next.SpliceIdle, false},
+		{"tenant caps and limits", limitsOf(old.Tenants), limitsOf(next.Tenants), false},
+	}
+	for _, c := range live {
+		if c.changed() {
+			applied = append(applied, c.String())
+		}
+	}
+	for _, c := range fixed {
+		if c.changed() {
+			restart = append(restart, c.String())
+		}
+	}
+	return applied, restart
+}
+
+// reloader applies reload requests one at a time.
+type reloader struct {
+	current  RelayFlags
+	requests chan string // what asked for the reload
+}
+
+func newReloader(opts RelayFlags) *reloader {
+	return &reloader{current: opts, requests: make(chan string, 1)}
+}
+
+// request asks for a reload; requests arriving while one is pending are merged
+func (r *reloader) request(why string) {
+	select {
+	case r.requests <- why:
+	default:
+	}
+}
+
+// run serves reload requests until ctx is cancelled
+func (r *reloader) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case why := <-r.requests:
+			r.reload(why)
+		}
+	}
+}
+
+func (r *reloader) reload(why string) {
+	if r.current.Reload == nil {
+		log.Printf("[RELOAD] %s: this relay cannot reload its config", why)
+		return
+	}
+	next, err := r.current.Reload()
+	if err == nil {
+		err = applyReload(next)
+	}
+	if err != nil {
+		log.Printf("[RELOAD] %s: reload failed, keeping the current config: %v", why, err)
+		return
+	}
+	applied, restart := diffRelayFlags(r.current, next)
+	next.Reload = r.current.Reload
+	r.current = next
+	switch {
+	case len(applied) == 0 && len(restart) == 0:
+		log.Printf("[RELOAD] %s: config reloaded, no changes", why)
+	case len(applied) == 0:
+		log.Printf("[RELOAD] %s: config reloaded, nothing applied", why)
+	default:
+		log.Printf("[RELOAD] %s: config reloaded, applied to new connections: %s", why, strings.Join(applied, ", "))
+	}
+	if len(restart) > 0 {
+		log.Printf("[RELOAD] changed but only applied after a restart: %s", strings.Join(restart, ", "))
+	}
+}
+
+// watchConfigFile requests a reload whenever path changes, until ctx is
+// cancelled. It watches the directory, so files replaced by editors or
+// updated through symlinks (Kubernetes config maps) are seen too.
+func watchConfigFile(ctx context.Context, path string, r *reloader) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	path = filepath.Clean(path)
+	if err := w.Add(filepath.Dir(path)); err != nil {
+		w.Close()
+		return err
+	}
+	go func() {
+		defer w.Close()
+		target, _ := filepath.EvalSymlinks(path)
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				// The file itself, or the target of its symlink, was written or replaced
+				current, _ := filepath.EvalSymlinks(path)
+				if filepath.Clean(ev.Name) != path && current == target {
+					continue
+				}
+				if !ev.Has(fsnotify.Write) && !ev.Has(fsnotify.Create) && !ev.Has(fsnotify.Rename) && !ev.Has(fsnotify.Remove) {
+					continue
+				}
+				target = current
+				debounce = time.After(reloadDebounce)
+			case <-debounce:
+				debounce = nil
+				if _, err := os.Stat(path); err != nil {
+					// Mid-replace; the create that follows triggers again
+					continue
+				}
+				r.request("config file changed")
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("[RELOAD] config file watch error: %v", err)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,188 @@
+package relay
+
+import (
+	"io"
+	"log"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Splices copy through pooled buffers when they have to look at every chunk
+// (bandwidth caps, idle timeouts, TLS). Otherwise, on Linux, TCP to TCP
+// splices hand the bytes to splice(2) through TCPConn.ReadFrom and they never
+// reach user space.
+const (
+	spliceBufSize = 32 << 10
+	// spliceStep is how much a zero-copy direction moves per ReadFrom; its
+	// byte counters advance in steps of at most this much
+	spliceStep = 64 << 10
+)
+
+var spliceBufs = sync.Pool{New: func() any {
+	b := make([]byte, spliceBufSize)
+	return &b
+}}
+
+// spliceDir is one direction of a splice.
+type spliceDir struct {
+	dst, src net.Conn
+	splice   *Splice
+	up       bool // receiver->sender, else sender->receiver
+}
+
+// relay copies src to dst until src ends. A nil error means src finished
+// sending (EOF).
+func (d *spliceDir) relay() error {
+	src, dst := d.src, rawConn(d.dst)
+	if bc, ok := src.(*bufferedConn); ok {
+		// What was read ahead with the handshake (the SSH banner) goes first,
+		// then the connection is read directly
+		if n := bc.br.Buffered(); n > 0 {
+			b, _ := bc.br.Peek(n)
+			if err := d.write(dst, b); err != nil {
+				return err
+			}
+			_, _ = bc.br.Discard(n)
+		}
+		src = bc.Conn
+	}
+	srcTCP, srcOK := src.(*net.TCPConn)
+	dstTCP, dstOK := dst.(*net.TCPConn)
+	if srcOK && dstOK && d.splice.limiter == nil && d.splice.activity == nil && runtime.GOOS == "linux" {
+		return d.spliceTCP(dstTCP, srcTCP)
+	}
+	return d.copy(dst, src)
+}
+
+// spliceTCP moves the bytes in the kernel
+func (d *spliceDir) spliceTCP(dst, src *net.TCPConn) error {
+	lr := &io.LimitedReader{R: src}
+	for {
+		lr.N = spliceStep
+		n, err := dst.ReadFrom(lr)
+		d.count(int(n))
+		if err != nil {
+			return err
+		}
+		if lr.N > 0 {
+			return nil // src ended before the step was full
+		}
+	}
+}
+
+// copy moves the bytes through a pooled buffer
+func (d *spliceDir) copy(dst, src net.Conn) error {
+	bp := spliceBufs.Get().(*[]byte)
+	defer spliceBufs.Put(bp)
+	for {
+		n, err := src.Read(*bp)
+		if n > 0 {
+			if werr := d.write(dst, (*bp)[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// write sends p to dst, held back to the splice's bandwidth caps
+func (d *spliceDir) write(dst net.Conn, p []byte) error {
+	l := d.splice.limiter
+	for len(p) > 0 {
+		chunk := p
+		if l != nil {
+			// Chunk by chunk, so splices sharing a cap take turns
+			chunk = p[:min(len(p), l.chunk)]
+			l.wait(len(chunk))
+		}
+		n, err := dst.Write(chunk)
+		d.count(n)
+		if err != nil {
+			return err
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+func (d *spliceDir) count(n int) {
+	if n <= 0 {
+		return
+	}
+	if d.up {
+		d.splice.bytesUp.Add(int64(n))
+		relayedBytesUp.Add(int64(n))
+	} else {
+		d.splice.bytesDown.Add(int64(n))
+		relayedBytesDown.Add(int64(n))
+	}
+	if a := d.splice.activity; a != nil {
+		a.Add(n, time.Now())
+	}
+}
+
+// rawConn returns the connection under a bufferedConn, which writes to it
+// unchanged
+func rawConn(c net.Conn) net.Conn {
+	if bc, ok := c.(*bufferedConn); ok {
+		return bc.Conn
+	}
+	return c
+}
+
+// closeWrite half-closes c; false if it can't be
+func closeWrite(c net.Conn) bool {
+	hc, ok := rawConn(c).(interface{ CloseWrite() error })
+	return ok && hc.CloseWrite() == nil
+}
+
+func spliceConnections(receiver, sender net.Conn, splice *Splice) {
+	defer receiver.Close()
+	defer sender.Close()
+
+	done := make(chan struct{}, 2)
+	receiverAddr := receiver.RemoteAddr().String()
+	senderAddr := sender.RemoteAddr().String()
+
+	relay := func(d *spliceDir) {
+		// An endpoint that finished sending may still be reading: pass the
+		// half-close on and leave the other direction running. On errors, or
+		// if it can't be passed on, close both so the other endpoint sees it
+		// right away instead of waiting for its keepalive timeout.
+		if err := d.relay(); err != nil || !closeWrite(d.dst) {
+			receiver.Close()
+			sender.Close()
+		}
+		done <- struct{}{}
+	}
+	go relay(&spliceDir{dst: sender, src: receiver, splice: splice, up: true})
+	go relay(&spliceDir{dst: receiver, src: sender, splice: splice})
+	<-done
+	<-done
+
+	// Mark splice as closed
+	now := time.Now()
+	spliceMu.Lock()
+	splice.ClosedAt = &now
+	spliceMu.Unlock()
+
+	finalUp, finalDown := splice.BytesUp(), splice.BytesDown()
+	spliceMu.RLock()
+	auditSplice(AuditSpliceClosed, splice)
+	spliceMu.RUnlock()
+
+	log.Printf("[SPLICE] stats: %s <-> %s (%d bytes receiver->sender, %d bytes sender->receiver)",
+		receiverAddr, senderAddr, finalUp, finalDown)
+
+	// Call callback for closed splice
+	if callbacks != nil && callbacks.OnClosedSplice != nil {
+		callbacks.OnClosedSplice(splice)
+	}
+}
@@ -0,0 +1,183 @@
+package relay
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ssh-portal/internal/cli/framing"
+)
+
+// copiedConn hides the *net.TCPConn under it, so splices copy through user
+// space as they do for TLS or capped connections
+type copiedConn struct{ *net.TCPConn }
+
+// splicePair is a spliced session seen from its two endpoints
+type splicePair struct {
+	receiver, sender *net.TCPConn
+	done             chan struct{}
+}
+
+// newSplicePairs starts n splices over loopback TCP, copying through user
+// space if copied is set, and tears them down when tb ends.
+func newSplicePairs(tb testing.TB, n int, copied bool) []*splicePair {
+	tb.Helper()
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer ln.Close()
+	connect := func() (endpoint, relaySide net.Conn) {
+		endpoint, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			tb.Fatal(err)
+		}
+		relaySide, err = ln.Accept()
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if copied {
+			relaySide = copiedConn{relaySide.(*net.TCPConn)}
+		}
+		return endpoint, relaySide
+	}
+	pairs := make([]*splicePair, n)
+	for i := range pairs {
+		receiver, rc := connect()
+		sender, sc := connect()
+		p := &splicePair{receiver: receiver.(*net.TCPConn), sender: sender.(*net.TCPConn), done: make(chan struct{})}
+		go func() {
+			spliceConnections(rc, sc, &Splice{ID: fmt.Sprint(i)})
+			close(p.done)
+		}()
+		pairs[i] = p
+	}
+	tb.Cleanup(func() {
+		for _, p := range pairs {
+			p.receiver.Close()
+			p.sender.Close()
+			<-p.done
+		}
+		log.SetOutput(out)
+	})
+	return pairs
+}
+
+func TestSpliceHalfClose(t *testing.T) {
+	for _, copied := range []bool{false, true} {
+		p := newSplicePairs(t, 1, copied)[0]
+		// The sender is done sending but still waits for the answer
+		if _, err := p.sender.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.sender.CloseWrite(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(p.receiver)
+		if err != nil || string(got) != "ping" {
+			t.Fatalf("copied=%v: receiver got %q, %v; want ping and EOF", copied, got, err)
+		}
+		if _, err := p.receiver.Write([]byte("pong")); err != nil {
+			t.Fatal(err)
+		}
+		p.receiver.Close()
+		got, err = io.ReadAll(p.sender)
+		if err != nil || string(got) != "pong" {
+			t.Fatalf("copied=%v: sender got %q, %v; want pong and EOF", copied, got, err)
+		}
+		<-p.done
+	}
+}
+
+func TestSpliceIdleTimeout(t *testing.T) {
+	tests := []struct {
+		name        string
+		every       time.Duration
+		bytes       int
+		wantExpired bool
+	}{
+		// A technician typing slowly: a keystroke and its echo every 5s
+		{"slow typing", 5 * time.Second, 2 * 80, false},
+		// An SSH keepalive and its reply every 15s
+		{"keepalives", 15 * time.Second, 2 * 100, true},
+		{"silent", 0, 0, true},
+	}
+	start := time.Unix(1_700_000_000, 0)
+	limits := framing.SessionLimits{IdleTimeout: 5 * time.Minute}
+	for _, tt := range tests {
+		s := &Splice{ID: "idle-" + tt.name, CreatedAt: start, Limits: limits, activity: framing.NewActivityMeter(start)}
+		spliceMu.Lock()
+		splices[s.ID] = s
+		spliceMu.Unlock()
+		expired := false
+		for now := start; now.Before(start.Add(30 * time.Minute)); now = now.Add(time.Second) {
+			if tt.every > 0 && now.Sub(start)%tt.every == 0 {
+				s.activity.Add(tt.bytes, now)
+			}
+			if _, ok := expiredSplices(now)[s]; ok {
+				expired = true
+				break
+			}
+		}
+		spliceMu.Lock()
+		delete(splices, s.ID)
+		spliceMu.Unlock()
+		if expired != tt.wantExpired {
+			t.Errorf("%s: expired = %v, want %v", tt.name, expired, tt.wantExpired)
+		}
+	}
+}
+
+// Sessions pushing data from sender to receiver at full speed, through the
+// kernel and through user space
+func BenchmarkSplice(b *testing.B) {
+	for _, n := range []int{1, 100, 1000} {
+		for _, copied := range []bool{false, true} {
+			path := "zero-copy"
+			if copied {
+				path = "copy"
+			}
+			b.Run(fmt.Sprintf("splices=%d/%s", n, path), func(b *testing.B) {
+				benchmarkSplice(b, n, copied)
+			})
+		}
+	}
+}
+
+func benchmarkSplice(b *testing.B, n int, copied bool) {
+	pairs := newSplicePairs(b, n, copied)
+	chunk := make([]byte, spliceBufSize)
+	var left atomic.Int64
+	left.Store(int64(b.N))
+	b.SetBytes(int64(len(chunk)))
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for _, p := range pairs {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for left.Add(-1) >= 0 {
+				if _, err := p.sender.Write(chunk); err != nil {
+					b.Error(err)
+					break
+				}
+			}
+			p.sender.CloseWrite()
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := io.Copy(io.Discard, p.receiver); err != nil {
+				b.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	b.StopTimer()
+}
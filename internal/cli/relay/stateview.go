@@ -1,67 +1,232 @@
 package relay
 
 import (
+	"cmp"
 	"fmt"
+	"slices"
 	"sort"
+	"strings"
 	"time"
 
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/table"
 	"github.com/charmbracelet/lipgloss"
+
+	"ssh-portal/internal/cli/tui"
 )
 
-// NewInvitesTable creates and returns a table.Model configured for invites
-func NewInvitesTable(width, height int) table.Model {
-	if width < 20 {
-		width = 20
+// sortedInvites returns the outstanding invites in table order (earliest
+// expiration first), so a table cursor indexes into it
+func sortedInvites() []*Invite {
+	invites := GetOutstandingInvites()
+	expires := make(map[*Invite]time.Time, len(invites))
+	for _, inv := range invites {
+		expires[inv] = inv.ExpiresAt()
 	}
-	if height < 3 {
-		height = 3
+	sort.Slice(invites, func(i, j int) bool {
+		return expires[invites[i]].Before(expires[invites[j]])
+	})
+	return invites
+}
+
+// sortedActiveSplices returns the active splices in table order (by code)
+func sortedActiveSplices() []*Splice {
+	splices := GetActiveSplices()
+	sort.Slice(splices, func(i, j int) bool {
+		return splices[i].Code < splices[j].Code
+	})
+	return splices
+}
+
+// tableSort is the order of a table's rows: by one of its columns, or in the
+// table's default order when Column is -1
+type tableSort struct {
+	Column int
+	Desc   bool
+}
+
+// apply turns an ascending comparison into one in the order of by
+func (by tableSort) apply(c int) int {
+	if by.Desc {
+		return -c
 	}
-	availableWidth := width - 4
-	// Four columns: Code, RID, Receiver Addr, Expires
-	colWidth := availableWidth / 4
+	return c
+}
 
-	columns := []table.Column{
-		{Title: "Code", Width: colWidth},
-		{Title: "RID", Width: colWidth},
-		{Title: "Receiver Addr", Width: colWidth},
-		{Title: "Expires", Width: colWidth},
+// title returns a column title, marked if the table is sorted by it
+func (by tableSort) title(title string, col int) string {
+	switch {
+	case by.Column != col:
+		return title
+	case by.Desc:
+		return title + " ▼"
+	default:
+		return title + " ▲"
 	}
+}
 
-	rows := []table.Row{}
-	invites := GetOutstandingInvites()
-	// Sort invites by expiration (earliest first)
-	sort.Slice(invites, func(i, j int) bool {
-		return invites[i].ExpiresAt.Before(invites[j].ExpiresAt)
-	})
-	for _, inv := range invites {
-		expiresIn := time.Until(inv.ExpiresAt)
-		expiresStr := expiresIn.Round(time.Second).String()
-		if len(expiresStr) > 12 {
-			expiresStr = expiresStr[:12]
+// next returns the order that sorts by the column after by's, wrapping around
+// to the default order after the last of the table's columns
+func (by tableSort) next(columns int) tableSort {
+	if by.Column+1 >= columns {
+		return tableSort{Column: -1}
+	}
+	return tableSort{Column: by.Column + 1, Desc: by.Desc}
+}
+
+// sortRows sorts rows by column in the order of by, keeping their current
+// (default) order for ties
+func sortRows[T any](rows []T, by tableSort, compare func(a, b T, column int) int) {
+	if by.Column < 0 {
+		if by.Desc {
+			slices.Reverse(rows)
 		}
+		return
+	}
+	slices.SortStableFunc(rows, func(a, b T) int {
+		return by.apply(compare(a, b, by.Column))
+	})
+}
 
-		code := inv.Code
-		if len(code) > colWidth {
-			code = code[:colWidth]
+// matchesFilter reports whether any of fields contains filter, ignoring case
+func matchesFilter(filter string, fields ...string) bool {
+	if filter == "" {
+		return true
+	}
+	filter = strings.ToLower(filter)
+	return slices.ContainsFunc(fields, func(f string) bool {
+		return strings.Contains(strings.ToLower(f), filter)
+	})
+}
+
+// Columns of the invites, splices and throttle tables
+const (
+	invitesColumns = 5
+	splicesColumns = 7
+	blocksColumns  = 5
+)
+
+// viewInvites returns the outstanding invites matching filter, in the order
+// of by
+func viewInvites(filter string, by tableSort) []*Invite {
+	invites := slices.DeleteFunc(sortedInvites(), func(inv *Invite) bool {
+		identity := ""
+		if sender := inv.Sender(); sender != nil {
+			identity = sender.Identity
 		}
-		rid := inv.RID
-		if len(rid) > colWidth {
-			rid = rid[:colWidth]
+		return !matchesFilter(filter, inv.Code, inv.RID, inv.Label, inv.ReceiverLabel(), inviteReceiverAddr(inv), inv.ReceiverFP(), inv.Tenant(), identity)
+	})
+	sortRows(invites, by, func(a, b *Invite, column int) int {
+		switch column {
+		case 0:
+			return strings.Compare(a.Code, b.Code)
+		case 1:
+			return strings.Compare(inviteLabel(a), inviteLabel(b))
+		case 2:
+			return strings.Compare(a.RID, b.RID)
+		case 3:
+			return strings.Compare(inviteReceiverAddr(a), inviteReceiverAddr(b))
+		default:
+			return a.ExpiresAt().Compare(b.ExpiresAt())
 		}
+	})
+	return invites
+}
 
-		// Get receiver address if receiver has connected
-		receiverAddr := "-"
-		if inv.ReceiverConn != nil {
-			receiverAddr = inv.ReceiverConn.RemoteAddr().String()
-			if len(receiverAddr) > colWidth {
-				receiverAddr = receiverAddr[:colWidth]
+// viewSplices returns the active splices matching filter, in the order of by
+func viewSplices(filter string, by tableSort) []*Splice {
+	splices := slices.DeleteFunc(sortedActiveSplices(), func(s *Splice) bool {
+		return !matchesFilter(filter, s.Code, s.ID, s.RID, s.Label, s.SenderAddr, s.ReceiverAddr, s.SenderIdentity, s.ReceiverFP, s.Tenant)
+	})
+	// Rates change with every sample
+	spliceMu.RLock()
+	defer spliceMu.RUnlock()
+	sortRows(splices, by, func(a, b *Splice, column int) int {
+		switch column {
+		case 0:
+			return strings.Compare(a.Code, b.Code)
+		case 1:
+			return cmp.Compare(a.BytesUp(), b.BytesUp())
+		case 2:
+			return cmp.Compare(a.BytesDown(), b.BytesDown())
+		case 3:
+			return cmp.Compare(a.Rate, b.Rate)
+		case 4:
+			return cmp.Compare(meanRate(a.rateHistory), meanRate(b.rateHistory))
+		case 5:
+			return strings.Compare(a.SenderAddr, b.SenderAddr)
+		default:
+			return strings.Compare(a.ReceiverAddr, b.ReceiverAddr)
+		}
+	})
+	return splices
+}
+
+// viewBlocks returns the throttled and banned addresses matching filter, in
+// the order of by
+func viewBlocks(filter string, by tableSort) []ThrottledIP {
+	blocks := slices.DeleteFunc(GetThrottledIPs(), func(t ThrottledIP) bool {
+		return !matchesFilter(filter, t.IP, t.Limit)
+	})
+	sortRows(blocks, by, func(a, b ThrottledIP, column int) int {
+		switch column {
+		case 0:
+			return strings.Compare(a.IP, b.IP)
+		case 1:
+			return strings.Compare(a.Limit, b.Limit)
+		case 2:
+			return cmp.Compare(a.Refused, b.Refused)
+		case 3:
+			return a.LastRefused.Compare(b.LastRefused)
+		default:
+			var until [2]time.Time
+			for i, t := range []ThrottledIP{a, b} {
+				if t.BannedUntil != nil {
+					until[i] = *t.BannedUntil
+				}
 			}
+			return until[0].Compare(until[1])
 		}
+	})
+	return blocks
+}
 
-		rows = append(rows, table.Row{code, rid, receiverAddr, expiresStr})
+// meanRate returns the mean of rate samples
+func meanRate(samples []float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, r := range samples {
+		sum += r
 	}
+	return sum / float64(len(samples))
+}
 
+// inviteLabel returns the label of an invite, or what its receiver says it is
+func inviteLabel(inv *Invite) string {
+	return cmp.Or(inv.Label, inv.ReceiverLabel())
+}
+
+// inviteReceiverAddr describes where the receiver of an invite is
+func inviteReceiverAddr(inv *Invite) string {
+	if rc := inv.ReceiverConn(); rc != nil {
+		addr := rc.RemoteAddr().String()
+		// A trailing ! marks receivers that stopped answering pings
+		if inv.Unresponsive() {
+			addr += "!"
+		}
+		return addr
+	}
+	if !inv.Claimed() {
+		return "unclaimed"
+	}
+	return "-"
+}
+
+// newStateTable creates a table with the relay TUI's styles
+func newStateTable(columns []table.Column, rows []table.Row, width, height int) table.Model {
 	t := table.New(
 		table.WithColumns(columns),
 		table.WithRows(rows),
@@ -86,207 +251,191 @@ func NewInvitesTable(width, height int) table.Model {
 	return t
 }
 
-// UpdateInvitesTable updates the table with current invites data
-func UpdateInvitesTable(t table.Model, width, height int) table.Model {
-	if width < 20 {
-		width = 20
-	}
-	if height < 3 {
-		height = 3
+// updateStateTable replaces the columns and rows of a table
+func updateStateTable(t table.Model, columns []table.Column, rows []table.Row, width, height int) table.Model {
+	t.SetColumns(columns)
+	t.SetRows(rows)
+	t.SetWidth(width)
+	t.SetHeight(height)
+	// Keep the cursor on a row when rows went away
+	t.SetCursor(t.Cursor())
+
+	return t
+}
+
+// clipCells cuts the cells of a row to the column width
+func clipCells(row table.Row, colWidth int) table.Row {
+	for i, cell := range row {
+		if len(cell) > colWidth {
+			row[i] = cell[:colWidth]
+		}
 	}
+	return row
+}
+
+// invitesTableColumns returns the columns of the invites table, sorted by
+// by, and the rows of invites
+func invitesTableColumns(width int, invites []*Invite, by tableSort) ([]table.Column, []table.Row) {
 	availableWidth := width - 4
-	colWidth := availableWidth / 4
+	// Five columns: Code, Label, RID, Receiver Addr, Expires
+	colWidth := availableWidth / invitesColumns
 
 	columns := []table.Column{
-		{Title: "Code", Width: colWidth},
-		{Title: "RID", Width: colWidth},
-		{Title: "Receiver Addr", Width: colWidth},
-		{Title: "Expires", Width: colWidth},
+		{Title: by.title("Code", 0), Width: colWidth},
+		{Title: by.title("Label", 1), Width: colWidth},
+		{Title: by.title("RID", 2), Width: colWidth},
+		{Title: by.title("Receiver Addr", 3), Width: colWidth},
+		{Title: by.title("Expires", 4), Width: colWidth},
 	}
 
 	rows := []table.Row{}
-	invites := GetOutstandingInvites()
-	// Sort invites by expiration (earliest first)
-	sort.Slice(invites, func(i, j int) bool {
-		return invites[i].ExpiresAt.Before(invites[j].ExpiresAt)
-	})
 	for _, inv := range invites {
-		expiresIn := time.Until(inv.ExpiresAt)
+		expiresIn := time.Until(inv.ExpiresAt())
 		expiresStr := expiresIn.Round(time.Second).String()
 		if len(expiresStr) > 12 {
 			expiresStr = expiresStr[:12]
 		}
+		label := cmp.Or(inviteLabel(inv), "-")
 
-		code := inv.Code
-		if len(code) > colWidth {
-			code = code[:colWidth]
-		}
-		rid := inv.RID
-		if len(rid) > colWidth {
-			rid = rid[:colWidth]
-		}
-
-		// Get receiver address if receiver has connected
-		receiverAddr := "-"
-		if inv.ReceiverConn != nil {
-			receiverAddr = inv.ReceiverConn.RemoteAddr().String()
-			if len(receiverAddr) > colWidth {
-				receiverAddr = receiverAddr[:colWidth]
-			}
-		}
-
-		rows = append(rows, table.Row{code, rid, receiverAddr, expiresStr})
+		rows = append(rows, clipCells(table.Row{inv.Code, label, inv.RID, inviteReceiverAddr(inv), expiresStr}, colWidth))
 	}
+	return columns, rows
+}
 
-	t.SetColumns(columns)
-	t.SetRows(rows)
-	t.SetWidth(width)
-	t.SetHeight(height)
-
-	return t
+// NewInvitesTable creates and returns a table.Model configured for invites
+func NewInvitesTable(width, height int, invites []*Invite, by tableSort) table.Model {
+	if width < 20 {
+		width = 20
+	}
+	if height < 3 {
+		height = 3
+	}
+	columns, rows := invitesTableColumns(width, invites, by)
+	return newStateTable(columns, rows, width, height)
 }
 
-// NewSplicesTable creates and returns a table.Model configured for splices
-func NewSplicesTable(width, height int) table.Model {
+// UpdateInvitesTable updates the table with current invites data
+func UpdateInvitesTable(t table.Model, width, height int, invites []*Invite, by tableSort) table.Model {
 	if width < 20 {
 		width = 20
 	}
 	if height < 3 {
 		height = 3
 	}
+	columns, rows := invitesTableColumns(width, invites, by)
+	return updateStateTable(t, columns, rows, width, height)
+}
+
+// splicesTableColumns returns the columns of the splices table, sorted by
+// by, and the rows of splices
+func splicesTableColumns(width int, splices []*Splice, by tableSort) ([]table.Column, []table.Row) {
 	availableWidth := width - 4
-	// Five columns: Code, Up, Down, Sender Addr, Receiver Addr
-	colWidth := availableWidth / 5
+	// Seven columns: Code, Up, Down, Rate, Throughput, Sender Addr, Receiver Addr
+	colWidth := availableWidth / splicesColumns
 
 	columns := []table.Column{
-		{Title: "Code", Width: colWidth},
-		{Title: "Up", Width: colWidth},
-		{Title: "Down", Width: colWidth},
-		{Title: "Sender Addr", Width: colWidth},
-		{Title: "Receiver Addr", Width: colWidth},
+		{Title: by.title("Code", 0), Width: colWidth},
+		{Title: by.title("Up", 1), Width: colWidth},
+		{Title: by.title("Down", 2), Width: colWidth},
+		{Title: by.title("Rate", 3), Width: colWidth},
+		{Title: by.title("Throughput", 4), Width: colWidth},
+		{Title: by.title("Sender Addr", 5), Width: colWidth},
+		{Title: by.title("Receiver Addr", 6), Width: colWidth},
 	}
 
 	rows := []table.Row{}
-	splices := GetActiveSplices()
-	// Sort splices by code (alphabetically)
-	sort.Slice(splices, func(i, j int) bool {
-		return splices[i].Code < splices[j].Code
-	})
 	for _, s := range splices {
-		bytesUpStr := formatBytes(s.BytesUp)
-		bytesDownStr := formatBytes(s.BytesDown)
-
-		code := s.Code
-		if len(code) > colWidth {
-			code = code[:colWidth]
-		}
-		up := bytesUpStr
-		if len(up) > colWidth {
-			up = up[:colWidth]
-		}
-		down := bytesDownStr
-		if len(down) > colWidth {
-			down = down[:colWidth]
+		// A trailing * marks splices held back by a bandwidth cap
+		rate := formatRate(s.Rate)
+		if s.Throttled {
+			rate += "*"
 		}
-		senderAddr := s.SenderAddr
-		if len(senderAddr) > colWidth {
-			senderAddr = senderAddr[:colWidth]
-		}
-		receiverAddr := s.ReceiverAddr
-		if len(receiverAddr) > colWidth {
-			receiverAddr = receiverAddr[:colWidth]
-		}
-
-		rows = append(rows, table.Row{code, up, down, senderAddr, receiverAddr})
+		row := clipCells(table.Row{s.Code, formatBytes(s.BytesUp()), formatBytes(s.BytesDown()), rate, "", s.SenderAddr, s.ReceiverAddr}, colWidth)
+		row[4] = tui.Sparkline(s.RateHistory(), colWidth)
+		rows = append(rows, row)
 	}
+	return columns, rows
+}
 
-	t := table.New(
-		table.WithColumns(columns),
-		table.WithRows(rows),
-		table.WithFocused(true),
-		table.WithHeight(height),
-		table.WithWidth(width),
-	)
-
-	s := table.DefaultStyles()
-	s.Header = s.Header.
-		BorderStyle(lipgloss.NormalBorder()).
-		BorderForeground(lipgloss.Color("240")).
-		BorderBottom(true).
-		Bold(true).
-		Foreground(lipgloss.Color("62"))
-	s.Selected = s.Selected.
-		Foreground(lipgloss.Color("230")).
-		Background(lipgloss.Color("62")).
-		Bold(false)
-	t.SetStyles(s)
-
-	return t
+// NewSplicesTable creates and returns a table.Model configured for splices
+func NewSplicesTable(width, height int, splices []*Splice, by tableSort) table.Model {
+	if width < 20 {
+		width = 20
+	}
+	if height < 3 {
+		height = 3
+	}
+	columns, rows := splicesTableColumns(width, splices, by)
+	return newStateTable(columns, rows, width, height)
 }
 
 // UpdateSplicesTable updates the table with current splices data
-func UpdateSplicesTable(t table.Model, width, height int) table.Model {
+func UpdateSplicesTable(t table.Model, width, height int, splices []*Splice, by tableSort) table.Model {
 	if width < 20 {
 		width = 20
 	}
 	if height < 3 {
 		height = 3
 	}
+	columns, rows := splicesTableColumns(width, splices, by)
+	return updateStateTable(t, columns, rows, width, height)
+}
+
+// blocksTableColumns returns the columns of the throttle and ban table,
+// sorted by by, and the rows of blocks
+func blocksTableColumns(width int, blocks []ThrottledIP, by tableSort) ([]table.Column, []table.Row) {
 	availableWidth := width - 4
-	colWidth := availableWidth / 5
+	// Five columns: Address, Limit, Refused, Last Refused, Banned For
+	colWidth := availableWidth / blocksColumns
 
 	columns := []table.Column{
-		{Title: "Code", Width: colWidth},
-		{Title: "Up", Width: colWidth},
-		{Title: "Down", Width: colWidth},
-		{Title: "Sender Addr", Width: colWidth},
-		{Title: "Receiver Addr", Width: colWidth},
+		{Title: by.title("Address", 0), Width: colWidth},
+		{Title: by.title("Limit", 1), Width: colWidth},
+		{Title: by.title("Refused", 2), Width: colWidth},
+		{Title: by.title("Last Refused", 3), Width: colWidth},
+		{Title: by.title("Banned For", 4), Width: colWidth},
 	}
 
 	rows := []table.Row{}
-	splices := GetActiveSplices()
-	// Sort splices by code (alphabetically)
-	sort.Slice(splices, func(i, j int) bool {
-		return splices[i].Code < splices[j].Code
-	})
-	for _, s := range splices {
-		bytesUpStr := formatBytes(s.BytesUp)
-		bytesDownStr := formatBytes(s.BytesDown)
-
-		code := s.Code
-		if len(code) > colWidth {
-			code = code[:colWidth]
-		}
-		up := bytesUpStr
-		if len(up) > colWidth {
-			up = up[:colWidth]
-		}
-		down := bytesDownStr
-		if len(down) > colWidth {
-			down = down[:colWidth]
+	now := time.Now()
+	for _, t := range blocks {
+		banned := "-"
+		if t.BannedUntil != nil {
+			banned = t.BannedUntil.Sub(now).Round(time.Second).String()
 		}
-		senderAddr := s.SenderAddr
-		if len(senderAddr) > colWidth {
-			senderAddr = senderAddr[:colWidth]
-		}
-		receiverAddr := s.ReceiverAddr
-		if len(receiverAddr) > colWidth {
-			receiverAddr = receiverAddr[:colWidth]
-		}
-
-		rows = append(rows, table.Row{code, up, down, senderAddr, receiverAddr})
+		rows = append(rows, clipCells(table.Row{t.IP, t.Limit, fmt.Sprint(t.Refused), t.LastRefused.Format("15:04:05"), banned}, colWidth))
 	}
+	return columns, rows
+}
 
-	t.SetColumns(columns)
-	t.SetRows(rows)
-	t.SetWidth(width)
-	t.SetHeight(height)
+// NewBlocksTable creates and returns a table.Model configured for throttled
+// and banned addresses
+func NewBlocksTable(width, height int, blocks []ThrottledIP, by tableSort) table.Model {
+	if width < 20 {
+		width = 20
+	}
+	if height < 3 {
+		height = 3
+	}
+	columns, rows := blocksTableColumns(width, blocks, by)
+	return newStateTable(columns, rows, width, height)
+}
 
-	return t
+// UpdateBlocksTable updates the table with the current throttle and ban table
+func UpdateBlocksTable(t table.Model, width, height int, blocks []ThrottledIP, by tableSort) table.Model {
+	if width < 20 {
+		width = 20
+	}
+	if height < 3 {
+		height = 3
+	}
+	columns, rows := blocksTableColumns(width, blocks, by)
+	return updateStateTable(t, columns, rows, width, height)
 }
 
-// RenderLeftPaneContent renders the invites table with header
-func RenderLeftPaneContent(width int, invitesTable table.Model) string {
+// RenderLeftPaneContent renders the invites table with header; filtered
+// tables also count the rows they show
+func RenderLeftPaneContent(width int, invitesTable table.Model, filtered bool) string {
 	titleStyle := lipgloss.NewStyle().
 		Bold(true).
 		Foreground(lipgloss.Color("62")).
@@ -299,7 +448,20 @@ func RenderLeftPaneContent(width int, invitesTable table.Model) string {
 	title := titleStyle.Render("Outstanding Invites")
 
 	invites := GetOutstandingInvites()
-	info := infoStyle.Render(fmt.Sprintf("Active: %d", len(invites)))
+	unresponsive := 0
+	for _, inv := range invites {
+		if inv.Unresponsive() {
+			unresponsive++
+		}
+	}
+	summary := fmt.Sprintf("Active: %d", len(invites))
+	if unresponsive > 0 {
+		summary += fmt.Sprintf(" | Unresponsive: %d", unresponsive)
+	}
+	if filtered {
+		summary += fmt.Sprintf(" | Shown: %d", len(invitesTable.Rows()))
+	}
+	info := infoStyle.Render(summary)
 
 	tableView := invitesTable.View()
 	if tableView == "" {
@@ -316,8 +478,10 @@ func RenderLeftPaneContent(width int, invitesTable table.Model) string {
 	return content
 }
 
-// RenderRightPaneContent renders the splices table with header
-func RenderRightPaneContent(width int, splicesTable table.Model) string {
+// RenderRightPaneContent renders the splices table and the throttle and ban
+// table with their headers, and the key help; filtered tables also count the
+// rows they show
+func RenderRightPaneContent(width int, splicesTable, blocksTable table.Model, helpModel help.Model, filtered bool) string {
 	titleStyle := lipgloss.NewStyle().
 		Bold(true).
 		Foreground(lipgloss.Color("62")).
@@ -330,23 +494,75 @@ func RenderRightPaneContent(width int, splicesTable table.Model) string {
 	title := titleStyle.Render("Active Splices")
 
 	splices := GetActiveSplices()
-	info := infoStyle.Render(fmt.Sprintf("Active: %d", len(splices)))
+	summary := fmt.Sprintf("Active: %d", len(splices))
+	if filtered {
+		summary += fmt.Sprintf(" | Shown: %d", len(splicesTable.Rows()))
+	}
+	info := infoStyle.Render(summary + " | " + bandwidthSummary(splices))
 
 	tableView := splicesTable.View()
 	if tableView == "" {
 		tableView = "  No active splices"
 	}
 
+	_, bans, banned, pending := abuseStats()
+	blocksTitle := titleStyle.Render("Throttled and Banned")
+	blocksSummary := fmt.Sprintf("Banned: %d | Bans so far: %d | Pending handshakes: %d", banned, bans, pending)
+	if filtered {
+		blocksSummary += fmt.Sprintf(" | Shown: %d", len(blocksTable.Rows()))
+	}
+	blocksInfo := infoStyle.Render(blocksSummary)
+
+	keys := []key.Binding{
+		key.NewBinding(key.WithKeys("tab"), key.WithHelp("tab", "switch table")),
+		key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "details")),
+		key.NewBinding(key.WithKeys("/"), key.WithHelp("/", "filter")),
+		key.NewBinding(key.WithKeys("s", "S"), key.WithHelp("s/S", "sort/reverse")),
+		key.NewBinding(key.WithKeys("r"), key.WithHelp("r", "revoke invite")),
+		key.NewBinding(key.WithKeys("e"), key.WithHelp("e", "extend invite 10m")),
+		key.NewBinding(key.WithKeys("k"), key.WithHelp("k", "kill splice")),
+		key.NewBinding(key.WithKeys("u"), key.WithHelp("u", "unban")),
+		key.NewBinding(key.WithKeys("D"), key.WithHelp("D", "drain")),
+	}
+
 	content := lipgloss.JoinVertical(
 		lipgloss.Left,
 		title,
 		info,
 		tableView,
+		"",
+		blocksTitle,
+		blocksInfo,
+		blocksTable.View(),
+		"",
+		helpModel.ShortHelpView(keys),
 	)
 
 	return content
 }
 
+// bandwidthSummary describes the relay's current rate against its cap and
+// how many splices are being throttled
+func bandwidthSummary(splices []*Splice) string {
+	throttled := 0
+	spliceMu.RLock()
+	for _, s := range splices {
+		if s.Throttled {
+			throttled++
+		}
+	}
+	spliceMu.RUnlock()
+
+	summary := "Rate: " + formatRate(CurrentRelayRate())
+	if limit := GlobalBandwidthCap(); limit > 0 {
+		summary += " of " + formatRate(limit)
+	}
+	if throttled > 0 {
+		summary += fmt.Sprintf(" | Throttled*: %d", throttled)
+	}
+	return summary
+}
+
 func formatBytes(b int64) string {
 	const unit = 1024
 	if b < unit {
@@ -0,0 +1,63 @@
+package relay
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTableSort(t *testing.T) {
+	rows := []string{"b2", "a3", "c1", "a1"}
+	byDigit := func(a, b string, column int) int {
+		return strings.Compare(a[column:column+1], b[column:column+1])
+	}
+
+	sorted := slices.Clone(rows)
+	sortRows(sorted, tableSort{Column: -1}, byDigit)
+	if !slices.Equal(sorted, rows) {
+		t.Fatalf("default order changed: %v", sorted)
+	}
+	sortRows(sorted, tableSort{Column: -1, Desc: true}, byDigit)
+	if want := []string{"a1", "c1", "a3", "b2"}; !slices.Equal(sorted, want) {
+		t.Fatalf("reversed default order %v, want %v", sorted, want)
+	}
+
+	// Ties keep the default order
+	sorted = slices.Clone(rows)
+	sortRows(sorted, tableSort{Column: 0}, byDigit)
+	if want := []string{"a3", "a1", "b2", "c1"}; !slices.Equal(sorted, want) {
+		t.Fatalf("by column 0: %v, want %v", sorted, want)
+	}
+	sorted = slices.Clone(rows)
+	sortRows(sorted, tableSort{Column: 1, Desc: true}, byDigit)
+	if want := []string{"a3", "b2", "c1", "a1"}; !slices.Equal(sorted, want) {
+		t.Fatalf("by column 1, descending: %v, want %v", sorted, want)
+	}
+
+	by := tableSort{Column: -1}
+	for _, want := range []int{0, 1, -1, 0} {
+		if by = by.next(2); by.Column != want {
+			t.Fatalf("next column %d, want %d", by.Column, want)
+		}
+	}
+	if got := (tableSort{Column: 1, Desc: true}).title("Rate", 1); got != "Rate ▼" {
+		t.Fatalf("title %q", got)
+	}
+}
+
+func TestMatchesFilter(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		fields []string
+		want   bool
+	}{
+		{"", nil, true},
+		{"10.0.", []string{"ABC", "10.0.0.7:51234"}, true},
+		{"alice", []string{"ABC", "Alice@example.com"}, true},
+		{"bob", []string{"ABC", "alice"}, false},
+	} {
+		if got := matchesFilter(tc.filter, tc.fields...); got != tc.want {
+			t.Errorf("matchesFilter(%q, %q) = %v, want %v", tc.filter, tc.fields, got, tc.want)
+		}
+	}
+}
@@ -2,10 +2,13 @@ package relay
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/table"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
@@ -19,25 +22,41 @@ const (
 	topSectionHeight  = 60                     // Percentage of available height for top section (rest goes to logs)
 	leftSectionWidth  = 30                     // Percentage of available width for left section (invites), rest goes to right (splices)
 	tuiUpdateInterval = 500 * time.Millisecond // Interval for updating TUI content
+	tuiExtendStep     = 10 * time.Minute       // How much 'e' extends the selected invite
 )
 
 // TUI model for relay
 type relayTUIModel struct {
 	invitesTable  table.Model
 	splicesTable  table.Model
+	blocksTable   table.Model // throttled and banned addresses
 	leftViewport  viewport.Model
 	rightViewport viewport.Model
 	logViewer     *tui.LogViewer
+	help          help.Model
 	cancel        context.CancelFunc
+	drain         func() bool
+	activeTable   int          // 0 = invites, 1 = splices, 2 = throttled and banned addresses
+	sorts         [3]tableSort // order of each table
+	filter        string       // shows only rows containing it
+	filtering     bool         // the filter is being typed
+	detail        bool         // the detail pane replaces the logs
+	invites       []*Invite    // rows of the tables, as shown
+	splices       []*Splice
+	blocks        []ThrottledIP
 	width         int
 	height        int
+	bottomHeight  int
 	ready         bool
 }
 
-func newRelayTUIModel(logWriter *tui.LogTailWriter, cancel context.CancelFunc) *relayTUIModel {
+func newRelayTUIModel(logWriter *tui.LogTailWriter, cancel context.CancelFunc, drain func() bool) *relayTUIModel {
 	return &relayTUIModel{
 		logViewer: tui.NewLogViewer(logWriter),
+		help:      help.New(),
 		cancel:    cancel,
+		drain:     drain,
+		sorts:     [3]tableSort{{Column: -1}, {Column: -1}, {Column: -1}},
 	}
 }
 
@@ -58,6 +77,10 @@ func (m *relayTUIModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if m.filtering && msg.String() != "ctrl+c" {
+			m.updateFilter(msg)
+			break
+		}
 		switch msg.String() {
 		case "ctrl+c", "q":
 			// Signal shutdown before quitting
@@ -65,6 +88,98 @@ func (m *relayTUIModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.cancel()
 			}
 			return m, tea.Quit
+		case "tab":
+			// Cycle through the invites, splices and throttle tables
+			if m.ready {
+				m.activeTable = (m.activeTable + 1) % 3
+				m.updateTableFocus()
+			}
+		case "r":
+			// Revoke the selected invite
+			if inv := m.selectedInvite(); inv != nil {
+				if _, err := RevokeInvite(inv.RID); err != nil {
+					log.Printf("[ADMIN] revoke failed: %v", err)
+				}
+				m.updateTopContent()
+			}
+		case "e":
+			// Extend the selected invite
+			if inv := m.selectedInvite(); inv != nil {
+				if _, err := ExtendInvite(inv.RID, tuiExtendStep); err != nil {
+					log.Printf("[ADMIN] extend failed: %v", err)
+				}
+				m.updateTopContent()
+			}
+		case "k":
+			// Kill the selected splice
+			if s := m.selectedSplice(); s != nil {
+				if _, err := KillSplice(s.ID); err != nil {
+					log.Printf("[ADMIN] kill failed: %v", err)
+				}
+			}
+		case "u":
+			// Unban the selected address, or all of them outside the throttle table
+			addr := ""
+			if m.activeTable == 2 {
+				t := m.selectedBlock()
+				if t == nil {
+					break
+				}
+				addr = t.IP
+			}
+			if Unban(addr) == 0 {
+				log.Printf("[ADMIN] no throttled addresses to unban")
+			}
+			m.updateTopContent()
+		case "D":
+			// Start draining for a restart
+			if m.drain != nil && m.drain() {
+				log.Printf("[DRAIN] drain requested from the TUI")
+			}
+		case "enter":
+			// Show or hide the details of the selected row
+			m.detail = !m.detail
+		case "esc":
+			// Close the details, else drop the filter
+			if m.detail {
+				m.detail = false
+			} else if m.filter != "" {
+				m.filter = ""
+				m.updateTopContent()
+			}
+		case "/":
+			// Type a filter
+			m.filtering = true
+		case "s":
+			// Sort the active table by its next column
+			if m.ready {
+				columns := []int{invitesColumns, splicesColumns, blocksColumns}[m.activeTable]
+				m.sorts[m.activeTable] = m.sorts[m.activeTable].next(columns)
+				m.updateTopContent()
+			}
+		case "S":
+			// Reverse the order of the active table
+			if m.ready {
+				m.sorts[m.activeTable].Desc = !m.sorts[m.activeTable].Desc
+				m.updateTopContent()
+			}
+		default:
+			// Let the active table handle navigation keys (up/down)
+			if m.ready {
+				var tableCmd tea.Cmd
+				switch m.activeTable {
+				case 0:
+					m.invitesTable, tableCmd = m.invitesTable.Update(msg)
+				case 1:
+					m.splicesTable, tableCmd = m.splicesTable.Update(msg)
+				case 2:
+					m.blocksTable, tableCmd = m.blocksTable.Update(msg)
+				}
+				if tableCmd != nil {
+					cmds = append(cmds, tableCmd)
+				}
+				m.updateTopContent()
+			}
 		}
 
 	case tea.WindowSizeMsg:
@@ -84,23 +199,25 @@ func (m *relayTUIModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		leftWidth := (availableWidth * leftSectionWidth) / 100
 		rightWidth := availableWidth - leftWidth - 3 // -3 for divider and padding
 
-		// Reserve some height for header/info, rest for table
-		tableHeight := topHeight - 4
+		// Reserve some height for header/info and help, rest for table
+		tableHeight := topHeight - 6
 		if tableHeight < 3 {
 			tableHeight = 3
 		}
 
 		if !m.ready {
-			m.invitesTable = NewInvitesTable(leftWidth, tableHeight)
-			m.splicesTable = NewSplicesTable(rightWidth, tableHeight)
+			m.refreshRows()
+			m.invitesTable = NewInvitesTable(leftWidth, tableHeight, m.invites, m.sorts[0])
+			splicesHeight, blocksHeight := splitRightPane(topHeight)
+			m.splicesTable = NewSplicesTable(rightWidth, splicesHeight, m.splices, m.sorts[1])
+			m.blocksTable = NewBlocksTable(rightWidth, blocksHeight, m.blocks, m.sorts[2])
 			m.leftViewport = viewport.New(leftWidth, topHeight)
 			m.rightViewport = viewport.New(rightWidth, topHeight)
 			m.width = msg.Width
 			m.height = msg.Height
 			m.ready = true
+			m.updateTableFocus()
 		} else {
-			m.invitesTable = UpdateInvitesTable(m.invitesTable, leftWidth, tableHeight)
-			m.splicesTable = UpdateSplicesTable(m.splicesTable, rightWidth, tableHeight)
 			m.leftViewport.Width = leftWidth
 			m.leftViewport.Height = topHeight
 			m.rightViewport.Width = rightWidth
@@ -112,9 +229,10 @@ func (m *relayTUIModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		// Update log viewer size
 		m.logViewer.SetSize(msg.Width, bottomHeight)
+		m.bottomHeight = bottomHeight
 
 		// Handle table and viewport updates
-		var invitesCmd, splicesCmd, leftCmd, rightCmd tea.Cmd
+		var invitesCmd, splicesCmd, blocksCmd, leftCmd, rightCmd tea.Cmd
 		m.invitesTable, invitesCmd = m.invitesTable.Update(msg)
 		if invitesCmd != nil {
 			cmds = append(cmds, invitesCmd)
@@ -123,6 +241,10 @@ func (m *relayTUIModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if splicesCmd != nil {
 			cmds = append(cmds, splicesCmd)
 		}
+		m.blocksTable, blocksCmd = m.blocksTable.Update(msg)
+		if blocksCmd != nil {
+			cmds = append(cmds, blocksCmd)
+		}
 		m.leftViewport, leftCmd = m.leftViewport.Update(msg)
 		if leftCmd != nil {
 			cmds = append(cmds, leftCmd)
@@ -141,7 +263,7 @@ func (m *relayTUIModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	default:
 		// Handle table and viewport updates
 		if m.ready {
-			var invitesCmd, splicesCmd, leftCmd, rightCmd tea.Cmd
+			var invitesCmd, splicesCmd, blocksCmd, leftCmd, rightCmd tea.Cmd
 			m.invitesTable, invitesCmd = m.invitesTable.Update(msg)
 			if invitesCmd != nil {
 				cmds = append(cmds, invitesCmd)
@@ -150,6 +272,10 @@ func (m *relayTUIModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if splicesCmd != nil {
 				cmds = append(cmds, splicesCmd)
 			}
+			m.blocksTable, blocksCmd = m.blocksTable.Update(msg)
+			if blocksCmd != nil {
+				cmds = append(cmds, blocksCmd)
+			}
 			m.leftViewport, leftCmd = m.leftViewport.Update(msg)
 			if leftCmd != nil {
 				cmds = append(cmds, leftCmd)
@@ -175,17 +301,24 @@ func (m *relayTUIModel) updateTopContent() {
 		return
 	}
 
+	// Sorting and filtering move rows around: keep the selected ones selected
+	selectedInvite, _ := rowAt(m.invites, m.invitesTable)
+	selectedSplice, _ := rowAt(m.splices, m.splicesTable)
+	selectedBlock, hadBlock := rowAt(m.blocks, m.blocksTable)
+	m.refreshRows()
+
 	// Update invites table with current data
 	// Use viewport width instead of table width to ensure correct sizing
 	invitesTableWidth := m.leftViewport.Width
 	if invitesTableWidth < 20 {
 		invitesTableWidth = 20
 	}
-	invitesTableHeight := m.leftViewport.Height - 4 // Reserve space for title/info
+	invitesTableHeight := m.leftViewport.Height - 6 // Reserve space for title/info/help
 	if invitesTableHeight < 3 {
 		invitesTableHeight = 3
 	}
-	m.invitesTable = UpdateInvitesTable(m.invitesTable, invitesTableWidth, invitesTableHeight)
+	m.invitesTable = UpdateInvitesTable(m.invitesTable, invitesTableWidth, invitesTableHeight, m.invites, m.sorts[0])
+	keepSelection(&m.invitesTable, slices.Index(m.invites, selectedInvite))
 
 	// Update splices table with current data
 	// Use viewport width instead of table width to ensure correct sizing
@@ -193,18 +326,23 @@ func (m *relayTUIModel) updateTopContent() {
 	if splicesTableWidth < 20 {
 		splicesTableWidth = 20
 	}
-	splicesTableHeight := m.rightViewport.Height - 4 // Reserve space for title/info
-	if splicesTableHeight < 3 {
-		splicesTableHeight = 3
+	splicesTableHeight, blocksTableHeight := splitRightPane(m.rightViewport.Height)
+	m.splicesTable = UpdateSplicesTable(m.splicesTable, splicesTableWidth, splicesTableHeight, m.splices, m.sorts[1])
+	keepSelection(&m.splicesTable, slices.Index(m.splices, selectedSplice))
+	m.blocksTable = UpdateBlocksTable(m.blocksTable, splicesTableWidth, blocksTableHeight, m.blocks, m.sorts[2])
+	if hadBlock {
+		keepSelection(&m.blocksTable, slices.IndexFunc(m.blocks, func(t ThrottledIP) bool {
+			return t.IP == selectedBlock.IP && t.Limit == selectedBlock.Limit
+		}))
 	}
-	m.splicesTable = UpdateSplicesTable(m.splicesTable, splicesTableWidth, splicesTableHeight)
 
 	// Render left pane: invites table
-	leftContent := RenderLeftPaneContent(m.leftViewport.Width, m.invitesTable)
+	leftContent := RenderLeftPaneContent(m.leftViewport.Width, m.invitesTable, m.filter != "")
 	m.leftViewport.SetContent(leftContent)
 
-	// Render right pane: splices table
-	rightContent := RenderRightPaneContent(m.rightViewport.Width, m.splicesTable)
+	// Render right pane: splices and throttle tables
+	m.help.Width = m.rightViewport.Width
+	rightContent := RenderRightPaneContent(m.rightViewport.Width, m.splicesTable, m.blocksTable, m.help, m.filter != "")
 	m.rightViewport.SetContent(rightContent)
 }
 
@@ -214,7 +352,7 @@ func (m *relayTUIModel) View() string {
 	}
 
 	// Header spans full width
-	header := tui.RenderTitleBar("Relay", m.width-2)
+	header := tui.RenderTitleBar(m.title(), m.width-2)
 
 	// Invisible borders to maintain spacing
 	splitStyle := lipgloss.NewStyle().
@@ -274,6 +412,9 @@ func (m *relayTUIModel) View() string {
 
 	topRow := strings.Join(combinedLines, "\n")
 	bottomContent := m.logViewer.View()
+	if m.detail {
+		bottomContent = m.detailView()
+	}
 
 	topSection := splitStyle.
 		Width(m.width - 2).
@@ -293,10 +434,145 @@ func (m *relayTUIModel) View() string {
 	return result
 }
 
+// splitRightPane shares the height of the right pane between the splices
+// table and the throttle table, after the titles, infos and key help
+func splitRightPane(height int) (splices, blocks int) {
+	tables := height - 11
+	blocks = max(tables/3, 3)
+	return max(tables-blocks, 3), blocks
+}
+
+// updateTableFocus highlights the selection of the active table only
+func (m *relayTUIModel) updateTableFocus() {
+	m.invitesTable.Blur()
+	m.splicesTable.Blur()
+	m.blocksTable.Blur()
+	switch m.activeTable {
+	case 0:
+		m.invitesTable.Focus()
+	case 1:
+		m.splicesTable.Focus()
+	case 2:
+		m.blocksTable.Focus()
+	}
+}
+
+// selectedInvite returns the invite under the cursor of the focused invites table
+func (m *relayTUIModel) selectedInvite() *Invite {
+	if !m.ready || m.activeTable != 0 {
+		return nil
+	}
+	inv, _ := rowAt(m.invites, m.invitesTable)
+	return inv
+}
+
+// selectedSplice returns the splice under the cursor of the focused splices table
+func (m *relayTUIModel) selectedSplice() *Splice {
+	if !m.ready || m.activeTable != 1 {
+		return nil
+	}
+	s, _ := rowAt(m.splices, m.splicesTable)
+	return s
+}
+
+// selectedBlock returns the entry under the cursor of the focused throttle table
+func (m *relayTUIModel) selectedBlock() *ThrottledIP {
+	if !m.ready || m.activeTable != 2 {
+		return nil
+	}
+	if t, ok := rowAt(m.blocks, m.blocksTable); ok {
+		return &t
+	}
+	return nil
+}
+
+// rowAt returns the row under the cursor of t, from the rows it shows
+func rowAt[T any](rows []T, t table.Model) (T, bool) {
+	var row T
+	if i := t.Cursor(); i >= 0 && i < len(rows) {
+		return rows[i], true
+	}
+	return row, false
+}
+
+// keepSelection moves the cursor of t to row i, if the row is still shown
+func keepSelection(t *table.Model, i int) {
+	if i >= 0 {
+		t.SetCursor(i)
+	}
+}
+
+// refreshRows gets the rows of the tables, filtered and sorted
+func (m *relayTUIModel) refreshRows() {
+	m.invites = viewInvites(m.filter, m.sorts[0])
+	m.splices = viewSplices(m.filter, m.sorts[1])
+	m.blocks = viewBlocks(m.filter, m.sorts[2])
+}
+
+// updateFilter edits the filter while it is being typed; enter keeps it and
+// esc drops it
+func (m *relayTUIModel) updateFilter(msg tea.KeyMsg) {
+	switch msg.Type {
+	case tea.KeyEnter:
+		m.filtering = false
+	case tea.KeyEsc:
+		m.filtering = false
+		m.filter = ""
+	case tea.KeyBackspace:
+		if r := []rune(m.filter); len(r) > 0 {
+			m.filter = string(r[:len(r)-1])
+		}
+	case tea.KeyCtrlU:
+		m.filter = ""
+	case tea.KeyRunes, tea.KeySpace:
+		m.filter += string(msg.Runes)
+	}
+	m.updateTopContent()
+}
+
+// title returns the title bar text with the filter
+func (m *relayTUIModel) title() string {
+	title := relayTitle()
+	if m.filtering {
+		return title + " - filter: " + m.filter + "█"
+	}
+	if m.filter != "" {
+		return title + " - filter: " + m.filter + " (esc clears)"
+	}
+	return title
+}
+
+// detailView renders the details of the selected row of the active table
+func (m *relayTUIModel) detailView() string {
+	var (
+		title  = "Nothing selected"
+		fields []detailField
+		rates  []float64
+	)
+	switch {
+	case m.selectedInvite() != nil:
+		title, fields = inviteDetail(m.selectedInvite())
+	case m.selectedSplice() != nil:
+		title, fields, rates = spliceDetail(m.selectedSplice())
+	case m.selectedBlock() != nil:
+		title, fields = blockDetail(*m.selectedBlock())
+	}
+	return RenderDetailPane(m.width, m.bottomHeight, title, fields, rates)
+}
+
+// relayTitle returns the title bar text, flagging a running drain so operators
+// can see why new sessions are refused
+func relayTitle() string {
+	if !IsDraining() {
+		return "Relay"
+	}
+	return fmt.Sprintf("Relay - DRAINING (%d active, closing at %s)", len(GetActiveSplices()), DrainDeadline().Format("15:04:05"))
+}
+
 // startTUI starts the TUI in a goroutine and sets up log capture
 // When the TUI quits, it calls cancel to signal server shutdown
 // Returns a channel that will be closed when the TUI goroutine finishes
-func startTUI(ctx context.Context, cancel context.CancelFunc) (<-chan struct{}, error) {
+func startTUI(ctx context.Context, cancel context.CancelFunc, drain func() bool) (<-chan struct{}, error) {
 	originalOutput := log.Writer()
 
 	// Create log writer
@@ -306,7 +582,7 @@ func startTUI(ctx context.Context, cancel context.CancelFunc) (<-chan struct{},
 	log.SetOutput(logWriter)
 
 	// Create and start the TUI program
-	model := newRelayTUIModel(logWriter, cancel)
+	model := newRelayTUIModel(logWriter, cancel, drain)
 	p := tea.NewProgram(model, tea.WithAltScreen())
 
 	// Channel to signal when TUI goroutine finishes
@@ -319,6 +595,11 @@ func startTUI(ctx context.Context, cancel context.CancelFunc) (<-chan struct{},
 			log.SetOutput(originalOutput)
 			close(done)
 		}()
+		// Quit when the relay stops on its own (signal, drain finished)
+		go func() {
+			<-ctx.Done()
+			p.Quit()
+		}()
 		if _, err := p.Run(); err != nil {
 			log.Printf("TUI error: %v", err)
 		}
@@ -3,11 +3,13 @@ package cli
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
 	"ssh-portal/internal/cli/receiver"
+	"ssh-portal/internal/cli/usercode"
 	"ssh-portal/internal/config"
 	"ssh-portal/internal/log"
 	"ssh-portal/internal/version"
@@ -45,15 +47,22 @@ var (
 			// Load receiver config and merge with flags
 			cfg := receiver.LoadReceiverConfig()
 			merged := receiver.MergeReceiverFlags(cmd, cfg, receiver.ReceiverFlags{
-				RelayHost:   receiverRelayHost,
-				RelayPort:   receiverRelayPort,
-				Token:       receiverToken,
-				Interactive: receiverInteractive,
-				Session:     receiverSession,
-				LogView:     receiverLogView,
+				RelayHost:     receiverRelayHost,
+				RelayPort:     receiverRelayPort,
+				TLS:           receiverTLS,
+				Token:         receiverToken,
+				Interactive:   receiverInteractive,
+				Session:       receiverSession,
+				LogView:       receiverLogView,
+				CodeWords:     receiverCodeWords,
+				CodeEncoding:  receiverCodeEncoding,
+				SenderToken:   receiverSenderToken,
+				Claim:         receiverClaim,
+				Label:         receiverLabel,
+				ForwardPolicy: receiverForward,
 			})
 
-			return receiver.Run(merged.RelayHost, merged.RelayPort, merged.Interactive, merged.Session, merged.LogView, merged.Token)
+			return receiver.Run(merged)
 		},
 	}
 )
@@ -71,10 +80,20 @@ func init() {
 	// Add receiver flags to root command (since receiver is the default)
 	rootCmd.Flags().StringVar(&receiverRelayHost, "relay", "", "Relay server host")
 	rootCmd.Flags().IntVar(&receiverRelayPort, "relay-port", 0, "Relay server TCP port")
+	rootCmd.Flags().BoolVar(&receiverTLS, "tls", false, "connect to the relay over TLS")
 	rootCmd.Flags().BoolVar(&receiverInteractive, "interactive", true, "interactive mode")
 	rootCmd.Flags().BoolVar(&receiverSession, "session", false, "enable session handling (PTY/shell/exec)")
 	rootCmd.Flags().BoolVar(&receiverLogView, "logview", true, "show log panel in interactive mode")
 	rootCmd.Flags().StringVar(&receiverToken, "token", "", "optional access token")
+	rootCmd.Flags().IntVar(&receiverCodeWords, "code-words", 0, "requested code strength in words (4, 6 or 8); the relay may raise it")
+	rootCmd.Flags().StringVar(&receiverCodeEncoding, "code-encoding", "", "user code encoding ("+strings.Join(usercode.Encodings(), ", ")+")")
+	rootCmd.Flags().StringVar(&receiverSenderToken, "sender-token", "", "sender token of the relay; only a short hash of it is added to share links (token-hint)")
+	rootCmd.Flags().StringVar(&receiverClaim, "claim", "", "claim token of an invite pre-minted by the relay's invite API (e.g. from a helpdesk ticket)")
+	rootCmd.Flags().StringVar(&receiverLabel, "label", "", "what this machine is, shown to senders that inspect the code (default: the hostname; empty for none)")
+	rootCmd.Flags().StringSliceVar(&receiverForward.Allow, "forward-allow", nil, "destinations the sender's forwards may reach: CIDRs, addresses or host names (*.example.com for subdomains); repeatable")
+	rootCmd.Flags().StringSliceVar(&receiverForward.Deny, "forward-deny", nil, "destinations the sender's forwards may not reach (same forms as --forward-allow); repeatable")
+	rootCmd.Flags().StringSliceVar(&receiverForward.Ports, "forward-ports", nil, "ports the sender's forwards may reach, e.g. 22,8000-8100")
+	rootCmd.Flags().BoolVar(&receiverForward.LoopbackOnly, "forward-loopback-only", false, "only let the sender's forwards reach this machine's loopback addresses")
 
 	// Add subcommands
 	rootCmd.AddCommand(senderCmd)
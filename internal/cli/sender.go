@@ -1,19 +1,28 @@
 package cli
 
 import (
+	"bufio"
 	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/term"
 
 	"ssh-portal/internal/cli/sender"
+	"ssh-portal/internal/cli/usercode"
 )
 
 var (
 	senderCode             string
 	senderRelayHost        string
 	senderRelayPort        int
+	senderTLS              bool
 	senderInteractive      bool
 	senderKeepaliveTimeout string
 	senderIdentity         string
@@ -24,22 +33,32 @@ var (
 )
 
 var senderCmd = &cobra.Command{
-	Use:   "sender",
+	Use:   "sender [code | ssh-portal://relay:port/code]",
 	Short: "Sender command",
+	Args:  cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// Load top-level sender config from viper
-		var topLevel *sender.SenderConfig
-		if viper.IsSet("sender") {
-			var cfg sender.SenderConfig
-			if err := viper.UnmarshalKey("sender", &cfg); err == nil {
-				topLevel = &cfg
-			}
+		link, err := senderArgs(args)
+		if err != nil {
+			return err
 		}
+		topLevel := loadSenderConfig()
+		senderProfileForLink(topLevel, link)
 
 		// Show menu if enabled and profiles exist
 		if senderMenu && topLevel != nil && len(topLevel.Profiles) > 0 && senderProfile == "" {
-			needsCode := senderCode == ""
-			result, err := sender.SelectProfile(topLevel.Profiles, needsCode)
+			// The menu shows what the code points to on the highlighted profile's relay
+			inspect := func(profile, code string) (*sender.InspectResult, error) {
+				p, err := findSenderProfile(topLevel, profile)
+				if err != nil {
+					return nil, err
+				}
+				cfg := sender.MergeConfig(topLevel, p)
+				relayHost, relayPort := senderRelay(cmd, cfg, link)
+				addr := net.JoinHostPort(relayHost, strconv.Itoa(relayPort))
+				// No prompts under the menu: a link to another relay is inspected without the token
+				return sender.InspectInvite(addr, cfg.TLS, code, senderTokenFor(cmd, cfg, link, relayHost, relayPort, nil))
+			}
+			result, err := sender.SelectProfile(topLevel.Profiles, senderCode, inspect)
 			if err != nil {
 				return fmt.Errorf("profile selection failed: %w", err)
 			}
@@ -54,38 +73,16 @@ var senderCmd = &cobra.Command{
 		}
 
 		// Find and merge profile if specified
-		var profile *sender.Profile
-		if senderProfile != "" {
-			if topLevel == nil {
-				return fmt.Errorf("no sender configuration found in config file")
-			}
-			// Find profile by name
-			found := false
-			for i := range topLevel.Profiles {
-				if topLevel.Profiles[i].Name == senderProfile {
-					profile = &topLevel.Profiles[i]
-					found = true
-					break
-				}
-			}
-			if !found {
-				return fmt.Errorf("profile '%s' not found in configuration", senderProfile)
-			}
+		profile, err := findSenderProfile(topLevel, senderProfile)
+		if err != nil {
+			return err
 		}
 
 		// Merge top-level + profile
 		mergedCfg := sender.MergeConfig(topLevel, profile)
 
-		// Apply CLI flags (they override config)
-		relayHost := mergedCfg.Relay
-		if cmd.Flags().Changed("relay") && senderRelayHost != "" {
-			relayHost = senderRelayHost
-		}
-
-		relayPort := mergedCfg.RelayPort
-		if cmd.Flags().Changed("relay-port") && senderRelayPort > 0 {
-			relayPort = senderRelayPort
-		}
+		// Apply share link, then CLI flags (they override config)
+		relayHost, relayPort := senderRelay(cmd, mergedCfg, link)
 
 		interactive := mergedCfg.Interactive
 		if cmd.Flags().Changed("interactive") {
@@ -106,18 +103,14 @@ var senderCmd = &cobra.Command{
 			identity = senderIdentity
 		}
 
-		token := mergedCfg.Token
-		if cmd.Flags().Changed("token") && senderToken != "" {
-			token = senderToken
+		token := senderTokenFor(cmd, mergedCfg, link, relayHost, relayPort, confirmSendToken)
+		if link != nil && !usercode.MatchesTokenHint(token, link.TokenHint) {
+			log.Printf("warning: sender token does not match the link's token-hint; the relay may reject it")
 		}
 
-		// Get code (required)
-		code := senderCode
-		if code == "" {
-			code = viper.GetString("sender.code")
-		}
+		code := senderCodeOrConfig()
 		if code == "" {
-			return fmt.Errorf("code is required (use --code flag or config)")
+			return fmt.Errorf("code is required (use --code flag, a share link or config)")
 		}
 
 		// Run sender with merged configuration
@@ -125,17 +118,183 @@ var senderCmd = &cobra.Command{
 	},
 }
 
+var senderInspectCmd = &cobra.Command{
+	Use:   "inspect [code | ssh-portal://relay:port/code]",
+	Short: "Show what a code points to without connecting",
+	Long: `Asks the relay whether the invite behind a code exists, when it expires and
+what the receiver says it is (its --label, by default its hostname). The
+invite is not used up; only the relay part of the code is sent.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		link, err := senderArgs(args)
+		if err != nil {
+			return err
+		}
+		topLevel := loadSenderConfig()
+		senderProfileForLink(topLevel, link)
+		profile, err := findSenderProfile(topLevel, senderProfile)
+		if err != nil {
+			return err
+		}
+		cfg := sender.MergeConfig(topLevel, profile)
+		relayHost, relayPort := senderRelay(cmd, cfg, link)
+
+		code := senderCodeOrConfig()
+		if code == "" {
+			return fmt.Errorf("code is required (use --code flag, a share link or config)")
+		}
+		addr := net.JoinHostPort(relayHost, strconv.Itoa(relayPort))
+		cmd.SilenceUsage = true
+		result, err := sender.InspectInvite(addr, cfg.TLS, code, senderTokenFor(cmd, cfg, link, relayHost, relayPort, confirmSendToken))
+		if err != nil {
+			return fmt.Errorf("inspect on %s: %w", addr, err)
+		}
+		if !result.Exists {
+			return fmt.Errorf("%s: %s", addr, result)
+		}
+		fmt.Printf("%s: %s\n", addr, result)
+		return nil
+	},
+}
+
+// senderArgs takes a positional code or share link in place of --code
+func senderArgs(args []string) (*usercode.ShareLink, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	if !usercode.IsShareLink(args[0]) {
+		senderCode = args[0]
+		return nil, nil
+	}
+	link, err := usercode.ParseShareLink(args[0])
+	if err != nil {
+		return nil, err
+	}
+	senderCode = link.Code
+	return link, nil
+}
+
+// loadSenderConfig loads the top-level sender config, nil if there is none
+func loadSenderConfig() *sender.SenderConfig {
+	if !viper.IsSet("sender") {
+		return nil
+	}
+	var cfg sender.SenderConfig
+	if err := viper.UnmarshalKey("sender", &cfg); err != nil {
+		return nil
+	}
+	return &cfg
+}
+
+// senderProfileForLink picks the profile holding the token matching a share
+// link's token hint, unless a profile was named
+func senderProfileForLink(topLevel *sender.SenderConfig, link *usercode.ShareLink) {
+	if link == nil || link.TokenHint == "" || topLevel == nil || senderProfile != "" {
+		return
+	}
+	for _, p := range topLevel.Profiles {
+		if p.Token != "" && usercode.MatchesTokenHint(p.Token, link.TokenHint) {
+			senderProfile = p.Name
+			return
+		}
+	}
+}
+
+// findSenderProfile returns the named profile, nil for no name
+func findSenderProfile(topLevel *sender.SenderConfig, name string) (*sender.Profile, error) {
+	if name == "" {
+		return nil, nil
+	}
+	if topLevel == nil {
+		return nil, fmt.Errorf("no sender configuration found in config file")
+	}
+	for i := range topLevel.Profiles {
+		if topLevel.Profiles[i].Name == name {
+			return &topLevel.Profiles[i], nil
+		}
+	}
+	return nil, fmt.Errorf("profile '%s' not found in configuration", name)
+}
+
+// senderRelay applies the share link, then the flags, to the relay of cfg
+func senderRelay(cmd *cobra.Command, cfg *sender.Config, link *usercode.ShareLink) (string, int) {
+	relayHost := cfg.Relay
+	relayPort := cfg.RelayPort
+	if link != nil {
+		relayHost = link.RelayHost
+		if link.RelayPort > 0 {
+			relayPort = link.RelayPort
+		}
+		// A link may ask for TLS but not turn off TLS the config asks for
+		cfg.TLS = cfg.TLS || link.TLS
+	}
+	if cmd.Flags().Changed("relay") && senderRelayHost != "" {
+		relayHost = senderRelayHost
+	}
+
+	if cmd.Flags().Changed("relay-port") && senderRelayPort > 0 {
+		relayPort = senderRelayPort
+	}
+	if cmd.Flags().Changed("tls") {
+		cfg.TLS = senderTLS
+	}
+	return relayHost, relayPort
+}
+
+// senderTokenFor returns the sender token of the flag, else of cfg. A share
+// link naming another relay than cfg's gets the configured token only if
+// confirm (nil: never) allows it, so a crafted link can't collect it.
+func senderTokenFor(cmd *cobra.Command, cfg *sender.Config, link *usercode.ShareLink, relayHost string, relayPort int, confirm func(addr string) bool) string {
+	if cmd.Flags().Changed("token") && senderToken != "" {
+		return senderToken
+	}
+	if cfg.Token == "" || link == nil || cmd.Flags().Changed("relay") ||
+		(strings.EqualFold(relayHost, cfg.Relay) && relayPort == cfg.RelayPort) {
+		return cfg.Token
+	}
+	addr := net.JoinHostPort(relayHost, strconv.Itoa(relayPort))
+	if confirm != nil && confirm(addr) {
+		return cfg.Token
+	}
+	log.Printf("warning: not sending the configured sender token to %s, the link's relay; use --token to send one", addr)
+	return ""
+}
+
+// confirmSendToken asks on the terminal whether the configured sender token
+// may go to the relay of a share link; without a terminal it may not
+func confirmSendToken(addr string) bool {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "The link points to relay %s, not the configured one. Send your sender token there? [y/N] ", addr)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// senderCodeOrConfig returns the code from the command line, else from the
+// config or environment
+func senderCodeOrConfig() string {
+	if senderCode != "" {
+		return senderCode
+	}
+	return viper.GetString("sender.code")
+}
+
 func init() {
-	senderCmd.Flags().StringVarP(&senderCode, "code", "c", "", "connection code")
-	senderCmd.Flags().StringVar(&senderRelayHost, "relay", "", "Relay server host")
-	senderCmd.Flags().IntVar(&senderRelayPort, "relay-port", 0, "Relay server TCP port")
+	// Shared with sender inspect
+	senderCmd.PersistentFlags().StringVarP(&senderCode, "code", "c", "", "connection code")
+	senderCmd.PersistentFlags().StringVar(&senderRelayHost, "relay", "", "Relay server host")
+	senderCmd.PersistentFlags().IntVar(&senderRelayPort, "relay-port", 0, "Relay server TCP port")
+	senderCmd.PersistentFlags().BoolVar(&senderTLS, "tls", false, "connect to the relay over TLS")
+	senderCmd.PersistentFlags().StringVar(&senderToken, "token", "", "optional token to send in hello message")
+	senderCmd.PersistentFlags().StringVar(&senderProfile, "profile", "", "profile name to use from config file")
 	senderCmd.Flags().BoolVar(&senderInteractive, "interactive", false, "interactive mode")
 	senderCmd.Flags().StringVar(&senderKeepaliveTimeout, "keepalive", "", "keepalive timeout (e.g., 30s, 1m)")
 	senderCmd.Flags().StringVar(&senderIdentity, "identity", "", "sender identity label to display at receiver")
-	senderCmd.Flags().StringVar(&senderToken, "token", "", "optional token to send in hello message")
-	senderCmd.Flags().StringVar(&senderProfile, "profile", "", "profile name to use from config file")
 	senderCmd.Flags().BoolVar(&senderMenu, "menu", true, "show profile selection menu if profiles exist")
 	senderCmd.Flags().BoolVar(&senderShell, "shell", false, "open a remote shell on the receiver (no TUI)")
-	_ = viper.BindPFlag("sender.code", senderCmd.Flags().Lookup("code"))
+	_ = viper.BindPFlag("sender.code", senderCmd.PersistentFlags().Lookup("code"))
 	_ = viper.BindEnv("sender.code", "SSH_PORTAL_SENDER_CODE")
+	senderCmd.AddCommand(senderInspectCmd)
 }
@@ -16,6 +16,7 @@ type Profile struct {
 	Description string              `yaml:"description,omitempty"`
 	Relay       string              `yaml:"relay,omitempty"`
 	RelayPort   int                 `yaml:"relay-port,omitempty"`
+	TLS         *bool               `yaml:"tls,omitempty"`
 	Interactive *bool               `yaml:"interactive,omitempty"`
 	Keepalive   string              `yaml:"keepalive,omitempty"`
 	Identity    string              `yaml:"identity,omitempty"`
@@ -28,6 +29,7 @@ type Profile struct {
 type SenderConfig struct {
 	Relay       string    `yaml:"relay,omitempty"`
 	RelayPort   int       `yaml:"relay-port,omitempty"`
+	TLS         *bool     `yaml:"tls,omitempty"`
 	Interactive *bool     `yaml:"interactive,omitempty"`
 	Keepalive   string    `yaml:"keepalive,omitempty"`
 	Identity    string    `yaml:"identity,omitempty"`
@@ -39,6 +41,7 @@ type SenderConfig struct {
 type Config struct {
 	Relay       string
 	RelayPort   int
+	TLS         bool // the relay listener requires TLS
 	Interactive bool
 	Keepalive   time.Duration
 	Identity    string
@@ -68,6 +71,9 @@ func MergeConfig(topLevel *SenderConfig, profile *Profile) *Config {
 		if topLevel.RelayPort > 0 {
 			cfg.RelayPort = topLevel.RelayPort
 		}
+		if topLevel.TLS != nil {
+			cfg.TLS = *topLevel.TLS
+		}
 		if topLevel.Interactive != nil {
 			cfg.Interactive = *topLevel.Interactive
 		}
@@ -92,6 +98,9 @@ func MergeConfig(topLevel *SenderConfig, profile *Profile) *Config {
 		if profile.RelayPort > 0 {
 			cfg.RelayPort = profile.RelayPort
 		}
+		if profile.TLS != nil {
+			cfg.TLS = *profile.TLS
+		}
 		if profile.Interactive != nil {
 			cfg.Interactive = *profile.Interactive
 		}
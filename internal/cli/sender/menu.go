@@ -1,9 +1,9 @@
 package sender
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"regexp"
 
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
@@ -11,6 +11,8 @@ import (
 	"github.com/charmbracelet/huh"
 	"github.com/charmbracelet/lipgloss"
 	zone "github.com/lrstanley/bubblezone"
+
+	"ssh-portal/internal/cli/usercode"
 )
 
 var menuDocStyle = lipgloss.NewStyle().Margin(1, 2)
@@ -40,6 +42,23 @@ type profileMenuModel struct {
 	quitting     bool
 	needsCode    bool
 	formActive   bool
+
+	// What the code points to on the relay of the highlighted profile
+	givenCode    string      // code from the command line, if any
+	inspect      InspectFunc // nil: don't inspect
+	inspectAsked string      // profile and code last inspected
+	inspectShown string      // profile and code inspectLine is about
+	inspectLine  string
+
+	suggestion string // correction of the code last submitted, if it was invalid
+}
+
+// InspectFunc inspects code on the relay of the named profile ("" for none)
+type InspectFunc func(profile, code string) (*InspectResult, error)
+
+// inspectedMsg carries the result of an inspection to the menu
+type inspectedMsg struct {
+	key, line string
 }
 
 type profileMenuKeyMap struct {
@@ -120,7 +139,8 @@ func (d *markedDelegate) Render(w io.Writer, m list.Model, index int, listItem l
 	io.WriteString(w, zone.Mark(it.id, row))
 }
 
-func newProfileMenuModel(profiles []Profile, needsCode bool) *profileMenuModel {
+func newProfileMenuModel(profiles []Profile, code string, inspect InspectFunc) *profileMenuModel {
+	needsCode := code == ""
 	items := make([]list.Item, 0, len(profiles)+1)
 
 	// Add "none" option first
@@ -168,6 +188,8 @@ func newProfileMenuModel(profiles []Profile, needsCode bool) *profileMenuModel {
 		quitting:   false,
 		needsCode:  needsCode,
 		formActive: false, // Start with list focused, user can tab to form
+		givenCode:  code,
+		inspect:    inspect,
 	}
 
 	// Set "none" as the default selected item
@@ -179,22 +201,15 @@ func newProfileMenuModel(profiles []Profile, needsCode bool) *profileMenuModel {
 				huh.NewInput().
 					Title("Connection Code").
 					Description("Enter the connection code").
-					Placeholder("series-spell-lava-then-038-8307").
+					Placeholder("series-spell-lava-then-stove-038-8307").
 					Value(&pm.codeFormData.Code).
 					Validate(func(s string) error {
 						if s == "" {
 							return fmt.Errorf("code is required")
 						}
-						// Validate format: word-word-word-word-123-4567
-						pattern := `^[a-zA-Z0-9]+-[a-zA-Z0-9]+-[a-zA-Z0-9]+-[a-zA-Z0-9]+-\d{3}-\d{4}$`
-						matched, err := regexp.MatchString(pattern, s)
-						if err != nil {
-							return fmt.Errorf("invalid code format: %v", err)
-						}
-						if !matched {
-							return fmt.Errorf("code must be in format: word-word-word-word-123-4567")
-						}
-						return nil
+						// Format and checksum only; the "did you mean" hint is
+						// looked for on enter, see update
+						return usercode.CheckUserCode(s)
 					}),
 			),
 		).WithWidth(80)
@@ -205,12 +220,73 @@ func newProfileMenuModel(profiles []Profile, needsCode bool) *profileMenuModel {
 
 func (m *profileMenuModel) Init() tea.Cmd {
 	if m.form != nil {
-		return m.form.Init()
+		return tea.Batch(m.form.Init(), m.inspectCmd())
 	}
-	return nil
+	return m.inspectCmd()
 }
 
 func (m *profileMenuModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(inspectedMsg); ok {
+		m.inspectShown, m.inspectLine = msg.key, msg.line
+		return m, nil
+	}
+	model, cmd := m.update(msg)
+	if m.quitting || m.code != "" {
+		return model, cmd
+	}
+	return model, tea.Batch(cmd, m.inspectCmd())
+}
+
+// currentTarget returns the profile and code the menu would connect with now;
+// code is empty until a valid one is entered
+func (m *profileMenuModel) currentTarget() (profile, code string) {
+	profile = m.selected
+	if !m.formActive {
+		if item, ok := m.list.SelectedItem().(profileMenuItem); ok {
+			profile = item.name
+		}
+	}
+	if profile == "none" {
+		profile = ""
+	}
+	code = m.givenCode
+	if code == "" {
+		code = m.codeFormData.Code
+	}
+	if usercode.CheckUserCode(code) != nil {
+		return profile, ""
+	}
+	return profile, code
+}
+
+// inspectCmd inspects the current code on the relay of the current profile,
+// unless that was done already
+func (m *profileMenuModel) inspectCmd() tea.Cmd {
+	profile, code := m.currentTarget()
+	if m.inspect == nil || code == "" {
+		return nil
+	}
+	key := profile + "\x00" + code
+	if key == m.inspectAsked {
+		return nil
+	}
+	m.inspectAsked = key
+	inspect := m.inspect
+	return func() tea.Msg {
+		r, err := inspect(profile, code)
+		switch {
+		case errors.Is(err, ErrInspectUnsupported):
+			return inspectedMsg{key, ""}
+		case err != nil:
+			return inspectedMsg{key, fmt.Sprintf("can't inspect the code: %v", err)}
+		case !r.Exists:
+			return inspectedMsg{key, r.String()}
+		}
+		return inspectedMsg{key, "connecting to: " + r.String()}
+	}
+}
+
+func (m *profileMenuModel) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmds []tea.Cmd
 
 	// 1) Global / sizing first
@@ -263,6 +339,14 @@ func (m *profileMenuModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	// 2) If the form has focus, forward *every* msg to it (keys, mouse, internal)
 	if m.form != nil && m.formActive {
+		// Looking for a correction is too slow for every keystroke, so it
+		// is only done when an invalid code is submitted
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			m.suggestion = ""
+			if msg.String() == "enter" && m.codeFormData.Code != "" && usercode.CheckUserCode(m.codeFormData.Code) != nil {
+				m.suggestion = usercode.Suggest(m.codeFormData.Code)
+			}
+		}
 		form, cmd := m.form.Update(msg)
 		if f, ok := form.(*huh.Form); ok {
 			m.form = f
@@ -428,6 +512,11 @@ func (m *profileMenuModel) View() string {
 		views = append(views, focusBorderStyle.Render(listView))
 	}
 
+	// What the code points to, once the relay of the highlighted profile answered
+	if profile, code := m.currentTarget(); code != "" && m.inspectShown == profile+"\x00"+code && m.inspectLine != "" {
+		views = append(views, lipgloss.NewStyle().Foreground(lipgloss.Color("201")).Padding(0, 2).Render(m.inspectLine))
+	}
+
 	// Always show form below if code is needed
 	if m.form != nil {
 		formView := m.form.View()
@@ -438,6 +527,9 @@ func (m *profileMenuModel) View() string {
 			// Form is not focused
 			views = append(views, unfocusBorderStyle.Render(formView))
 		}
+		if m.suggestion != "" {
+			views = append(views, lipgloss.NewStyle().Foreground(lipgloss.Color("201")).Padding(0, 2).Render(fmt.Sprintf("Did you mean %s?", m.suggestion)))
+		}
 		// Help hint
 		hint := lipgloss.NewStyle().Faint(true).Render("(tab to switch focus between list and code form)")
 		views = append(views, "", hint)
@@ -453,15 +545,17 @@ type SelectProfileResult struct {
 }
 
 // SelectProfile shows a menu to select a profile from the given profiles
-// If needsCode is true, also shows a form to enter the connection code
+// If code is empty, also shows a form to enter the connection code
+// If inspect is set, shows what the code points to on the highlighted profile's relay
 // Returns the selected profile name and code, or empty strings if cancelled/no profiles
-func SelectProfile(profiles []Profile, needsCode bool) (*SelectProfileResult, error) {
+func SelectProfile(profiles []Profile, code string, inspect InspectFunc) (*SelectProfileResult, error) {
+	needsCode := code == ""
 	if len(profiles) == 0 {
 		return nil, fmt.Errorf("no profiles available")
 	}
 
 	zone.NewGlobal()
-	p := tea.NewProgram(newProfileMenuModel(profiles, needsCode), tea.WithAltScreen(), tea.WithMouseCellMotion())
+	p := tea.NewProgram(newProfileMenuModel(profiles, code, inspect), tea.WithAltScreen(), tea.WithMouseCellMotion())
 	finalModel, err := p.Run()
 	if err != nil {
 		return nil, err
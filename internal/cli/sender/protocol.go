@@ -4,12 +4,15 @@ import (
 	"bufio"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"ssh-portal/internal/cli/framing"
 	"ssh-portal/internal/cli/usercode"
+	"ssh-portal/internal/version"
 
 	//"strconv"
 	"strings"
@@ -30,26 +33,44 @@ var debugProtocol = os.Getenv("SSH_PORTAL_DEBUG") != ""
 
 // JSONHello is the JSON hello message sent to the relay before SSH starts
 type JSONHello struct {
-	Msg    string      `json:"msg"`
-	Role   string      `json:"role"`
-	Code   string      `json:"code,omitempty"`
-	RID    string      `json:"rid,omitempty"`
-	Sender *SenderInfo `json:"sender,omitempty"`
-	Token  string      `json:"token,omitempty"`
+	Msg     string      `json:"msg"`
+	Role    string      `json:"role"`
+	Code    string      `json:"code,omitempty"`
+	RID     string      `json:"rid,omitempty"`
+	Sender  *SenderInfo `json:"sender,omitempty"`
+	Token   string      `json:"token,omitempty"`
+	Version string      `json:"version,omitempty"` // our software version
+	Caps    []string    `json:"caps,omitempty"`    // our capabilities
 }
 
 // JSONOKResponse is the JSON success response sent back by the relay
 type JSONOKResponse struct {
-	Msg string `json:"msg"`
-	FP  string `json:"fp"`
-	Exp int64  `json:"exp"`
-	Alg string `json:"alg"`
+	Msg     string   `json:"msg"`
+	FP      string   `json:"fp"`
+	Exp     int64    `json:"exp"`
+	Alg     string   `json:"alg"`
+	Version string   `json:"version,omitempty"` // relay version (absent on old relays)
+	Caps    []string `json:"caps,omitempty"`    // capabilities shared with the relay
+	// Session limits in seconds (framing.CapSessionLimits)
+	MaxDuration int `json:"max_duration,omitempty"`
+	IdleTimeout int `json:"idle_timeout,omitempty"`
 }
 
 // JSONErrorResponse is the JSON error response sent back by the relay
 type JSONErrorResponse struct {
-	Msg   string `json:"msg"`
-	Error string `json:"error"`
+	Msg        string `json:"msg"`
+	Error      string `json:"error"`
+	Message    string `json:"message,omitempty"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+// Capabilities lists the protocol features this sender supports.
+var Capabilities = []string{framing.CapErrorMessage, framing.CapSessionLimits, framing.CapRetryAfter}
+
+// okSchemas are the relay replies a sender accepts after its hello
+var okSchemas = framing.Schemas{
+	"ok":    {Required: []string{"msg", "fp"}, Optional: []string{"exp", "alg", "version", "caps", "max_duration", "idle_timeout"}},
+	"error": {Required: []string{"msg", "error"}, Optional: []string{"message", "retry_after"}},
 }
 
 type ConnectionResult struct {
@@ -57,6 +78,7 @@ type ConnectionResult struct {
 	SSHConn      net.Conn // reader positioned at SSH banner
 	Fingerprint  string   // from kv["fp"]
 	ClientConfig *ssh.ClientConfig
+	Limits       framing.SessionLimits // limits the relay puts on the session
 }
 
 // SenderInfo contains optional metadata about the sender advertised in hello
@@ -67,12 +89,15 @@ type SenderInfo struct {
 
 // --- Entry point ---
 
-func ConnectAndHandshake(relayAddr, code string, senderKASeconds int, senderIdentity string, token string) (*ConnectionResult, error) {
+func ConnectAndHandshake(relayAddr string, useTLS bool, code string, senderKASeconds int, senderIdentity string, token string) (*ConnectionResult, error) {
 	// Parse code to separate relay code from local secret
-	relayCode, _, fullCode, _ := usercode.ParseUserCode(code)
+	relayCode, _, fullCode, err := usercode.ParseUserCode(code)
+	if err != nil {
+		return nil, err
+	}
 
 	// 1) Connect (with timeout)
-	sock, err := net.DialTimeout("tcp", relayAddr, 10*time.Second)
+	sock, err := framing.DialRelay(relayAddr, useTLS, 10*time.Second)
 	if err != nil {
 		return nil, fmt.Errorf("connect relay: %w", err)
 	}
@@ -81,11 +106,11 @@ func ConnectAndHandshake(relayAddr, code string, senderKASeconds int, senderIden
 	_ = sock.SetDeadline(time.Now().Add(20 * time.Second))
 
 	// 2) Send version + JSON hello (only relay code to relay)
-	if _, err := fmt.Fprintln(sock, "ssh-relay/1.0"); err != nil {
+	if _, err := fmt.Fprintln(sock, framing.VersionLine); err != nil {
 		sock.Close()
 		return nil, fmt.Errorf("send version: %w", err)
 	}
-	hello := JSONHello{Msg: "hello", Role: "sender", Code: relayCode}
+	hello := JSONHello{Msg: "hello", Role: "sender", Code: relayCode, Version: version.String(), Caps: Capabilities}
 	// Attach optional token
 	if token != "" {
 		hello.Token = token
@@ -107,41 +132,42 @@ func ConnectAndHandshake(relayAddr, code string, senderKASeconds int, senderIden
 
 	// 3) Read JSON ok, then a blank line; leave SSH banner buffered
 	br := bufio.NewReader(sock)
-	line, err := br.ReadString('\n')
+	msg, line, err := okSchemas.ReadFrame(br)
 	if err != nil {
 		sock.Close()
 		return nil, fmt.Errorf("read ok: %w", err)
 	}
-	if debugProtocol && line != "" {
-		fmt.Fprintf(os.Stderr, "\n=== Relay JSON Response ===\n%s=== END ===\n\n", line)
+	if debugProtocol {
+		fmt.Fprintf(os.Stderr, "\n=== Relay JSON Response ===\n%s\n=== END ===\n\n", line)
 	}
-	var ok JSONOKResponse
-	if err := json.Unmarshal([]byte(strings.TrimSpace(line)), &ok); err != nil {
+	if msg != "ok" {
+		// Decode error response for a better message
+		var er JSONErrorResponse
+		_ = framing.DecodeStrict(line, &er)
 		sock.Close()
-		return nil, fmt.Errorf("decode response: %w", err)
+		return nil, &framing.RemoteError{Code: er.Error, Message: er.Message, RetryAfter: er.RetryAfter}
 	}
-	if ok.Msg != "ok" {
-		// Try to decode error response for a better message
-		var er JSONErrorResponse
-		_ = json.Unmarshal([]byte(strings.TrimSpace(line)), &er)
+	var ok JSONOKResponse
+	if err := framing.DecodeStrict(line, &ok); err != nil {
 		sock.Close()
-		if er.Error != "" {
-			return nil, fmt.Errorf("relay error: %s", er.Error)
-		}
-		return nil, fmt.Errorf("unexpected response: %s", strings.TrimSpace(line))
+		return nil, fmt.Errorf("decode response: %w", err)
 	}
 
 	// Expect a single blank line before SSH banner
-	blank, err := br.ReadString('\n')
+	blank, err := framing.ReadLine(br, framing.MaxVersionLine)
 	if err != nil {
 		sock.Close()
 		return nil, fmt.Errorf("read blank line: %w", err)
 	}
-	if strings.TrimSpace(blank) != "" {
+	if strings.TrimSpace(string(blank)) != "" {
 		sock.Close()
 		return nil, fmt.Errorf("expected blank line before SSH banner")
 	}
 
+	if ok.Version != "" {
+		log.Printf("Relay version %s, negotiated capabilities: %v", ok.Version, ok.Caps)
+	}
+
 	fp := strings.TrimSpace(ok.FP)
 	if fp == "" {
 		sock.Close()
@@ -198,6 +224,10 @@ func ConnectAndHandshake(relayAddr, code string, senderKASeconds int, senderIden
 		SSHConn:      sshConn,
 		Fingerprint:  fp,
 		ClientConfig: cfg,
+		Limits: framing.SessionLimits{
+			MaxDuration: time.Duration(ok.MaxDuration) * time.Second,
+			IdleTimeout: time.Duration(ok.IdleTimeout) * time.Second,
+		},
 	}, nil
 }
 
@@ -211,3 +241,97 @@ type prebufConn struct {
 }
 
 func (c *prebufConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// --- Invite inspection ---
+
+// JSONInspect asks the relay what an invite code points to
+type JSONInspect struct {
+	Msg     string   `json:"msg"` // "inspect"
+	Role    string   `json:"role"`
+	Code    string   `json:"code"`
+	Token   string   `json:"token,omitempty"`
+	Version string   `json:"version,omitempty"`
+	Caps    []string `json:"caps,omitempty"`
+}
+
+// inspectSchemas are the relay replies a sender accepts after an inspect
+var inspectSchemas = framing.Schemas{
+	"inspect_ok": framing.InspectOKSchema,
+	"error":      okSchemas["error"],
+}
+
+// InspectResult is what a relay knows about an invite code
+type InspectResult struct {
+	Exists    bool
+	Waiting   bool      // a receiver is attached and answering
+	ExpiresAt time.Time // zero unless Exists
+	Label     string    // what the receiver says it is, if it said
+}
+
+// ErrInspectUnsupported is returned by relays that predate invite inspection
+var ErrInspectUnsupported = errors.New("the relay does not support inspecting invites")
+
+// InspectInvite asks the relay at relayAddr what code points to, without
+// using up the invite. Only the relay part of the code is sent.
+func InspectInvite(relayAddr string, useTLS bool, code string, token string) (*InspectResult, error) {
+	relayCode, _, _, err := usercode.ParseUserCode(code)
+	if err != nil {
+		return nil, err
+	}
+	sock, err := framing.DialRelay(relayAddr, useTLS, 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("connect relay: %w", err)
+	}
+	defer sock.Close()
+	_ = sock.SetDeadline(time.Now().Add(20 * time.Second))
+
+	if _, err := fmt.Fprintln(sock, framing.VersionLine); err != nil {
+		return nil, fmt.Errorf("send version: %w", err)
+	}
+	req := JSONInspect{Msg: "inspect", Role: "sender", Code: relayCode, Token: token, Version: version.String(), Caps: Capabilities}
+	if err := json.NewEncoder(sock).Encode(req); err != nil {
+		return nil, fmt.Errorf("send inspect: %w", err)
+	}
+	msg, line, err := inspectSchemas.ReadFrame(bufio.NewReader(sock))
+	if err != nil {
+		return nil, fmt.Errorf("read inspect reply: %w", err)
+	}
+	if msg == "error" {
+		var er JSONErrorResponse
+		_ = framing.DecodeStrict(line, &er)
+		if er.Error == framing.CodeUnknownMsg {
+			return nil, ErrInspectUnsupported
+		}
+		return nil, &framing.RemoteError{Code: er.Error, Message: er.Message, RetryAfter: er.RetryAfter}
+	}
+	var ok framing.InspectOK
+	if err := framing.DecodeStrict(line, &ok); err != nil {
+		return nil, fmt.Errorf("decode inspect reply: %w", err)
+	}
+	res := &InspectResult{Exists: ok.Exists, Waiting: ok.Waiting, Label: framing.CleanLabel(ok.Label)}
+	if ok.Exists && ok.Exp != 0 {
+		res.ExpiresAt = time.Unix(ok.Exp, 0)
+	}
+	return res, nil
+}
+
+// String describes the invite in a line, e.g.
+// "build-server-3 (expires in 7m)"
+func (r *InspectResult) String() string {
+	if !r.Exists {
+		return "no such invite (wrong code or relay, or expired)"
+	}
+	name := r.Label
+	if name == "" {
+		name = "unnamed receiver"
+	}
+	left := max(time.Until(r.ExpiresAt).Round(time.Second), 0)
+	state := "expires in " + left.String()
+	if left >= time.Minute {
+		state = "expires in " + strings.TrimSuffix(left.Round(time.Minute).String(), "0s")
+	}
+	if !r.Waiting {
+		state = "receiver not connected, " + state
+	}
+	return fmt.Sprintf("%s (%s)", name, state)
+}
@@ -2,16 +2,22 @@ package sender
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"os"
+	"os/signal"
 	"strconv"
 	"sync"
+	"syscall"
 	"time"
 
 	"golang.org/x/crypto/ssh"
 
+	"ssh-portal/internal/cli/framing"
+	"ssh-portal/internal/cli/usercode"
 	"ssh-portal/internal/cli/validate"
 	"ssh-portal/internal/version"
 )
@@ -32,7 +38,7 @@ type activeForward struct {
 
 // --- Main client ---
 
-func startSSHClient(ctx context.Context, relayHost string, relayPort int, code string, keepaliveTimeout time.Duration, identity string, token string) error {
+func startSSHClient(ctx context.Context, relayHost string, relayPort int, useTLS bool, code string, keepaliveTimeout time.Duration, identity string, token string) error {
 	// Build relay TCP address
 	relayTCP := net.JoinHostPort(relayHost, strconv.Itoa(relayPort))
 
@@ -41,7 +47,7 @@ func startSSHClient(ctx context.Context, relayHost string, relayPort int, code s
 
 	// Connect and perform protocol handshake
 	// Provide hello metadata: keepalive seconds and optional identity
-	result, err := ConnectAndHandshake(relayTCP, code, int(keepaliveTimeout/time.Second), identity, token)
+	result, err := ConnectAndHandshake(relayTCP, useTLS, code, int(keepaliveTimeout/time.Second), identity, token)
 	if err != nil {
 		SetStatus("failed", fmt.Sprintf("Handshake failed: %v", err))
 		log.Printf("handshake failed: %v", err)
@@ -53,8 +59,18 @@ func startSSHClient(ctx context.Context, relayHost string, relayPort int, code s
 	log.Printf("Connected to relay: %s", relayTCP)
 	SetStatus("connecting", "Establishing SSH connection...")
 
+	// Follow the relay's session limits on our own traffic for the countdown
+	sshConn := result.SSHConn
+	limits := framing.NewSessionTimer(result.Limits, time.Now())
+	if !result.Limits.IsZero() {
+		log.Printf("Relay session limits: %v", result.Limits)
+		sshConn = limits.Conn(sshConn)
+	} else {
+		limits = nil
+	}
+
 	// Establish SSH connection
-	cc, chans, reqs, err := ssh.NewClientConn(result.SSHConn, "paired", result.ClientConfig)
+	cc, chans, reqs, err := ssh.NewClientConn(sshConn, "paired", result.ClientConfig)
 	if err != nil {
 		// Close connection on error since SSH client creation failed
 		result.Conn.Close()
@@ -65,14 +81,22 @@ func startSSHClient(ctx context.Context, relayHost string, relayPort int, code s
 
 	log.Printf("SSH connection established with receiver via relay: %s", relayTCP)
 
-	client := ssh.NewClient(cc, chans, reqs)
+	closeReason := &framing.CloseRecorder{}
+	client := ssh.NewClient(cc, chans, watchDisconnect(ctx, reqs, closeReason))
 
 	// Store SSH client for dynamic port forward management
 	sshClientMu.Lock()
 	sshClient = client
 	sshClientMu.Unlock()
 
+	SetSessionLimits(limits)
 	SetStatus("connected", "SSH connection established")
+	sessionDone := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(sessionDone)
+	}()
+	go limits.Watch(sessionDone, func(msg string) { log.Printf("%s", msg) })
 
 	// Monitor connection for closure and send keepalives
 	keepaliveInterval := 5 * time.Second
@@ -89,7 +113,7 @@ func startSSHClient(ctx context.Context, relayHost string, relayPort int, code s
 				ok, _, err := client.SendRequest("keepalive@ssh-portal", true, nil)
 				if err != nil || !ok {
 					log.Printf("Keepalive failed, connection closed: %v", err)
-					SetStatus("failed", fmt.Sprintf("Connection closed: %v", err))
+					setClosedStatus(closeReason, err)
 					// Clear SSH client on connection failure
 					sshClientMu.Lock()
 					sshClient = nil
@@ -115,6 +139,7 @@ func startSSHClient(ctx context.Context, relayHost string, relayPort int, code s
 			case <-ticker.C:
 				if time.Since(lastKeepalive) > keepaliveTimeout {
 					log.Printf("Keepalive timeout, connection appears dead")
+					_ = framing.SendDisconnect(client, framing.ReasonKeepaliveTimeout)
 					SetStatus("failed", "Connection timeout")
 					// Clear SSH client
 					sshClientMu.Lock()
@@ -143,12 +168,60 @@ func startSSHClient(ctx context.Context, relayHost string, relayPort int, code s
 	closeAllReverseForwards()
 
 	if clientToClose != nil {
+		// Let the receiver know the session was ended on purpose
+		_ = framing.SendDisconnect(clientToClose, framing.ReasonSenderClosed)
 		clientToClose.Close()
 	}
 
 	return nil
 }
 
+// watchDisconnect records the reason carried by a disconnect@ssh-portal request
+// from the receiver and passes every other global request on to the ssh.Client.
+// When the connection ends it updates the status with the recorded reason.
+func watchDisconnect(ctx context.Context, in <-chan *ssh.Request, closeReason *framing.CloseRecorder) <-chan *ssh.Request {
+	out := make(chan *ssh.Request)
+	go func() {
+		defer close(out)
+		for req := range in {
+			if req.Type != framing.DisconnectRequest {
+				out <- req
+				continue
+			}
+			reason, err := framing.ParseDisconnect(req.Payload)
+			if err != nil {
+				log.Printf("bad %s payload: %v", framing.DisconnectRequest, err)
+				continue
+			}
+			log.Printf("Receiver disconnecting: %v", reason)
+			closeReason.Record(reason)
+		}
+		// All requests are delivered before the channel closes, so a reason
+		// sent just before the connection dropped is already recorded here.
+		// Leave the status alone if we closed on purpose or already explained why.
+		if ctx.Err() == nil && GetState().Status == "connected" {
+			setClosedStatus(closeReason, io.EOF)
+		}
+	}()
+	return out
+}
+
+// setClosedStatus reports why the SSH connection ended: the reason the receiver
+// gave, or err when it closed without one.
+func setClosedStatus(closeReason *framing.CloseRecorder, err error) {
+	// A limit that ran out explains a connection the relay closed
+	GetState().Limits.RecordExpiry(closeReason)
+	if reason := closeReason.Reason(); reason != nil {
+		SetStatus("closed", "Session ended: "+reason.Error())
+		return
+	}
+	if errors.Is(err, io.EOF) {
+		SetStatus("failed", "Connection lost: the relay or receiver closed the connection without a reason")
+		return
+	}
+	SetStatus("failed", fmt.Sprintf("Connection closed: %v", err))
+}
+
 // createLocalForward creates a new local port forward and immediately starts forwarding traffic
 func createLocalForward(pfID, listen, target string) error {
 	// Validate pfID
@@ -321,8 +394,18 @@ func RunWithConfig(relayHost string, relayPort int, code string, interactive boo
 	if code == "" {
 		return fmt.Errorf("code is required")
 	}
+	// Catch typos locally (with a "did you mean" hint) before contacting the relay
+	if _, _, _, err := usercode.ParseUserCode(code); err != nil {
+		return err
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	// The relay listener may require TLS
+	useTLS := cfg != nil && cfg.TLS
+
+	// SIGINT/SIGTERM shut down like quitting the TUI, so the peer learns why
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(sigCtx)
 	defer cancel()
 
 	// Shell mode: skip TUI, connect, open remote shell, exit when done
@@ -330,7 +413,7 @@ func RunWithConfig(relayHost string, relayPort int, code string, interactive boo
 		// Start SSH client in a goroutine
 		errChan := make(chan error, 1)
 		go func() {
-			errChan <- startSSHClient(ctx, relayHost, relayPort, code, keepaliveTimeout, identity, token)
+			errChan <- startSSHClient(ctx, relayHost, relayPort, useTLS, code, keepaliveTimeout, identity, token)
 		}()
 
 		// Apply port forwards from config after SSH connection is established
@@ -347,6 +430,7 @@ func RunWithConfig(relayHost string, relayPort int, code string, interactive boo
 		// Run shell (blocks until shell exits)
 		shellErr := NewShellCmd(client).Run()
 		cancel()
+		waitForShutdown(errChan)
 		if shellErr != nil {
 			return fmt.Errorf("shell session ended: %w", shellErr)
 		}
@@ -366,7 +450,7 @@ func RunWithConfig(relayHost string, relayPort int, code string, interactive boo
 	// Start SSH client in a goroutine
 	errChan := make(chan error, 1)
 	go func() {
-		errChan <- startSSHClient(ctx, relayHost, relayPort, code, keepaliveTimeout, identity, token)
+		errChan <- startSSHClient(ctx, relayHost, relayPort, useTLS, code, keepaliveTimeout, identity, token)
 	}()
 
 	// Apply port forwards from config after SSH connection is established
@@ -382,6 +466,7 @@ func RunWithConfig(relayHost string, relayPort int, code string, interactive boo
 		if tuiDone != nil {
 			<-tuiDone
 		}
+		waitForShutdown(errChan)
 		return nil
 	case err := <-errChan:
 		// Connection failed
@@ -399,6 +484,15 @@ func RunWithConfig(relayHost string, relayPort int, code string, interactive boo
 	}
 }
 
+// waitForShutdown gives startSSHClient a moment to tell the receiver we are
+// leaving before the process exits.
+func waitForShutdown(errChan <-chan error) {
+	select {
+	case <-errChan:
+	case <-time.After(2 * time.Second):
+	}
+}
+
 // applyConfigPortForwards applies port forwards from configuration once SSH is connected
 func applyConfigPortForwards(ctx context.Context, cfg *Config) {
 	// Wait for SSH connection to be established (poll until client is available)
@@ -5,13 +5,17 @@ import (
 
 	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/lipgloss"
+
+	"ssh-portal/internal/cli/framing"
+	"ssh-portal/internal/cli/tui"
 )
 
 // SenderState holds the current sender state
 type SenderState struct {
 	mu      sync.RWMutex
-	Status  string // "connecting", "connected", "failed"
-	Message string // Optional status message
+	Status  string                // "connecting", "connected", "closed", "failed"
+	Message string                // Optional status message
+	Limits  *framing.SessionTimer // Session limits set by the relay, nil if none
 }
 
 var (
@@ -27,6 +31,7 @@ func GetState() *SenderState {
 	return &SenderState{
 		Status:  currentState.Status,
 		Message: currentState.Message,
+		Limits:  currentState.Limits,
 	}
 }
 
@@ -38,6 +43,13 @@ func SetStatus(status, message string) {
 	currentState.Message = message
 }
 
+// SetSessionLimits stores the limits of the current session for the countdown
+func SetSessionLimits(t *framing.SessionTimer) {
+	currentState.mu.Lock()
+	defer currentState.mu.Unlock()
+	currentState.Limits = t
+}
+
 // RenderStateView renders the sender state (connection status) for the right side
 func RenderStateView(width int, connectingSp spinner.Model, connectedSp spinner.Model) string {
 	state := GetState()
@@ -66,6 +78,7 @@ func RenderStateView(width int, connectingSp spinner.Model, connectedSp spinner.
 				Foreground(lipgloss.Color("75")) // Bluish color
 			content += "\n" + messageStyle.Render(state.Message)
 		}
+		content += tui.RenderSessionLimits(state.Limits)
 	case "failed":
 		failedStyle := lipgloss.NewStyle().
 			Foreground(lipgloss.Color("160")). // Red shade
@@ -77,6 +90,17 @@ func RenderStateView(width int, connectingSp spinner.Model, connectedSp spinner.
 			content += "\nError: " + errorStyle.Render(state.Message)
 		}
 		content += "\n\nPress 'q' to quit"
+	case "closed":
+		closedStyle := lipgloss.NewStyle().
+			Foreground(lipgloss.Color("220")). // Yellow shade
+			Bold(true)
+		content = "\nStatus: " + closedStyle.Render("Closed")
+		if state.Message != "" {
+			messageStyle := lipgloss.NewStyle().
+				Foreground(lipgloss.Color("75")) // Bluish color
+			content += "\n" + messageStyle.Render(state.Message)
+		}
+		content += "\n\nPress 'q' to quit"
 	default:
 		content = "\nStatus: Unknown"
 	}
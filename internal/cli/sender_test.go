@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"ssh-portal/internal/cli/sender"
+	"ssh-portal/internal/cli/usercode"
+)
+
+// newSenderTestCmd returns a command with the sender's relay flags set as in
+// args, and restores the flag variables when t ends.
+func newSenderTestCmd(t *testing.T, args ...string) *cobra.Command {
+	t.Helper()
+	host, port, tls, token := senderRelayHost, senderRelayPort, senderTLS, senderToken
+	t.Cleanup(func() {
+		senderRelayHost, senderRelayPort, senderTLS, senderToken = host, port, tls, token
+	})
+	cmd := &cobra.Command{}
+	cmd.Flags().StringVar(&senderRelayHost, "relay", "", "")
+	cmd.Flags().IntVar(&senderRelayPort, "relay-port", 0, "")
+	cmd.Flags().BoolVar(&senderTLS, "tls", false, "")
+	cmd.Flags().StringVar(&senderToken, "token", "", "")
+	if err := cmd.Flags().Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+func TestSenderRelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      sender.Config
+		link     *usercode.ShareLink
+		args     []string
+		wantHost string
+		wantPort int
+		wantTLS  bool
+	}{
+		{"config only", sender.Config{Relay: "relay.example", RelayPort: 4430, TLS: true}, nil, nil, "relay.example", 4430, true},
+		{"link", sender.Config{Relay: "relay.example", RelayPort: 4430}, &usercode.ShareLink{RelayHost: "other.example", RelayPort: 443, TLS: true}, nil, "other.example", 443, true},
+		{"link without port", sender.Config{Relay: "relay.example", RelayPort: 4430}, &usercode.ShareLink{RelayHost: "other.example"}, nil, "other.example", 4430, false},
+		{"link can't turn TLS off", sender.Config{Relay: "relay.example", RelayPort: 4430, TLS: true}, &usercode.ShareLink{RelayHost: "relay.example"}, nil, "relay.example", 4430, true},
+		{"flags win over link", sender.Config{Relay: "relay.example", RelayPort: 4430}, &usercode.ShareLink{RelayHost: "other.example", RelayPort: 443, TLS: true}, []string{"--relay", "mine.example", "--relay-port", "2222", "--tls=false"}, "mine.example", 2222, false},
+	}
+	for _, tt := range tests {
+		cmd := newSenderTestCmd(t, tt.args...)
+		cfg := tt.cfg
+		host, port := senderRelay(cmd, &cfg, tt.link)
+		if host != tt.wantHost || port != tt.wantPort || cfg.TLS != tt.wantTLS {
+			t.Errorf("%s: got %s:%d tls=%v, want %s:%d tls=%v", tt.name, host, port, cfg.TLS, tt.wantHost, tt.wantPort, tt.wantTLS)
+		}
+	}
+}
+
+func TestSenderTokenFor(t *testing.T) {
+	cfg := sender.Config{Relay: "relay.example", RelayPort: 4430, Token: "configured"}
+	tests := []struct {
+		name      string
+		cfg       sender.Config
+		link      *usercode.ShareLink
+		args      []string
+		confirm   bool
+		want      string
+		wantAsked string // address confirm was asked about, "" for not asked
+	}{
+		{"no link", cfg, nil, nil, false, "configured", ""},
+		{"link to the configured relay", cfg, &usercode.ShareLink{RelayHost: "RELAY.example", RelayPort: 4430}, nil, false, "configured", ""},
+		{"link to another relay, declined", cfg, &usercode.ShareLink{RelayHost: "evil.example", RelayPort: 4430}, nil, false, "", "evil.example:4430"},
+		{"link to another port, confirmed", cfg, &usercode.ShareLink{RelayHost: "relay.example", RelayPort: 443}, nil, true, "configured", "relay.example:443"},
+		{"relay flag", cfg, &usercode.ShareLink{RelayHost: "evil.example"}, []string{"--relay", "mine.example"}, false, "configured", ""},
+		{"token flag", cfg, &usercode.ShareLink{RelayHost: "evil.example"}, []string{"--token", "flag"}, false, "flag", ""},
+		{"no configured token", sender.Config{Relay: "relay.example", RelayPort: 4430}, &usercode.ShareLink{RelayHost: "evil.example"}, nil, true, "", ""},
+	}
+	for _, tt := range tests {
+		cmd := newSenderTestCmd(t, tt.args...)
+		cfg := tt.cfg
+		host, port := senderRelay(cmd, &cfg, tt.link)
+		asked := ""
+		got := senderTokenFor(cmd, &cfg, tt.link, host, port, func(addr string) bool {
+			asked = addr
+			return tt.confirm
+		})
+		if got != tt.want || asked != tt.wantAsked {
+			t.Errorf("%s: got token %q, asked about %q; want %q, asked about %q", tt.name, got, asked, tt.want, tt.wantAsked)
+		}
+	}
+	// Without a way to ask, a link to another relay gets no token
+	cmd := newSenderTestCmd(t)
+	c := cfg
+	if got := senderTokenFor(cmd, &c, &usercode.ShareLink{RelayHost: "evil.example"}, "evil.example", 4430, nil); got != "" {
+		t.Errorf("nil confirm: got token %q, want none", got)
+	}
+}
@@ -0,0 +1,41 @@
+package tui
+
+import (
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"ssh-portal/internal/cli/framing"
+)
+
+// RenderSessionLimits renders a countdown line for every limit the relay puts
+// on the session, turning red and adding a warning in the last five minutes.
+// It returns "" if no limit applies.
+func RenderSessionLimits(t *framing.SessionTimer) string {
+	if t == nil || t.Limits.IsZero() {
+		return ""
+	}
+	now := time.Now()
+	line := func(label string, left time.Duration) string {
+		left = max(left, 0).Truncate(time.Second)
+		color := "75" // Bluish color
+		if left <= 5*time.Minute {
+			color = "196" // Bright red
+		}
+		return "\n" + label + lipgloss.NewStyle().Foreground(lipgloss.Color(color)).Render(left.String())
+	}
+	var s string
+	if t.Limits.MaxDuration > 0 {
+		s += line("Session ends in: ", t.MaxDurationLeft(now))
+	}
+	if t.Limits.IdleTimeout > 0 {
+		s += line("Idle timeout in: ", t.IdleLeft(now))
+	}
+	if reason, left, _ := t.Next(now); left > 0 && left <= 5*time.Minute {
+		warningStyle := lipgloss.NewStyle().
+			Foreground(lipgloss.Color("220")). // Yellow shade
+			Bold(true)
+		s += "\n" + warningStyle.Render(framing.LimitWarning(reason, left.Truncate(time.Second)))
+	}
+	return s
+}
@@ -0,0 +1,63 @@
+package tui
+
+import (
+	"os"
+	"strings"
+
+	"github.com/aymanbagabas/go-osc52/v2"
+	"github.com/charmbracelet/lipgloss"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// CopyToClipboard copies s to the system clipboard of the user's terminal using
+// the OSC52 escape sequence, which also works over SSH. Terminal multiplexers
+// need the sequence wrapped, so tmux and screen are detected from the environment.
+func CopyToClipboard(s string) error {
+	seq := osc52.New(s)
+	if os.Getenv("TMUX") != "" {
+		seq = seq.Tmux()
+	} else if strings.HasPrefix(os.Getenv("TERM"), "screen") {
+		seq = seq.Screen()
+	}
+	_, err := seq.WriteTo(os.Stderr)
+	return err
+}
+
+// RenderQRCode renders content as a QR code using half-block characters (two
+// modules per character cell), drawing light modules in the foreground color so
+// it scans on dark terminal backgrounds. It returns an error if content is too long.
+func RenderQRCode(content string) (string, error) {
+	qr, err := qrcode.New(content, qrcode.Low)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(qr.ToSmallString(false), "\n"), nil
+}
+
+// RenderShareView renders a full-screen panel with a QR code of link, the link
+// itself and a status line (e.g. whether the link was copied), centered in
+// width x height.
+func RenderShareView(width, height int, link, status string) string {
+	linkStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("220")). // Same accent as the user code
+		Bold(true)
+	infoStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("240"))
+
+	qr, err := RenderQRCode(link)
+	if err != nil {
+		qr = "QR code unavailable: " + err.Error()
+	} else if lipgloss.Width(qr) > width || lipgloss.Height(qr)+4 > height {
+		qr = "Terminal too small for the QR code"
+	}
+
+	content := lipgloss.JoinVertical(
+		lipgloss.Center,
+		qr,
+		"",
+		linkStyle.Width(width).Align(lipgloss.Center).Render(link),
+		infoStyle.Render(status),
+		infoStyle.Render("Press 's' or 'esc' to close"),
+	)
+	return lipgloss.Place(width, height, lipgloss.Center, lipgloss.Center, content)
+}
@@ -0,0 +1,35 @@
+package tui
+
+import (
+	"slices"
+	"strings"
+)
+
+// sparkBars are the sparkline levels, lowest first
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+// Sparkline renders the last width values (oldest first) as a bar per value,
+// scaled to the largest of them. Zero and negative values get the lowest bar,
+// and missing values on the left are blank.
+func Sparkline(values []float64, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	values = values[max(len(values)-width, 0):]
+	peak := 0.0
+	if len(values) > 0 {
+		peak = slices.Max(values)
+	}
+
+	var b strings.Builder
+	b.WriteString(strings.Repeat(" ", width-len(values)))
+	for _, v := range values {
+		level := 0
+		if v > 0 && peak > 0 {
+			// Anything above zero shows above the lowest bar
+			level = 1 + int(v/peak*float64(len(sparkBars)-2))
+		}
+		b.WriteRune(sparkBars[level])
+	}
+	return b.String()
+}
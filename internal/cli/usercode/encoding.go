@@ -0,0 +1,108 @@
+package usercode
+
+import (
+	"fmt"
+	"strings"
+
+	bip39 "github.com/tyler-smith/go-bip39/wordlists"
+)
+
+// Encoding turns the full code bytes into a human-readable user code and back.
+//
+// Every encoding carries the same payload (the relay code and the receiver code,
+// see PartBits) plus its own checksum, so a code can be moved between encodings
+// without changing what the relay and the receiver see.
+type Encoding interface {
+	// Name is the identifier used in configuration (e.g. "english", "numeric").
+	Name() string
+	// Encode formats the full code bytes of a words-strength code.
+	Encode(words int, full []byte) (string, error)
+	// Decode parses a user code and returns the full code bytes and its strength.
+	Decode(code string) (full []byte, words int, err error)
+	// Suggest returns the nearest valid code for a mistyped one, or "".
+	Suggest(code string) string
+	// Match scores how likely it is that the split code was written in this
+	// encoding; 0 means it cannot be.
+	Match(parts []string) int
+}
+
+// DefaultEncoding is the encoding used when none is configured.
+const DefaultEncoding = "english"
+
+var (
+	english = newWordlistEncoding("english", bip39.English, true)
+
+	// encodings lists the known encodings in detection order. English comes
+	// first so codes valid in several wordlists keep their historic meaning.
+	encodings = []Encoding{
+		english,
+		newWordlistEncoding("spanish", bip39.Spanish, false),
+		newWordlistEncoding("french", bip39.French, false),
+		newWordlistEncoding("italian", bip39.Italian, false),
+		newWordlistEncoding("czech", bip39.Czech, false),
+		newWordlistEncoding("japanese", bip39.Japanese, false),
+		newWordlistEncoding("korean", bip39.Korean, false),
+		numericEncoding{},
+		pgpEncoding{},
+	}
+)
+
+// Encodings returns the names of all supported encodings.
+func Encodings() []string {
+	names := make([]string, len(encodings))
+	for i, e := range encodings {
+		names[i] = e.Name()
+	}
+	return names
+}
+
+// LookupEncoding returns the encoding with the given name (case-insensitive).
+// An empty name selects DefaultEncoding.
+func LookupEncoding(name string) (Encoding, error) {
+	if name == "" {
+		name = DefaultEncoding
+	}
+	for _, e := range encodings {
+		if strings.EqualFold(e.Name(), name) {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown code encoding %q (supported: %s)", name, strings.Join(Encodings(), ", "))
+}
+
+// DetectEncoding returns the encoding a user code is most likely written in.
+// It never returns nil; codes no encoding recognizes are attributed to English.
+func DetectEncoding(code string) Encoding {
+	parts := splitCode(code)
+	var best Encoding = english
+	bestScore := 0
+	for _, e := range encodings {
+		if s := e.Match(parts); s > bestScore {
+			best, bestScore = e, s
+		}
+	}
+	return best
+}
+
+// decodeAny decodes a user code with the first encoding it is valid in, trying
+// encodings in order of how well they match. It returns the error of the best
+// matching encoding when the code is valid in none.
+func decodeAny(code string) ([]byte, int, Encoding, error) {
+	parts := splitCode(code)
+	best := DetectEncoding(code)
+	full, words, err := best.Decode(code)
+	if err == nil {
+		return full, words, best, nil
+	}
+	// Wordlists overlap (e.g. English and French share words), so a code that
+	// fails in the best match may still be valid in another one
+	for _, e := range encodings {
+		if e == best || e.Match(parts) == 0 {
+			continue
+		}
+		if f, w, err2 := e.Decode(code); err2 == nil {
+			return f, w, e, nil
+		}
+	}
+	return nil, 0, best, err
+}
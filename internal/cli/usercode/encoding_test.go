@@ -0,0 +1,79 @@
+package usercode
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncodingsRoundTrip(t *testing.T) {
+	zero := func(words int) []byte { return make([]byte, 2*partBytes(words)) }
+	for _, enc := range encodings {
+		for _, words := range []int{4, 6, 8} {
+			code := testCode(t, enc, words)
+			relay, receiver, _, err := ParseUserCode(code)
+			if err != nil {
+				t.Fatalf("%s/%d: %q rejected: %v", enc.Name(), words, code, err)
+			}
+			wantFull := append(mustDecodePart(t, relay, words), mustDecodePart(t, receiver, words)...)
+
+			for _, c := range []string{code, strings.ToUpper(code), strings.ReplaceAll(code, "-", " ")} {
+				if got := DetectEncoding(c); got != enc {
+					t.Errorf("%s/%d: %q detected as %s", enc.Name(), words, c, got.Name())
+				}
+				full, gotWords, gotEnc, err := decodeAny(c)
+				if err != nil || gotEnc != enc || gotWords != words || !bytes.Equal(full, wantFull) {
+					t.Errorf("%s/%d: %q decoded as %s/%d (%v)", enc.Name(), words, c, gotEnc.Name(), gotWords, err)
+				}
+			}
+
+			// The smallest payload keeps its leading zeros and its length
+			code, err = enc.Encode(words, zero(words))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if full, gotWords, gotEnc, err := decodeAny(code); err != nil || gotEnc != enc || gotWords != words || !bytes.Equal(full, zero(words)) {
+				t.Errorf("%s/%d: zero payload %q decoded as %s/%d (%v)", enc.Name(), words, code, gotEnc.Name(), gotWords, err)
+			}
+		}
+	}
+}
+
+func TestDetectEncodingOverlaps(t *testing.T) {
+	pgp, _ := LookupEncoding("pgp")
+	numeric, _ := LookupEncoding("numeric")
+	pgpCode := strings.Split(testCode(t, pgp, 4), "-")
+	numericCode := testCode(t, numeric, 4)
+
+	for _, tc := range []struct {
+		name string
+		code string
+		want Encoding
+	}{
+		// Even and odd PGP words come from different lists: a skipped word
+		// shifts the rest onto the wrong list, which still reads as PGP
+		{"pgp word skipped", strings.Join(append(pgpCode[:1:1], pgpCode[2:]...), "-"), pgp},
+		{"pgp words swapped", strings.Join(append([]string{pgpCode[1], pgpCode[0]}, pgpCode[2:]...), "-"), pgp},
+		{"numeric without dashes", strings.ReplaceAll(numericCode, "-", ""), numeric},
+		{"numeric digit missing", numericCode[1:], numeric},
+		// Wordlist codes end in digits too; without words they are not numeric
+		{"digits only of a word code", "123-4567", english},
+		{"nothing", "", english},
+	} {
+		if got := DetectEncoding(tc.code); got != tc.want {
+			t.Errorf("%s: %q detected as %s, want %s", tc.name, tc.code, got.Name(), tc.want.Name())
+		}
+	}
+	if _, _, _, err := ParseUserCode(strings.Join(append([]string{pgpCode[1], pgpCode[0]}, pgpCode[2:]...), "-")); err == nil || !strings.Contains(err.Error(), "out of place") {
+		t.Errorf("swapped PGP words: %v, want an out of place error", err)
+	}
+}
+
+func mustDecodePart(t *testing.T, b64 string, words int) []byte {
+	t.Helper()
+	b, err := decodePart(b64, words)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
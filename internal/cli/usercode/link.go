@@ -0,0 +1,105 @@
+package usercode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ShareLinkScheme is the URI scheme of share links:
+//
+//	ssh-portal://relay.example.com:4430/<user code>?token-hint=1a2b3c4d&tls=1
+//
+// The token hint is a short hash of the sender token, never the token itself;
+// it lets the sender pick the matching profile or spot a wrong token early.
+// tls=1 tells the sender the relay listener requires TLS.
+const ShareLinkScheme = "ssh-portal"
+
+// tokenHintBytes is the number of SHA-256 bytes kept in a token hint.
+const tokenHintBytes = 4
+
+// ShareLink is a parsed ssh-portal:// link.
+type ShareLink struct {
+	RelayHost string
+	RelayPort int    // 0 when the link has no port
+	Code      string // user code, in any encoding
+	TokenHint string // "" when the link has no token hint
+	TLS       bool   // the relay listener requires TLS
+}
+
+// TokenHint returns the hint published in share links for a token ("" for no token).
+func TokenHint(token string) string {
+	if token == "" {
+		return ""
+	}
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:tokenHintBytes])
+}
+
+// MatchesTokenHint reports whether token matches a share link's token hint.
+// An empty hint matches any token.
+func MatchesTokenHint(token, hint string) bool {
+	return hint == "" || strings.EqualFold(TokenHint(token), hint)
+}
+
+// IsShareLink reports whether s looks like an ssh-portal:// link rather than a bare code.
+func IsShareLink(s string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(s)), ShareLinkScheme+"://")
+}
+
+// FormatShareLink builds the share link for a user code. senderToken is only
+// used to derive the token hint and may be empty.
+func FormatShareLink(relayHost string, relayPort int, useTLS bool, code, senderToken string) string {
+	u := url.URL{
+		Scheme: ShareLinkScheme,
+		Host:   net.JoinHostPort(relayHost, strconv.Itoa(relayPort)),
+		Path:   "/" + code,
+	}
+	query := url.Values{}
+	if hint := TokenHint(senderToken); hint != "" {
+		query.Set("token-hint", hint)
+	}
+	if useTLS {
+		query.Set("tls", "1")
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// ParseShareLink parses an ssh-portal:// link. The code is returned as written;
+// validate it with ParseUserCode.
+func ParseShareLink(link string) (*ShareLink, error) {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return nil, fmt.Errorf("invalid share link: %w", err)
+	}
+	if !strings.EqualFold(u.Scheme, ShareLinkScheme) {
+		return nil, fmt.Errorf("invalid share link: scheme must be %s://", ShareLinkScheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("invalid share link: missing relay host")
+	}
+
+	sl := &ShareLink{
+		RelayHost: u.Hostname(),
+		Code:      strings.Trim(u.Path, "/"),
+		TokenHint: u.Query().Get("token-hint"),
+		TLS:       u.Query().Get("tls") == "1",
+	}
+	if p := u.Port(); p != "" {
+		port, err := strconv.Atoi(p)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid share link: bad port %q", p)
+		}
+		sl.RelayPort = port
+	}
+	if sl.Code == "" {
+		return nil, errors.New("invalid share link: missing code")
+	}
+	return sl, nil
+}
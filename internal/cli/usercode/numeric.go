@@ -0,0 +1,125 @@
+package usercode
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// numericCheckDigits is the number of decimal check digits appended to numeric codes.
+const numericCheckDigits = 4
+
+// numericEncoding encodes codes as decimal digits only, for devices with a
+// numeric keypad: the payload in decimal followed by 4 check digits, in groups
+// of four (e.g. 1234-5678-9012-3456-7890-1234 for a 4-word strength code).
+type numericEncoding struct{}
+
+func (numericEncoding) Name() string { return "numeric" }
+
+// payloadDigits returns the number of decimal digits needed for the payload of
+// a words-strength code (20, 26 or 33).
+func payloadDigits(words int) int {
+	limit := new(big.Int).Lsh(big.NewInt(1), uint(11*words+20))
+	return len(limit.Sub(limit, big.NewInt(1)).String())
+}
+
+// numericLayout maps a total digit count back to the code strength.
+func numericLayout(nDigits int) (words int, ok bool) {
+	for w := MinCodeWords; w <= MaxCodeWords; w += 2 {
+		if payloadDigits(w)+numericCheckDigits == nDigits {
+			return w, true
+		}
+	}
+	return 0, false
+}
+
+func numericCheck(full []byte) string {
+	return fmt.Sprintf("%0*d", numericCheckDigits, int(checksum(CodeVersionChecksum, "numeric", full))%10000)
+}
+
+func (numericEncoding) Match(parts []string) int {
+	digits := strings.Join(parts, "")
+	if digits == "" || !allDigits(digits) {
+		return 0
+	}
+	if _, ok := numericLayout(len(digits)); ok {
+		return 2
+	}
+	// Right alphabet, wrong length: still the best guess for suggestions
+	if len(parts) > 2 {
+		return 1
+	}
+	return 0
+}
+
+func (numericEncoding) Encode(words int, full []byte) (string, error) {
+	if len(full) != 2*partBytes(words) {
+		return "", fmt.Errorf("fullCode must be %d bytes", 2*partBytes(words))
+	}
+	payload := payloadInt(full, words).String()
+	digits := strings.Repeat("0", payloadDigits(words)-len(payload)) + payload + numericCheck(full)
+
+	var groups []string
+	for len(digits) > 4 {
+		groups = append(groups, digits[:4])
+		digits = digits[4:]
+	}
+	groups = append(groups, digits)
+	return strings.Join(groups, "-"), nil
+}
+
+func (numericEncoding) Decode(code string) ([]byte, int, error) {
+	digits := strings.Join(splitCode(code), "")
+	if !allDigits(digits) {
+		return nil, 0, errors.New("numeric code must contain only digits")
+	}
+	words, ok := numericLayout(len(digits))
+	if !ok {
+		return nil, 0, fmt.Errorf("numeric code has %d digits", len(digits))
+	}
+	payload, check := digits[:len(digits)-numericCheckDigits], digits[len(digits)-numericCheckDigits:]
+
+	u, ok := new(big.Int).SetString(payload, 10)
+	if !ok || u.BitLen() > 11*words+20 {
+		return nil, 0, errors.New("numeric code out of range")
+	}
+	full := payloadBytes(u, words)
+	if numericCheck(full) != check {
+		return nil, 0, ErrChecksum
+	}
+	return full, words, nil
+}
+
+// Suggest for numeric codes tries every single-digit substitution and every
+// adjacent transposition, the two most common keypad typos.
+func (e numericEncoding) Suggest(code string) string {
+	parts := splitCode(code)
+	digits := strings.Join(parts, "")
+	if _, ok := numericLayout(len(digits)); !ok || !allDigits(digits) {
+		return ""
+	}
+
+	var found []string
+	try := func(candidate string) {
+		if full, words, err := e.Decode(candidate); err == nil {
+			c, _ := e.Encode(words, full)
+			found = append(found, c)
+		}
+	}
+	for _, t := range transpositions(digits) {
+		try(t)
+	}
+	b := []byte(digits)
+	for i := range b {
+		orig := b[i]
+		for d := byte('0'); d <= '9'; d++ {
+			if d != orig {
+				b[i] = d
+				try(string(b))
+			}
+		}
+		b[i] = orig
+	}
+	return pickUnique(found)
+}
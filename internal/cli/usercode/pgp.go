@@ -0,0 +1,255 @@
+package usercode
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// pgpEncoding encodes codes with the PGP word list: one word per byte, taken
+// alternately from the two-syllable (even positions) and three-syllable (odd
+// positions) lists, so a dropped, duplicated or swapped word is detected by its
+// position alone. The payload bytes are followed by a 2-byte checksum.
+type pgpEncoding struct{}
+
+// pgpChecksumBytes is the number of checksum bytes (words) appended to PGP codes.
+const pgpChecksumBytes = 2
+
+func (pgpEncoding) Name() string { return "pgp" }
+
+// pgpPayloadBytes returns the number of payload bytes of a words-strength code (8, 11 or 14).
+func pgpPayloadBytes(words int) int {
+	return (11*words + 20 + 7) / 8
+}
+
+// pgpLayout maps a word count back to the code strength.
+func pgpLayout(nParts int) (words int, ok bool) {
+	for w := MinCodeWords; w <= MaxCodeWords; w += 2 {
+		if pgpPayloadBytes(w)+pgpChecksumBytes == nParts {
+			return w, true
+		}
+	}
+	return 0, false
+}
+
+// pgpLookup returns the byte encoded by word w at position pos; ok is false
+// when w is not a PGP word or belongs to the list of the other parity.
+func pgpLookup(w string, pos int) (b byte, ok bool) {
+	w = strings.ToLower(strings.TrimSpace(w))
+	if pos%2 == 0 {
+		b, ok = pgpEvenIndex[w]
+	} else {
+		b, ok = pgpOddIndex[w]
+	}
+	return b, ok
+}
+
+func pgpWord(b byte, pos int) string {
+	if pos%2 == 0 {
+		return strings.ToLower(pgpEven[b])
+	}
+	return strings.ToLower(pgpOdd[b])
+}
+
+func (pgpEncoding) Match(parts []string) int {
+	if len(parts) == 0 || allDigits(parts[len(parts)-1]) {
+		return 0
+	}
+	score := 0
+	for i, p := range parts {
+		if _, ok := pgpLookup(p, i); ok {
+			score += 2
+		}
+	}
+	return score
+}
+
+func (pgpEncoding) Encode(words int, full []byte) (string, error) {
+	if len(full) != 2*partBytes(words) {
+		return "", fmt.Errorf("fullCode must be %d bytes", 2*partBytes(words))
+	}
+	payload := make([]byte, pgpPayloadBytes(words))
+	payloadInt(full, words).FillBytes(payload)
+	sum := checksum(CodeVersionChecksum, "pgp", full)
+	payload = append(payload, byte(sum>>8), byte(sum))
+
+	parts := make([]string, len(payload))
+	for i, b := range payload {
+		parts[i] = pgpWord(b, i)
+	}
+	return strings.Join(parts, "-"), nil
+}
+
+func (pgpEncoding) Decode(code string) ([]byte, int, error) {
+	parts := splitCode(code)
+	words, ok := pgpLayout(len(parts))
+	if !ok {
+		return nil, 0, fmt.Errorf("PGP word code has %d words", len(parts))
+	}
+	payload := make([]byte, len(parts))
+	for i, p := range parts {
+		b, ok := pgpLookup(p, i)
+		if !ok {
+			if _, known := pgpLookup(p, i+1); known {
+				return nil, 0, fmt.Errorf("word %d (%q) is out of place: a word was skipped or repeated", i+1, p)
+			}
+			return nil, 0, fmt.Errorf("word %q not in PGP word list", p)
+		}
+		payload[i] = b
+	}
+
+	n := pgpPayloadBytes(words)
+	u := new(big.Int).SetBytes(payload[:n])
+	if u.BitLen() > 11*words+20 {
+		return nil, 0, errors.New("PGP word code out of range")
+	}
+	full := payloadBytes(u, words)
+	sum := checksum(CodeVersionChecksum, "pgp", full)
+	if payload[n] != byte(sum>>8) || payload[n+1] != byte(sum) {
+		return nil, 0, ErrChecksum
+	}
+	return full, words, nil
+}
+
+// Suggest for PGP codes replaces unknown words with the nearest word of the list
+// expected at that position, and tries swapping adjacent words back.
+func (e pgpEncoding) Suggest(code string) string {
+	parts := splitCode(code)
+	if _, ok := pgpLayout(len(parts)); !ok {
+		return ""
+	}
+	var found []string
+	try := func(cand []string) {
+		c := strings.Join(cand, "-")
+		if _, _, err := e.Decode(c); err == nil {
+			found = append(found, c)
+		}
+	}
+
+	cand := make([]string, len(parts))
+	unknown := -1
+	for i, p := range parts {
+		if b, ok := pgpLookup(p, i); ok {
+			cand[i] = pgpWord(b, i)
+			continue
+		}
+		if unknown >= 0 {
+			return "" // more than one bad word
+		}
+		unknown = i
+	}
+
+	if unknown >= 0 {
+		list := pgpEven[:]
+		if unknown%2 == 1 {
+			list = pgpOdd[:]
+		}
+		w := strings.ToLower(parts[unknown])
+		for _, alt := range list {
+			if editDistance(w, strings.ToLower(alt), maxSuggestDistance+1) <= maxSuggestDistance {
+				cand[unknown] = strings.ToLower(alt)
+				try(cand)
+			}
+		}
+		return pickUnique(found)
+	}
+
+	// All words known but the checksum fails: words swapped with their neighbour
+	for i := 0; i+1 < len(parts); i++ {
+		b1, ok1 := pgpLookup(parts[i+1], i)
+		b2, ok2 := pgpLookup(parts[i], i+1)
+		if !ok1 || !ok2 {
+			continue
+		}
+		swapped := append([]string(nil), cand...)
+		swapped[i], swapped[i+1] = pgpWord(b1, i), pgpWord(b2, i+1)
+		try(swapped)
+	}
+	return pickUnique(found)
+}
+
+var (
+	pgpEvenIndex = pgpIndex(pgpEven[:])
+	pgpOddIndex  = pgpIndex(pgpOdd[:])
+)
+
+func pgpIndex(list []string) map[string]byte {
+	m := make(map[string]byte, len(list))
+	for i, w := range list {
+		m[strings.ToLower(w)] = byte(i)
+	}
+	return m
+}
+
+// pgpEven is the PGP word list for even byte positions (two syllables).
+var pgpEven = [256]string{
+	"aardvark", "absurd", "accrue", "acme", "adrift", "adult", "afflict", "ahead",
+	"aimless", "Algol", "allow", "alone", "ammo", "ancient", "apple", "artist",
+	"assume", "Athens", "atlas", "Aztec", "baboon", "backfield", "backward", "banjo",
+	"beaming", "bedlamp", "beehive", "beeswax", "befriend", "Belfast", "berserk", "billiard",
+	"bison", "blackjack", "blockade", "blowtorch", "bluebird", "bombast", "bookshelf", "brackish",
+	"breadline", "breakup", "brickyard", "briefcase", "Burbank", "button", "buzzard", "cement",
+	"chairlift", "chatter", "checkup", "chisel", "choking", "chopper", "Christmas", "clamshell",
+	"classic", "classroom", "cleanup", "clockwork", "cobra", "commence", "concert", "cowbell",
+	"crackdown", "cranky", "crowfoot", "crucial", "crumpled", "crusade", "cubic", "dashboard",
+	"deadbolt", "deckhand", "dogsled", "dragnet", "drainage", "dreadful", "drifter", "dropper",
+	"drumbeat", "drunken", "Dupont", "dwelling", "eating", "edict", "egghead", "eightball",
+	"endorse", "endow", "enlist", "erase", "escape", "exceed", "eyeglass", "eyetooth",
+	"facial", "fallout", "flagpole", "flatfoot", "flytrap", "fracture", "framework", "freedom",
+	"frighten", "gazelle", "Geiger", "glitter", "glucose", "goggles", "goldfish", "gremlin",
+	"guidance", "hamlet", "highchair", "hockey", "indoors", "indulge", "inverse", "involve",
+	"island", "jawbone", "keyboard", "kickoff", "kiwi", "klaxon", "locale", "lockup",
+	"merit", "minnow", "miser", "Mohawk", "mural", "music", "necklace", "Neptune",
+	"newborn", "nightbird", "Oakland", "obtuse", "offload", "optic", "orca", "payday",
+	"peachy", "pheasant", "physique", "playhouse", "Pluto", "preclude", "prefer", "preshrunk",
+	"printer", "prowler", "pupil", "puppy", "python", "quadrant", "quiver", "quota",
+	"ragtime", "ratchet", "rebirth", "reform", "regain", "reindeer", "rematch", "repay",
+	"retouch", "revenge", "reward", "rhythm", "ribcage", "ringbolt", "robust", "rocker",
+	"ruffled", "sailboat", "sawdust", "scallion", "scenic", "scorecard", "Scotland", "seabird",
+	"select", "sentence", "shadow", "shamrock", "showgirl", "skullcap", "skydive", "slingshot",
+	"slowdown", "snapline", "snapshot", "snowcap", "snowslide", "solo", "southward", "soybean",
+	"spaniel", "spearhead", "spellbind", "spheroid", "spigot", "spindle", "spyglass", "stagehand",
+	"stagnate", "stairway", "standard", "stapler", "steamship", "sterling", "stockman", "stopwatch",
+	"stormy", "sugar", "surmount", "suspense", "sweatband", "swelter", "tactics", "talon",
+	"tapeworm", "tempest", "tiger", "tissue", "tonic", "topmost", "tracker", "transit",
+	"trauma", "treadmill", "Trojan", "trouble", "tumor", "tunnel", "tycoon", "uncut",
+	"unearth", "unwind", "uproot", "upset", "upshot", "vapor", "village", "virus",
+	"Vulcan", "waffle", "wallet", "watchword", "wayside", "willow", "woodlark", "Zulu",
+}
+
+// pgpOdd is the PGP word list for odd byte positions (three syllables).
+var pgpOdd = [256]string{
+	"adroitness", "adviser", "aftermath", "aggregate", "alkali", "almighty", "amulet", "amusement",
+	"antenna", "applicant", "Apollo", "armistice", "article", "asteroid", "Atlantic", "atmosphere",
+	"autopsy", "Babylon", "backwater", "barbecue", "belowground", "bifocals", "bodyguard", "bookseller",
+	"borderline", "bottomless", "Bradbury", "bravado", "Brazilian", "breakaway", "Burlington", "businessman",
+	"butterfat", "Camelot", "candidate", "cannonball", "Capricorn", "caravan", "caretaker", "celebrate",
+	"cellulose", "certify", "chambermaid", "Cherokee", "Chicago", "clergyman", "coherence", "combustion",
+	"commando", "company", "component", "concurrent", "confidence", "conformist", "congregate", "consensus",
+	"consulting", "corporate", "corrosion", "councilman", "crossover", "crucifix", "cumbersome", "customer",
+	"Dakota", "decadence", "December", "decimal", "designing", "detector", "detergent", "determine",
+	"dictator", "dinosaur", "direction", "disable", "disbelief", "disruptive", "distortion", "document",
+	"embezzle", "enchanting", "enrollment", "enterprise", "equation", "equipment", "escapade", "Eskimo",
+	"everyday", "examine", "existence", "exodus", "fascinate", "filament", "finicky", "forever",
+	"fortitude", "frequency", "gadgetry", "Galveston", "getaway", "glossary", "gossamer", "graduate",
+	"gravity", "guitarist", "hamburger", "Hamilton", "handiwork", "hazardous", "headwaters", "hemisphere",
+	"hesitate", "hideaway", "holiness", "hurricane", "hydraulic", "impartial", "impetus", "inception",
+	"indigo", "inertia", "infancy", "inferno", "informant", "insincere", "insurgent", "integrate",
+	"intention", "inventive", "Istanbul", "Jamaica", "Jupiter", "leprosy", "letterhead", "liberty",
+	"maritime", "matchmaker", "maverick", "Medusa", "megaton", "microscope", "microwave", "midsummer",
+	"millionaire", "miracle", "misnomer", "molasses", "molecule", "Montana", "monument", "mosquito",
+	"narrative", "nebula", "newsletter", "Norwegian", "October", "Ohio", "onlooker", "opulent",
+	"Orlando", "outfielder", "Pacific", "pandemic", "Pandora", "paperweight", "paragon", "paragraph",
+	"paramount", "passenger", "pedigree", "Pegasus", "penetrate", "perceptive", "performance", "pharmacy",
+	"phonetic", "photograph", "pioneer", "pocketful", "politeness", "positive", "potato", "processor",
+	"provincial", "proximate", "puberty", "publisher", "pyramid", "quantity", "racketeer", "rebellion",
+	"recipe", "recover", "repellent", "replica", "reproduce", "resistor", "responsive", "retraction",
+	"retrieval", "retrospect", "revenue", "revival", "revolver", "sandalwood", "sardonic", "Saturday",
+	"savagery", "scavenger", "sensation", "sociable", "souvenir", "specialist", "speculate", "stethoscope",
+	"stupendous", "supportive", "surrender", "suspicious", "sympathy", "tambourine", "telephone", "therapist",
+	"tobacco", "tolerance", "tomorrow", "torpedo", "tradition", "travesty", "trombonist", "truncated",
+	"typewriter", "ultimate", "undaunted", "underfoot", "unicorn", "unify", "universe", "unravel",
+	"upcoming", "vacancy", "vagabond", "vertigo", "Virginia", "visitor", "vocalist", "voyager",
+	"warranty", "Waterloo", "whimsical", "Wichita", "Wilmington", "Wyoming", "yesteryear", "Yucatan",
+}
@@ -0,0 +1,246 @@
+package usercode
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	// maxSuggestDistance is the largest edit distance considered when
+	// replacing a mistyped word with a BIP39 word.
+	maxSuggestDistance = 2
+	// maxSuggestCandidates bounds the number of combinations tried when
+	// several words are mistyped at once.
+	maxSuggestCandidates = 20000
+)
+
+// Suggest returns the most likely intended code for a mistyped user code, or ""
+// when no unambiguous correction exists. The encoding is detected from the code.
+func Suggest(code string) string {
+	return DetectEncoding(code).Suggest(code)
+}
+
+// Suggest for wordlist codes: unknown words are replaced by the nearest words of
+// the list (by edit distance) and adjacent transposed digits are swapped back.
+// For checksummed codes a candidate is only suggested when its checksum verifies;
+// legacy codes carry no checksum, so only unambiguous word corrections are offered
+// for them.
+func (e *wordlistEncoding) Suggest(code string) string {
+	parts := splitCode(code)
+	version, _, ok := e.layout(len(parts))
+	if !ok {
+		return ""
+	}
+	nWords := len(parts) - 2 // all words, including the checksum word
+	digits := parts[nWords] + parts[nWords+1]
+	if len(digits) != 7 || !allDigits(digits) {
+		return ""
+	}
+
+	wordCands := make([][]string, nWords)
+	for i, w := range parts[:nWords] {
+		if id, ok := e.lookup(w); ok {
+			wordCands[i] = []string{e.words[id]}
+			continue
+		}
+		near := e.nearestWords(fold(w), maxSuggestDistance)
+		if p := e.prefixWord(w); p != "" {
+			near = append([]string{p}, remove(near, p)...)
+		}
+		if len(near) == 0 {
+			return ""
+		}
+		wordCands[i] = near
+	}
+
+	// Legacy codes: nothing to verify against, only fix unambiguous word typos
+	if version == CodeVersionLegacy {
+		fixed := make([]string, nWords)
+		changed := false
+		for i, c := range wordCands {
+			if p := e.prefixWord(parts[i]); p != "" {
+				fixed[i] = p
+			} else if len(c) == 1 {
+				fixed[i] = c[0]
+			} else {
+				return ""
+			}
+			changed = changed || fold(fixed[i]) != fold(parts[i])
+		}
+		if !changed {
+			return ""
+		}
+		return formatCode(fixed, digits)
+	}
+
+	// 1) Replace unknown words, optionally undoing one digit transposition
+	digitCands := append([]string{digits}, transpositions(digits)...)
+	if found := e.searchValid(wordCands, digitCands); len(found) > 0 {
+		return pickUnique(found)
+	}
+
+	// 2) All words are real list words but one of them is the wrong one:
+	// try close neighbours of each word in turn (one substitution only)
+	var found []string
+	for i, c := range wordCands {
+		if len(c) != 1 {
+			continue
+		}
+		for _, alt := range e.nearestWords(fold(c[0]), 1) {
+			if alt == c[0] {
+				continue
+			}
+			trial := make([][]string, len(wordCands))
+			copy(trial, wordCands)
+			trial[i] = []string{alt}
+			found = append(found, e.searchValid(trial, []string{digits})...)
+		}
+	}
+	return pickUnique(found)
+}
+
+// searchValid enumerates all word/digit combinations and returns the formatted
+// codes whose checksum verifies.
+func (e *wordlistEncoding) searchValid(wordCands [][]string, digitCands []string) []string {
+	total := len(digitCands)
+	for _, c := range wordCands {
+		total *= len(c)
+		if total > maxSuggestCandidates {
+			return nil
+		}
+	}
+
+	var found []string
+	pick := make([]string, len(wordCands))
+	var walk func(i int)
+	walk = func(i int) {
+		if i == len(wordCands) {
+			for _, d := range digitCands {
+				candidate := formatCode(pick, d)
+				if _, _, err := e.Decode(candidate); err == nil {
+					found = append(found, candidate)
+				}
+			}
+			return
+		}
+		for _, w := range wordCands[i] {
+			pick[i] = w
+			walk(i + 1)
+		}
+	}
+	walk(0)
+	return found
+}
+
+// pickUnique returns the single distinct candidate, or "" if there are none or several.
+func pickUnique(found []string) string {
+	if len(found) == 0 {
+		return ""
+	}
+	sort.Strings(found)
+	for _, f := range found[1:] {
+		if f != found[0] {
+			return ""
+		}
+	}
+	return found[0]
+}
+
+// prefixWord returns the list word sharing its first four letters with an
+// unknown word w, or "". Only used for lists whose words are unique by their
+// four-letter prefix (the Latin-script BIP39 lists).
+func (e *wordlistEncoding) prefixWord(w string) string {
+	w = fold(w)
+	if !e.prefix || len([]rune(w)) < 4 {
+		return ""
+	}
+	if _, known := e.index[w]; known {
+		return ""
+	}
+	p := prefix4(w)
+	for i, cand := range e.folded {
+		if prefix4(cand) == p {
+			return e.words[i]
+		}
+	}
+	return ""
+}
+
+// nearestWords returns the list words closest to the folded word w, limited to maxDist edits.
+func (e *wordlistEncoding) nearestWords(w string, maxDist int) []string {
+	best := maxDist
+	var result []string
+	for i, cand := range e.folded {
+		d := editDistance(w, cand, best+1)
+		if d > best {
+			continue
+		}
+		if d < best {
+			best = d
+			result = result[:0]
+		}
+		if d == best {
+			result = append(result, e.words[i])
+		}
+	}
+	return result
+}
+
+// transpositions returns every string obtained by swapping two adjacent digits.
+func transpositions(digits string) []string {
+	var out []string
+	for i := 0; i+1 < len(digits); i++ {
+		if digits[i] == digits[i+1] {
+			continue
+		}
+		b := []byte(digits)
+		b[i], b[i+1] = b[i+1], b[i]
+		out = append(out, string(b))
+	}
+	return out
+}
+
+// editDistance computes the Levenshtein distance (in runes) between a and b. It
+// gives up early and returns limit once the distance is known to be at least limit.
+func editDistance(as, bs string, limit int) int {
+	a, b := []rune(as), []rune(bs)
+	if d := len(a) - len(b); d >= limit || -d >= limit {
+		return limit
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin >= limit {
+			return limit
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func formatCode(ws []string, digits string) string {
+	return strings.Join(ws, "-") + "-" + digits[:3] + "-" + digits[3:]
+}
+
+func remove(ss []string, v string) []string {
+	out := ss[:0:0]
+	for _, s := range ss {
+		if s != v {
+			out = append(out, s)
+		}
+	}
+	return out
+}
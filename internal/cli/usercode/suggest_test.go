@@ -0,0 +1,82 @@
+package usercode
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testCode encodes fixed code halves of a words-strength code with enc
+func testCode(t *testing.T, enc Encoding, words int) string {
+	t.Helper()
+	n := partBytes(words)
+	relay, receiver := make([]byte, n), make([]byte, n)
+	for i := range n {
+		relay[i], receiver[i] = byte(37*i+11), byte(53*i+7)
+	}
+	relay[0] &= 0xFF >> (n*8 - PartBits(words))
+	receiver[0] &= 0xFF >> (n*8 - PartBits(words))
+	code, _, err := GenerateUserCode(enc, words, encodeBytes(relay), encodeBytes(receiver))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestParseUserCodeSuggestions(t *testing.T) {
+	code := testCode(t, english, 4) // five words, 3 digits, 4 digits
+	parts := strings.Split(code, "-")
+	wantRelay, wantReceiver, _, err := ParseUserCode(code)
+	if err != nil {
+		t.Fatalf("%s: %v", code, err)
+	}
+	with := func(i int, part string) string {
+		p := append([]string{}, parts...)
+		p[i] = part
+		return strings.Join(p, "-")
+	}
+	swapped := []byte(parts[6])
+	swapped[1], swapped[2] = swapped[2], swapped[1]
+	if swapped[1] == swapped[2] {
+		t.Fatalf("%s: pick other digits to swap", code)
+	}
+	otherWord := english.words[(int(english.index[parts[4]])+1)%2048]
+	legacy := strings.Join(append(parts[:4:4], parts[5:]...), "-")
+
+	for _, tc := range []struct {
+		name    string
+		code    string
+		suggest string // "": accepted
+		err     error  // wrapped by the *InvalidCodeError, if not nil
+	}{
+		{"valid", code, "", nil},
+		{"upper case and spaces", strings.ToUpper(strings.ReplaceAll(code, "-", " ")), "", nil},
+		{"mistyped word", with(1, parts[1][:len(parts[1])-1]+"q"), code, nil},
+		{"swapped digits", with(6, string(swapped)), code, nil},
+		{"bad checksum word", with(4, otherWord), "", ErrChecksum},
+		{"v1 code without checksum", legacy, "", nil},
+		{"mistyped v1 code", strings.Replace(legacy, parts[0], parts[0][:4]+"x", 1), legacy, nil},
+	} {
+		relay, receiver, _, err := ParseUserCode(tc.code)
+		// CheckUserCode agrees, but doesn't look for a suggestion
+		var checked *InvalidCodeError
+		if cerr := CheckUserCode(tc.code); (cerr == nil) != (err == nil) || errors.As(cerr, &checked) && checked.Suggestion != "" {
+			t.Errorf("%s: %q: CheckUserCode = %v, ParseUserCode = %v", tc.name, tc.code, cerr, err)
+		}
+		var invalid *InvalidCodeError
+		switch {
+		case tc.suggest == "" && tc.err == nil:
+			if err != nil {
+				t.Errorf("%s: %q rejected: %v", tc.name, tc.code, err)
+			} else if relay != wantRelay || receiver != wantReceiver {
+				t.Errorf("%s: %q decoded to other code halves", tc.name, tc.code)
+			}
+		case !errors.As(err, &invalid):
+			t.Errorf("%s: %q: error %v, want *InvalidCodeError", tc.name, tc.code, err)
+		case tc.err != nil && !errors.Is(err, tc.err):
+			t.Errorf("%s: %q: error %v, want %v", tc.name, tc.code, err, tc.err)
+		case tc.suggest != "" && invalid.Suggestion != tc.suggest:
+			t.Errorf("%s: %q: suggestion %q, want %q", tc.name, tc.code, invalid.Suggestion, tc.suggest)
+		}
+	}
+}
@@ -2,89 +2,179 @@ package usercode
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"math/big"
 	"strings"
-
-	bip39 "github.com/tyler-smith/go-bip39/wordlists"
 )
 
 // =========================
 // Public API
 // =========================
 
-// GenerateRelayCode returns a base64 (raw, no padding) string representing 32 bits of entropy (4 bytes).
-func GenerateRelayCode() (string, error) {
-	return gen32b()
+// Code format versions. Version 1 is the original checksum-less format
+// (word-word-word-word-123-4567, English only); version 2 inserts a checksum word
+// after the payload words (word-word-word-word-word-123-4567) so typos are detected
+// locally instead of being sent to the relay. Numeric and PGP codes always carry a
+// checksum and use version 2.
+const (
+	CodeVersionLegacy   = 1
+	CodeVersionChecksum = 2
+)
+
+// Code strength is expressed as the number of payload words in the user code.
+// Every code also carries 7 digits (20 bits), so a code of W words holds
+// 11*W+20 bits, split evenly between the relay code and the receiver code:
+//
+//	4 words:  64 bits (32 + 32)
+//	6 words:  86 bits (43 + 43)
+//	8 words: 108 bits (54 + 54)
+const (
+	MinCodeWords     = 4
+	DefaultCodeWords = 4
+	MaxCodeWords     = 8
+)
+
+// ErrChecksum is returned when a versioned user code fails its checksum.
+var ErrChecksum = errors.New("checksum mismatch (code mistyped?)")
+
+// InvalidCodeError is returned by ParseUserCode for malformed or mistyped codes.
+// Suggestion, when non-empty, is the nearest valid code ("did you mean ...").
+type InvalidCodeError struct {
+	Err        error
+	Suggestion string
 }
 
-// GenerateReceiverCode returns a base64 (raw, no padding) string representing 32 bits of entropy (4 bytes).
-func GenerateReceiverCode() (string, error) {
-	return gen32b()
+func (e *InvalidCodeError) Error() string {
+	if e.Suggestion != "" {
+		return fmt.Sprintf("invalid code: %v; did you mean %q?", e.Err, e.Suggestion)
+	}
+	return fmt.Sprintf("invalid code: %v", e.Err)
 }
 
-// generateUserCode returns the userCode (and fullCode base64) from two base64 32-bit codes.
-func GenerateUserCode(relayCodeB64, receiverCodeB64 string) (userCode, fullCodeB64 string, err error) {
-	rb, err := decode32b(relayCodeB64)
+func (e *InvalidCodeError) Unwrap() error { return e.Err }
+
+// ValidCodeWords reports whether words is a supported code strength (4, 6 or 8).
+func ValidCodeWords(words int) bool {
+	return words >= MinCodeWords && words <= MaxCodeWords && words%2 == 0
+}
+
+// NormalizeCodeWords rounds words up to the nearest supported code strength,
+// clamping to the supported range.
+func NormalizeCodeWords(words int) int {
+	if words <= MinCodeWords {
+		return MinCodeWords
+	}
+	if words >= MaxCodeWords {
+		return MaxCodeWords
+	}
+	return words + words%2
+}
+
+// PartBits returns the number of entropy bits in each half (relay code or receiver
+// code) of a code with the given number of words.
+func PartBits(words int) int {
+	return (11*words + 20) / 2
+}
+
+// GenerateRelayCode returns a base64 (raw, no padding) string carrying PartBits(words) bits of entropy.
+func GenerateRelayCode(words int) (string, error) {
+	return genPart(words)
+}
+
+// GenerateReceiverCode returns a base64 (raw, no padding) string carrying PartBits(words) bits of entropy.
+func GenerateReceiverCode(words int) (string, error) {
+	return genPart(words)
+}
+
+// GenerateUserCode returns the userCode (and fullCode base64) from the two base64 code halves,
+// formatted with the given encoding (nil selects DefaultEncoding).
+// Wordlist codes are always emitted in the current (checksummed) format.
+func GenerateUserCode(enc Encoding, words int, relayCodeB64, receiverCodeB64 string) (userCode, fullCodeB64 string, err error) {
+	if enc == nil {
+		enc = english
+	}
+	if !ValidCodeWords(words) {
+		return "", "", fmt.Errorf("unsupported code strength: %d words", words)
+	}
+	rb, err := decodePart(relayCodeB64, words)
 	if err != nil {
 		return "", "", fmt.Errorf("relayCode: %w", err)
 	}
-	sb, err := decode32b(receiverCodeB64)
+	sb, err := decodePart(receiverCodeB64, words)
 	if err != nil {
 		return "", "", fmt.Errorf("receiverCode: %w", err)
 	}
 	full := append(rb, sb...)
-	user, err := fullToUserCode(full)
+	user, err := enc.Encode(words, full)
 	if err != nil {
 		return "", "", err
 	}
-	return user, encode64b(full), nil
+	return user, encodeBytes(full), nil
 }
 
-// parseUserCode parses a userCode and returns relayCode and receiverCode (both base64, raw no padding).
+// ParseUserCode parses a userCode and returns relayCode and receiverCode (both base64, raw no padding).
+// The encoding is detected automatically; for English both the legacy and the checksummed
+// format are accepted. Errors are returned as *InvalidCodeError, carrying a corrected code
+// when one can be found.
 func ParseUserCode(userCode string) (relayCodeB64, receiverCodeB64 string, fullCodeB64 string, err error) {
-	full, err := userCodeToFull(userCode)
+	full, words, enc, err := decodeAny(userCode)
+	if err != nil {
+		return "", "", "", &InvalidCodeError{Err: err, Suggestion: enc.Suggest(userCode)}
+	}
+	n := partBytes(words)
+	if len(full) != 2*n {
+		return "", "", "", &InvalidCodeError{Err: errors.New("decoded fullCode has invalid length")}
+	}
+	return encodeBytes(full[:n]), encodeBytes(full[n:]), encodeBytes(full), nil
+}
+
+// CheckUserCode reports whether userCode is well formed and its checksum
+// verifies, like ParseUserCode but without looking for a suggestion, so it is
+// cheap enough to run on every keystroke.
+func CheckUserCode(userCode string) error {
+	full, words, _, err := decodeAny(userCode)
 	if err != nil {
-		return "", "", "", err
+		return &InvalidCodeError{Err: err}
 	}
-	if len(full) != 8 {
-		return "", "", "", errors.New("decoded fullCode has invalid length")
+	if len(full) != 2*partBytes(words) {
+		return &InvalidCodeError{Err: errors.New("decoded fullCode has invalid length")}
 	}
-	return encode32b(full[:4]), encode32b(full[4:]), encode64b(full), nil
+	return nil
 }
 
 // =========================
 // Internals
 // =========================
 
-var (
-	// BIP39 English list (2048 words) via go-bip39 wordlists package.
-	words = bip39.English
-	// quick reverse lookup
-	wordIndex = func() map[string]uint16 {
-		m := make(map[string]uint16, len(words))
-		for i, w := range words {
-			m[strings.ToLower(w)] = uint16(i)
-		}
-		return m
-	}()
-)
+// partBytes returns the byte length of one code half (big-endian, top bits unused).
+func partBytes(words int) int {
+	return (PartBits(words) + 7) / 8
+}
 
-func gen32b() (string, error) {
-	b := make([]byte, 4)
+func genPart(words int) (string, error) {
+	if !ValidCodeWords(words) {
+		return "", fmt.Errorf("unsupported code strength: %d words", words)
+	}
+	b := make([]byte, partBytes(words))
 	if _, err := rand.Read(b); err != nil {
 		return "", err
 	}
-	return encode32b(b), nil
+	// Clear the unused high bits so the value fits in PartBits(words)
+	if spare := len(b)*8 - PartBits(words); spare > 0 {
+		b[0] &= 0xFF >> spare
+	}
+	return encodeBytes(b), nil
 }
 
-func encode32b(b []byte) string {
+func encodeBytes(b []byte) string {
 	// raw base64, no '=' padding
 	return base64.RawStdEncoding.EncodeToString(b)
 }
 
-func decode32b(s string) ([]byte, error) {
+func decodePart(s string, words int) ([]byte, error) {
 	// accept raw or padded
 	dec, err := base64.RawStdEncoding.DecodeString(s)
 	if err != nil {
@@ -95,90 +185,50 @@ func decode32b(s string) ([]byte, error) {
 		}
 		dec = dec2
 	}
-	if len(dec) != 4 {
-		return nil, fmt.Errorf("expected 4 bytes, got %d", len(dec))
+	if want := partBytes(words); len(dec) != want {
+		return nil, fmt.Errorf("expected %d bytes, got %d", want, len(dec))
+	}
+	if spare := len(dec)*8 - PartBits(words); spare > 0 && dec[0]>>(8-spare) != 0 {
+		return nil, fmt.Errorf("value exceeds %d bits", PartBits(words))
 	}
 	return dec, nil
 }
 
-func encode64b(b []byte) string {
-	return base64.RawStdEncoding.EncodeToString(b)
+// payloadInt packs the two code halves into one (11*words+20)-bit integer.
+func payloadInt(full []byte, words int) *big.Int {
+	n := partBytes(words)
+	v := new(big.Int).SetBytes(full[:n])
+	v.Lsh(v, uint(PartBits(words)))
+	return v.Or(v, new(big.Int).SetBytes(full[n:]))
 }
 
-func fullToUserCode(full []byte) (string, error) {
-	if len(full) != 8 {
-		return "", errors.New("fullCode must be 8 bytes (64 bits)")
-	}
-	// Interpret as big-endian 64-bit integer for stable mapping
-	var u uint64
-	for i := 0; i < 8; i++ {
-		u = (u << 8) | uint64(full[i])
-	}
-
-	// Top 44 bits -> 4 words (each 11 bits)
-	w1 := (u >> 53) & 0x7FF // bits 63..53
-	w2 := (u >> 42) & 0x7FF // bits 52..42
-	w3 := (u >> 31) & 0x7FF // bits 41..31
-	w4 := (u >> 20) & 0x7FF // bits 30..20
-	// Low 20 bits -> numeric (0..1,048,575) -> 7 decimal digits, zero-padded
-	num := int(u & 0xFFFFF)
-	if int(w1) >= len(words) || int(w2) >= len(words) || int(w3) >= len(words) || int(w4) >= len(words) {
-		return "", errors.New("word index out of range (should not happen)")
-	}
-	numStr := fmt.Sprintf("%07d", num)     // 7 digits
-	numStr = numStr[:3] + "-" + numStr[3:] // format xxx-xxxx
-
-	return fmt.Sprintf("%s-%s-%s-%s-%s",
-		words[w1], words[w2], words[w3], words[w4], numStr), nil
+// payloadBytes is the inverse of payloadInt.
+func payloadBytes(v *big.Int, words int) []byte {
+	n := partBytes(words)
+	mask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(PartBits(words))), big.NewInt(1))
+	lo := new(big.Int).And(v, mask)
+	hi := new(big.Int).Rsh(v, uint(PartBits(words)))
+	full := make([]byte, 2*n)
+	hi.FillBytes(full[:n])
+	lo.FillBytes(full[n:])
+	return full
 }
 
-func userCodeToFull(code string) ([]byte, error) {
-	parts := strings.Split(code, "-")
-	if len(parts) != 6 {
-		return nil, errors.New("userCode must look like word-word-word-word-123-4567")
-	}
-	wStrs := parts[:4]
-	d1 := parts[4]
-	d2 := parts[5]
-
-	// Map words (case-insensitive) to 11-bit indices
-	var idx [4]uint64
-	for i := 0; i < 4; i++ {
-		w := strings.ToLower(strings.TrimSpace(wStrs[i]))
-		id, ok := wordIndex[w]
-		if !ok {
-			return nil, fmt.Errorf("word %q not in BIP39 English list", wStrs[i])
-		}
-		idx[i] = uint64(id)
-	}
-
-	// Parse 3+4 digits
-	if len(d1) != 3 || len(d2) != 4 {
-		return nil, errors.New("numeric part must be 3 digits then 4 digits")
-	}
-	if !allDigits(d1) || !allDigits(d2) {
-		return nil, errors.New("numeric part must contain only digits")
-	}
-	numVal := atoiUnsafe(d1)*10000 + atoiUnsafe(d2) // 0..9,999,999
-	if numVal > 0xFFFFF {                           // > 1,048,575 is invalid for our 20-bit mapping
-		return nil, errors.New("numeric part out of range for 20-bit payload")
-	}
-
-	// Recompose 64-bit big-endian
-	var u uint64
-	u |= (idx[0] & 0x7FF) << 53
-	u |= (idx[1] & 0x7FF) << 42
-	u |= (idx[2] & 0x7FF) << 31
-	u |= (idx[3] & 0x7FF) << 20
-	u |= uint64(numVal) // low 20 bits
+// checksum returns the first 16 bits of SHA-256 over the format version, the
+// encoding tag and the payload. Wordlist encodings keep the top 11 bits (one word).
+func checksum(version byte, tag string, full []byte) uint16 {
+	b := append([]byte{version}, tag...)
+	h := sha256.Sum256(append(b, full...))
+	return uint16(h[0])<<8 | uint16(h[1])
+}
 
-	// to bytes
-	full := make([]byte, 8)
-	for i := 7; i >= 0; i-- {
-		full[i] = byte(u & 0xFF)
-		u >>= 8
-	}
-	return full, nil
+// splitCode splits a user code into its dash-separated parts, tolerating
+// surrounding whitespace and spaces used instead of dashes.
+func splitCode(code string) []string {
+	code = strings.TrimSpace(code)
+	return strings.FieldsFunc(code, func(r rune) bool {
+		return r == '-' || r == ' ' || r == '\t' || r == '\u3000' // ideographic space (Japanese)
+	})
 }
 
 func allDigits(s string) bool {
@@ -0,0 +1,47 @@
+package usercode
+
+import "testing"
+
+func FuzzParseUserCode(f *testing.F) {
+	f.Add("")
+	f.Add("ab12cd34-ab12cd34")
+	f.Add("abandon-ability-able-about-above-absent")
+	f.Add("abandon ability able about above absent acid")
+	f.Add("ábaco-abdomen-abeja-abismo-abogado-abono-aborto")
+	f.Add("0000-0000-0000-0000-0000-0000")
+	f.Add("aardvark-absurd-accrue-acme-adrift-adult-afflict-ahead-aimless-Algol")
+	f.Add("あいこくしん　あいさつ　あいだ　あおぞら　あかちゃん　あきる　あけがた")
+
+	f.Fuzz(func(t *testing.T, code string) {
+		if len(code) > 512 {
+			t.Skip("longer than any code a user would type")
+		}
+		relay, receiver, full, err := ParseUserCode(code)
+		if err != nil {
+			if _, ok := err.(*InvalidCodeError); !ok {
+				t.Fatalf("error is %T, want *InvalidCodeError", err)
+			}
+			return
+		}
+		if relay == "" || receiver == "" || full == "" {
+			t.Fatalf("empty part for accepted code %q", code)
+		}
+
+		// Re-encoding what was parsed must round-trip to the same parts
+		_, words, enc, err := decodeAny(code)
+		if err != nil {
+			t.Fatalf("decodeAny rejected a code ParseUserCode accepted: %v", err)
+		}
+		again, _, err := GenerateUserCode(enc, words, relay, receiver)
+		if err != nil {
+			t.Fatalf("re-encode %q as %s: %v", code, enc.Name(), err)
+		}
+		relay2, receiver2, full2, err := ParseUserCode(again)
+		if err != nil {
+			t.Fatalf("re-encoded code %q rejected: %v", again, err)
+		}
+		if relay2 != relay || receiver2 != receiver || full2 != full {
+			t.Fatalf("round trip changed parts: %q -> %q", code, again)
+		}
+	})
+}
@@ -0,0 +1,198 @@
+package usercode
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// wordlistEncoding encodes codes as BIP39 words followed by a checksum word and
+// 7 digits: word-...-word-check-123-4567 (see PartBits for the word counts).
+type wordlistEncoding struct {
+	name   string
+	words  []string
+	folded []string          // words as normalized by fold
+	index  map[string]uint16 // folded word -> index
+	legacy bool              // accept the checksum-less 6-part format (English only)
+	prefix bool              // words are unique by their first four letters
+}
+
+func newWordlistEncoding(name string, list []string, legacy bool) *wordlistEncoding {
+	e := &wordlistEncoding{
+		name:   name,
+		words:  list,
+		folded: make([]string, len(list)),
+		index:  make(map[string]uint16, len(list)),
+		legacy: legacy,
+		prefix: true,
+	}
+	prefixes := make(map[string]bool, len(list))
+	for i, w := range list {
+		e.folded[i] = fold(w)
+		e.index[e.folded[i]] = uint16(i)
+		p := prefix4(e.folded[i])
+		if prefixes[p] {
+			e.prefix = false
+		}
+		prefixes[p] = true
+	}
+	return e
+}
+
+func (e *wordlistEncoding) Name() string { return e.name }
+
+// checksumTag keeps the English checksum compatible with codes issued before
+// other encodings existed; other wordlists mix their name in so that a code
+// made of words shared by two lists only verifies in one of them.
+func (e *wordlistEncoding) checksumTag() string {
+	if e == english {
+		return ""
+	}
+	return e.name
+}
+
+// lookup returns the index of a (possibly unaccented or differently cased) word.
+func (e *wordlistEncoding) lookup(w string) (uint16, bool) {
+	id, ok := e.index[fold(w)]
+	return id, ok
+}
+
+// layout returns the format version and payload word count for a code with
+// the given number of parts, or ok=false if no format has that many parts.
+func (e *wordlistEncoding) layout(nParts int) (version byte, words int, ok bool) {
+	if nParts == 6 && e.legacy {
+		return CodeVersionLegacy, 4, true
+	}
+	words = nParts - 3 // payload words + checksum word + 2 digit groups
+	if ValidCodeWords(words) {
+		return CodeVersionChecksum, words, true
+	}
+	return 0, 0, false
+}
+
+func (e *wordlistEncoding) Match(parts []string) int {
+	if _, _, ok := e.layout(len(parts)); !ok || !allDigits(parts[len(parts)-1]) {
+		return 0
+	}
+	// Digits only: a numeric code with a digit too many or too few
+	if allDigits(strings.Join(parts, "")) {
+		return 0
+	}
+	score := 1
+	for _, p := range parts[:len(parts)-2] {
+		if _, ok := e.lookup(p); ok {
+			score += 2
+		} else if e.prefix && e.prefixWord(p) != "" {
+			score++
+		}
+	}
+	return score
+}
+
+func (e *wordlistEncoding) Encode(words int, full []byte) (string, error) {
+	if len(full) != 2*partBytes(words) {
+		return "", fmt.Errorf("fullCode must be %d bytes", 2*partBytes(words))
+	}
+	// Interpret as a big-endian integer for stable mapping:
+	// top 11*words bits -> words (11 bits each), low 20 bits -> numeric
+	u := payloadInt(full, words)
+	num := new(big.Int).And(u, big.NewInt(0xFFFFF)).Int64()
+	u.Rsh(u, 20)
+
+	parts := make([]string, words+1)
+	for i := words - 1; i >= 0; i-- {
+		idx := new(big.Int).And(u, big.NewInt(0x7FF)).Int64()
+		parts[i] = e.words[idx]
+		u.Rsh(u, 11)
+	}
+	parts[words] = e.words[checksum(CodeVersionChecksum, e.checksumTag(), full)&0x7FF]
+
+	// Low 20 bits -> numeric (0..1,048,575) -> 7 decimal digits, zero-padded
+	numStr := fmt.Sprintf("%07d", num)     // 7 digits
+	numStr = numStr[:3] + "-" + numStr[3:] // format xxx-xxxx
+
+	return strings.Join(parts, "-") + "-" + numStr, nil
+}
+
+func (e *wordlistEncoding) Decode(code string) ([]byte, int, error) {
+	parts := splitCode(code)
+	version, nWords, ok := e.layout(len(parts))
+	if !ok {
+		return nil, 0, errors.New("userCode must look like word-word-word-word-word-123-4567")
+	}
+	wStrs := parts[:len(parts)-2]
+	d1 := parts[len(parts)-2]
+	d2 := parts[len(parts)-1]
+
+	// Map words (case- and accent-insensitive) to 11-bit indices
+	idx := make([]int64, len(wStrs))
+	for i := range wStrs {
+		id, ok := e.lookup(wStrs[i])
+		if !ok {
+			return nil, 0, fmt.Errorf("word %q not in BIP39 %s list", wStrs[i], e.name)
+		}
+		idx[i] = int64(id)
+	}
+
+	// Parse 3+4 digits
+	if len(d1) != 3 || len(d2) != 4 {
+		return nil, 0, errors.New("numeric part must be 3 digits then 4 digits")
+	}
+	if !allDigits(d1) || !allDigits(d2) {
+		return nil, 0, errors.New("numeric part must contain only digits")
+	}
+	numVal := atoiUnsafe(d1)*10000 + atoiUnsafe(d2) // 0..9,999,999
+	if numVal > 0xFFFFF {                           // > 1,048,575 is invalid for our 20-bit mapping
+		return nil, 0, errors.New("numeric part out of range for 20-bit payload")
+	}
+
+	// Recompose the big-endian payload
+	u := new(big.Int)
+	for i := 0; i < nWords; i++ {
+		u.Lsh(u, 11)
+		u.Or(u, big.NewInt(idx[i]))
+	}
+	u.Lsh(u, 20)
+	u.Or(u, big.NewInt(int64(numVal))) // low 20 bits
+	full := payloadBytes(u, nWords)
+
+	// Verify the checksum word of versioned codes
+	if version == CodeVersionChecksum && uint16(idx[nWords]) != checksum(version, e.checksumTag(), full)&0x7FF {
+		return nil, 0, ErrChecksum
+	}
+	return full, nWords, nil
+}
+
+// fold normalizes a word for lookup: lower case, and for Latin-script words
+// without accents, so "arbol" finds "árbol". Other scripts are only NFC
+// normalized, as their combining marks are significant (e.g. Japanese dakuten).
+func fold(w string) string {
+	w = strings.ToLower(norm.NFC.String(strings.TrimSpace(w)))
+	for _, r := range w {
+		if r > unicode.MaxLatin1 && !unicode.In(r, unicode.Latin) {
+			return w
+		}
+	}
+	// Strip combining marks (accents) after canonical decomposition; the chain
+	// is stateful, so build a fresh one per call
+	s, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), w)
+	if err != nil {
+		return w
+	}
+	return s
+}
+
+// prefix4 returns the first four runes of w.
+func prefix4(w string) string {
+	r := []rune(w)
+	if len(r) > 4 {
+		r = r[:4]
+	}
+	return string(r)
+}
@@ -1,5 +1,10 @@
 package version
 
+import (
+	"strconv"
+	"strings"
+)
+
 var (
 	Version = "dev"
 	Commit  = "none"
@@ -9,3 +14,45 @@ var (
 func String() string {
 	return Version + " (" + Commit + ", " + Date + ")"
 }
+
+// Semver extracts the leading MAJOR.MINOR.PATCH numbers of a version string such
+// as "v1.4.2" or "1.4.2-rc1 (abc123, 2025-01-01)". Missing minor or patch numbers
+// count as 0. ok is false for dev builds and other unparsable versions.
+func Semver(s string) (v [3]int, ok bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexAny(s, " -+"); i >= 0 {
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return v, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
+
+// AtLeast reports whether version v is min or newer. A v that cannot be parsed
+// (such as "dev") is treated as new enough, so development builds are never
+// locked out; an unparsable min accepts everything.
+func AtLeast(v, min string) bool {
+	have, ok := Semver(v)
+	if !ok {
+		return true
+	}
+	want, ok := Semver(min)
+	if !ok {
+		return true
+	}
+	for i := range have {
+		if have[i] != want[i] {
+			return have[i] > want[i]
+		}
+	}
+	return true
+}